@@ -0,0 +1,33 @@
+package payment_gateway
+
+import (
+	"time"
+
+	"github.com/marioarizaj/payment-gateway/internal/decline"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+)
+
+// PaymentAttempt is a payment sent to an acquirer. Payments that cascade to a fallback acquirer have an attempt for each acquirer.
+type PaymentAttempt struct {
+	// Attempt is the number of the attempt, starting from 1
+	Attempt  int    `json:"attempt"`
+	Acquirer string `json:"acquirer"`
+	// Status is processing until the acquirer answers, and then succeeded or failed
+	Status status.PaymentStatus `json:"status"`
+	// Decline describes why the acquirer did not accept the attempt
+	Decline   *decline.Decline `json:"decline,omitempty"`
+	CreatedAt *time.Time       `json:"created_at"`
+	UpdatedAt *time.Time       `json:"updated_at"`
+}
+
+func GetPaymentAttemptFromStoredAttempt(a *repositiory.PaymentAttempt) PaymentAttempt {
+	return PaymentAttempt{
+		Attempt:   a.Attempt,
+		Acquirer:  a.Acquirer,
+		Status:    a.Status,
+		Decline:   a.Decline,
+		CreatedAt: a.CreatedAt,
+		UpdatedAt: a.UpdatedAt,
+	}
+}
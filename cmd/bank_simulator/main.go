@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/marioarizaj/payment-gateway/internal/acquiringbank"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"go.uber.org/zap"
+)
+
+func main() {
+	zapLogger, err := zap.NewDevelopment()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		zapLogger.Fatal("could not load config", zap.Error(err))
+	}
+
+	simulator := acquiringbank.NewSimulator(cfg.MockBankConfig, cfg.AcquirerCallback.Secret, &http.Client{Timeout: 5 * time.Second}, zapLogger)
+	server := http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.BankSimulatorConfig.Port),
+		Handler: simulator.Handler(),
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.BankSimulatorConfig.TCPPort))
+	if err != nil {
+		zapLogger.Fatal("could not listen for ISO 8583 connections", zap.Error(err))
+	}
+	go func() {
+		zapLogger.Info("Bank simulator accepting ISO 8583 connections on port: ", zap.Int("port", cfg.BankSimulatorConfig.TCPPort))
+		if err := simulator.ServeTCP(listener); err != nil {
+			zapLogger.Error("simulator.ServeTCP failed", zap.Error(err))
+		}
+	}()
+
+	shutdownCompleteChan := handleShutdownSignal(func() {
+		_ = listener.Close()
+		if err := server.Shutdown(context.Background()); err != nil {
+			zapLogger.Fatal("could not shutdown server", zap.Error(err))
+		}
+	})
+	zapLogger.Info("Bank simulator starting to listen on port: ", zap.Int("port", cfg.BankSimulatorConfig.Port))
+	if err = server.ListenAndServe(); err == http.ErrServerClosed {
+		<-shutdownCompleteChan
+	} else {
+		zapLogger.Error("http.ListenAndServer failed", zap.Error(err))
+	}
+
+	fmt.Println("INFO: Shutdown gracefully")
+}
+
+func handleShutdownSignal(fn func()) <-chan struct{} {
+	shutdownSignal := make(chan struct{}, 1)
+	go func() {
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+		<-sigChan
+
+		fn()
+
+		shutdownSignal <- struct{}{}
+	}()
+	return shutdownSignal
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,7 +13,10 @@ import (
 
 	"github.com/marioarizaj/payment-gateway/internal/config"
 	"github.com/marioarizaj/payment-gateway/internal/dependencies"
+	"github.com/marioarizaj/payment-gateway/internal/domain/payment"
+	"github.com/marioarizaj/payment-gateway/internal/domain/webhook"
 	"github.com/marioarizaj/payment-gateway/internal/handlers"
+	"github.com/marioarizaj/payment-gateway/kit/prometheus"
 	"go.uber.org/zap"
 )
 
@@ -21,6 +25,8 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	// Dependencies that are created before the router, like the bank client, log through the global logger
+	zap.ReplaceGlobals(zapLogger)
 	cfg, err := config.LoadConfig()
 	if err != nil {
 		zapLogger.Fatal("could not load config", zap.Error(err))
@@ -31,19 +37,50 @@ func main() {
 		zapLogger.Fatal("could not initialise dependencies", zap.Error(err))
 	}
 
+	// The state of the circuit breakers is read on every scrape of the metrics
+	if err = prometheus.RegisterBreakerStates(deps.Breakers.States); err != nil {
+		zapLogger.Error("could not register the circuit breaker metrics", zap.Error(err))
+	}
+
+	domains := handlers.NewDomains(cfg, deps, zapLogger)
+	// The outbox submits the payments to their acquirer, the inbox applies the results of the acquirer that could not
+	// be applied when they arrived, the resolver finds out the result of the payments whose outcome is unknown, the
+	// sweeper the result of the payments stuck processing, the dispatcher sends the webhook events of the merchants, and
+	// the settlement files of the acquirers are reconciled, until the server shuts down
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	go payment.NewOutbox(domains.Payments, cfg.OutboxConfig).Run(workersCtx)
+	go payment.NewInbox(domains.Payments, cfg.InboxConfig).Run(workersCtx)
+	go payment.NewResolver(domains.Payments, cfg.ResolutionConfig).Run(workersCtx)
+	go payment.NewSweeper(domains.Payments, cfg.SweeperConfig).Run(workersCtx)
+	go webhook.NewDispatcher(domains.Webhooks, cfg.WebhookConfig).Run(workersCtx)
+	go domains.Reconciliation.Run(workersCtx)
+
+	// Every request context derives from this one, so requests that are still running once the shutdown
+	// grace period is over get canceled, along with their calls to the acquiring bank
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
 	server := http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.Server.Port),
-		Handler:      handlers.NewRouter(cfg, deps, zapLogger),
+		Handler:      handlers.NewRouterWithDomains(cfg, deps, domains, zapLogger),
 		ReadTimeout:  time.Duration(cfg.Server.ReadTimeout) * time.Second,
 		WriteTimeout: time.Duration(cfg.Server.WriteTimeout) * time.Second,
 		IdleTimeout:  time.Duration(cfg.Server.IdleTimeout) * time.Second,
+		BaseContext: func(net.Listener) context.Context {
+			return baseCtx
+		},
 	}
 
 	shutdownCompleteChan := handleShutdownSignal(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeout)*time.Second)
+		defer cancel()
+		stopWorkers()
 		// When we call this, ListenAndServe will immediately return
 		// http.ErrServerClosed
-		if err := server.Shutdown(context.Background()); err != nil {
-			zapLogger.Fatal("could not shutdown server", zap.Error(err))
+		if err := server.Shutdown(ctx); err != nil {
+			zapLogger.Warn("requests did not finish before the shutdown timeout, canceling them", zap.Error(err))
+			cancelRequests()
+			_ = server.Close()
 		}
 	})
 	zapLogger.Info("Server starting to listen on port: ", zap.Int("port", cfg.Server.Port))
@@ -2,6 +2,8 @@ package acquiringbank
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -9,13 +11,19 @@ import (
 	"time"
 
 	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
+	"github.com/marioarizaj/payment-gateway/internal/status"
 
 	"github.com/marioarizaj/payment-gateway"
 )
 
+// defaultDeclineResponseCode is given to failed operations when the config does not have a response code.
+const defaultDeclineResponseCode = "05"
+
 type paymentsStore struct {
-	cache map[string]payment_gateway.Payment
-	lock  *sync.Mutex
+	cache   map[string]payment_gateway.Payment
+	refunds map[string]payment_gateway.Refund
+	lock    *sync.Mutex
 }
 
 func (p *paymentsStore) set(key string, value payment_gateway.Payment) {
@@ -24,49 +32,245 @@ func (p *paymentsStore) set(key string, value payment_gateway.Payment) {
 	p.lock.Unlock()
 }
 
+// setUnlessCanceled stores the payment, unless the stored one was canceled. It returns false when it was canceled.
+func (p *paymentsStore) setUnlessCanceled(key string, value payment_gateway.Payment) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.cache[key].PaymentStatus == status.PaymentCanceled {
+		return false
+	}
+	p.cache[key] = value
+	return true
+}
+
+func (p *paymentsStore) get(key string) (payment_gateway.Payment, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	payment, ok := p.cache[key]
+	return payment, ok
+}
+
+func (p *paymentsStore) setRefund(key string, value payment_gateway.Refund) {
+	p.lock.Lock()
+	p.refunds[key] = value
+	p.lock.Unlock()
+}
+
 type MockClient struct {
 	paymentsStore               paymentsStore
 	StatusCode                  int
-	FailedReason                string
+	DeclineResponseCode         string
 	NewStatus                   string
 	SleepIntervalInitialRequest time.Duration
 	SleepIntervalForCallback    time.Duration
 	ShouldRunCallback           bool
+	// ScenarioTimeout is how long the timeout scenario waits before answering
+	ScenarioTimeout time.Duration
+}
+
+// outcome is how the bank answers a single operation: the response to the request, and the result of the callback.
+type outcome struct {
+	statusCode   int
+	delay        time.Duration
+	runCallback  bool
+	status       string
+	responseCode string
 }
 
 func NewMockClient(cfg config.MockBankConfig) *MockClient {
 	return &MockClient{
 		paymentsStore: paymentsStore{
-			cache: map[string]payment_gateway.Payment{},
-			lock:  &sync.Mutex{},
+			cache:   map[string]payment_gateway.Payment{},
+			refunds: map[string]payment_gateway.Refund{},
+			lock:    &sync.Mutex{},
 		},
 		StatusCode:                  cfg.StatusCode,
 		NewStatus:                   cfg.UpdateToStatus,
 		SleepIntervalInitialRequest: time.Duration(cfg.SleepIntervalInitialRequest) * time.Millisecond,
 		SleepIntervalForCallback:    time.Duration(cfg.SleepIntervalForCallback) * time.Millisecond,
 		ShouldRunCallback:           cfg.ShouldRunCallback,
-		FailedReason:                cfg.FailedReason,
+		DeclineResponseCode:         cfg.DeclineResponseCode,
+		ScenarioTimeout:             time.Duration(cfg.ScenarioTimeout) * time.Millisecond,
 	}
 }
 
-func (c *MockClient) CreatePayment(payment payment_gateway.Payment, callBack func(payment payment_gateway.Payment)) http.Response {
-	time.Sleep(c.SleepIntervalInitialRequest)
+// outcomeFor decides the outcome of an operation from the scenario of its card number or amount,
+// and from the configuration when neither of them has a scenario.
+func (c *MockClient) outcomeFor(cardNumber string, amount int64) outcome {
+	o := outcome{
+		statusCode:   c.StatusCode,
+		delay:        c.SleepIntervalInitialRequest,
+		runCallback:  c.ShouldRunCallback,
+		status:       c.NewStatus,
+		responseCode: c.DeclineResponseCode,
+	}
+	scenario, ok := FindScenario(cardNumber, amount)
+	if !ok {
+		return o
+	}
+	o.statusCode = http.StatusAccepted
+	switch {
+	case scenario.Timeout:
+		o.statusCode = http.StatusGatewayTimeout
+		o.delay = c.ScenarioTimeout
+		o.runCallback = false
+	case scenario.NoCallback:
+		o.runCallback = false
+	case scenario.StatusCode != 0:
+		o.statusCode = scenario.StatusCode
+		o.runCallback = false
+	case scenario.Declined():
+		o.runCallback = true
+		o.status = string(status.PaymentFailed)
+		o.responseCode = scenario.ResponseCode
+	}
+	return o
+}
+
+// decline returns the decline given to operations that fail, or nil when they succeed.
+func (o outcome) decline() *decline.Decline {
+	if o.status != string(status.PaymentFailed) {
+		return nil
+	}
+	if o.responseCode == "" {
+		return decline.FromResponseCode(defaultDeclineResponseCode)
+	}
+	return decline.FromResponseCode(o.responseCode)
+}
+
+// CreatePayment decides the outcome from the card number and the amount of the payment. When the context is done
+// before the bank answers, the payment is not processed and 504 Gateway Timeout is returned, like a real bank
+// whose answer did not arrive in time. Payments that time out on their own are approved, but their answer is lost,
+// so only a status inquiry finds out about them.
+func (c *MockClient) CreatePayment(ctx context.Context, payment payment_gateway.Payment, callBack func(payment payment_gateway.Payment)) http.Response {
+	o := c.outcomeFor(payment.CardInfo.CardNumber, payment.Amount.AmountFractional)
+	if o.statusCode == http.StatusGatewayTimeout {
+		approved := payment
+		approved.PaymentStatus = status.PaymentSucceeded
+		c.paymentsStore.set(payment.ID.String(), approved)
+	}
+	err := sleep(ctx, o.delay)
+	if err != nil {
+		return errorResponse(http.StatusGatewayTimeout, err)
+	}
+	if o.statusCode < 299 {
+		payment.PaymentStatus = status.PaymentProcessing
+		c.paymentsStore.set(payment.ID.String(), payment)
+	}
 	go func() {
 		time.Sleep(c.SleepIntervalForCallback)
-		if c.ShouldRunCallback {
-			payment.PaymentStatus = c.NewStatus
+		if o.runCallback {
+			payment.PaymentStatus = status.PaymentStatus(o.status)
 			if payment.PaymentStatus == "" {
-				payment.PaymentStatus = "success"
+				payment.PaymentStatus = status.PaymentSucceeded
 			}
-			if c.FailedReason != "" {
-				payment.FailedReason = c.FailedReason
+			payment.Decline = o.decline()
+			if !c.paymentsStore.setUnlessCanceled(payment.ID.String(), payment) {
+				return
 			}
+			callBack(payment)
+		}
+	}()
+	return response(o.statusCode)
+}
+
+// CreateRefund behaves the same as CreatePayment, deciding the outcome from the amount of the refund.
+func (c *MockClient) CreateRefund(ctx context.Context, _ payment_gateway.Payment, refund payment_gateway.Refund, callBack func(refund payment_gateway.Refund)) http.Response {
+	o := c.outcomeFor("", refund.Amount.AmountFractional)
+	err := sleep(ctx, o.delay)
+	if err != nil {
+		return errorResponse(http.StatusGatewayTimeout, err)
+	}
+	go func() {
+		time.Sleep(c.SleepIntervalForCallback)
+		if o.runCallback {
+			refund.RefundStatus = status.RefundStatus(o.status)
+			if refund.RefundStatus == "" {
+				refund.RefundStatus = status.RefundSucceeded
+			}
+			refund.Decline = o.decline()
+			c.paymentsStore.setRefund(refund.ID.String(), refund)
+			callBack(refund)
+		}
+	}()
+	return response(o.statusCode)
+}
+
+// CapturePayment captures the AmountCaptured of a previously authorized payment,
+// deciding the outcome of the capture from the captured amount.
+func (c *MockClient) CapturePayment(ctx context.Context, payment payment_gateway.Payment, callBack func(payment payment_gateway.Payment)) http.Response {
+	o := c.outcomeFor("", payment.AmountCaptured)
+	err := sleep(ctx, o.delay)
+	if err != nil {
+		return errorResponse(http.StatusGatewayTimeout, err)
+	}
+	go func() {
+		time.Sleep(c.SleepIntervalForCallback)
+		if o.runCallback {
+			payment.PaymentStatus = status.PaymentStatus(o.status)
+			if payment.PaymentStatus == "" {
+				payment.PaymentStatus = status.PaymentSucceeded
+			}
+			payment.Decline = o.decline()
 			c.paymentsStore.set(payment.ID.String(), payment)
 			callBack(payment)
 		}
 	}()
+	return response(o.statusCode)
+}
+
+// CancelPayment releases an authorization, or stops a payment that is still processing.
+// Once the payment is canceled, the bank does not run the callback of the payment.
+func (c *MockClient) CancelPayment(ctx context.Context, payment payment_gateway.Payment) http.Response {
+	err := sleep(ctx, c.SleepIntervalInitialRequest)
+	if err != nil {
+		return errorResponse(http.StatusGatewayTimeout, err)
+	}
+	if c.StatusCode < 299 {
+		payment.PaymentStatus = status.PaymentCanceled
+		c.paymentsStore.set(payment.ID.String(), payment)
+	}
+	return response(c.StatusCode)
+}
+
+// sleep waits for the given duration, returning the error of the context when it is done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// InquirePayment answers with the payment as the bank knows it, or with 404 Not Found when the bank never
+// received it. Payments that are accepted and waiting for their callback are still processing.
+func (c *MockClient) InquirePayment(ctx context.Context, payment payment_gateway.Payment) http.Response {
+	err := sleep(ctx, c.SleepIntervalInitialRequest)
+	if err != nil {
+		return errorResponse(http.StatusGatewayTimeout, err)
+	}
+	stored, ok := c.paymentsStore.get(payment.ID.String())
+	if !ok {
+		return errorResponse(http.StatusNotFound, fmt.Errorf("payment %s not found", payment.ID))
+	}
+	// The card details are not sent back
+	stored.CardInfo = payment_gateway.CardInfo{}
+	bts, err := json.Marshal(stored)
+	if err != nil {
+		return errorResponse(http.StatusInternalServerError, err)
+	}
+	return http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(bytes.NewReader(bts)),
+	}
+}
+
+func response(statusCode int) http.Response {
 	return http.Response{
-		StatusCode: c.StatusCode,
-		Body:       io.NopCloser(bytes.NewBuffer([]byte(fmt.Sprintf(`{"status": "%d"}`, c.StatusCode)))),
+		StatusCode: statusCode,
+		Body:       io.NopCloser(bytes.NewBuffer([]byte(fmt.Sprintf(`{"status": "%d"}`, statusCode)))),
 	}
 }
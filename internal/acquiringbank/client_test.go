@@ -1,6 +1,9 @@
 package acquiringbank_test
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
 	"testing"
 	"time"
 
@@ -8,6 +11,7 @@ import (
 	"github.com/marioarizaj/payment-gateway"
 	"github.com/marioarizaj/payment-gateway/internal/acquiringbank"
 	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/status"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -113,11 +117,11 @@ func TestMockClient_CreatePayment(t *testing.T) {
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
 			mockBank := acquiringbank.NewMockClient(c.bankConfig)
-			res := mockBank.CreatePayment(c.inputPayment, c.callback)
+			res := mockBank.CreatePayment(context.Background(), c.inputPayment, c.callback)
 			assert.Equal(t, c.bankConfig.StatusCode, res.StatusCode)
 			if c.bankConfig.ShouldRunCallback {
 				returnedPayment := <-signalChan
-				assert.Equal(t, c.bankConfig.UpdateToStatus, returnedPayment.PaymentStatus)
+				assert.Equal(t, status.PaymentStatus(c.bankConfig.UpdateToStatus), returnedPayment.PaymentStatus)
 			} else {
 				select {
 				case <-signalChan:
@@ -131,3 +135,154 @@ func TestMockClient_CreatePayment(t *testing.T) {
 	}
 	close(signalChan)
 }
+
+func TestMockClient_CreateRefund(t *testing.T) {
+	signalChan := make(chan payment_gateway.Refund, 1)
+	refund := payment_gateway.Refund{
+		ID:         uuid.Must(uuid.Parse("0d3bd1f2-0b8e-4c3a-8d43-67b1dfd4c1a2")),
+		PaymentID:  uuid.Must(uuid.Parse("b5f9c307-5202-4c52-aba9-752167eef9bf")),
+		MerchantID: uuid.Must(uuid.Parse("6c5a19d0-f132-4a55-93d3-2c00db06d41b")),
+		Amount: payment_gateway.Amount{
+			AmountFractional: 500,
+			CurrencyCode:     "USD",
+		},
+	}
+	mockBank := acquiringbank.NewMockClient(config.MockBankConfig{
+		StatusCode:                  202,
+		UpdateToStatus:              "succeeded",
+		SleepIntervalInitialRequest: 1,
+		SleepIntervalForCallback:    10,
+		ShouldRunCallback:           true,
+	})
+	res := mockBank.CreateRefund(context.Background(), payment_gateway.Payment{}, refund, func(refund payment_gateway.Refund) {
+		signalChan <- refund
+	})
+	assert.Equal(t, 202, res.StatusCode)
+	returnedRefund := <-signalChan
+	assert.Equal(t, status.RefundSucceeded, returnedRefund.RefundStatus)
+	assert.Equal(t, refund.ID, returnedRefund.ID)
+}
+
+func TestMockClient_CancelPayment(t *testing.T) {
+	mockBank := acquiringbank.NewMockClient(config.MockBankConfig{
+		StatusCode:                  202,
+		SleepIntervalInitialRequest: 1,
+	})
+	res := mockBank.CancelPayment(context.Background(), payment_gateway.Payment{
+		ID:            uuid.Must(uuid.Parse("b5f9c307-5202-4c52-aba9-752167eef9bf")),
+		PaymentStatus: "authorized",
+	})
+	assert.Equal(t, 202, res.StatusCode)
+}
+
+func TestMockClient_CancelPayment_DropsCallback(t *testing.T) {
+	signalChan := make(chan payment_gateway.Payment, 1)
+	mockBank := acquiringbank.NewMockClient(config.MockBankConfig{
+		StatusCode:                  202,
+		UpdateToStatus:              "succeeded",
+		SleepIntervalInitialRequest: 1,
+		SleepIntervalForCallback:    50,
+		ShouldRunCallback:           true,
+	})
+	p := payment_gateway.Payment{ID: uuid.New()}
+	res := mockBank.CreatePayment(context.Background(), p, func(payment payment_gateway.Payment) {
+		signalChan <- payment
+	})
+	_ = res.Body.Close()
+	res = mockBank.CancelPayment(context.Background(), p)
+	_ = res.Body.Close()
+	assert.Equal(t, 202, res.StatusCode)
+	select {
+	case <-signalChan:
+		t.Error("callback was run for a canceled payment")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMockClient_ContextDone(t *testing.T) {
+	signalChan := make(chan payment_gateway.Payment, 1)
+	mockBank := acquiringbank.NewMockClient(config.MockBankConfig{
+		StatusCode:                  202,
+		UpdateToStatus:              "succeeded",
+		SleepIntervalInitialRequest: 1000,
+		ShouldRunCallback:           true,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	res := mockBank.CreatePayment(ctx, payment_gateway.Payment{ID: uuid.New()}, func(payment payment_gateway.Payment) {
+		signalChan <- payment
+	})
+	_ = res.Body.Close()
+	// The bank stops waiting as soon as the deadline passes, and reports that the outcome is unknown
+	assert.Equal(t, http.StatusGatewayTimeout, res.StatusCode)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	select {
+	case <-signalChan:
+		t.Error("callback was run for a payment whose context was done")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMockClient_InquirePayment(t *testing.T) {
+	cases := []struct {
+		name               string
+		cardNumber         string
+		send               bool
+		expectedStatusCode int
+		expectedStatus     status.PaymentStatus
+	}{
+		{
+			name:               "not_received",
+			cardNumber:         testPayment.CardInfo.CardNumber,
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "waiting_for_callback",
+			cardNumber:         testPayment.CardInfo.CardNumber,
+			send:               true,
+			expectedStatusCode: http.StatusOK,
+			expectedStatus:     status.PaymentProcessing,
+		},
+		{
+			// The timeout card is approved, but the answer is lost
+			name:               "answer_lost",
+			cardNumber:         "4000000000000127",
+			send:               true,
+			expectedStatusCode: http.StatusOK,
+			expectedStatus:     status.PaymentSucceeded,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockBank := acquiringbank.NewMockClient(config.MockBankConfig{
+				StatusCode:                  202,
+				UpdateToStatus:              "succeeded",
+				SleepIntervalInitialRequest: 1,
+				ScenarioTimeout:             1,
+			})
+			p := testPayment
+			p.ID = uuid.New()
+			p.CardInfo.CardNumber = c.cardNumber
+			if c.send {
+				res := mockBank.CreatePayment(context.Background(), p, nil)
+				_ = res.Body.Close()
+			}
+			res := mockBank.InquirePayment(context.Background(), payment_gateway.Payment{ID: p.ID})
+			defer func() {
+				_ = res.Body.Close()
+			}()
+			assert.Equal(t, c.expectedStatusCode, res.StatusCode)
+			if c.expectedStatusCode != http.StatusOK {
+				return
+			}
+			var inquired payment_gateway.Payment
+			assert.NoError(t, json.NewDecoder(res.Body).Decode(&inquired))
+			assert.Equal(t, p.ID, inquired.ID)
+			assert.Equal(t, c.expectedStatus, inquired.PaymentStatus)
+			// The card details are not sent back
+			assert.Empty(t, inquired.CardInfo.CVV)
+			assert.Empty(t, inquired.CardInfo.CardNumber)
+		})
+	}
+}
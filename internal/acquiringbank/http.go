@@ -0,0 +1,170 @@
+package acquiringbank
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	kitctx "github.com/marioarizaj/payment-gateway/kit/ctx"
+	"github.com/marioarizaj/payment-gateway/kit/requestid"
+	"go.uber.org/zap"
+)
+
+const (
+	OperationPayment = "payment"
+	OperationCapture = "capture"
+	OperationRefund  = "refund"
+
+	// CallbackSignatureHeader contains the time the callback was signed and the signature, as t=<unix time>,v1=<hex hmac>
+	CallbackSignatureHeader = "Acquirer-Signature"
+	// MerchantIDHeader tells the bank which merchant the operation is made for
+	MerchantIDHeader = "X-Merchant-ID"
+)
+
+// PaymentRequest is the body of the payment, capture and cancel requests sent to the bank simulator.
+// Acquirer is the name the gateway gives to the acquirer, which is sent back on the callbacks.
+type PaymentRequest struct {
+	CallbackURL string                  `json:"callback_url"`
+	Acquirer    string                  `json:"acquirer"`
+	Payment     payment_gateway.Payment `json:"payment"`
+}
+
+// RefundRequest is the body of the refund requests sent to the bank simulator, along with the refunded payment.
+type RefundRequest struct {
+	CallbackURL string                  `json:"callback_url"`
+	Acquirer    string                  `json:"acquirer"`
+	Payment     payment_gateway.Payment `json:"payment"`
+	Refund      payment_gateway.Refund  `json:"refund"`
+}
+
+// Callback is the result of an operation, sent by the bank simulator to the callback url of the request.
+// EventID is the same on every delivery of a result, so the gateway applies it only once. Acquirer tells the gateway
+// which acquirer processed the operation, since a payment can cascade to another acquirer before its result arrives.
+type Callback struct {
+	EventID   string                   `json:"event_id"`
+	Acquirer  string                   `json:"acquirer"`
+	Operation string                   `json:"operation"`
+	Payment   *payment_gateway.Payment `json:"payment,omitempty"`
+	Refund    *payment_gateway.Refund  `json:"refund,omitempty"`
+}
+
+// HTTPClient sends the operations to the bank simulator service over HTTP. The bank answers with 202 Accepted,
+// and later posts the signed result to the callback url, where the gateway applies it. The callbacks given with
+// the operations are not run, so results are not lost when the gateway restarts while waiting for them.
+type HTTPClient struct {
+	acquirer    string
+	baseURL     string
+	callbackURL string
+	client      *http.Client
+	logger      *zap.Logger
+}
+
+func NewHTTPClient(cfg config.BankConfig, l *zap.Logger) *HTTPClient {
+	return &HTTPClient{
+		acquirer:    cfg.Name,
+		baseURL:     cfg.URL,
+		callbackURL: cfg.CallbackURL,
+		client:      &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Millisecond},
+		logger:      l,
+	}
+}
+
+func (c *HTTPClient) CreatePayment(ctx context.Context, payment payment_gateway.Payment, _ func(payment payment_gateway.Payment)) http.Response {
+	return c.post(ctx, "/payments", PaymentRequest{CallbackURL: c.callbackURL, Acquirer: c.acquirer, Payment: payment})
+}
+
+func (c *HTTPClient) CreateRefund(ctx context.Context, payment payment_gateway.Payment, refund payment_gateway.Refund, _ func(refund payment_gateway.Refund)) http.Response {
+	return c.post(ctx, "/refunds", RefundRequest{CallbackURL: c.callbackURL, Acquirer: c.acquirer, Payment: payment, Refund: refund})
+}
+
+func (c *HTTPClient) CapturePayment(ctx context.Context, payment payment_gateway.Payment, _ func(payment payment_gateway.Payment)) http.Response {
+	return c.post(ctx, fmt.Sprintf("/payments/%s/capture", payment.ID), PaymentRequest{CallbackURL: c.callbackURL, Acquirer: c.acquirer, Payment: payment})
+}
+
+// CancelPayment is answered synchronously by the bank.
+func (c *HTTPClient) CancelPayment(ctx context.Context, payment payment_gateway.Payment) http.Response {
+	return c.post(ctx, fmt.Sprintf("/payments/%s/cancel", payment.ID), PaymentRequest{Acquirer: c.acquirer, Payment: payment})
+}
+
+// InquirePayment asks the bank for the status of the payment, which it answers synchronously.
+func (c *HTTPClient) InquirePayment(ctx context.Context, payment payment_gateway.Payment) http.Response {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/payments/%s", c.baseURL, payment.ID), nil)
+	if err != nil {
+		return errorResponse(http.StatusInternalServerError, err)
+	}
+	return c.do(ctx, req)
+}
+
+// post sends the body to the bank, until the context is done.
+func (c *HTTPClient) post(ctx context.Context, path string, body interface{}) http.Response {
+	bts, err := json.Marshal(body)
+	if err != nil {
+		return errorResponse(http.StatusInternalServerError, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(bts))
+	if err != nil {
+		return errorResponse(http.StatusInternalServerError, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(ctx, req)
+}
+
+// do sends the request to the bank. Network errors are returned as 503 Service Unavailable, so they are retried and
+// counted by the circuit breaker the same way as errors from the bank. Requests that time out or are canceled are
+// returned as 504 Gateway Timeout, since the bank may have processed them.
+func (c *HTTPClient) do(ctx context.Context, req *http.Request) http.Response {
+	path := req.URL.Path
+	setContextHeaders(ctx, req.Header)
+	res, err := c.client.Do(req)
+	if err != nil {
+		c.logger.Error("Could not reach the acquiring bank", zap.String("path", path), zap.Error(err))
+		return errorResponse(networkErrorStatus(err), err)
+	}
+	return *res
+}
+
+// setContextHeaders sends the request id and the merchant of the context to the bank, when they are known.
+func setContextHeaders(ctx context.Context, header http.Header) {
+	if id, err := kitctx.GetRequestID(ctx); err == nil {
+		header.Set(requestid.HeaderKey, id)
+	}
+	if merchantID, err := kitctx.GetMerchantID(ctx); err == nil {
+		header.Set(MerchantIDHeader, merchantID.String())
+	}
+}
+
+// networkErrorStatus returns 504 Gateway Timeout for errors after which the bank may have processed the request,
+// and 503 Service Unavailable for the rest.
+func networkErrorStatus(err error) int {
+	if isOutcomeUnknown(err) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusServiceUnavailable
+}
+
+// isOutcomeUnknown tells whether the request may have reached the bank without its answer reaching us: it timed out,
+// it was canceled while waiting, or the connection was lost after it was sent.
+func isOutcomeUnknown(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) ||
+		errors.Is(err, ErrResponseTimeout) || errors.Is(err, ErrLinkClosed) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func errorResponse(statusCode int, err error) http.Response {
+	bts, _ := json.Marshal(map[string]string{"error": err.Error()})
+	return http.Response{
+		StatusCode: statusCode,
+		Body:       io.NopCloser(bytes.NewReader(bts)),
+	}
+}
@@ -0,0 +1,305 @@
+package acquiringbank_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/acquiringbank"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"github.com/marioarizaj/payment-gateway/kit/auth"
+	kitctx "github.com/marioarizaj/payment-gateway/kit/ctx"
+	"github.com/marioarizaj/payment-gateway/kit/requestid"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+var testPayment = payment_gateway.Payment{
+	ID:            uuid.Must(uuid.Parse("b5f9c307-5202-4c52-aba9-752167eef9bf")),
+	MerchantID:    uuid.Must(uuid.Parse("6c5a19d0-f132-4a55-93d3-2c00db06d41b")),
+	PaymentStatus: "processing",
+	Amount: payment_gateway.Amount{
+		AmountFractional: 2000,
+		CurrencyCode:     "USD",
+	},
+	Description: "Payment test",
+	CardInfo: payment_gateway.CardInfo{
+		CardName:    "Mario Arizaj",
+		CardNumber:  "378282246310005",
+		ExpiryMonth: 10,
+		ExpiryYear:  22,
+		CVV:         "123",
+	},
+}
+
+const testCallbackSecret = "acquirer-secret"
+
+// receivedCallback is a callback received by the gateway stand-in, along with the result of checking its signature.
+type receivedCallback struct {
+	callback     acquiringbank.Callback
+	signatureErr error
+}
+
+// startSimulator runs the bank simulator and a gateway stand-in that receives the callbacks, returning a client
+// connected to the simulator. The stand-in answers each callback with the next status code, and 204 once they run out.
+func startSimulator(t *testing.T, bankConfig config.MockBankConfig, statusCodes ...int) (*acquiringbank.HTTPClient, chan receivedCallback) {
+	simulator := httptest.NewServer(acquiringbank.NewSimulator(bankConfig, testCallbackSecret, http.DefaultClient, zap.NewNop()).Handler())
+	t.Cleanup(simulator.Close)
+	callbacks := make(chan receivedCallback, 10)
+	var lock sync.Mutex
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var received receivedCallback
+		received.signatureErr = auth.VerifyPayload(testCallbackSecret, r.Header.Get(acquiringbank.CallbackSignatureHeader), body, time.Now(), time.Minute)
+		_ = json.Unmarshal(body, &received.callback)
+		callbacks <- received
+		lock.Lock()
+		defer lock.Unlock()
+		if len(statusCodes) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(statusCodes[0])
+		statusCodes = statusCodes[1:]
+	}))
+	t.Cleanup(gateway.Close)
+	client := acquiringbank.NewHTTPClient(config.BankConfig{
+		Name:        "primary",
+		URL:         simulator.URL,
+		CallbackURL: gateway.URL,
+		Timeout:     1000,
+	}, zap.NewNop())
+	return client, callbacks
+}
+
+func waitForCallback(t *testing.T, callbacks chan receivedCallback) (acquiringbank.Callback, bool) {
+	select {
+	case received := <-callbacks:
+		assert.NoError(t, received.signatureErr)
+		return received.callback, true
+	case <-time.After(2 * time.Second):
+		t.Error("callback was not received")
+		return acquiringbank.Callback{}, false
+	}
+}
+
+func TestHTTPClient_CreatePayment(t *testing.T) {
+	cases := []struct {
+		name       string
+		bankConfig config.MockBankConfig
+	}{
+		{
+			name: "create_payment_success",
+			bankConfig: config.MockBankConfig{
+				StatusCode:                  202,
+				UpdateToStatus:              "succeeded",
+				SleepIntervalInitialRequest: 1,
+				SleepIntervalForCallback:    10,
+				ShouldRunCallback:           true,
+			},
+		},
+		{
+			name: "create_payment_failed",
+			bankConfig: config.MockBankConfig{
+				StatusCode:                  202,
+				UpdateToStatus:              "failed",
+				SleepIntervalInitialRequest: 1,
+				SleepIntervalForCallback:    10,
+				ShouldRunCallback:           true,
+				DeclineResponseCode:         "51",
+			},
+		},
+		{
+			name: "create_payment_rejected",
+			bankConfig: config.MockBankConfig{
+				StatusCode:                  400,
+				SleepIntervalInitialRequest: 1,
+				SleepIntervalForCallback:    10,
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client, callbacks := startSimulator(t, c.bankConfig)
+			res := client.CreatePayment(context.Background(), testPayment, nil)
+			_ = res.Body.Close()
+			assert.Equal(t, c.bankConfig.StatusCode, res.StatusCode)
+			if !c.bankConfig.ShouldRunCallback {
+				return
+			}
+			callback, ok := waitForCallback(t, callbacks)
+			if !ok || !assert.NotNil(t, callback.Payment) {
+				return
+			}
+			assert.NotEmpty(t, callback.EventID)
+			assert.Equal(t, "primary", callback.Acquirer)
+			assert.Equal(t, acquiringbank.OperationPayment, callback.Operation)
+			assert.Equal(t, testPayment.ID, callback.Payment.ID)
+			assert.Equal(t, status.PaymentStatus(c.bankConfig.UpdateToStatus), callback.Payment.PaymentStatus)
+			if c.bankConfig.UpdateToStatus == "failed" {
+				assert.Equal(t, decline.FromResponseCode(c.bankConfig.DeclineResponseCode), callback.Payment.Decline)
+			} else {
+				assert.Nil(t, callback.Payment.Decline)
+			}
+		})
+	}
+}
+
+func TestHTTPClient_CreateRefund(t *testing.T) {
+	client, callbacks := startSimulator(t, config.MockBankConfig{
+		StatusCode:                  202,
+		UpdateToStatus:              "succeeded",
+		SleepIntervalInitialRequest: 1,
+		SleepIntervalForCallback:    10,
+		ShouldRunCallback:           true,
+	})
+	refund := payment_gateway.Refund{
+		ID:         uuid.Must(uuid.Parse("0d3bd1f2-0b8e-4c3a-8d43-67b1dfd4c1a2")),
+		PaymentID:  testPayment.ID,
+		MerchantID: testPayment.MerchantID,
+		Amount: payment_gateway.Amount{
+			AmountFractional: 500,
+			CurrencyCode:     "USD",
+		},
+	}
+	res := client.CreateRefund(context.Background(), testPayment, refund, nil)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusAccepted, res.StatusCode)
+	callback, ok := waitForCallback(t, callbacks)
+	if !ok || !assert.NotNil(t, callback.Refund) {
+		return
+	}
+	assert.Equal(t, "primary", callback.Acquirer)
+	assert.Equal(t, acquiringbank.OperationRefund, callback.Operation)
+	assert.Equal(t, refund.ID, callback.Refund.ID)
+	assert.Equal(t, status.RefundSucceeded, callback.Refund.RefundStatus)
+}
+
+func TestSimulator_CallbackRetriesKeepEventID(t *testing.T) {
+	client, callbacks := startSimulator(t, config.MockBankConfig{
+		StatusCode:                  202,
+		UpdateToStatus:              "succeeded",
+		SleepIntervalInitialRequest: 1,
+		SleepIntervalForCallback:    10,
+		ShouldRunCallback:           true,
+	}, http.StatusServiceUnavailable)
+	res := client.CreatePayment(context.Background(), testPayment, nil)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusAccepted, res.StatusCode)
+	first, ok := waitForCallback(t, callbacks)
+	if !ok {
+		return
+	}
+	// The gateway answered with a server error, so the same result is sent again
+	retry, ok := waitForCallback(t, callbacks)
+	if !ok {
+		return
+	}
+	assert.NotEmpty(t, first.EventID)
+	assert.Equal(t, first.EventID, retry.EventID)
+}
+
+func TestHTTPClient_BankUnreachable(t *testing.T) {
+	client := acquiringbank.NewHTTPClient(config.BankConfig{
+		Name:    "primary",
+		URL:     "http://127.0.0.1:1",
+		Timeout: 100,
+	}, zap.NewNop())
+	res := client.CreatePayment(context.Background(), testPayment, func(payment payment_gateway.Payment) {})
+	_ = res.Body.Close()
+	// Network errors are reported as server errors, so they are retried by the domain
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+}
+
+func TestHTTPClient_SendsContextHeaders(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	bank := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(bank.Close)
+	client := acquiringbank.NewHTTPClient(config.BankConfig{URL: bank.URL, Timeout: 1000}, zap.NewNop())
+
+	ctx := kitctx.AddRequestID(context.Background(), "req-123")
+	ctx = kitctx.AddMerchantID(ctx, testPayment.MerchantID)
+	res := client.CreatePayment(ctx, testPayment, nil)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusAccepted, res.StatusCode)
+	header := <-headers
+	assert.Equal(t, "req-123", header.Get(requestid.HeaderKey))
+	assert.Equal(t, testPayment.MerchantID.String(), header.Get(acquiringbank.MerchantIDHeader))
+}
+
+func TestHTTPClient_OutcomeUnknown(t *testing.T) {
+	bank := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(bank.Close)
+
+	cases := []struct {
+		name    string
+		timeout int
+		ctx     func() (context.Context, context.CancelFunc)
+	}{
+		{
+			name:    "client_timeout",
+			timeout: 50,
+			ctx:     func() (context.Context, context.CancelFunc) { return context.Background(), func() {} },
+		},
+		{
+			name:    "context_deadline",
+			timeout: 5000,
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := acquiringbank.NewHTTPClient(config.BankConfig{URL: bank.URL, Timeout: c.timeout}, zap.NewNop())
+			ctx, cancel := c.ctx()
+			defer cancel()
+			res := client.CreatePayment(ctx, testPayment, nil)
+			_ = res.Body.Close()
+			// The request reached the bank, so it is not reported as unreachable
+			assert.Equal(t, http.StatusGatewayTimeout, res.StatusCode)
+		})
+	}
+}
+
+func TestHTTPClient_InquirePayment(t *testing.T) {
+	client, _ := startSimulator(t, config.MockBankConfig{StatusCode: 202, UpdateToStatus: "succeeded"})
+	p := testPayment
+	p.ID = uuid.New()
+
+	res := client.InquirePayment(context.Background(), p)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	res = client.CreatePayment(context.Background(), p, nil)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusAccepted, res.StatusCode)
+
+	res = client.InquirePayment(context.Background(), p)
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var inquired payment_gateway.Payment
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&inquired))
+	assert.Equal(t, p.ID, inquired.ID)
+	assert.Equal(t, status.PaymentProcessing, inquired.PaymentStatus)
+}
@@ -0,0 +1,319 @@
+package acquiringbank
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	kitctx "github.com/marioarizaj/payment-gateway/kit/ctx"
+	"github.com/marioarizaj/payment-gateway/kit/iso8583"
+	"go.uber.org/zap"
+)
+
+const (
+	mtiAuthorization      = "0100"
+	mtiFinancial          = "0200"
+	mtiCompletion         = "0220"
+	mtiReversal           = "0400"
+	processingCodePayment = "000000"
+	processingCodeRefund  = "200000"
+	// posEntryModeECommerce tells the issuer that the card details were typed by the shopper online
+	posEntryModeECommerce = "810"
+	terminalID            = "PGW00001"
+
+	ResponseCodeApproved = "00"
+)
+
+// currencyNumericCodes maps the ISO 4217 currency codes to the numeric codes sent on field 49.
+var currencyNumericCodes = map[string]string{
+	"AUD": "036",
+	"CAD": "124",
+	"CHF": "756",
+	"EUR": "978",
+	"GBP": "826",
+	"JPY": "392",
+	"USD": "840",
+}
+
+// ISOTransport exchanges ISO 8583 messages with the acquirer, returning the response to each request.
+// It stops waiting for the response when the context is done.
+type ISOTransport interface {
+	Exchange(ctx context.Context, request *iso8583.Message) (*iso8583.Message, error)
+}
+
+// StatusError is returned by transports when the acquirer answers with an error status instead of a message.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("acquirer responded with status %d", e.StatusCode)
+}
+
+// ISOClient sends the operations to the acquirer as ISO 8583 messages. The acquirer answers each request
+// synchronously, and the result is passed to the callback on its own goroutine, like the other clients do.
+type ISOClient struct {
+	transport ISOTransport
+	stan      uint32
+	logger    *zap.Logger
+}
+
+func NewISOClient(transport ISOTransport, l *zap.Logger) *ISOClient {
+	return &ISOClient{
+		transport: transport,
+		logger:    l,
+	}
+}
+
+// CreatePayment sends a 0200 financial request, or a 0100 authorization request for payments captured manually.
+func (c *ISOClient) CreatePayment(ctx context.Context, payment payment_gateway.Payment, callBack func(payment payment_gateway.Payment)) http.Response {
+	mti := mtiFinancial
+	if payment.CaptureMethod == payment_gateway.CaptureMethodManual {
+		mti = mtiAuthorization
+	}
+	req, err := c.newRequest(mti, processingCodePayment, payment.Amount, payment.ID.String(), payment.MerchantID.String())
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, err)
+	}
+	req.Set(iso8583.FieldPAN, payment.CardInfo.CardNumber)
+	req.Set(iso8583.FieldExpiryDate, fmt.Sprintf("%02d%02d", payment.CardInfo.ExpiryYear%100, payment.CardInfo.ExpiryMonth))
+	req.Set(iso8583.FieldPOSEntryMode, posEntryModeECommerce)
+	res, errRes := c.exchange(ctx, req)
+	if errRes != nil {
+		return *errRes
+	}
+	go func() {
+		payment.PaymentStatus, payment.Decline = paymentResult(res)
+		if payment.PaymentStatus == status.PaymentSucceeded {
+			payment.AcquirerReference = authorizationReference(req, res)
+		}
+		callBack(payment)
+	}()
+	return acceptedResponse(res)
+}
+
+// CapturePayment sends a 0220 completion for the captured amount of an authorized payment, referring to its authorization.
+func (c *ISOClient) CapturePayment(ctx context.Context, payment payment_gateway.Payment, callBack func(payment payment_gateway.Payment)) http.Response {
+	if payment.AcquirerReference == nil {
+		return errorResponse(http.StatusBadRequest, fmt.Errorf("the authorization of payment %s is not known", payment.ID))
+	}
+	amount := payment_gateway.Amount{AmountFractional: payment.AmountCaptured, CurrencyCode: payment.Amount.CurrencyCode}
+	req, err := c.newRequest(mtiCompletion, processingCodePayment, amount, payment.ID.String(), payment.MerchantID.String())
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, err)
+	}
+	setOriginalData(req, payment.AcquirerReference)
+	res, errRes := c.exchange(ctx, req)
+	if errRes != nil {
+		return *errRes
+	}
+	go func() {
+		payment.PaymentStatus, payment.Decline = paymentResult(res)
+		callBack(payment)
+	}()
+	return acceptedResponse(res)
+}
+
+// CreateRefund sends a 0200 financial request with the refund processing code to the card of the payment, referring to
+// the authorization of the payment when it is known.
+func (c *ISOClient) CreateRefund(ctx context.Context, payment payment_gateway.Payment, refund payment_gateway.Refund, callBack func(refund payment_gateway.Refund)) http.Response {
+	req, err := c.newRequest(mtiFinancial, processingCodeRefund, refund.Amount, refund.ID.String(), refund.MerchantID.String())
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, err)
+	}
+	req.Set(iso8583.FieldPAN, payment.CardInfo.CardNumber)
+	req.Set(iso8583.FieldExpiryDate, fmt.Sprintf("%02d%02d", payment.CardInfo.ExpiryYear%100, payment.CardInfo.ExpiryMonth))
+	req.Set(iso8583.FieldPOSEntryMode, posEntryModeECommerce)
+	if payment.AcquirerReference != nil {
+		setOriginalData(req, payment.AcquirerReference)
+	}
+	res, errRes := c.exchange(ctx, req)
+	if errRes != nil {
+		return *errRes
+	}
+	go func() {
+		refund.RefundStatus = status.RefundSucceeded
+		refund.Decline = nil
+		if code := res.Get(iso8583.FieldResponseCode); code != ResponseCodeApproved {
+			refund.RefundStatus = status.RefundFailed
+			refund.Decline = decline.FromResponseCode(code)
+		}
+		callBack(refund)
+	}()
+	return acceptedResponse(res)
+}
+
+// CancelPayment sends a 0400 reversal, which the acquirer answers synchronously. Reversals of authorized payments refer
+// to their authorization, while the payments whose authorization did not answer are only found by their id on field 48.
+func (c *ISOClient) CancelPayment(ctx context.Context, payment payment_gateway.Payment) http.Response {
+	req, err := c.newRequest(mtiReversal, processingCodePayment, payment.Amount, payment.ID.String(), payment.MerchantID.String())
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, err)
+	}
+	if payment.AcquirerReference != nil {
+		setOriginalData(req, payment.AcquirerReference)
+	}
+	res, errRes := c.exchange(ctx, req)
+	if errRes != nil {
+		return *errRes
+	}
+	if code := res.Get(iso8583.FieldResponseCode); code != ResponseCodeApproved {
+		return errorResponse(http.StatusBadRequest, errors.New(decline.FromResponseCode(code).Message))
+	}
+	return jsonResponse(http.StatusOK, res)
+}
+
+// InquirePayment is not supported by the acquirer, so payments whose outcome is unknown are reversed instead.
+func (c *ISOClient) InquirePayment(_ context.Context, payment payment_gateway.Payment) http.Response {
+	return errorResponse(http.StatusNotImplemented, fmt.Errorf("status inquiries are not supported on ISO 8583, payment %s should be reversed", payment.ID))
+}
+
+// newRequest returns a request with the fields every operation sends. The id of the payment or refund
+// is sent on the private field 48, so the acquirer can link the operations of a payment.
+func (c *ISOClient) newRequest(mti, processingCode string, amount payment_gateway.Amount, id, merchantID string) (*iso8583.Message, error) {
+	currency, ok := currencyNumericCodes[amount.CurrencyCode]
+	if !ok {
+		return nil, fmt.Errorf("currency %s is not supported by the acquirer", amount.CurrencyCode)
+	}
+	now := time.Now().UTC()
+	// STANs go from 000001 to 999999, since many acquirers reject 000000 as a trace number
+	stan := fmt.Sprintf("%06d", atomic.AddUint32(&c.stan, 1)%999999+1)
+	req := iso8583.NewMessage(mti)
+	req.Set(iso8583.FieldProcessingCode, processingCode)
+	req.Set(iso8583.FieldAmount, fmt.Sprintf("%012d", amount.AmountFractional))
+	req.Set(iso8583.FieldTransmissionDateTime, now.Format("0102150405"))
+	req.Set(iso8583.FieldSTAN, stan)
+	req.Set(iso8583.FieldLocalTime, now.Format("150405"))
+	req.Set(iso8583.FieldLocalDate, now.Format("0102"))
+	// The retrieval reference number is the last digit of the year, the day of the year, the hour and the STAN
+	req.Set(iso8583.FieldRRN, fmt.Sprintf("%s%03d%s%s", now.Format("06")[1:], now.YearDay(), now.Format("15"), stan))
+	req.Set(iso8583.FieldTerminalID, terminalID)
+	req.Set(iso8583.FieldCardAcceptorID, strings.ReplaceAll(merchantID, "-", "")[:15])
+	req.Set(iso8583.FieldAdditionalData, id)
+	req.Set(iso8583.FieldCurrencyCode, currency)
+	return req, nil
+}
+
+// authorizationReference returns what identifies an approved authorization, from its request and response.
+func authorizationReference(req, res *iso8583.Message) *repositiory.AcquirerReference {
+	rrn := res.Get(iso8583.FieldRRN)
+	if rrn == "" {
+		rrn = req.Get(iso8583.FieldRRN)
+	}
+	return &repositiory.AcquirerReference{
+		MTI:                  req.MTI,
+		STAN:                 req.Get(iso8583.FieldSTAN),
+		RRN:                  rrn,
+		TransmissionDateTime: req.Get(iso8583.FieldTransmissionDateTime),
+		AuthorizationCode:    res.Get(iso8583.FieldAuthorizationCode),
+	}
+}
+
+// setOriginalData refers the request to the authorization: the original data elements go on field 90, and the
+// request keeps the RRN and authorization code of the authorization, so the acquirer finds it.
+func setOriginalData(req *iso8583.Message, reference *repositiory.AcquirerReference) {
+	req.Set(iso8583.FieldOriginalDataElements, iso8583.OriginalDataElements(reference.MTI, reference.STAN, reference.TransmissionDateTime))
+	if reference.RRN != "" {
+		req.Set(iso8583.FieldRRN, reference.RRN)
+	}
+	if reference.AuthorizationCode != "" {
+		req.Set(iso8583.FieldAuthorizationCode, reference.AuthorizationCode)
+	}
+}
+
+// exchange sends the request to the acquirer. When there is no response message, it returns the http response
+// to give to the domain: the status of the acquirer, 504 Gateway Timeout when the response did not arrive in time,
+// or 503 Service Unavailable when it could not be reached.
+func (c *ISOClient) exchange(ctx context.Context, req *iso8583.Message) (*iso8583.Message, *http.Response) {
+	res, err := c.transport.Exchange(ctx, req)
+	if err != nil {
+		requestID, _ := kitctx.GetRequestID(ctx)
+		c.logger.Error("ISO 8583 exchange failed", zap.String("mti", req.MTI), zap.String("request_id", requestID), zap.Error(err))
+		statusCode := networkErrorStatus(err)
+		var statusErr StatusError
+		if errors.As(err, &statusErr) {
+			statusCode = statusErr.StatusCode
+		}
+		errRes := errorResponse(statusCode, err)
+		return nil, &errRes
+	}
+	return res, nil
+}
+
+// paymentResult returns the status of a payment from the response code of the acquirer.
+func paymentResult(res *iso8583.Message) (status.PaymentStatus, *decline.Decline) {
+	code := res.Get(iso8583.FieldResponseCode)
+	if code == ResponseCodeApproved {
+		return status.PaymentSucceeded, nil
+	}
+	return status.PaymentFailed, decline.FromResponseCode(code)
+}
+
+func acceptedResponse(res *iso8583.Message) http.Response {
+	return jsonResponse(http.StatusAccepted, res)
+}
+
+func jsonResponse(statusCode int, res *iso8583.Message) http.Response {
+	bts, _ := json.Marshal(map[string]string{
+		"response_code": res.Get(iso8583.FieldResponseCode),
+		"rrn":           res.Get(iso8583.FieldRRN),
+	})
+	return http.Response{
+		StatusCode: statusCode,
+		Body:       io.NopCloser(bytes.NewReader(bts)),
+	}
+}
+
+// HTTPISOTransport posts each packed message to the bank simulator, and reads the packed response from the body.
+type HTTPISOTransport struct {
+	url    string
+	client *http.Client
+	spec   iso8583.Spec
+}
+
+func NewHTTPISOTransport(cfg config.BankConfig, spec iso8583.Spec) *HTTPISOTransport {
+	return &HTTPISOTransport{
+		url:    cfg.URL + "/iso8583",
+		client: &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Millisecond},
+		spec:   spec,
+	}
+}
+
+func (t *HTTPISOTransport) Exchange(ctx context.Context, request *iso8583.Message) (*iso8583.Message, error) {
+	packed, err := t.spec.Pack(request)
+	if err != nil {
+		// A message that can not be packed would never be accepted, so it is not retried
+		return nil, fmt.Errorf("%w: %v", StatusError{StatusCode: http.StatusBadRequest}, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	setContextHeaders(ctx, req.Header)
+	res, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = res.Body.Close() }()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, StatusError{StatusCode: res.StatusCode}
+	}
+	return t.spec.Unpack(body)
+}
@@ -0,0 +1,405 @@
+package acquiringbank_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/acquiringbank"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"github.com/marioarizaj/payment-gateway/kit/iso8583"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// recordingTransport keeps the last request, and answers it with the given response code, along with the given
+// authorization code when the request is approved.
+type recordingTransport struct {
+	request           *iso8583.Message
+	responseCode      string
+	authorizationCode string
+}
+
+func (t *recordingTransport) Exchange(_ context.Context, request *iso8583.Message) (*iso8583.Message, error) {
+	t.request = request
+	mti, err := iso8583.ResponseMTI(request.MTI)
+	if err != nil {
+		return nil, err
+	}
+	res := iso8583.NewMessage(mti)
+	res.Set(iso8583.FieldResponseCode, t.responseCode)
+	if t.authorizationCode != "" && t.responseCode == acquiringbank.ResponseCodeApproved {
+		res.Set(iso8583.FieldAuthorizationCode, t.authorizationCode)
+	}
+	return res, nil
+}
+
+// testReference is the authorization of testPayment on the acquirer.
+var testReference = &repositiory.AcquirerReference{
+	MTI:                  "0100",
+	STAN:                 "000042",
+	RRN:                  "224509000042",
+	TransmissionDateTime: "0902091530",
+	AuthorizationCode:    "123456",
+}
+
+func startISOSimulator(t *testing.T, bankConfig config.MockBankConfig) *acquiringbank.ISOClient {
+	simulator := httptest.NewServer(acquiringbank.NewSimulator(bankConfig, "", http.DefaultClient, zap.NewNop()).Handler())
+	t.Cleanup(simulator.Close)
+	transport := acquiringbank.NewHTTPISOTransport(config.BankConfig{URL: simulator.URL, Timeout: 1000}, iso8583.DefaultSpec)
+	return acquiringbank.NewISOClient(transport, zap.NewNop())
+}
+
+func TestISOClient_CreatePayment_Request(t *testing.T) {
+	cases := []struct {
+		name          string
+		captureMethod string
+		expectedMTI   string
+	}{
+		{
+			name:          "automatic_capture_sends_financial_request",
+			captureMethod: payment_gateway.CaptureMethodAutomatic,
+			expectedMTI:   "0200",
+		},
+		{
+			name:          "manual_capture_sends_authorization_request",
+			captureMethod: payment_gateway.CaptureMethodManual,
+			expectedMTI:   "0100",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			transport := &recordingTransport{responseCode: "00"}
+			client := acquiringbank.NewISOClient(transport, zap.NewNop())
+			p := testPayment
+			p.CaptureMethod = c.captureMethod
+			res := client.CreatePayment(context.Background(), p, func(payment payment_gateway.Payment) {})
+			_ = res.Body.Close()
+			assert.Equal(t, http.StatusAccepted, res.StatusCode)
+			req := transport.request
+			assert.Equal(t, c.expectedMTI, req.MTI)
+			assert.Equal(t, "378282246310005", req.Get(iso8583.FieldPAN))
+			assert.Equal(t, "000000002000", req.Get(iso8583.FieldAmount))
+			assert.Equal(t, "2210", req.Get(iso8583.FieldExpiryDate))
+			assert.Equal(t, "840", req.Get(iso8583.FieldCurrencyCode))
+			assert.Equal(t, p.ID.String(), req.Get(iso8583.FieldAdditionalData))
+			assert.Equal(t, "6c5a19d0f1324a5", req.Get(iso8583.FieldCardAcceptorID))
+			_, err := iso8583.DefaultSpec.Pack(req)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestISOClient_CreatePayment_Reference(t *testing.T) {
+	cases := []struct {
+		name              string
+		responseCode      string
+		expectedReference bool
+	}{
+		{
+			name:              "approved_authorization_is_referenced",
+			responseCode:      "00",
+			expectedReference: true,
+		},
+		{
+			name:         "declined_authorization_has_no_reference",
+			responseCode: "05",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			transport := &recordingTransport{responseCode: c.responseCode, authorizationCode: "654321"}
+			client := acquiringbank.NewISOClient(transport, zap.NewNop())
+			p := testPayment
+			p.CaptureMethod = payment_gateway.CaptureMethodManual
+			signalChan := make(chan payment_gateway.Payment, 1)
+			res := client.CreatePayment(context.Background(), p, func(payment payment_gateway.Payment) {
+				signalChan <- payment
+			})
+			_ = res.Body.Close()
+			select {
+			case payment := <-signalChan:
+				if !c.expectedReference {
+					assert.Nil(t, payment.AcquirerReference)
+					return
+				}
+				req := transport.request
+				assert.Equal(t, &repositiory.AcquirerReference{
+					MTI:                  "0100",
+					STAN:                 req.Get(iso8583.FieldSTAN),
+					RRN:                  req.Get(iso8583.FieldRRN),
+					TransmissionDateTime: req.Get(iso8583.FieldTransmissionDateTime),
+					AuthorizationCode:    "654321",
+				}, payment.AcquirerReference)
+			case <-time.After(time.Second):
+				t.Error("callback was not run")
+			}
+		})
+	}
+}
+
+func TestISOClient_CapturePayment_Request(t *testing.T) {
+	cases := []struct {
+		name               string
+		reference          *repositiory.AcquirerReference
+		expectedStatusCode int
+	}{
+		{
+			name:               "completion_refers_to_the_authorization",
+			reference:          testReference,
+			expectedStatusCode: http.StatusAccepted,
+		},
+		{
+			name:               "unknown_authorization_is_not_completed",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			transport := &recordingTransport{responseCode: "00"}
+			client := acquiringbank.NewISOClient(transport, zap.NewNop())
+			p := testPayment
+			p.CaptureMethod = payment_gateway.CaptureMethodManual
+			p.AmountCaptured = 1500
+			p.AcquirerReference = c.reference
+			res := client.CapturePayment(context.Background(), p, func(payment payment_gateway.Payment) {})
+			_ = res.Body.Close()
+			assert.Equal(t, c.expectedStatusCode, res.StatusCode)
+			if c.reference == nil {
+				assert.Nil(t, transport.request)
+				return
+			}
+			req := transport.request
+			assert.Equal(t, "0220", req.MTI)
+			assert.Equal(t, "000000001500", req.Get(iso8583.FieldAmount))
+			assert.Equal(t, "01000000420902091530"+strings.Repeat("0", 22), req.Get(iso8583.FieldOriginalDataElements))
+			assert.Equal(t, testReference.RRN, req.Get(iso8583.FieldRRN))
+			assert.Equal(t, testReference.AuthorizationCode, req.Get(iso8583.FieldAuthorizationCode))
+			// The completion has its own STAN
+			assert.NotEqual(t, testReference.STAN, req.Get(iso8583.FieldSTAN))
+			_, err := iso8583.DefaultSpec.Pack(req)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestISOClient_CreatePayment(t *testing.T) {
+	cases := []struct {
+		name               string
+		bankConfig         config.MockBankConfig
+		currencyCode       string
+		expectedStatusCode int
+		expectedStatus     status.PaymentStatus
+		expectedDecline    *decline.Decline
+	}{
+		{
+			name: "create_payment_approved",
+			bankConfig: config.MockBankConfig{
+				StatusCode:     202,
+				UpdateToStatus: "succeeded",
+			},
+			expectedStatusCode: http.StatusAccepted,
+			expectedStatus:     status.PaymentSucceeded,
+		},
+		{
+			name: "create_payment_declined",
+			bankConfig: config.MockBankConfig{
+				StatusCode:     202,
+				UpdateToStatus: "failed",
+			},
+			expectedStatusCode: http.StatusAccepted,
+			expectedStatus:     status.PaymentFailed,
+			expectedDecline:    decline.FromResponseCode("05"),
+		},
+		{
+			name: "create_payment_bank_error",
+			bankConfig: config.MockBankConfig{
+				StatusCode: 500,
+			},
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+		{
+			name: "create_payment_unsupported_currency",
+			bankConfig: config.MockBankConfig{
+				StatusCode: 202,
+			},
+			currencyCode:       "XYZ",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := startISOSimulator(t, c.bankConfig)
+			p := testPayment
+			if c.currencyCode != "" {
+				p.Amount.CurrencyCode = c.currencyCode
+			}
+			signalChan := make(chan payment_gateway.Payment, 1)
+			res := client.CreatePayment(context.Background(), p, func(payment payment_gateway.Payment) {
+				signalChan <- payment
+			})
+			_ = res.Body.Close()
+			assert.Equal(t, c.expectedStatusCode, res.StatusCode)
+			if c.expectedStatus == "" {
+				return
+			}
+			select {
+			case payment := <-signalChan:
+				assert.Equal(t, c.expectedStatus, payment.PaymentStatus)
+				assert.Equal(t, c.expectedDecline, payment.Decline)
+			case <-time.After(time.Second):
+				t.Error("callback was not run")
+			}
+		})
+	}
+}
+
+func TestISOClient_CreateRefund(t *testing.T) {
+	client := startISOSimulator(t, config.MockBankConfig{StatusCode: 202, UpdateToStatus: "succeeded"})
+	refund := payment_gateway.Refund{
+		ID:         uuid.Must(uuid.Parse("0d3bd1f2-0b8e-4c3a-8d43-67b1dfd4c1a2")),
+		PaymentID:  testPayment.ID,
+		MerchantID: testPayment.MerchantID,
+		Amount: payment_gateway.Amount{
+			AmountFractional: 500,
+			CurrencyCode:     "USD",
+		},
+	}
+	signalChan := make(chan payment_gateway.Refund, 1)
+	res := client.CreateRefund(context.Background(), testPayment, refund, func(refund payment_gateway.Refund) {
+		signalChan <- refund
+	})
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusAccepted, res.StatusCode)
+	select {
+	case returnedRefund := <-signalChan:
+		assert.Equal(t, status.RefundSucceeded, returnedRefund.RefundStatus)
+	case <-time.After(time.Second):
+		t.Error("callback was not run")
+	}
+}
+
+func TestISOClient_CreateRefund_Request(t *testing.T) {
+	cases := []struct {
+		name                 string
+		reference            *repositiory.AcquirerReference
+		expectedOriginalData string
+	}{
+		{
+			name:                 "refund_refers_to_the_authorization",
+			reference:            testReference,
+			expectedOriginalData: "01000000420902091530" + strings.Repeat("0", 22),
+		},
+		{
+			// Payments approved before their authorization was kept are only found by the card
+			name: "refund_without_authorization",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			transport := &recordingTransport{responseCode: "00"}
+			client := acquiringbank.NewISOClient(transport, zap.NewNop())
+			p := testPayment
+			p.AcquirerReference = c.reference
+			refund := payment_gateway.Refund{
+				ID:         uuid.Must(uuid.Parse("0d3bd1f2-0b8e-4c3a-8d43-67b1dfd4c1a2")),
+				PaymentID:  p.ID,
+				MerchantID: p.MerchantID,
+				Amount:     payment_gateway.Amount{AmountFractional: 500, CurrencyCode: "USD"},
+			}
+			res := client.CreateRefund(context.Background(), p, refund, func(refund payment_gateway.Refund) {})
+			_ = res.Body.Close()
+			assert.Equal(t, http.StatusAccepted, res.StatusCode)
+			// The refund is checked as the acquirer reads it
+			packed, err := iso8583.DefaultSpec.Pack(transport.request)
+			if !assert.NoError(t, err) {
+				return
+			}
+			req, err := iso8583.DefaultSpec.Unpack(packed)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, "0200", req.MTI)
+			assert.Equal(t, "200000", req.Get(iso8583.FieldProcessingCode))
+			assert.Equal(t, "378282246310005", req.Get(iso8583.FieldPAN))
+			assert.Equal(t, "2210", req.Get(iso8583.FieldExpiryDate))
+			assert.Equal(t, "000000000500", req.Get(iso8583.FieldAmount))
+			assert.Equal(t, refund.ID.String(), req.Get(iso8583.FieldAdditionalData))
+			assert.Equal(t, c.expectedOriginalData, req.Get(iso8583.FieldOriginalDataElements))
+			if c.reference != nil {
+				assert.Equal(t, c.reference.RRN, req.Get(iso8583.FieldRRN))
+				assert.Equal(t, c.reference.AuthorizationCode, req.Get(iso8583.FieldAuthorizationCode))
+			}
+		})
+	}
+}
+
+func TestISOClient_CancelPayment(t *testing.T) {
+	cases := []struct {
+		name                 string
+		responseCode         string
+		reference            *repositiory.AcquirerReference
+		expectedStatusCode   int
+		expectedOriginalData string
+	}{
+		{
+			name:                 "reversal_approved",
+			responseCode:         "00",
+			reference:            testReference,
+			expectedStatusCode:   http.StatusOK,
+			expectedOriginalData: "01000000420902091530" + strings.Repeat("0", 22),
+		},
+		{
+			name:                 "reversal_declined",
+			responseCode:         "57",
+			reference:            testReference,
+			expectedStatusCode:   http.StatusBadRequest,
+			expectedOriginalData: "01000000420902091530" + strings.Repeat("0", 22),
+		},
+		{
+			// Payments whose authorization did not answer are only found by their id
+			name:               "reversal_without_authorization",
+			responseCode:       "00",
+			expectedStatusCode: http.StatusOK,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			transport := &recordingTransport{responseCode: c.responseCode}
+			client := acquiringbank.NewISOClient(transport, zap.NewNop())
+			p := testPayment
+			p.AcquirerReference = c.reference
+			res := client.CancelPayment(context.Background(), p)
+			_ = res.Body.Close()
+			assert.Equal(t, c.expectedStatusCode, res.StatusCode)
+			req := transport.request
+			assert.Equal(t, "0400", req.MTI)
+			assert.Equal(t, c.expectedOriginalData, req.Get(iso8583.FieldOriginalDataElements))
+			assert.Equal(t, p.ID.String(), req.Get(iso8583.FieldAdditionalData))
+			if c.reference != nil {
+				assert.Equal(t, c.reference.AuthorizationCode, req.Get(iso8583.FieldAuthorizationCode))
+				assert.Equal(t, c.reference.RRN, req.Get(iso8583.FieldRRN))
+			}
+			_, err := iso8583.DefaultSpec.Pack(req)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestISOClient_InquirePayment(t *testing.T) {
+	transport := &recordingTransport{responseCode: "00"}
+	client := acquiringbank.NewISOClient(transport, zap.NewNop())
+	res := client.InquirePayment(context.Background(), testPayment)
+	_ = res.Body.Close()
+	// Payments are reversed instead, so nothing is sent to the acquirer
+	assert.Equal(t, http.StatusNotImplemented, res.StatusCode)
+	assert.Nil(t, transport.request)
+}
@@ -0,0 +1,65 @@
+package acquiringbank
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Scenario is an outcome that the simulated bank gives to the operations made with a test card number,
+// or with an amount that ends with the given digits. Scenarios take precedence over the MockBankConfig,
+// so a single running simulator can be used to reach every outcome.
+type Scenario struct {
+	Name       string
+	CardNumber string
+	// AmountSuffix matches the amounts in minor units that end with these digits, like 1051 for 51
+	AmountSuffix string
+	// ResponseCode is the ISO 8583 response code of the result. Declines use it for their failed reason
+	ResponseCode string
+	// StatusCode is the status of the response to the request, instead of 202 Accepted
+	StatusCode int
+	// Timeout means the bank does not answer the request before the client gives up
+	Timeout bool
+	// NoCallback means the request is accepted, but its result is never sent
+	NoCallback bool
+}
+
+// Scenarios are the test card numbers and amounts documented on the README. Card numbers only match payments,
+// while amounts also match the captured amount of captures and the amount of refunds.
+var Scenarios = []Scenario{
+	{Name: "insufficient_funds", CardNumber: "4000000000009995", AmountSuffix: "51", ResponseCode: "51"},
+	{Name: "do_not_honor", CardNumber: "4000000000000002", AmountSuffix: "05", ResponseCode: "05"},
+	{Name: "lost_card", CardNumber: "4000000000009987", AmountSuffix: "41", ResponseCode: "41"},
+	{Name: "stolen_card", CardNumber: "4000000000009979", AmountSuffix: "43", ResponseCode: "43"},
+	{Name: "expired_card", CardNumber: "4000000000000069", AmountSuffix: "54", ResponseCode: "54"},
+	{Name: "bank_error", CardNumber: "4000000000000119", ResponseCode: "96", StatusCode: http.StatusInternalServerError},
+	{Name: "timeout", CardNumber: "4000000000000127", Timeout: true},
+	{Name: "no_callback", CardNumber: "4000000000000101", NoCallback: true},
+}
+
+// FindScenario returns the scenario of the card number, or of the amount when the card number does not have one.
+func FindScenario(cardNumber string, amount int64) (Scenario, bool) {
+	for _, s := range Scenarios {
+		if cardNumber != "" && s.CardNumber == cardNumber {
+			return s, true
+		}
+	}
+	formattedAmount := strconv.FormatInt(amount, 10)
+	for _, s := range Scenarios {
+		// Amounts need at least one more digit, so 51 alone is not read as .51 of a decline
+		if s.AmountSuffix != "" && len(formattedAmount) > len(s.AmountSuffix) && strings.HasSuffix(formattedAmount, s.AmountSuffix) {
+			return s, true
+		}
+	}
+	return Scenario{}, false
+}
+
+// Declined tells whether the result of the scenario is a decline, and not an error or a missing result.
+func (s Scenario) Declined() bool {
+	return s.ResponseCode != "" && s.ResponseCode != ResponseCodeApproved && s.StatusCode == 0
+}
+
+// unanswered tells whether the request of the scenario never gets its result.
+func (s Scenario) unanswered() bool {
+	return s.Timeout || s.NoCallback
+}
@@ -0,0 +1,204 @@
+package acquiringbank_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/acquiringbank"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestFindScenario(t *testing.T) {
+	cases := []struct {
+		name             string
+		cardNumber       string
+		amount           int64
+		expectedFound    bool
+		expectedScenario string
+	}{
+		{
+			name:             "card_number",
+			cardNumber:       "4000000000009995",
+			amount:           2000,
+			expectedFound:    true,
+			expectedScenario: "insufficient_funds",
+		},
+		{
+			name:             "card_number_before_amount",
+			cardNumber:       "4000000000000127",
+			amount:           1054,
+			expectedFound:    true,
+			expectedScenario: "timeout",
+		},
+		{
+			name:             "amount_suffix",
+			cardNumber:       "378282246310005",
+			amount:           1043,
+			expectedFound:    true,
+			expectedScenario: "stolen_card",
+		},
+		{
+			name:          "amount_equal_to_suffix",
+			cardNumber:    "378282246310005",
+			amount:        51,
+			expectedFound: false,
+		},
+		{
+			name:          "no_scenario",
+			cardNumber:    "378282246310005",
+			amount:        2000,
+			expectedFound: false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			scenario, found := acquiringbank.FindScenario(c.cardNumber, c.amount)
+			assert.Equal(t, c.expectedFound, found)
+			assert.Equal(t, c.expectedScenario, scenario.Name)
+		})
+	}
+}
+
+func TestMockClient_Scenarios(t *testing.T) {
+	// The configuration approves everything, so the outcomes only come from the scenarios
+	bankConfig := config.MockBankConfig{
+		StatusCode:                  202,
+		UpdateToStatus:              "succeeded",
+		SleepIntervalInitialRequest: 1,
+		SleepIntervalForCallback:    10,
+		ShouldRunCallback:           true,
+		ScenarioTimeout:             20,
+	}
+	cases := []struct {
+		name               string
+		cardNumber         string
+		amount             int64
+		expectedStatusCode int
+		expectedCallback   bool
+		expectedDecline    *decline.Decline
+	}{
+		{
+			name:               "insufficient_funds_card",
+			cardNumber:         "4000000000009995",
+			amount:             2000,
+			expectedStatusCode: http.StatusAccepted,
+			expectedCallback:   true,
+			expectedDecline:    decline.FromResponseCode("51"),
+		},
+		{
+			name:               "expired_card_amount",
+			cardNumber:         testPayment.CardInfo.CardNumber,
+			amount:             2054,
+			expectedStatusCode: http.StatusAccepted,
+			expectedCallback:   true,
+			expectedDecline:    decline.FromResponseCode("54"),
+		},
+		{
+			name:               "bank_error",
+			cardNumber:         "4000000000000119",
+			amount:             2000,
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+		{
+			name:               "timeout",
+			cardNumber:         "4000000000000127",
+			amount:             2000,
+			expectedStatusCode: http.StatusGatewayTimeout,
+		},
+		{
+			name:               "no_callback",
+			cardNumber:         "4000000000000101",
+			amount:             2000,
+			expectedStatusCode: http.StatusAccepted,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			signalChan := make(chan payment_gateway.Payment, 1)
+			p := testPayment
+			p.CardInfo.CardNumber = c.cardNumber
+			p.Amount.AmountFractional = c.amount
+			res := acquiringbank.NewMockClient(bankConfig).CreatePayment(context.Background(), p, func(payment payment_gateway.Payment) {
+				signalChan <- payment
+			})
+			assert.Equal(t, c.expectedStatusCode, res.StatusCode)
+			select {
+			case payment := <-signalChan:
+				assert.True(t, c.expectedCallback, "callback should not run")
+				assert.Equal(t, status.PaymentFailed, payment.PaymentStatus)
+				assert.Equal(t, c.expectedDecline, payment.Decline)
+			case <-time.After(100 * time.Millisecond):
+				assert.False(t, c.expectedCallback, "callback was not run")
+			}
+		})
+	}
+}
+
+func TestISOClient_Scenarios_TCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		_ = acquiringbank.NewSimulator(config.MockBankConfig{StatusCode: 202, UpdateToStatus: "succeeded"}, "", http.DefaultClient, zap.NewNop()).ServeTCP(listener)
+	}()
+	transport := startTCPTransport(t, config.BankConfig{TCPAddress: listener.Addr().String(), Timeout: 100})
+	client := acquiringbank.NewISOClient(transport, zap.NewNop())
+
+	cases := []struct {
+		name               string
+		cardNumber         string
+		expectedStatusCode int
+		expectedDecline    *decline.Decline
+	}{
+		{
+			name:               "do_not_honor",
+			cardNumber:         "4000000000000002",
+			expectedStatusCode: http.StatusAccepted,
+			expectedDecline:    decline.FromResponseCode("05"),
+		},
+		{
+			name:               "bank_error",
+			cardNumber:         "4000000000000119",
+			expectedStatusCode: http.StatusAccepted,
+			expectedDecline:    decline.FromResponseCode("96"),
+		},
+		{
+			// The request is never answered, and the transport gives up after its timeout without knowing the outcome
+			name:               "timeout",
+			cardNumber:         "4000000000000127",
+			expectedStatusCode: http.StatusGatewayTimeout,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			signalChan := make(chan payment_gateway.Payment, 1)
+			p := testPayment
+			p.CardInfo.CardNumber = c.cardNumber
+			res := client.CreatePayment(context.Background(), p, func(payment payment_gateway.Payment) {
+				signalChan <- payment
+			})
+			_ = res.Body.Close()
+			assert.Equal(t, c.expectedStatusCode, res.StatusCode)
+			if c.expectedStatusCode != http.StatusAccepted {
+				return
+			}
+			select {
+			case payment := <-signalChan:
+				assert.Equal(t, status.PaymentFailed, payment.PaymentStatus)
+				assert.Equal(t, c.expectedDecline, payment.Decline)
+			case <-time.After(time.Second):
+				t.Error("callback was not run")
+			}
+		})
+	}
+}
@@ -0,0 +1,357 @@
+package acquiringbank
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"github.com/marioarizaj/payment-gateway/kit/auth"
+	"github.com/marioarizaj/payment-gateway/kit/iso8583"
+	"go.uber.org/zap"
+)
+
+// isoEchoedFields are copied from the requests to their responses.
+var isoEchoedFields = []int{
+	iso8583.FieldProcessingCode,
+	iso8583.FieldAmount,
+	iso8583.FieldTransmissionDateTime,
+	iso8583.FieldSTAN,
+	iso8583.FieldLocalTime,
+	iso8583.FieldLocalDate,
+	iso8583.FieldRRN,
+	iso8583.FieldTerminalID,
+	iso8583.FieldCardAcceptorID,
+	iso8583.FieldAdditionalData,
+	iso8583.FieldCurrencyCode,
+	iso8583.FieldOriginalDataElements,
+}
+
+const (
+	callbackAttempts       = 5
+	callbackInitialBackoff = 500 * time.Millisecond
+)
+
+// Simulator is the acquiring bank served over HTTP by cmd/bank_simulator. It decides the outcome of the operations
+// the same way as the MockClient, and posts their results to the callback url of each request, signed with the callback secret.
+type Simulator struct {
+	bank           *MockClient
+	callbackSecret string
+	client         *http.Client
+	logger         *zap.Logger
+}
+
+func NewSimulator(cfg config.MockBankConfig, callbackSecret string, client *http.Client, l *zap.Logger) *Simulator {
+	return &Simulator{
+		bank:           NewMockClient(cfg),
+		callbackSecret: callbackSecret,
+		client:         client,
+		logger:         l,
+	}
+}
+
+func (s *Simulator) Handler() http.Handler {
+	r := mux.NewRouter()
+	r.HandleFunc("/payments", s.createPayment).Methods(http.MethodPost)
+	r.HandleFunc("/payments/{id}", s.inquirePayment).Methods(http.MethodGet)
+	r.HandleFunc("/payments/{id}/capture", s.capturePayment).Methods(http.MethodPost)
+	r.HandleFunc("/payments/{id}/cancel", s.cancelPayment).Methods(http.MethodPost)
+	r.HandleFunc("/refunds", s.createRefund).Methods(http.MethodPost)
+	r.HandleFunc("/iso8583", s.exchangeISO).Methods(http.MethodPost)
+	return r
+}
+
+// exchangeISO answers an ISO 8583 request with its response message. The outcome is decided by the same
+// scenarios and configuration as the other operations: error status codes are returned as they are, instead of a message.
+// The results of ISO 8583 requests are only given on their responses, so a result that never arrives is a response
+// that does not arrive in time.
+func (s *Simulator) exchangeISO(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	req, err := iso8583.DefaultSpec.Unpack(body)
+	if err != nil {
+		s.logger.Warn("Could not unpack ISO 8583 request", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	scenario, hasScenario := isoScenario(req)
+	if hasScenario && scenario.unanswered() {
+		time.Sleep(s.bank.ScenarioTimeout)
+		w.WriteHeader(http.StatusGatewayTimeout)
+		return
+	}
+	time.Sleep(s.bank.SleepIntervalInitialRequest)
+	statusCode := s.bank.StatusCode
+	if hasScenario {
+		statusCode = scenario.StatusCode
+	}
+	if statusCode > 299 {
+		w.WriteHeader(statusCode)
+		return
+	}
+	res, err := s.isoResponse(req)
+	if err != nil {
+		s.logger.Warn("Could not answer ISO 8583 request", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	packed, err := iso8583.DefaultSpec.Pack(res)
+	if err != nil {
+		s.logger.Error("Could not pack ISO 8583 response", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = w.Write(packed)
+}
+
+// ServeTCP answers the ISO 8583 messages sent on the connections accepted by the listener, until it is closed.
+// Each request is answered on its own goroutine, so responses can be written in a different order than their requests,
+// like a real acquirer does. Error status codes are answered with the response codes 96, or 30 for client errors.
+func (s *Simulator) ServeTCP(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.serveTCPConnection(conn)
+	}
+}
+
+func (s *Simulator) serveTCPConnection(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	var writeLock sync.Mutex
+	r := bufio.NewReader(conn)
+	for {
+		frame, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		req, err := iso8583.DefaultSpec.Unpack(frame)
+		if err != nil {
+			s.logger.Warn("Could not unpack ISO 8583 request", zap.Error(err))
+			continue
+		}
+		go func() {
+			res, err := s.tcpResponse(req)
+			if err != nil {
+				s.logger.Warn("Could not answer ISO 8583 request", zap.Error(err))
+				return
+			}
+			if res == nil {
+				return
+			}
+			packed, err := iso8583.DefaultSpec.Pack(res)
+			if err != nil {
+				s.logger.Error("Could not pack ISO 8583 response", zap.Error(err))
+				return
+			}
+			writeLock.Lock()
+			defer writeLock.Unlock()
+			if err = writeFrame(conn, packed); err != nil {
+				s.logger.Warn("Could not write ISO 8583 response", zap.Error(err))
+			}
+		}()
+	}
+}
+
+func (s *Simulator) tcpResponse(req *iso8583.Message) (*iso8583.Message, error) {
+	if req.MTI == mtiNetworkManagement {
+		return echoResponse(req)
+	}
+	scenario, hasScenario := isoScenario(req)
+	if hasScenario && scenario.unanswered() {
+		// The request is left without a response, so it times out on the gateway
+		return nil, nil
+	}
+	time.Sleep(s.bank.SleepIntervalInitialRequest)
+	res, err := s.isoResponse(req)
+	if err != nil {
+		return nil, err
+	}
+	// The response codes of scenarios are already set by isoResponse
+	if !hasScenario && s.bank.StatusCode > 499 {
+		res.Set(iso8583.FieldResponseCode, "96")
+	} else if !hasScenario && s.bank.StatusCode > 299 {
+		res.Set(iso8583.FieldResponseCode, "30")
+	}
+	if res.Get(iso8583.FieldResponseCode) != ResponseCodeApproved {
+		delete(res.Fields, iso8583.FieldAuthorizationCode)
+	}
+	return res, nil
+}
+
+// isoScenario finds the scenario of an authorization, financial or completion request from its card number and amount.
+// Refunds are found by their amount, and reversals are left to the configuration, like on the MockClient.
+func isoScenario(req *iso8583.Message) (Scenario, bool) {
+	switch req.MTI {
+	case mtiAuthorization, mtiFinancial, mtiCompletion:
+	default:
+		return Scenario{}, false
+	}
+	amount, _ := strconv.ParseInt(req.Get(iso8583.FieldAmount), 10, 64)
+	pan := req.Get(iso8583.FieldPAN)
+	if req.Get(iso8583.FieldProcessingCode) == processingCodeRefund {
+		pan = ""
+	}
+	return FindScenario(pan, amount)
+}
+
+// isoResponse echoes the fields that identify the request, and adds the authorization and response codes.
+func (s *Simulator) isoResponse(req *iso8583.Message) (*iso8583.Message, error) {
+	mti, err := iso8583.ResponseMTI(req.MTI)
+	if err != nil {
+		return nil, err
+	}
+	res := iso8583.NewMessage(mti)
+	for _, field := range isoEchoedFields {
+		if req.Has(field) {
+			res.Set(field, req.Get(field))
+		}
+	}
+	responseCode := ResponseCodeApproved
+	if scenario, ok := isoScenario(req); ok {
+		responseCode = scenario.ResponseCode
+	} else if s.bank.NewStatus != "" && s.bank.NewStatus != string(status.PaymentSucceeded) {
+		responseCode = s.bank.DeclineResponseCode
+		if responseCode == "" {
+			responseCode = defaultDeclineResponseCode
+		}
+	}
+	if responseCode == ResponseCodeApproved {
+		res.Set(iso8583.FieldAuthorizationCode, fmt.Sprintf("%06d", rand.Intn(1000000)))
+	}
+	res.Set(iso8583.FieldResponseCode, responseCode)
+	return res, nil
+}
+
+func (s *Simulator) createPayment(w http.ResponseWriter, r *http.Request) {
+	var req PaymentRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	res := s.bank.CreatePayment(r.Context(), req.Payment, func(payment payment_gateway.Payment) {
+		s.sendCallback(req.CallbackURL, Callback{Acquirer: req.Acquirer, Operation: OperationPayment, Payment: &payment})
+	})
+	writeResponse(w, res)
+}
+
+func (s *Simulator) capturePayment(w http.ResponseWriter, r *http.Request) {
+	var req PaymentRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	res := s.bank.CapturePayment(r.Context(), req.Payment, func(payment payment_gateway.Payment) {
+		s.sendCallback(req.CallbackURL, Callback{Acquirer: req.Acquirer, Operation: OperationCapture, Payment: &payment})
+	})
+	writeResponse(w, res)
+}
+
+func (s *Simulator) cancelPayment(w http.ResponseWriter, r *http.Request) {
+	var req PaymentRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	writeResponse(w, s.bank.CancelPayment(r.Context(), req.Payment))
+}
+
+func (s *Simulator) inquirePayment(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	writeResponse(w, s.bank.InquirePayment(r.Context(), payment_gateway.Payment{ID: id}))
+}
+
+func (s *Simulator) createRefund(w http.ResponseWriter, r *http.Request) {
+	var req RefundRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	res := s.bank.CreateRefund(r.Context(), req.Payment, req.Refund, func(refund payment_gateway.Refund) {
+		s.sendCallback(req.CallbackURL, Callback{Acquirer: req.Acquirer, Operation: OperationRefund, Refund: &refund})
+	})
+	writeResponse(w, res)
+}
+
+// sendCallback posts the result to the gateway, retrying with a growing backoff while the gateway is unreachable
+// or answers with a server error, so results are not lost when the gateway restarts. Every attempt has the same
+// event id, so the gateway can tell that a result it already applied is being sent again.
+func (s *Simulator) sendCallback(callbackURL string, callback Callback) {
+	callback.EventID = uuid.New().String()
+	bts, err := json.Marshal(callback)
+	if err != nil {
+		s.logger.Error("Could not encode callback", zap.Error(err))
+		return
+	}
+	backoff := callbackInitialBackoff
+	for attempt := 1; attempt <= callbackAttempts; attempt++ {
+		err = s.postCallback(callbackURL, bts)
+		if err == nil {
+			return
+		}
+		s.logger.Warn("Callback was not delivered", zap.Int("attempt", attempt), zap.Error(err))
+		if attempt < callbackAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	s.logger.Error("Giving up on callback", zap.String("operation", callback.Operation), zap.String("callback_url", callbackURL))
+}
+
+func (s *Simulator) postCallback(callbackURL string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// Each attempt is signed again, so retries are not rejected as stale
+	req.Header.Set(CallbackSignatureHeader, auth.SignPayload(s.callbackSecret, time.Now(), body))
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = res.Body.Close() }()
+	_, _ = io.Copy(io.Discard, res.Body)
+	// Client errors mean the gateway will never accept this result, so it is not retried
+	if res.StatusCode > 499 {
+		return fmt.Errorf("gateway responded with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+func decodeRequest(w http.ResponseWriter, r *http.Request, dest interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(dest)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeResponse(w http.ResponseWriter, res http.Response) {
+	defer func() { _ = res.Body.Close() }()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(res.StatusCode)
+	_, _ = io.Copy(w, res.Body)
+}
@@ -0,0 +1,356 @@
+package acquiringbank
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/kit/iso8583"
+	"go.uber.org/zap"
+)
+
+const (
+	mtiNetworkManagement = "0800"
+	// networkManagementEcho is the network management code of the echo test, used as a heartbeat
+	networkManagementEcho = "301"
+	maxFrameLength        = 1<<16 - 1
+)
+
+var (
+	ErrNotConnected    = errors.New("acquirer link is not connected")
+	ErrTooManyInFlight = errors.New("too many messages waiting for a response on the acquirer link")
+	ErrLinkClosed      = errors.New("acquirer link was closed before the response arrived")
+	ErrResponseTimeout = errors.New("no response from the acquirer before the timeout")
+)
+
+// TCPTransport keeps a persistent TCP connection to the acquirer, and sends every message over it.
+// Messages are framed with a 2 byte length prefix, and responses are matched to their request by STAN and RRN,
+// so many requests can wait for their response at the same time. The connection is checked with 0800 echo
+// messages, and it is opened again with a growing backoff when it is lost.
+type TCPTransport struct {
+	address                 string
+	spec                    iso8583.Spec
+	timeout                 time.Duration
+	maxInFlight             int
+	heartbeatInterval       time.Duration
+	reconnectInitialBackoff time.Duration
+	reconnectMaxBackoff     time.Duration
+	logger                  *zap.Logger
+
+	lock    sync.RWMutex
+	current *tcpConnection
+	stan    uint32
+	closing chan struct{}
+	stopped chan struct{}
+}
+
+func NewTCPTransport(cfg config.BankConfig, spec iso8583.Spec, l *zap.Logger) *TCPTransport {
+	return &TCPTransport{
+		address:                 cfg.TCPAddress,
+		spec:                    spec,
+		timeout:                 time.Duration(cfg.Timeout) * time.Millisecond,
+		maxInFlight:             cfg.MaxInFlight,
+		heartbeatInterval:       time.Duration(cfg.HeartbeatInterval) * time.Millisecond,
+		reconnectInitialBackoff: time.Duration(cfg.ReconnectInitialBackoff) * time.Millisecond,
+		reconnectMaxBackoff:     time.Duration(cfg.ReconnectMaxBackoff) * time.Millisecond,
+		logger:                  l,
+		closing:                 make(chan struct{}),
+		stopped:                 make(chan struct{}),
+	}
+}
+
+// Start connects to the acquirer on the background, and keeps reconnecting until Close is called.
+func (t *TCPTransport) Start() {
+	go t.run()
+}
+
+// Close closes the connection and stops reconnecting. Messages waiting for a response get ErrLinkClosed.
+func (t *TCPTransport) Close() {
+	close(t.closing)
+	if c := t.connection(); c != nil {
+		c.close()
+	}
+	<-t.stopped
+}
+
+// Connected tells whether there is an open connection to the acquirer.
+func (t *TCPTransport) Connected() bool {
+	return t.connection() != nil
+}
+
+// Exchange sends the request on the current connection and waits for its response, up to the configured timeout
+// or until the context is done, whichever comes first.
+func (t *TCPTransport) Exchange(ctx context.Context, request *iso8583.Message) (*iso8583.Message, error) {
+	c := t.connection()
+	if c == nil {
+		return nil, ErrNotConnected
+	}
+	return c.exchange(ctx, request, t.timeout)
+}
+
+func (t *TCPTransport) connection() *tcpConnection {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.current
+}
+
+func (t *TCPTransport) setConnection(c *tcpConnection) {
+	t.lock.Lock()
+	t.current = c
+	t.lock.Unlock()
+}
+
+// run opens the connection and waits until it is lost, doubling the wait between attempts that fail.
+func (t *TCPTransport) run() {
+	defer close(t.stopped)
+	backoff := t.reconnectInitialBackoff
+	for {
+		select {
+		case <-t.closing:
+			return
+		default:
+		}
+		conn, err := net.DialTimeout("tcp", t.address, t.timeout)
+		if err != nil {
+			t.logger.Warn("Could not connect to the acquirer", zap.String("address", t.address), zap.Duration("retry_in", backoff), zap.Error(err))
+			select {
+			case <-t.closing:
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > t.reconnectMaxBackoff {
+				backoff = t.reconnectMaxBackoff
+			}
+			continue
+		}
+		backoff = t.reconnectInitialBackoff
+		c := newTCPConnection(conn, t.spec, t.maxInFlight, t.logger)
+		t.setConnection(c)
+		t.logger.Info("Connected to the acquirer", zap.String("address", t.address))
+		go c.readLoop()
+		t.heartbeat(c)
+		t.setConnection(nil)
+		t.logger.Warn("Connection to the acquirer was lost", zap.String("address", t.address))
+	}
+}
+
+// heartbeat sends an echo message on every interval, until the connection is closed or an echo is not answered.
+func (t *TCPTransport) heartbeat(c *tcpConnection) {
+	ticker := time.NewTicker(t.heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.closing:
+			c.close()
+			return
+		case <-ticker.C:
+			echo := iso8583.NewMessage(mtiNetworkManagement)
+			echo.Set(iso8583.FieldTransmissionDateTime, time.Now().UTC().Format("0102150405"))
+			echo.Set(iso8583.FieldSTAN, fmt.Sprintf("%06d", atomic.AddUint32(&t.stan, 1)%1000000))
+			echo.Set(iso8583.FieldNetworkManagementCode, networkManagementEcho)
+			_, err := c.exchange(context.Background(), echo, t.timeout)
+			if err != nil {
+				t.logger.Warn("Echo to the acquirer failed, closing the connection", zap.Error(err))
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+// tcpConnection is a single TCP connection to the acquirer, with the requests that are waiting for their response.
+type tcpConnection struct {
+	conn      net.Conn
+	spec      iso8583.Spec
+	logger    *zap.Logger
+	writeLock sync.Mutex
+	lock      sync.Mutex
+	pending   map[string]chan *iso8583.Message
+	inFlight  chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newTCPConnection(conn net.Conn, spec iso8583.Spec, maxInFlight int, l *zap.Logger) *tcpConnection {
+	return &tcpConnection{
+		conn:     conn,
+		spec:     spec,
+		logger:   l,
+		pending:  map[string]chan *iso8583.Message{},
+		inFlight: make(chan struct{}, maxInFlight),
+		done:     make(chan struct{}),
+	}
+}
+
+func (c *tcpConnection) exchange(ctx context.Context, request *iso8583.Message, timeout time.Duration) (*iso8583.Message, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case c.inFlight <- struct{}{}:
+		defer func() { <-c.inFlight }()
+	default:
+		return nil, ErrTooManyInFlight
+	}
+
+	key := matchKey(request)
+	responseChan := make(chan *iso8583.Message, 1)
+	c.lock.Lock()
+	if _, ok := c.pending[key]; ok {
+		c.lock.Unlock()
+		return nil, fmt.Errorf("a message with stan and rrn %s is already waiting for a response", key)
+	}
+	c.pending[key] = responseChan
+	c.lock.Unlock()
+	defer func() {
+		c.lock.Lock()
+		delete(c.pending, key)
+		c.lock.Unlock()
+	}()
+
+	packed, err := c.spec.Pack(request)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", StatusError{StatusCode: http.StatusBadRequest}, err)
+	}
+	err = c.write(packed, timeout)
+	if err != nil {
+		c.close()
+		return nil, err
+	}
+
+	select {
+	case res := <-responseChan:
+		return res, nil
+	case <-c.done:
+		return nil, ErrLinkClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		return nil, fmt.Errorf("%w after %s", ErrResponseTimeout, timeout)
+	}
+}
+
+func (c *tcpConnection) write(packed []byte, timeout time.Duration) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(timeout))
+	return writeFrame(c.conn, packed)
+}
+
+// readLoop reads the messages sent by the acquirer, until the connection is closed. Responses are given to the
+// request waiting for them, and echo requests from the acquirer are answered.
+func (c *tcpConnection) readLoop() {
+	defer c.close()
+	r := bufio.NewReader(c.conn)
+	for {
+		frame, err := readFrame(r)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) && err != io.EOF {
+				c.logger.Warn("Could not read from the acquirer", zap.Error(err))
+			}
+			return
+		}
+		message, err := c.spec.Unpack(frame)
+		if err != nil {
+			c.logger.Error("Could not unpack message from the acquirer", zap.Error(err))
+			continue
+		}
+		if message.MTI == mtiNetworkManagement {
+			go c.answerEcho(message)
+			continue
+		}
+		c.lock.Lock()
+		responseChan, ok := c.pending[matchKey(message)]
+		c.lock.Unlock()
+		if !ok {
+			c.logger.Warn("Response from the acquirer does not match any request",
+				zap.String("mti", message.MTI), zap.String("key", matchKey(message)))
+			continue
+		}
+		// The channel has room for a single response, so a duplicated response is dropped instead of blocking reads
+		select {
+		case responseChan <- message:
+		default:
+		}
+	}
+}
+
+func (c *tcpConnection) answerEcho(request *iso8583.Message) {
+	res, err := echoResponse(request)
+	if err != nil {
+		c.logger.Error("Could not answer echo from the acquirer", zap.Error(err))
+		return
+	}
+	packed, err := c.spec.Pack(res)
+	if err != nil {
+		c.logger.Error("Could not pack echo response", zap.Error(err))
+		return
+	}
+	err = c.write(packed, time.Second)
+	if err != nil {
+		c.logger.Warn("Could not answer echo from the acquirer", zap.Error(err))
+	}
+}
+
+func (c *tcpConnection) close() {
+	c.closeOnce.Do(func() {
+		_ = c.conn.Close()
+		close(c.done)
+	})
+}
+
+// echoResponse answers a network management request, echoing the fields that identify it.
+func echoResponse(request *iso8583.Message) (*iso8583.Message, error) {
+	mti, err := iso8583.ResponseMTI(request.MTI)
+	if err != nil {
+		return nil, err
+	}
+	res := iso8583.NewMessage(mti)
+	for _, field := range []int{iso8583.FieldTransmissionDateTime, iso8583.FieldSTAN, iso8583.FieldNetworkManagementCode} {
+		if request.Has(field) {
+			res.Set(field, request.Get(field))
+		}
+	}
+	res.Set(iso8583.FieldResponseCode, ResponseCodeApproved)
+	return res, nil
+}
+
+// matchKey identifies a request and its response. Network management messages do not have an RRN,
+// so they are matched by their STAN only.
+func matchKey(m *iso8583.Message) string {
+	return m.Get(iso8583.FieldSTAN) + "/" + m.Get(iso8583.FieldRRN)
+}
+
+// writeFrame writes the message, prefixed with its length as a 2 byte big endian number.
+func writeFrame(w io.Writer, message []byte) error {
+	if len(message) > maxFrameLength {
+		return fmt.Errorf("message of %d bytes is too long for a frame", len(message))
+	}
+	frame := make([]byte, 2+len(message))
+	binary.BigEndian.PutUint16(frame, uint16(len(message)))
+	copy(frame[2:], message)
+	_, err := w.Write(frame)
+	return err
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+	message := make([]byte, binary.BigEndian.Uint16(header))
+	_, err = io.ReadFull(r, message)
+	return message, err
+}
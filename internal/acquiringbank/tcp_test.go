@@ -0,0 +1,356 @@
+package acquiringbank_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/acquiringbank"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"github.com/marioarizaj/payment-gateway/kit/iso8583"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// startStandIn listens on a local port like the acquirer would, and passes every accepted connection to handle.
+func startStandIn(t *testing.T, handle func(conn net.Conn)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				handle(conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func startTCPTransport(t *testing.T, cfg config.BankConfig) *acquiringbank.TCPTransport {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 1000
+	}
+	if cfg.MaxInFlight == 0 {
+		cfg.MaxInFlight = 10
+	}
+	if cfg.HeartbeatInterval == 0 {
+		cfg.HeartbeatInterval = 60000
+	}
+	cfg.ReconnectInitialBackoff = 10
+	cfg.ReconnectMaxBackoff = 50
+	transport := acquiringbank.NewTCPTransport(cfg, iso8583.DefaultSpec, zap.NewNop())
+	transport.Start()
+	t.Cleanup(transport.Close)
+	waitConnected(t, transport)
+	return transport
+}
+
+func waitConnected(t *testing.T, transport *acquiringbank.TCPTransport) {
+	deadline := time.Now().Add(time.Second)
+	for !transport.Connected() {
+		if time.Now().After(deadline) {
+			t.Fatal("transport did not connect")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func readMessage(r io.Reader) (*iso8583.Message, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return iso8583.DefaultSpec.Unpack(body)
+}
+
+func writeMessage(w io.Writer, m *iso8583.Message) error {
+	packed, err := iso8583.DefaultSpec.Pack(m)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, 2)
+	binary.BigEndian.PutUint16(header, uint16(len(packed)))
+	_, err = w.Write(append(header, packed...))
+	return err
+}
+
+func approve(req *iso8583.Message) *iso8583.Message {
+	mti, _ := iso8583.ResponseMTI(req.MTI)
+	res := iso8583.NewMessage(mti)
+	res.Set(iso8583.FieldSTAN, req.Get(iso8583.FieldSTAN))
+	if req.Has(iso8583.FieldRRN) {
+		res.Set(iso8583.FieldRRN, req.Get(iso8583.FieldRRN))
+	}
+	res.Set(iso8583.FieldResponseCode, acquiringbank.ResponseCodeApproved)
+	return res
+}
+
+func financialRequest(stan string) *iso8583.Message {
+	req := iso8583.NewMessage("0200")
+	req.Set(iso8583.FieldSTAN, stan)
+	req.Set(iso8583.FieldRRN, "229114"+stan)
+	return req
+}
+
+func TestTCPTransport_MatchesOutOfOrderResponses(t *testing.T) {
+	address := startStandIn(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		first, err := readMessage(r)
+		if err != nil {
+			return
+		}
+		second, err := readMessage(r)
+		if err != nil {
+			return
+		}
+		_ = writeMessage(conn, approve(second))
+		_ = writeMessage(conn, approve(first))
+	})
+	transport := startTCPTransport(t, config.BankConfig{TCPAddress: address})
+
+	stans := []string{"000001", "000002"}
+	results := make(chan *iso8583.Message, len(stans))
+	for i, stan := range stans {
+		req := financialRequest(stan)
+		go func() {
+			res, err := transport.Exchange(context.Background(), req)
+			assert.NoError(t, err)
+			results <- res
+		}()
+		if i == 0 {
+			// Makes sure the first request is written before the second one
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	received := map[string]string{}
+	for range stans {
+		select {
+		case res := <-results:
+			if res != nil {
+				received[res.Get(iso8583.FieldSTAN)] = res.Get(iso8583.FieldRRN)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("response was not received")
+		}
+	}
+	assert.Equal(t, map[string]string{"000001": "229114000001", "000002": "229114000002"}, received)
+}
+
+func TestTCPTransport_Reconnects(t *testing.T) {
+	connections := make(chan struct{}, 10)
+	address := startStandIn(t, func(conn net.Conn) {
+		connections <- struct{}{}
+		if len(connections) == 1 {
+			// The first connection is dropped as soon as a request arrives
+			_, _ = readMessage(conn)
+			return
+		}
+		r := bufio.NewReader(conn)
+		for {
+			req, err := readMessage(r)
+			if err != nil {
+				return
+			}
+			_ = writeMessage(conn, approve(req))
+		}
+	})
+	transport := startTCPTransport(t, config.BankConfig{TCPAddress: address})
+
+	_, err := transport.Exchange(context.Background(), financialRequest("000001"))
+	assert.ErrorIs(t, err, acquiringbank.ErrLinkClosed)
+
+	deadline := time.Now().Add(time.Second)
+	for len(connections) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("transport did not reconnect")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	waitConnected(t, transport)
+	res, err := transport.Exchange(context.Background(), financialRequest("000002"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, acquiringbank.ResponseCodeApproved, res.Get(iso8583.FieldResponseCode))
+	}
+}
+
+func TestTCPTransport_Heartbeat(t *testing.T) {
+	echoes := make(chan *iso8583.Message, 10)
+	address := startStandIn(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		for {
+			req, err := readMessage(r)
+			if err != nil {
+				return
+			}
+			echoes <- req
+			_ = writeMessage(conn, approve(req))
+		}
+	})
+	startTCPTransport(t, config.BankConfig{TCPAddress: address, HeartbeatInterval: 20})
+
+	select {
+	case echo := <-echoes:
+		assert.Equal(t, "0800", echo.MTI)
+		assert.Equal(t, "301", echo.Get(iso8583.FieldNetworkManagementCode))
+	case <-time.After(time.Second):
+		t.Fatal("echo was not sent")
+	}
+}
+
+func TestTCPTransport_UnansweredHeartbeatClosesConnection(t *testing.T) {
+	connections := make(chan struct{}, 10)
+	address := startStandIn(t, func(conn net.Conn) {
+		connections <- struct{}{}
+		// Reads the echoes without answering them
+		_, _ = io.Copy(io.Discard, conn)
+	})
+	startTCPTransport(t, config.BankConfig{TCPAddress: address, HeartbeatInterval: 20, Timeout: 50})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(connections) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("transport did not reconnect after the echo was not answered")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTCPTransport_MaxInFlight(t *testing.T) {
+	received := make(chan struct{}, 10)
+	address := startStandIn(t, func(conn net.Conn) {
+		// Reads the requests without answering them, so they stay in flight until they time out
+		r := bufio.NewReader(conn)
+		for {
+			if _, err := readMessage(r); err != nil {
+				return
+			}
+			received <- struct{}{}
+		}
+	})
+	transport := startTCPTransport(t, config.BankConfig{TCPAddress: address, MaxInFlight: 1, Timeout: 300})
+
+	go func() {
+		_, _ = transport.Exchange(context.Background(), financialRequest("000001"))
+	}()
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("request was not sent")
+	}
+	_, err := transport.Exchange(context.Background(), financialRequest("000002"))
+	assert.ErrorIs(t, err, acquiringbank.ErrTooManyInFlight)
+}
+
+func TestTCPTransport_ContextDone(t *testing.T) {
+	address := startStandIn(t, func(conn net.Conn) {
+		// Reads the requests without answering them
+		r := bufio.NewReader(conn)
+		for {
+			if _, err := readMessage(r); err != nil {
+				return
+			}
+		}
+	})
+	transport := startTCPTransport(t, config.BankConfig{TCPAddress: address, Timeout: 5000})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := transport.Exchange(ctx, financialRequest("000001"))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// The request was sent, so the acquirer may have processed it
+	client := acquiringbank.NewISOClient(transport, zap.NewNop())
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	res := client.CreatePayment(ctx, testPayment, func(payment payment_gateway.Payment) {})
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusGatewayTimeout, res.StatusCode)
+}
+
+func TestTCPTransport_NotConnected(t *testing.T) {
+	transport := acquiringbank.NewTCPTransport(config.BankConfig{TCPAddress: "127.0.0.1:1", Timeout: 100}, iso8583.DefaultSpec, zap.NewNop())
+	_, err := transport.Exchange(context.Background(), financialRequest("000001"))
+	assert.ErrorIs(t, err, acquiringbank.ErrNotConnected)
+
+	client := acquiringbank.NewISOClient(transport, zap.NewNop())
+	res := client.CreatePayment(context.Background(), testPayment, func(payment payment_gateway.Payment) {})
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+}
+
+func TestISOClient_CreatePayment_TCP(t *testing.T) {
+	cases := []struct {
+		name            string
+		bankConfig      config.MockBankConfig
+		expectedStatus  status.PaymentStatus
+		expectedDecline *decline.Decline
+	}{
+		{
+			name:           "create_payment_approved",
+			bankConfig:     config.MockBankConfig{StatusCode: 202, UpdateToStatus: "succeeded"},
+			expectedStatus: status.PaymentSucceeded,
+		},
+		{
+			name:            "create_payment_declined",
+			bankConfig:      config.MockBankConfig{StatusCode: 202, UpdateToStatus: "failed"},
+			expectedStatus:  status.PaymentFailed,
+			expectedDecline: decline.FromResponseCode("05"),
+		},
+		{
+			name:            "create_payment_bank_error",
+			bankConfig:      config.MockBankConfig{StatusCode: 500},
+			expectedStatus:  status.PaymentFailed,
+			expectedDecline: decline.FromResponseCode("96"),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { _ = listener.Close() })
+			go func() {
+				_ = acquiringbank.NewSimulator(c.bankConfig, "", http.DefaultClient, zap.NewNop()).ServeTCP(listener)
+			}()
+			transport := startTCPTransport(t, config.BankConfig{TCPAddress: listener.Addr().String()})
+			client := acquiringbank.NewISOClient(transport, zap.NewNop())
+
+			signalChan := make(chan payment_gateway.Payment, 1)
+			res := client.CreatePayment(context.Background(), testPayment, func(payment payment_gateway.Payment) {
+				signalChan <- payment
+			})
+			_ = res.Body.Close()
+			assert.Equal(t, http.StatusAccepted, res.StatusCode)
+			select {
+			case payment := <-signalChan:
+				assert.Equal(t, c.expectedStatus, payment.PaymentStatus)
+				assert.Equal(t, c.expectedDecline, payment.Decline)
+			case <-time.After(time.Second):
+				t.Error("callback was not run")
+			}
+		})
+	}
+}
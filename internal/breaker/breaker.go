@@ -0,0 +1,108 @@
+package breaker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/kit/prometheus"
+)
+
+// The operations that have their own circuit breaker on every acquirer.
+const (
+	OperationAuthorize = "authorize"
+	OperationCapture   = "capture"
+	OperationCancel    = "cancel"
+	OperationRefund    = "refund"
+	OperationInquiry   = "inquiry"
+)
+
+// Operations are all the operations that have a circuit breaker.
+var Operations = []string{OperationAuthorize, OperationCapture, OperationCancel, OperationRefund, OperationInquiry}
+
+// Name returns the name of the hystrix command of the operation on the acquirer, like primary.authorize.
+func Name(acquirer, operation string) string {
+	return acquirer + "." + operation
+}
+
+// IsOpen tells whether the breaker of the operation on the acquirer is open, rejecting the calls sent through it.
+func IsOpen(acquirer, operation string) bool {
+	circuit, _, err := hystrix.GetCircuit(Name(acquirer, operation))
+	return err == nil && circuit.IsOpen()
+}
+
+// Breakers are the circuit breakers of the registered acquirers. They tell the router which acquirers can take payments.
+type Breakers struct {
+	acquirers    []string
+	sleepWindows map[string]time.Duration
+
+	lock sync.Mutex
+	// probes has the last time a payment was let through to each acquirer whose authorize breaker is open
+	probes map[string]time.Time
+}
+
+// New configures a breaker for every operation on each of the acquirers, with the settings of the config.
+func New(cfg config.CircuitBreakerConfig, acquirers []string) *Breakers {
+	b := &Breakers{
+		acquirers:    acquirers,
+		sleepWindows: make(map[string]time.Duration, len(acquirers)),
+		probes:       map[string]time.Time{},
+	}
+	for _, acquirer := range acquirers {
+		for _, operation := range Operations {
+			settings := cfg.Settings(acquirer, operation)
+			hystrix.ConfigureCommand(Name(acquirer, operation), hystrix.CommandConfig{
+				Timeout:                settings.Timeout,
+				MaxConcurrentRequests:  settings.MaxConcurrentRequests,
+				ErrorPercentThreshold:  settings.ErrorPercentThreshold,
+				RequestVolumeThreshold: settings.RequestVolumeThreshold,
+				SleepWindow:            settings.SleepWindow,
+			})
+		}
+		b.sleepWindows[acquirer] = time.Duration(cfg.Settings(acquirer, OperationAuthorize).SleepWindow) * time.Millisecond
+	}
+	return b
+}
+
+// Available tells whether payments can be routed to the acquirer. Acquirers whose authorize breaker is open are not,
+// except for a single payment on every sleep window, which lets the breaker find out whether the acquirer recovered.
+func (b *Breakers) Available(acquirer string) bool {
+	open := IsOpen(acquirer, OperationAuthorize)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !open {
+		delete(b.probes, acquirer)
+		return true
+	}
+	now := time.Now()
+	last, ok := b.probes[acquirer]
+	if !ok {
+		b.probes[acquirer] = now
+		return false
+	}
+	sleepWindow, ok := b.sleepWindows[acquirer]
+	if !ok || sleepWindow == 0 {
+		sleepWindow = time.Duration(hystrix.DefaultSleepWindow) * time.Millisecond
+	}
+	if now.Sub(last) < sleepWindow {
+		return false
+	}
+	b.probes[acquirer] = now
+	return true
+}
+
+// States returns whether each of the breakers is open, to be exported as metrics.
+func (b *Breakers) States() []prometheus.BreakerState {
+	states := make([]prometheus.BreakerState, 0, len(b.acquirers)*len(Operations))
+	for _, acquirer := range b.acquirers {
+		for _, operation := range Operations {
+			states = append(states, prometheus.BreakerState{
+				Acquirer:  acquirer,
+				Operation: operation,
+				Open:      IsOpen(acquirer, operation),
+			})
+		}
+	}
+	return states
+}
@@ -0,0 +1,59 @@
+package breaker_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+	"github.com/marioarizaj/payment-gateway/internal/breaker"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/kit/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestName(t *testing.T) {
+	assert.Equal(t, "primary.authorize", breaker.Name("primary", breaker.OperationAuthorize))
+}
+
+func TestBreakers_Available(t *testing.T) {
+	cfg := config.CircuitBreakerConfig{
+		Timeout:                1000,
+		MaxConcurrentRequests:  10,
+		ErrorPercentThreshold:  50,
+		RequestVolumeThreshold: 100,
+		SleepWindow:            5000,
+		// Only the authorize breaker of the failing acquirer opens after a single error
+		RequestVolumeThresholdOverrides: map[string]int{"failing.authorize": 1},
+		SleepWindowOverrides:            map[string]int{"failing.authorize": 100},
+	}
+	// The circuits are global, so the ones opened by earlier runs are closed first
+	hystrix.Flush()
+	b := breaker.New(cfg, []string{"healthy", "failing"})
+	assert.True(t, b.Available("healthy"))
+	assert.True(t, b.Available("failing"))
+
+	_ = hystrix.Do(breaker.Name("failing", breaker.OperationAuthorize), func() error {
+		return errors.New("acquirer failed")
+	}, nil)
+	// Metrics are collected asynchronously, so the breaker opens once they were counted
+	assert.Eventually(t, func() bool {
+		return breaker.IsOpen("failing", breaker.OperationAuthorize)
+	}, time.Second, 10*time.Millisecond)
+	assert.False(t, b.Available("failing"))
+	assert.True(t, b.Available("healthy"))
+
+	// A single payment is let through once the sleep window is over
+	time.Sleep(150 * time.Millisecond)
+	assert.True(t, b.Available("failing"))
+	assert.False(t, b.Available("failing"))
+
+	var open []prometheus.BreakerState
+	for _, state := range b.States() {
+		if state.Open {
+			open = append(open, state)
+		}
+	}
+	assert.Len(t, b.States(), 2*len(breaker.Operations))
+	assert.Equal(t, []prometheus.BreakerState{{Acquirer: "failing", Operation: breaker.OperationAuthorize, Open: true}}, open)
+}
@@ -27,10 +27,14 @@ type Server struct {
 	IdleTimeout  int64 `envconfig:"SERVER_IDLE_TIMEOUT"`
 	ReadTimeout  int64 `envconfig:"SERVER_READ_TIMEOUT"`
 	WriteTimeout int64 `envconfig:"SERVER_WRITE_TIMEOUT"`
+	// ShutdownTimeout is how many seconds running requests get to finish on shutdown, before they are canceled
+	ShutdownTimeout int64 `envconfig:"SERVER_SHUTDOWN_TIMEOUT" default:"10"`
 }
 
 type Auth struct {
 	ApiKeySecret string `envconfig:"API_KEY_SECRET"`
+	// OperatorApiKey authenticates the operators of the gateway on the /ops routes, which are disabled when it is empty
+	OperatorApiKey string `envconfig:"OPERATOR_API_KEY"`
 }
 
 type Redis struct {
@@ -43,13 +47,50 @@ type RateLimiter struct {
 	AllowedReqsPerSecond int `envconfig:"ALLOWED_REQUESTS_PER_SECOND"`
 }
 
+// CircuitBreakerConfig has the settings of the circuit breakers, one for each acquirer and operation.
 type CircuitBreakerConfig struct {
-	Commands               []string `envconfig:"HYSTRIX_COMMANDS"`
-	Timeout                int      `envconfig:"HYSTRIX_TIMEOUT"`
-	MaxConcurrentRequests  int      `envconfig:"HYSTRIX_MAX_CONCURRENT_REQUESTS"`
-	ErrorPercentThreshold  int      `envconfig:"HYSTRIX_ERROR_PERCENT_THRESHOLD"`
-	RequestVolumeThreshold int      `envconfig:"HYSTRIX_REQUEST_VOLUME_THRESHOLD"`
-	SleepWindow            int      `envconfig:"HYSTRIX_SLEEP_WINDOW"`
+	Timeout                int `envconfig:"HYSTRIX_TIMEOUT"`
+	MaxConcurrentRequests  int `envconfig:"HYSTRIX_MAX_CONCURRENT_REQUESTS"`
+	ErrorPercentThreshold  int `envconfig:"HYSTRIX_ERROR_PERCENT_THRESHOLD"`
+	RequestVolumeThreshold int `envconfig:"HYSTRIX_REQUEST_VOLUME_THRESHOLD"`
+	SleepWindow            int `envconfig:"HYSTRIX_SLEEP_WINDOW"`
+	// The overrides replace the settings above for some of the breakers. They are keyed by operation, like
+	// inquiry:500, or by acquirer and operation, like primary.authorize:2000, which takes precedence
+	TimeoutOverrides                map[string]int `envconfig:"HYSTRIX_TIMEOUT_OVERRIDES"`
+	MaxConcurrentRequestsOverrides  map[string]int `envconfig:"HYSTRIX_MAX_CONCURRENT_REQUESTS_OVERRIDES"`
+	ErrorPercentThresholdOverrides  map[string]int `envconfig:"HYSTRIX_ERROR_PERCENT_THRESHOLD_OVERRIDES"`
+	RequestVolumeThresholdOverrides map[string]int `envconfig:"HYSTRIX_REQUEST_VOLUME_THRESHOLD_OVERRIDES"`
+	SleepWindowOverrides            map[string]int `envconfig:"HYSTRIX_SLEEP_WINDOW_OVERRIDES"`
+}
+
+// CircuitBreakerSettings are the settings of a single circuit breaker.
+type CircuitBreakerSettings struct {
+	Timeout                int
+	MaxConcurrentRequests  int
+	ErrorPercentThreshold  int
+	RequestVolumeThreshold int
+	SleepWindow            int
+}
+
+// Settings returns the settings of the breaker of the operation on the acquirer, with the overrides that apply to it.
+func (c CircuitBreakerConfig) Settings(acquirer, operation string) CircuitBreakerSettings {
+	return CircuitBreakerSettings{
+		Timeout:                override(c.Timeout, c.TimeoutOverrides, acquirer, operation),
+		MaxConcurrentRequests:  override(c.MaxConcurrentRequests, c.MaxConcurrentRequestsOverrides, acquirer, operation),
+		ErrorPercentThreshold:  override(c.ErrorPercentThreshold, c.ErrorPercentThresholdOverrides, acquirer, operation),
+		RequestVolumeThreshold: override(c.RequestVolumeThreshold, c.RequestVolumeThresholdOverrides, acquirer, operation),
+		SleepWindow:            override(c.SleepWindow, c.SleepWindowOverrides, acquirer, operation),
+	}
+}
+
+func override(value int, overrides map[string]int, acquirer, operation string) int {
+	if v, ok := overrides[acquirer+"."+operation]; ok {
+		return v
+	}
+	if v, ok := overrides[operation]; ok {
+		return v
+	}
+	return value
 }
 
 type MockBankConfig struct {
@@ -58,7 +99,127 @@ type MockBankConfig struct {
 	SleepIntervalInitialRequest int    `envconfig:"SLEEP_INTERVAL_INITIAL_REQUEST" default:"10"`
 	SleepIntervalForCallback    int    `envconfig:"SLEEP_INTERVAL_FOR_CALLBACK" default:"200"`
 	ShouldRunCallback           bool   `envconfig:"SHOULD_RUN_CALLBACK" default:"true"`
-	FailedReason                string `envconfig:"MOCK_FAILED_REASON"`
+	// DeclineResponseCode is the ISO 8583 response code given to the operations that fail
+	DeclineResponseCode string `envconfig:"MOCK_DECLINE_RESPONSE_CODE" default:"05"`
+	// ScenarioTimeout is how long the timeout test card waits before answering, longer than the gateway waits for the bank
+	ScenarioTimeout int `envconfig:"MOCK_SCENARIO_TIMEOUT_MS" default:"30000"`
+}
+
+// BankConfig selects the acquiring bank client used by the gateway.
+type BankConfig struct {
+	// Name is the name of the default acquirer, which the routing rules and the stored payments refer to
+	Name string `envconfig:"BANK_NAME" default:"primary"`
+	// Client is mock, for the in-process MockClient, http, for the bank simulator service,
+	// or iso, to send ISO 8583 messages to the bank simulator
+	Client string `envconfig:"BANK_CLIENT" default:"mock"`
+	URL    string `envconfig:"BANK_URL" default:"http://localhost:8081"`
+	// CallbackURL is where the bank sends the result of the operations, once they are processed
+	CallbackURL string `envconfig:"BANK_CALLBACK_URL" default:"http://localhost:8080/internal/acquirer/callbacks"`
+	Timeout     int    `envconfig:"BANK_TIMEOUT_MS" default:"5000"`
+	// ISOTransport is how ISO 8583 messages are sent: http, or tcp for a persistent connection to TCPAddress
+	ISOTransport string `envconfig:"BANK_ISO_TRANSPORT" default:"http"`
+	TCPAddress   string `envconfig:"BANK_TCP_ADDRESS" default:"localhost:8583"`
+	// MaxInFlight is the number of messages that can wait for their response on the connection at the same time
+	MaxInFlight             int `envconfig:"BANK_MAX_IN_FLIGHT" default:"50"`
+	HeartbeatInterval       int `envconfig:"BANK_HEARTBEAT_INTERVAL_MS" default:"30000"`
+	ReconnectInitialBackoff int `envconfig:"BANK_RECONNECT_INITIAL_BACKOFF_MS" default:"100"`
+	ReconnectMaxBackoff     int `envconfig:"BANK_RECONNECT_MAX_BACKOFF_MS" default:"10000"`
+}
+
+// RoutingConfig registers more acquirers, and the rules that route payments between them.
+type RoutingConfig struct {
+	// RulesPath is the JSON file with the acquirers and the rules. Without it, every payment goes to the default acquirer
+	RulesPath string `envconfig:"ROUTING_RULES_PATH"`
+}
+
+type BankSimulatorConfig struct {
+	Port    int `envconfig:"BANK_SIMULATOR_PORT" default:"8081"`
+	TCPPort int `envconfig:"BANK_SIMULATOR_TCP_PORT" default:"8583"`
+}
+
+// WebhookConfig sets up the workers that send the events to the webhook endpoints of the merchants.
+type WebhookConfig struct {
+	Workers int `envconfig:"WEBHOOK_WORKERS" default:"4"`
+	// PollInterval is how often an idle worker looks for events, besides being woken up when an event is published
+	PollInterval int `envconfig:"WEBHOOK_POLL_INTERVAL_MS" default:"1000"`
+	// VisibilityTimeout is how long a claimed event is hidden from the other workers. It needs to be longer than Timeout,
+	// or the event is claimed again while it is still being sent
+	VisibilityTimeout int `envconfig:"WEBHOOK_VISIBILITY_TIMEOUT_MS" default:"30000"`
+	InitialBackoff    int `envconfig:"WEBHOOK_INITIAL_BACKOFF_MS" default:"1000"`
+	MaxBackoff        int `envconfig:"WEBHOOK_MAX_BACKOFF_MS" default:"300000"`
+	// MaxAttempts is how many times an event is sent to an endpoint, before it is dead lettered
+	MaxAttempts int `envconfig:"WEBHOOK_MAX_ATTEMPTS" default:"6"`
+	Timeout     int `envconfig:"WEBHOOK_TIMEOUT_MS" default:"5000"`
+}
+
+// ResolutionConfig schedules the status inquiries and reversals of the payments whose outcome is unknown.
+type ResolutionConfig struct {
+	// Interval is how often the resolver looks for payments that are due
+	Interval       int `envconfig:"RESOLUTION_INTERVAL_MS" default:"5000"`
+	InitialBackoff int `envconfig:"RESOLUTION_INITIAL_BACKOFF_MS" default:"1000"`
+	MaxBackoff     int `envconfig:"RESOLUTION_MAX_BACKOFF_MS" default:"300000"`
+	// VisibilityTimeout is how long a claimed payment is hidden from the other resolvers. It needs to be longer than a
+	// call to the acquirer, or the payment is claimed again while it is still being resolved
+	VisibilityTimeout int `envconfig:"RESOLUTION_VISIBILITY_TIMEOUT_MS" default:"30000"`
+	// MaxInquiries is how many status inquiries are sent, before the payment is reversed
+	MaxInquiries int `envconfig:"RESOLUTION_MAX_INQUIRIES" default:"5"`
+}
+
+// SweeperConfig schedules the status inquiries of the payments that are stuck processing, because the result of the
+// acquirer never arrived.
+type SweeperConfig struct {
+	// Interval is how often the sweeper looks for stuck payments
+	Interval int `envconfig:"SWEEPER_INTERVAL_MS" default:"60000"`
+	// SLA is how long a payment can be processing before it is stuck
+	SLA            int `envconfig:"SWEEPER_SLA_MS" default:"900000"`
+	InitialBackoff int `envconfig:"SWEEPER_INITIAL_BACKOFF_MS" default:"60000"`
+	MaxBackoff     int `envconfig:"SWEEPER_MAX_BACKOFF_MS" default:"900000"`
+	// VisibilityTimeout is how long a claimed payment is hidden from the other sweepers. It needs to be longer than a
+	// call to the acquirer, or the payment is claimed again while it is still being swept
+	VisibilityTimeout int `envconfig:"SWEEPER_VISIBILITY_TIMEOUT_MS" default:"30000"`
+	// MaxAttempts is how many status inquiries are sent, before the payment is flagged for manual review
+	MaxAttempts int `envconfig:"SWEEPER_MAX_ATTEMPTS" default:"5"`
+}
+
+// ReconciliationConfig sets up the import of the settlement files of the acquirers.
+type ReconciliationConfig struct {
+	// Directory is where the settlement files are read from, named <acquirer>_<YYYY-MM-DD>.<format>
+	Directory string `envconfig:"RECONCILIATION_DIR" default:"settlements"`
+	// Interval is how often the directory is checked for new files
+	Interval int `envconfig:"RECONCILIATION_INTERVAL_MS" default:"300000"`
+}
+
+// OutboxConfig sets up the workers that submit the jobs of the outbox, like new payments, to the acquirer.
+type OutboxConfig struct {
+	Workers int `envconfig:"OUTBOX_WORKERS" default:"4"`
+	// PollInterval is how often an idle worker looks for jobs, besides being woken up when a payment is created
+	PollInterval int `envconfig:"OUTBOX_POLL_INTERVAL_MS" default:"1000"`
+	// VisibilityTimeout is how long a claimed job is hidden from the other workers. It needs to be longer than a call to
+	// the acquirer, retries included, or the job is claimed again while it is still running
+	VisibilityTimeout int `envconfig:"OUTBOX_VISIBILITY_TIMEOUT_MS" default:"30000"`
+	InitialBackoff    int `envconfig:"OUTBOX_INITIAL_BACKOFF_MS" default:"1000"`
+	MaxBackoff        int `envconfig:"OUTBOX_MAX_BACKOFF_MS" default:"60000"`
+	// MaxAttempts is how many times a job is claimed, before it is dead lettered
+	MaxAttempts int `envconfig:"OUTBOX_MAX_ATTEMPTS" default:"5"`
+}
+
+// InboxConfig sets up the worker that applies the results of the acquirers that could not be applied when they arrived.
+type InboxConfig struct {
+	PollInterval int `envconfig:"INBOX_POLL_INTERVAL_MS" default:"1000"`
+	// VisibilityTimeout is how long a claimed message is hidden from the other workers
+	VisibilityTimeout int `envconfig:"INBOX_VISIBILITY_TIMEOUT_MS" default:"30000"`
+	InitialBackoff    int `envconfig:"INBOX_INITIAL_BACKOFF_MS" default:"1000"`
+	MaxBackoff        int `envconfig:"INBOX_MAX_BACKOFF_MS" default:"300000"`
+	// MaxAttempts is how many times a message is claimed, before it is dead lettered
+	MaxAttempts int `envconfig:"INBOX_MAX_ATTEMPTS" default:"10"`
+}
+
+// AcquirerCallbackConfig authenticates the results posted by the acquiring bank to the gateway.
+type AcquirerCallbackConfig struct {
+	// Secret is shared with the acquirer, which uses it to sign every callback. Callbacks are rejected while it is not set
+	Secret string `envconfig:"ACQUIRER_CALLBACK_SECRET"`
+	// Tolerance is how far the signing time of a callback can be from now, before it is rejected as stale
+	Tolerance int `envconfig:"ACQUIRER_CALLBACK_TOLERANCE_SECONDS" default:"300"`
 }
 
 type Config struct {
@@ -68,8 +229,18 @@ type Config struct {
 	Auth                 Auth
 	Redis                Redis
 	MockBankConfig       MockBankConfig
+	BankConfig           BankConfig
+	RoutingConfig        RoutingConfig
+	BankSimulatorConfig  BankSimulatorConfig
 	CircuitBreakerConfig CircuitBreakerConfig
 	DatabaseConfig       DatabaseConfig
+	WebhookConfig        WebhookConfig
+	ResolutionConfig     ResolutionConfig
+	OutboxConfig         OutboxConfig
+	InboxConfig          InboxConfig
+	SweeperConfig        SweeperConfig
+	ReconciliationConfig ReconciliationConfig
+	AcquirerCallback     AcquirerCallbackConfig
 }
 
 func LoadConfig() (Config, error) {
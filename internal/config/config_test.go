@@ -13,3 +13,51 @@ func TestLoadConfig(t *testing.T) {
 	// TODO: Here we can add more tests like check weather the configs are appropriately set
 	// It protects against people changing the envconfig tag by mistake.
 }
+
+func TestCircuitBreakerConfig_Settings(t *testing.T) {
+	cfg := CircuitBreakerConfig{
+		Timeout:                1000,
+		MaxConcurrentRequests:  200,
+		ErrorPercentThreshold:  50,
+		RequestVolumeThreshold: 20,
+		SleepWindow:            5000,
+		TimeoutOverrides:       map[string]int{"inquiry": 500, "primary.inquiry": 300, "secondary.authorize": 2000},
+		SleepWindowOverrides:   map[string]int{"authorize": 10000},
+	}
+	cases := []struct {
+		name      string
+		acquirer  string
+		operation string
+		expected  CircuitBreakerSettings
+	}{
+		{
+			name:      "defaults",
+			acquirer:  "primary",
+			operation: "capture",
+			expected:  CircuitBreakerSettings{Timeout: 1000, MaxConcurrentRequests: 200, ErrorPercentThreshold: 50, RequestVolumeThreshold: 20, SleepWindow: 5000},
+		},
+		{
+			name:      "operation_override",
+			acquirer:  "secondary",
+			operation: "inquiry",
+			expected:  CircuitBreakerSettings{Timeout: 500, MaxConcurrentRequests: 200, ErrorPercentThreshold: 50, RequestVolumeThreshold: 20, SleepWindow: 5000},
+		},
+		{
+			name:      "acquirer_override_takes_precedence",
+			acquirer:  "primary",
+			operation: "inquiry",
+			expected:  CircuitBreakerSettings{Timeout: 300, MaxConcurrentRequests: 200, ErrorPercentThreshold: 50, RequestVolumeThreshold: 20, SleepWindow: 5000},
+		},
+		{
+			name:      "overrides_of_different_settings",
+			acquirer:  "secondary",
+			operation: "authorize",
+			expected:  CircuitBreakerSettings{Timeout: 2000, MaxConcurrentRequests: 200, ErrorPercentThreshold: 50, RequestVolumeThreshold: 20, SleepWindow: 10000},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, cfg.Settings(c.acquirer, c.operation))
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package decline
+
+import (
+	"net/http"
+
+	"github.com/marioarizaj/payment-gateway/kit/responses"
+)
+
+// Code is the normalized gateway code of a decline, the same for every acquirer.
+type Code string
+
+const (
+	CodeDoNotHonor              Code = "do_not_honor"
+	CodeInvalidCardNumber       Code = "invalid_card_number"
+	CodeInvalidRequest          Code = "invalid_request"
+	CodeLostCard                Code = "lost_card"
+	CodeStolenCard              Code = "stolen_card"
+	CodeInsufficientFunds       Code = "insufficient_funds"
+	CodeExpiredCard             Code = "expired_card"
+	CodeTransactionNotPermitted Code = "transaction_not_permitted"
+	CodeSuspectedFraud          Code = "suspected_fraud"
+	CodeLimitExceeded           Code = "limit_exceeded"
+	CodeIssuerUnavailable       Code = "issuer_unavailable"
+	CodeProcessingError         Code = "processing_error"
+	// CodeAcquirerUnavailable means that the acquirer could not be reached, or did not answer in time
+	CodeAcquirerUnavailable Code = "acquirer_unavailable"
+	// CodeUnknown is given to response codes that are not on the taxonomy
+	CodeUnknown Code = "unknown"
+)
+
+// Category groups the decline codes by what the merchant can do about them.
+type Category string
+
+const (
+	CategoryInsufficientFunds Category = "insufficient_funds"
+	CategoryFraudSuspected    Category = "fraud_suspected"
+	CategoryCardExpired       Category = "card_expired"
+	CategoryInvalidCard       Category = "invalid_card"
+	CategoryNotPermitted      Category = "not_permitted"
+	CategoryIssuerDeclined    Category = "issuer_declined"
+	CategoryIssuerUnavailable Category = "issuer_unavailable"
+	CategoryInvalidRequest    Category = "invalid_request"
+	CategoryProcessingError   Category = "processing_error"
+	CategoryUnknown           Category = "unknown"
+)
+
+// Decline describes why an operation was declined. Soft declines can be approved later with the same card,
+// while hard declines will not be approved, so the shopper needs to use another card. RetryAdvised tells
+// whether the same operation can be sent again without anything changing on the shopper side.
+type Decline struct {
+	Code Code `json:"code"`
+	// ResponseCode is the raw code given by the acquirer, like field 39 of ISO 8583 messages
+	ResponseCode string   `json:"response_code,omitempty"`
+	Category     Category `json:"category"`
+	Soft         bool     `json:"soft"`
+	RetryAdvised bool     `json:"retry_advised"`
+	Message      string   `json:"message"`
+}
+
+// responseCodes is the taxonomy of the ISO 8583 response codes that acquirers use to decline an operation.
+var responseCodes = map[string]Decline{
+	"05": {Code: CodeDoNotHonor, Category: CategoryIssuerDeclined, Soft: true, Message: "do not honor"},
+	"14": {Code: CodeInvalidCardNumber, Category: CategoryInvalidCard, Message: "invalid card number"},
+	"30": {Code: CodeInvalidRequest, Category: CategoryInvalidRequest, Message: "format error"},
+	"41": {Code: CodeLostCard, Category: CategoryFraudSuspected, Message: "lost card"},
+	"43": {Code: CodeStolenCard, Category: CategoryFraudSuspected, Message: "stolen card"},
+	"51": {Code: CodeInsufficientFunds, Category: CategoryInsufficientFunds, Soft: true, RetryAdvised: true, Message: "insufficient funds"},
+	"54": {Code: CodeExpiredCard, Category: CategoryCardExpired, Message: "expired card"},
+	"57": {Code: CodeTransactionNotPermitted, Category: CategoryNotPermitted, Message: "transaction not permitted"},
+	"59": {Code: CodeSuspectedFraud, Category: CategoryFraudSuspected, Message: "suspected fraud"},
+	"61": {Code: CodeLimitExceeded, Category: CategoryInsufficientFunds, Soft: true, RetryAdvised: true, Message: "exceeds withdrawal limit"},
+	"91": {Code: CodeIssuerUnavailable, Category: CategoryIssuerUnavailable, Soft: true, RetryAdvised: true, Message: "issuer unavailable"},
+	"96": {Code: CodeProcessingError, Category: CategoryProcessingError, Soft: true, RetryAdvised: true, Message: "system malfunction"},
+}
+
+// FromResponseCode returns the decline of an ISO 8583 response code. Codes that are not on the taxonomy,
+// or a missing code, are hard declines, so they are not retried until they are added.
+func FromResponseCode(code string) *Decline {
+	d, ok := responseCodes[code]
+	if !ok {
+		d = Decline{Code: CodeUnknown, Category: CategoryUnknown, Message: "declined by the acquirer"}
+	}
+	d.ResponseCode = code
+	return &d
+}
+
+// FromStatusCode returns the decline of an operation that the acquirer rejected with an error status, instead of
+// processing it. Status codes that do not reject the operation itself, like conflicts, do not have a decline.
+func FromStatusCode(statusCode int) *Decline {
+	switch {
+	case statusCode == http.StatusBadRequest:
+		return &Decline{Code: CodeInvalidRequest, Category: CategoryInvalidRequest, Message: "the acquirer rejected the request"}
+	case statusCode == http.StatusInternalServerError:
+		return &Decline{Code: CodeProcessingError, Category: CategoryProcessingError, Soft: true, RetryAdvised: true, Message: "the acquirer failed to process the request"}
+	// A zero status code means that there was no response, because of a timeout or an open circuit
+	case statusCode == 0 || statusCode > http.StatusInternalServerError:
+		return &Decline{Code: CodeAcquirerUnavailable, Category: CategoryProcessingError, Soft: true, RetryAdvised: true, Message: "the acquirer is unavailable"}
+	}
+	return nil
+}
+
+// Error is returned when the acquirer does not accept an operation. The decline is sent along with the error,
+// so the merchant can tell whether to retry the operation or to ask the shopper for another card.
+type Error struct {
+	StatusCode int
+	Err        error
+	Decline    *Decline
+}
+
+func (e Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e Error) Response(w http.ResponseWriter) {
+	responses.RespondWithJSON(w, e.StatusCode, map[string]interface{}{
+		"error":   e.Error(),
+		"decline": e.Decline,
+	})
+}
+
+// NewError returns the error for an operation the acquirer rejected with the given status code. It is sent with a
+// 400 Bad Request for client errors, and with a 500 Internal Server Error otherwise.
+func NewError(statusCode int, err error) error {
+	d := FromStatusCode(statusCode)
+	if d == nil {
+		return responses.GetErrorResponseFromStatusCode(statusCode, err)
+	}
+	responseStatus := http.StatusInternalServerError
+	if statusCode == http.StatusBadRequest {
+		responseStatus = http.StatusBadRequest
+	}
+	return Error{StatusCode: responseStatus, Err: err, Decline: d}
+}
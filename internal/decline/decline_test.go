@@ -0,0 +1,88 @@
+package decline_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/marioarizaj/payment-gateway/internal/decline"
+	"github.com/marioarizaj/payment-gateway/kit/responses"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromResponseCode(t *testing.T) {
+	cases := []struct {
+		name             string
+		responseCode     string
+		expectedCode     decline.Code
+		expectedCategory decline.Category
+		expectedSoft     bool
+		expectedRetry    bool
+	}{
+		{name: "insufficient_funds", responseCode: "51", expectedCode: decline.CodeInsufficientFunds, expectedCategory: decline.CategoryInsufficientFunds, expectedSoft: true, expectedRetry: true},
+		{name: "do_not_honor", responseCode: "05", expectedCode: decline.CodeDoNotHonor, expectedCategory: decline.CategoryIssuerDeclined, expectedSoft: true},
+		{name: "stolen_card", responseCode: "43", expectedCode: decline.CodeStolenCard, expectedCategory: decline.CategoryFraudSuspected},
+		{name: "expired_card", responseCode: "54", expectedCode: decline.CodeExpiredCard, expectedCategory: decline.CategoryCardExpired},
+		{name: "issuer_unavailable", responseCode: "91", expectedCode: decline.CodeIssuerUnavailable, expectedCategory: decline.CategoryIssuerUnavailable, expectedSoft: true, expectedRetry: true},
+		{name: "unknown_code", responseCode: "N7", expectedCode: decline.CodeUnknown, expectedCategory: decline.CategoryUnknown},
+		{name: "missing_code", responseCode: "", expectedCode: decline.CodeUnknown, expectedCategory: decline.CategoryUnknown},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d := decline.FromResponseCode(c.responseCode)
+			assert.Equal(t, c.expectedCode, d.Code)
+			assert.Equal(t, c.responseCode, d.ResponseCode)
+			assert.Equal(t, c.expectedCategory, d.Category)
+			assert.Equal(t, c.expectedSoft, d.Soft)
+			assert.Equal(t, c.expectedRetry, d.RetryAdvised)
+			assert.NotEmpty(t, d.Message)
+		})
+	}
+}
+
+func TestFromResponseCode_ReturnsCopies(t *testing.T) {
+	d := decline.FromResponseCode("51")
+	d.Message = "changed"
+	assert.Equal(t, "insufficient funds", decline.FromResponseCode("51").Message)
+}
+
+func TestNewError(t *testing.T) {
+	cases := []struct {
+		name               string
+		statusCode         int
+		expectedStatusCode int
+		expectedCode       decline.Code
+		expectedRetry      bool
+	}{
+		{name: "rejected_request", statusCode: http.StatusBadRequest, expectedStatusCode: http.StatusBadRequest, expectedCode: decline.CodeInvalidRequest},
+		{name: "bank_error", statusCode: http.StatusInternalServerError, expectedStatusCode: http.StatusInternalServerError, expectedCode: decline.CodeProcessingError, expectedRetry: true},
+		{name: "bank_unavailable", statusCode: http.StatusServiceUnavailable, expectedStatusCode: http.StatusInternalServerError, expectedCode: decline.CodeAcquirerUnavailable, expectedRetry: true},
+		{name: "no_response", statusCode: 0, expectedStatusCode: http.StatusInternalServerError, expectedCode: decline.CodeAcquirerUnavailable, expectedRetry: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := decline.NewError(c.statusCode, errors.New("bank error"))
+			w := httptest.NewRecorder()
+			err.(responses.ResponseError).Response(w)
+			assert.Equal(t, c.expectedStatusCode, w.Code)
+			var body struct {
+				Error   string           `json:"error"`
+				Decline *decline.Decline `json:"decline"`
+			}
+			if !assert.NoError(t, json.NewDecoder(w.Body).Decode(&body)) {
+				return
+			}
+			assert.Equal(t, "bank error", body.Error)
+			if assert.NotNil(t, body.Decline) {
+				assert.Equal(t, c.expectedCode, body.Decline.Code)
+				assert.Equal(t, c.expectedRetry, body.Decline.RetryAdvised)
+			}
+		})
+	}
+}
+
+func TestNewError_WithoutDecline(t *testing.T) {
+	assert.Equal(t, responses.ConflictError{}, decline.NewError(http.StatusConflict, errors.New("conflict")))
+}
@@ -3,10 +3,15 @@ package dependencies
 import (
 	"context"
 	"database/sql"
+	"fmt"
 
-	"github.com/afex/hystrix-go/hystrix"
 	"github.com/go-redis/redis_rate/v9"
 	"github.com/marioarizaj/payment-gateway/internal/acquiringbank"
+	"github.com/marioarizaj/payment-gateway/internal/breaker"
+	"github.com/marioarizaj/payment-gateway/internal/domain/payment"
+	"github.com/marioarizaj/payment-gateway/internal/routing"
+	"github.com/marioarizaj/payment-gateway/kit/iso8583"
+	"go.uber.org/zap"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/marioarizaj/payment-gateway/internal/config"
@@ -15,15 +20,29 @@ import (
 	"github.com/uptrace/bun/driver/pgdriver"
 )
 
+const (
+	bankClientMock = "mock"
+	bankClientHTTP = "http"
+	bankClientISO  = "iso"
+
+	isoTransportHTTP = "http"
+	isoTransportTCP  = "tcp"
+)
+
 type Dependencies struct {
-	DB         bun.IDB
-	Limiter    *redis_rate.Limiter
-	BankClient *acquiringbank.MockClient
-	Redis      *redis.Client
+	DB      bun.IDB
+	Limiter *redis_rate.Limiter
+	// BankClient is the default acquirer, which gets the payments that no routing rule matches
+	BankClient payment.BankClient
+	// Acquirers are the other acquirers the routing rules can send payments to, by their name
+	Acquirers map[string]payment.BankClient
+	Router    *routing.Router
+	// Breakers are the circuit breakers of the operations on every acquirer
+	Breakers *breaker.Breakers
+	Redis    *redis.Client
 }
 
 func InitDependencies(config config.Config) (Dependencies, error) {
-	ConfigureHystrix(config.CircuitBreakerConfig)
 	db, err := InitDB(config.DatabaseConfig.DatabaseURL)
 	if err != nil {
 		return Dependencies{}, err
@@ -32,13 +51,100 @@ func InitDependencies(config config.Config) (Dependencies, error) {
 	if err != nil {
 		return Dependencies{}, err
 	}
-	return Dependencies{
+	deps := Dependencies{
 		DB:      db,
 		Limiter: redis_rate.NewLimiter(rds),
 		Redis:   rds,
+	}
+	deps.BankClient, err = NewBankClient(config.BankConfig, config.MockBankConfig)
+	if err != nil {
+		return Dependencies{}, err
+	}
+	routingConfig, err := routing.LoadConfig(config.RoutingConfig.RulesPath)
+	if err != nil {
+		return Dependencies{}, err
+	}
+	deps.Acquirers = make(map[string]payment.BankClient, len(routingConfig.Acquirers))
+	for _, acquirer := range routingConfig.Acquirers {
+		if acquirer.Name == "" || acquirer.Name == config.BankConfig.Name {
+			return Dependencies{}, fmt.Errorf("acquirer name %q is not valid", acquirer.Name)
+		}
+		bankConfig := acquirerBankConfig(config.BankConfig, acquirer)
+		deps.Acquirers[acquirer.Name], err = NewBankClient(bankConfig, config.MockBankConfig)
+		if err != nil {
+			return Dependencies{}, fmt.Errorf("acquirer %s: %w", acquirer.Name, err)
+		}
+	}
+	deps.Router, err = routing.NewRouter(config.BankConfig.Name, routingConfig)
+	if err != nil {
+		return Dependencies{}, err
+	}
+	acquirers := []string{config.BankConfig.Name}
+	for _, acquirer := range routingConfig.Acquirers {
+		acquirers = append(acquirers, acquirer.Name)
+	}
+	deps.Breakers = breaker.New(config.CircuitBreakerConfig, acquirers)
+	// Payments are not routed to the acquirers whose breaker is open, while any other target is available
+	deps.Router.SkipUnavailable(deps.Breakers)
+	return deps, nil
+}
+
+// BankClients returns every registered acquirer by its name, the default one included.
+func (d Dependencies) BankClients() map[string]payment.BankClient {
+	clients := make(map[string]payment.BankClient, len(d.Acquirers)+1)
+	for name, client := range d.Acquirers {
+		clients[name] = client
+	}
+	clients[d.Router.DefaultAcquirer()] = d.BankClient
+	return clients
+}
+
+// NewBankClient returns the client selected by the bank config.
+func NewBankClient(bankConfig config.BankConfig, mockConfig config.MockBankConfig) (payment.BankClient, error) {
+	switch bankConfig.Client {
+	case bankClientMock:
 		// By default, let's always return a good response
-		BankClient: acquiringbank.NewMockClient(config.MockBankConfig),
-	}, nil
+		return acquiringbank.NewMockClient(mockConfig), nil
+	case bankClientHTTP:
+		return acquiringbank.NewHTTPClient(bankConfig, zap.L()), nil
+	case bankClientISO:
+		var transport acquiringbank.ISOTransport
+		switch bankConfig.ISOTransport {
+		case isoTransportHTTP:
+			transport = acquiringbank.NewHTTPISOTransport(bankConfig, iso8583.DefaultSpec)
+		case isoTransportTCP:
+			tcpTransport := acquiringbank.NewTCPTransport(bankConfig, iso8583.DefaultSpec, zap.L())
+			tcpTransport.Start()
+			transport = tcpTransport
+		default:
+			return nil, fmt.Errorf("iso transport %s is not supported", bankConfig.ISOTransport)
+		}
+		return acquiringbank.NewISOClient(transport, zap.L()), nil
+	default:
+		return nil, fmt.Errorf("bank client %s is not supported", bankConfig.Client)
+	}
+}
+
+// acquirerBankConfig returns the bank config of an acquirer from the routing file, using the settings
+// of the default acquirer for the ones that it does not give.
+func acquirerBankConfig(bankConfig config.BankConfig, acquirer routing.Acquirer) config.BankConfig {
+	bankConfig.Name = acquirer.Name
+	if acquirer.Client != "" {
+		bankConfig.Client = acquirer.Client
+	}
+	if acquirer.URL != "" {
+		bankConfig.URL = acquirer.URL
+	}
+	if acquirer.CallbackURL != "" {
+		bankConfig.CallbackURL = acquirer.CallbackURL
+	}
+	if acquirer.ISOTransport != "" {
+		bankConfig.ISOTransport = acquirer.ISOTransport
+	}
+	if acquirer.TCPAddress != "" {
+		bankConfig.TCPAddress = acquirer.TCPAddress
+	}
+	return bankConfig
 }
 
 func InitDB(dsn string) (bun.IDB, error) {
@@ -57,16 +163,3 @@ func InitRedis(cfg config.Redis) (*redis.Client, error) {
 	err := rdb.Ping(context.Background()).Err()
 	return rdb, err
 }
-
-// ConfigureHystrix sets up hystrix circuit breakers.
-func ConfigureHystrix(cfg config.CircuitBreakerConfig) {
-	for _, c := range cfg.Commands {
-		hystrix.ConfigureCommand(c, hystrix.CommandConfig{
-			Timeout:                cfg.Timeout,
-			MaxConcurrentRequests:  cfg.MaxConcurrentRequests,
-			ErrorPercentThreshold:  cfg.ErrorPercentThreshold,
-			RequestVolumeThreshold: cfg.RequestVolumeThreshold,
-			SleepWindow:            cfg.SleepWindow,
-		})
-	}
-}
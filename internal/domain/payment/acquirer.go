@@ -0,0 +1,146 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/internal/routing"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"github.com/marioarizaj/payment-gateway/kit/responses"
+	"go.uber.org/zap"
+)
+
+const (
+	AcquirerOperationPayment = "payment"
+	AcquirerOperationCapture = "capture"
+	AcquirerOperationRefund  = "refund"
+)
+
+// AcquirerResult is the result of an operation, posted by the acquiring bank to the gateway.
+type AcquirerResult struct {
+	// EventID is given by the acquiring bank, and it is the same on every delivery of the result
+	EventID string
+	// Acquirer is the acquirer that processed the operation. Payment results only decide the payment when it is the
+	// current acquirer of the payment
+	Acquirer  string
+	Operation string
+	// ID is the id of the payment, or of the refund for refund results
+	ID     uuid.UUID
+	Status string
+	// Decline is read for failed results, and only its response code is kept, so the taxonomy is always the gateway's
+	Decline *decline.Decline
+}
+
+// Receipt tells the acquiring bank what happened to a result it posted.
+type Receipt struct {
+	// Duplicate is true when the result was received before, so it is not applied again
+	Duplicate bool
+	// Pending is true when the result is stored, but it could not be applied yet. The inbox applies it later
+	Pending bool
+}
+
+// ReceiveAcquirerResult stores a result posted by the acquiring bank on the inbox, and applies it with the same updates
+// as the callbacks given to the bank client. Once the result is stored it is never lost, even when it can not be
+// applied right away, like a result for a payment that is not committed yet.
+func (d *Domain) ReceiveAcquirerResult(ctx context.Context, result AcquirerResult) (Receipt, error) {
+	err := validateAcquirerResult(result)
+	if err != nil {
+		return Receipt{}, responses.BadRequestError{Err: err}
+	}
+	if _, ok := d.bankClients[result.Acquirer]; result.Acquirer != "" && !ok {
+		return Receipt{}, responses.BadRequestError{Err: fmt.Errorf("acquirer %s is not registered", result.Acquirer)}
+	}
+	receipt, err := d.receiveResult(ctx, &repositiory.InboxMessage{
+		ID:           uuid.New(),
+		EventID:      result.EventID,
+		Acquirer:     result.Acquirer,
+		Operation:    result.Operation,
+		ResourceID:   result.ID,
+		ResultStatus: result.Status,
+		Decline:      resultDecline(result),
+	})
+	if err != nil {
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return Receipt{}, responses.InternalServerError{Err: err}
+	}
+	if receipt.Duplicate {
+		d.logger.Info("Acquirer result was already received", zap.String("event_id", result.EventID))
+	}
+	return receipt, nil
+}
+
+// applyAcquirerResult writes the result of the message, using the given repository. Payment results are written to the
+// attempt of the acquirer that sent them.
+func (d *Domain) applyAcquirerResult(ctx context.Context, repo repositiory.Repository, message *repositiory.InboxMessage) error {
+	if message.Operation == AcquirerOperationRefund {
+		refund, err := repo.GetRefundByID(ctx, message.ResourceID)
+		if err != nil {
+			return err
+		}
+		refund.RefundStatus = status.RefundStatus(message.ResultStatus)
+		refund.Decline = message.Decline
+		return repo.UpdateRefundStatus(ctx, refund)
+	}
+	// The row is locked, so a capture that is still waiting to be committed is not overwritten
+	storedPayment, err := repo.GetPaymentByIDForUpdate(ctx, message.ResourceID)
+	if err != nil {
+		return err
+	}
+	payment := payment_gateway.GetPaymentFromStoredPayment(storedPayment)
+	payment.PaymentStatus = status.PaymentStatus(message.ResultStatus)
+	payment.Decline = message.Decline
+	if message.Operation == AcquirerOperationCapture {
+		return d.applyCaptureResult(ctx, repo, payment)
+	}
+	// Results without a reference keep the one of the authorization
+	if message.AcquirerReference != nil {
+		payment.AcquirerReference = message.AcquirerReference
+	}
+	if message.Acquirer != "" {
+		route := routing.Route{Acquirer: message.Acquirer}
+		if payment.Route != nil {
+			route.Rule = payment.Route.Rule
+		}
+		payment.Route = &route
+	}
+	return d.applyPaymentResult(ctx, repo, payment)
+}
+
+// resultDecline classifies the response code of a failed result.
+func resultDecline(result AcquirerResult) *decline.Decline {
+	if result.Status != string(status.PaymentFailed) {
+		return nil
+	}
+	if result.Decline == nil {
+		return decline.FromResponseCode("")
+	}
+	return decline.FromResponseCode(result.Decline.ResponseCode)
+}
+
+// validateAcquirerResult checks the result before anything is stored. The acquiring bank only reports whether
+// an operation succeeded or failed, the other statuses are decided by the gateway.
+func validateAcquirerResult(result AcquirerResult) error {
+	if result.EventID == "" {
+		return errors.New("event id is required")
+	}
+	switch result.Operation {
+	case AcquirerOperationPayment, AcquirerOperationCapture, AcquirerOperationRefund:
+	default:
+		return fmt.Errorf("operation %s is not supported", result.Operation)
+	}
+	if result.ID == uuid.Nil {
+		return errors.New("id is required")
+	}
+	if result.Operation == AcquirerOperationPayment && result.Acquirer == "" {
+		return errors.New("acquirer is required")
+	}
+	if result.Status != string(status.PaymentSucceeded) && result.Status != string(status.PaymentFailed) {
+		return fmt.Errorf("status %s is not valid, it can be succeeded or failed", result.Status)
+	}
+	return nil
+}
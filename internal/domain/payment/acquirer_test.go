@@ -0,0 +1,102 @@
+package payment_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/acquiringbank"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/dependencies"
+	"github.com/marioarizaj/payment-gateway/internal/domain/payment"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"github.com/marioarizaj/payment-gateway/kit/responses"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDomain_ReceiveAcquirerResult(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	// The results are only posted by the tests, like a bank that sends them over HTTP
+	deps.BankClient = acquiringbank.NewMockClient(config.MockBankConfig{StatusCode: 202})
+	d, cleanFn, err := getDomain(deps)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cleanFn()
+	ctx := context.Background()
+	p := baseTestPayment
+	p.CaptureMethod = payment_gateway.CaptureMethodManual
+	_, err = d.CreatePayment(ctx, p)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	steps := []struct {
+		name            string
+		apply           func() error
+		result          payment.AcquirerResult
+		expectedReceipt payment.Receipt
+		expectedErr     error
+		expectedStatus  status.PaymentStatus
+		expectedAmount  int64
+	}{
+		{
+			name:           "approval_authorizes_manual_capture_payment",
+			result:         payment.AcquirerResult{EventID: "evt_1", Acquirer: cfg.BankConfig.Name, Operation: payment.AcquirerOperationPayment, ID: p.ID, Status: "succeeded"},
+			expectedStatus: status.PaymentAuthorized,
+		},
+		{
+			name:            "same_event_is_not_applied_again",
+			result:          payment.AcquirerResult{EventID: "evt_1", Acquirer: cfg.BankConfig.Name, Operation: payment.AcquirerOperationPayment, ID: p.ID, Status: "failed"},
+			expectedReceipt: payment.Receipt{Duplicate: true},
+			expectedStatus:  status.PaymentAuthorized,
+		},
+		{
+			name: "capture_result",
+			apply: func() error {
+				_, err := d.CapturePayment(ctx, p.MerchantID, p.ID, 1500)
+				return err
+			},
+			result:         payment.AcquirerResult{EventID: "evt_2", Operation: payment.AcquirerOperationCapture, ID: p.ID, Status: "succeeded"},
+			expectedStatus: status.PaymentCaptured,
+			expectedAmount: 1500,
+		},
+		{
+			name:           "rejected_transition_is_still_recorded",
+			result:         payment.AcquirerResult{EventID: "evt_3", Acquirer: cfg.BankConfig.Name, Operation: payment.AcquirerOperationPayment, ID: p.ID, Status: "failed"},
+			expectedStatus: status.PaymentCaptured,
+			expectedAmount: 1500,
+		},
+		{
+			name:           "invalid_status",
+			result:         payment.AcquirerResult{EventID: "evt_4", Acquirer: cfg.BankConfig.Name, Operation: payment.AcquirerOperationPayment, ID: p.ID, Status: "canceled"},
+			expectedErr:    responses.BadRequestError{Err: errors.New("status canceled is not valid, it can be succeeded or failed")},
+			expectedStatus: status.PaymentCaptured,
+			expectedAmount: 1500,
+		},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			if step.apply != nil && !assert.NoError(t, step.apply()) {
+				return
+			}
+			receipt, err := d.ReceiveAcquirerResult(ctx, step.result)
+			assert.Equal(t, step.expectedErr, err)
+			assert.Equal(t, step.expectedReceipt, receipt)
+			stored, err := d.GetPayment(ctx, p.MerchantID, p.ID)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, step.expectedStatus, stored.PaymentStatus)
+			assert.Equal(t, step.expectedAmount, stored.AmountCaptured)
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/breaker"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"github.com/marioarizaj/payment-gateway/kit/responses"
+	"go.uber.org/zap"
+)
+
+// CancelPayment releases an authorized payment, or stops a payment that is still processing at the acquiring bank.
+// Canceling an already canceled payment returns the payment as it is, while final payments can not be canceled.
+func (d *Domain) CancelPayment(ctx context.Context, merchantID uuid.UUID, paymentID uuid.UUID) (payment_gateway.Payment, error) {
+	txRepo, err := d.repo.Begin(ctx)
+	if err != nil {
+		d.logger.Error("Error initialising transaction")
+		return payment_gateway.Payment{}, responses.InternalServerError{Err: err}
+	}
+	storedPayment, err := txRepo.GetPaymentByIDForUpdate(ctx, paymentID)
+	if err != nil && err != sql.ErrNoRows {
+		d.rollback(ctx, &txRepo)
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return payment_gateway.Payment{}, responses.InternalServerError{Err: err}
+	}
+	if err == sql.ErrNoRows || storedPayment.MerchantID != merchantID {
+		d.rollback(ctx, &txRepo)
+		d.logger.Info("Payment not found", zap.String("id", paymentID.String()))
+		return payment_gateway.Payment{}, responses.NotFoundError{}
+	}
+	if storedPayment.PaymentStatus == status.PaymentCanceled {
+		d.rollback(ctx, &txRepo)
+		return payment_gateway.GetPaymentFromStoredPayment(storedPayment), nil
+	}
+	if !storedPayment.PaymentStatus.CanTransitionTo(status.PaymentCanceled) {
+		d.rollback(ctx, &txRepo)
+		return payment_gateway.Payment{}, responses.BadRequestError{Err: fmt.Errorf("payment with status %s can not be canceled", storedPayment.PaymentStatus)}
+	}
+	if storedPayment.AmountCaptured > 0 {
+		d.rollback(ctx, &txRepo)
+		d.logger.Warn("Payment capture already submitted", zap.String("id", paymentID.String()))
+		return payment_gateway.Payment{}, responses.ConflictError{}
+	}
+
+	err = txRepo.CancelPayment(ctx, storedPayment)
+	if err != nil {
+		d.rollback(ctx, &txRepo)
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return payment_gateway.Payment{}, responses.InternalServerError{Err: err}
+	}
+	err = d.enqueueCancel(ctx, &txRepo, paymentID)
+	if err != nil {
+		d.rollback(ctx, &txRepo)
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return payment_gateway.Payment{}, responses.InternalServerError{Err: err}
+	}
+	err = d.publishFinalStatus(ctx, &txRepo, paymentID)
+	if err != nil {
+		d.rollback(ctx, &txRepo)
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return payment_gateway.Payment{}, responses.InternalServerError{Err: err}
+	}
+	err = txRepo.Commit(ctx)
+	if err != nil {
+		d.logger.Error("Could not commit transaction", zap.Error(err))
+		return payment_gateway.Payment{}, responses.InternalServerError{Err: err}
+	}
+	d.notifyOutbox()
+	d.events.NotifyDispatcher()
+
+	err = d.cache.DeleteKey(ctx, getPaymentCacheKey(merchantID, paymentID))
+	if err != nil {
+		d.logger.Error("Redis unexpected error", zap.Error(err))
+	}
+	storedPayment, err = d.repo.GetPaymentByID(ctx, paymentID)
+	if err != nil {
+		d.logger.Error("Database Unexpected error", zap.Error(err))
+		return payment_gateway.Payment{}, responses.InternalServerError{Err: err}
+	}
+	return payment_gateway.GetPaymentFromStoredPayment(storedPayment), nil
+}
+
+func (d *Domain) CancelPaymentOnAcquiringBank(ctx context.Context, payment payment_gateway.Payment) error {
+	acquirer := d.acquirerName(payment.Acquirer())
+	bankClient, err := d.bankClientFor(acquirer)
+	if err != nil {
+		return err
+	}
+	res, err := d.CancelPaymentUsingCircuitBreaker(bankContext(ctx, payment.MerchantID), acquirer, bankClient, payment)
+	if err != nil {
+		return bankError(res.StatusCode, err)
+	}
+	_ = res.Body.Close()
+	return nil
+}
+
+func (d *Domain) CancelPaymentUsingCircuitBreaker(ctx context.Context, acquirer string, bankClient BankClient, payment payment_gateway.Payment) (http.Response, error) {
+	out, err := d.callBankUsingCircuitBreaker(ctx, acquirer, breaker.OperationCancel, func(ctx context.Context) http.Response {
+		return bankClient.CancelPayment(ctx, payment)
+	})
+	if err != nil {
+		return out, err
+	}
+	if out.StatusCode < 299 {
+		return out, nil
+	}
+	return out, fmt.Errorf("payment failed to get canceled on acquring bank, status: %d", out.StatusCode)
+}
@@ -0,0 +1,183 @@
+package payment_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/acquiringbank"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/dependencies"
+	"github.com/marioarizaj/payment-gateway/internal/domain/payment"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"github.com/marioarizaj/payment-gateway/kit/responses"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDomain_CancelPayment(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	acceptingMockConfig := config.MockBankConfig{
+		StatusCode:                  202,
+		SleepIntervalInitialRequest: 10,
+	}
+
+	cases := []struct {
+		name           string
+		paymentStatus  status.PaymentStatus
+		amountCaptured int64
+		mockConfig     config.MockBankConfig
+		expectedError  error
+		// expectedJobStatus is the status of the job that sends the cancellation, empty when none is written
+		expectedJobStatus string
+	}{
+		{
+			name:              "cancel_processing_payment",
+			paymentStatus:     "processing",
+			mockConfig:        acceptingMockConfig,
+			expectedJobStatus: repositiory.OutboxJobDone,
+		},
+		{
+			name:              "cancel_authorized_payment",
+			paymentStatus:     "authorized",
+			mockConfig:        acceptingMockConfig,
+			expectedJobStatus: repositiory.OutboxJobDone,
+		},
+		{
+			name:          "cancel_canceled_payment_is_idempotent",
+			paymentStatus: "canceled",
+			mockConfig:    acceptingMockConfig,
+		},
+		{
+			name:           "cancel_payment_with_capture_submitted",
+			paymentStatus:  "authorized",
+			amountCaptured: 1000,
+			mockConfig:     acceptingMockConfig,
+			expectedError:  responses.ConflictError{},
+		},
+		{
+			name:          "cancel_succeeded_payment",
+			paymentStatus: "succeeded",
+			mockConfig:    acceptingMockConfig,
+			expectedError: responses.BadRequestError{Err: errors.New("payment with status succeeded can not be canceled")},
+		},
+		{
+			// The payment is canceled on the gateway first, and the cancellation is sent again until the acquirer accepts it
+			name:          "cancel_rejected_by_acquiring_bank",
+			paymentStatus: "authorized",
+			mockConfig: config.MockBankConfig{
+				StatusCode:                  400,
+				SleepIntervalInitialRequest: 10,
+			},
+			expectedJobStatus: repositiory.OutboxJobPending,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			deps.BankClient = acquiringbank.NewMockClient(c.mockConfig)
+			d, repo, cleanFn, err := getDomainWithoutOutbox(deps)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer cleanFn()
+			ctx := context.Background()
+			p := baseTestPayment
+			p.PaymentStatus = c.paymentStatus
+			p.AmountCaptured = c.amountCaptured
+			err = repo.CreatePayment(ctx, p.GetStoragePayment())
+			if !assert.NoError(t, err) {
+				return
+			}
+			canceled, err := d.CancelPayment(ctx, p.MerchantID, p.ID)
+			if c.expectedError != nil {
+				assert.Equal(t, c.expectedError, err)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			// The payment is read again once the cancellation is committed
+			stored, err := repo.GetPaymentByID(ctx, p.ID)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, status.PaymentCanceled, canceled.PaymentStatus)
+			assert.Equal(t, payment_gateway.GetPaymentFromStoredPayment(stored), canceled)
+
+			outboxConfig := testOutboxConfig
+			outboxConfig.InitialBackoff = int(time.Minute / time.Millisecond)
+			outboxConfig.MaxBackoff = outboxConfig.InitialBackoff
+			payment.NewOutbox(d, outboxConfig).ProcessDue(ctx)
+			jobs, err := repo.GetOutboxJobs(ctx, p.ID)
+			if !assert.NoError(t, err) {
+				return
+			}
+			if c.expectedJobStatus == "" {
+				assert.Empty(t, jobs)
+				return
+			}
+			if !assert.Len(t, jobs, 1) {
+				return
+			}
+			assert.Equal(t, repositiory.OutboxJobCancelPayment, jobs[0].Kind)
+			assert.Equal(t, c.expectedJobStatus, jobs[0].Status)
+			if c.expectedJobStatus == repositiory.OutboxJobPending {
+				assert.NotEmpty(t, jobs[0].LastError)
+			}
+			stored, err = repo.GetPaymentByID(ctx, p.ID)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, status.PaymentCanceled, stored.PaymentStatus)
+		})
+	}
+}
+
+func TestDomain_CancelPaymentIgnoresLateCallback(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps.BankClient = acquiringbank.NewMockClient(config.MockBankConfig{
+		StatusCode:                  202,
+		UpdateToStatus:              "succeeded",
+		SleepIntervalInitialRequest: 10,
+		SleepIntervalForCallback:    300,
+		ShouldRunCallback:           true,
+	})
+	d, cleanFn, err := getDomain(deps)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cleanFn()
+	p := baseTestPayment
+	_, err = d.CreatePayment(context.Background(), p)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = d.CancelPayment(context.Background(), p.MerchantID, p.ID)
+	if !assert.NoError(t, err) {
+		return
+	}
+	// Let's wait for the callback of the payment, it should not override the cancellation
+	time.Sleep(time.Second)
+	stored, err := d.GetPayment(context.Background(), p.MerchantID, p.ID)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, status.PaymentCanceled, stored.PaymentStatus)
+	assert.Equal(t, payment_gateway.CaptureMethodAutomatic, stored.CaptureMethod)
+}
@@ -0,0 +1,166 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/breaker"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	kitctx "github.com/marioarizaj/payment-gateway/kit/ctx"
+	"github.com/marioarizaj/payment-gateway/kit/responses"
+	"go.uber.org/zap"
+)
+
+func (d *Domain) callbackFromAcquiringBankForCapture(payment payment_gateway.Payment) {
+	d.receiveCallbackResult(&repositiory.InboxMessage{
+		Operation:    AcquirerOperationCapture,
+		ResourceID:   payment.ID,
+		ResultStatus: string(payment.PaymentStatus),
+		Decline:      payment.Decline,
+	})
+}
+
+// applyCaptureResult writes the result of a capture from the acquiring bank, using the given repository.
+func (d *Domain) applyCaptureResult(ctx context.Context, repo repositiory.Repository, payment payment_gateway.Payment) error {
+	err := d.cache.DeleteKey(ctx, getPaymentCacheKey(payment.MerchantID, payment.ID))
+	if err != nil {
+		d.logger.Error("Unexpected redis error", zap.Error(err))
+	}
+	if payment.PaymentStatus == status.PaymentFailed {
+		// A failed capture does not release the authorization, so the merchant can try to capture again
+		return repo.FailCapture(ctx, payment.GetStoragePayment())
+	}
+	payment.PaymentStatus = status.PaymentCaptured
+	payment.Decline = nil
+	err = repo.UpdateCaptureStatus(ctx, payment.GetStoragePayment())
+	if err != nil {
+		return err
+	}
+	return d.publishFinalStatus(ctx, repo, payment.ID)
+}
+
+// CapturePayment captures the given amount of an authorized payment, created with a manual capture method.
+// When the amount is 0, the full authorized amount is captured. A payment can only be captured once.
+func (d *Domain) CapturePayment(ctx context.Context, merchantID uuid.UUID, paymentID uuid.UUID, amount int64) (payment_gateway.Payment, error) {
+	if amount < 0 {
+		return payment_gateway.Payment{}, responses.BadRequestError{Err: errors.New("capture amount must be greater than 0")}
+	}
+	txRepo, err := d.repo.Begin(ctx)
+	if err != nil {
+		d.logger.Error("Error initialising transaction")
+		return payment_gateway.Payment{}, responses.InternalServerError{Err: err}
+	}
+	storedPayment, err := txRepo.GetPaymentByIDForUpdate(ctx, paymentID)
+	if err != nil && err != sql.ErrNoRows {
+		d.rollback(ctx, &txRepo)
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return payment_gateway.Payment{}, responses.InternalServerError{Err: err}
+	}
+	if err == sql.ErrNoRows || storedPayment.MerchantID != merchantID {
+		d.rollback(ctx, &txRepo)
+		d.logger.Info("Payment not found", zap.String("id", paymentID.String()))
+		return payment_gateway.Payment{}, responses.NotFoundError{}
+	}
+	if storedPayment.PaymentStatus != status.PaymentAuthorized {
+		d.rollback(ctx, &txRepo)
+		return payment_gateway.Payment{}, responses.BadRequestError{Err: fmt.Errorf("payment with status %s can not be captured", storedPayment.PaymentStatus)}
+	}
+	if amount == 0 {
+		amount = storedPayment.Amount
+	}
+	if amount > storedPayment.Amount {
+		d.rollback(ctx, &txRepo)
+		return payment_gateway.Payment{}, responses.BadRequestError{Err: fmt.Errorf("capture amount exceeds the authorized amount of %d", storedPayment.Amount)}
+	}
+
+	storedPayment.AmountCaptured = amount
+	err = txRepo.CapturePayment(ctx, storedPayment)
+	if err == sql.ErrNoRows {
+		d.rollback(ctx, &txRepo)
+		d.logger.Warn("Payment capture already submitted", zap.String("id", paymentID.String()))
+		return payment_gateway.Payment{}, responses.ConflictError{}
+	}
+	if err != nil {
+		d.rollback(ctx, &txRepo)
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return payment_gateway.Payment{}, responses.InternalServerError{Err: err}
+	}
+	err = txRepo.Commit(ctx)
+	if err != nil {
+		d.logger.Error("Could not commit transaction", zap.Error(err))
+		return payment_gateway.Payment{}, responses.InternalServerError{Err: err}
+	}
+	err = d.cache.DeleteKey(ctx, getPaymentCacheKey(merchantID, paymentID))
+	if err != nil {
+		d.logger.Error("Redis unexpected error", zap.Error(err))
+	}
+
+	err = d.CapturePaymentOnAcquiringBank(ctx, payment_gateway.GetPaymentFromStoredPayment(storedPayment))
+	if err != nil {
+		// The capture was sent, so its result is written even if the caller is gone
+		d.failCapture(kitctx.WithoutCancel(ctx), storedPayment, err)
+		return payment_gateway.Payment{}, err
+	}
+	storedPayment, err = d.repo.GetPaymentByID(ctx, paymentID)
+	if err != nil {
+		d.logger.Error("Database Unexpected error", zap.Error(err))
+		return payment_gateway.Payment{}, responses.InternalServerError{Err: err}
+	}
+	return payment_gateway.GetPaymentFromStoredPayment(storedPayment), nil
+}
+
+// failCapture clears the captured amount of the payment when the acquirer did not accept its capture.
+func (d *Domain) failCapture(ctx context.Context, storedPayment *repositiory.Payment, err error) {
+	var outcomeErr OutcomeUnknownError
+	if errors.As(err, &outcomeErr) {
+		d.logger.Warn("Outcome of the capture is unknown, leaving it submitted", zap.String("id", storedPayment.ID.String()), zap.Error(err))
+		return
+	}
+	var declineErr decline.Error
+	if errors.As(err, &declineErr) {
+		storedPayment.Decline = declineErr.Decline
+	}
+	err = d.repo.FailCapture(ctx, storedPayment)
+	if err != nil {
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return
+	}
+	err = d.cache.DeleteKey(ctx, getPaymentCacheKey(storedPayment.MerchantID, storedPayment.ID))
+	if err != nil {
+		d.logger.Error("Redis unexpected error", zap.Error(err))
+	}
+}
+
+func (d *Domain) CapturePaymentOnAcquiringBank(ctx context.Context, payment payment_gateway.Payment) error {
+	acquirer := d.acquirerName(payment.Acquirer())
+	bankClient, err := d.bankClientFor(acquirer)
+	if err != nil {
+		return err
+	}
+	res, err := d.CapturePaymentUsingCircuitBreaker(bankContext(ctx, payment.MerchantID), acquirer, bankClient, payment, d.callbackFromAcquiringBankForCapture)
+	if err != nil {
+		return bankError(res.StatusCode, err)
+	}
+	_ = res.Body.Close()
+	return nil
+}
+
+func (d *Domain) CapturePaymentUsingCircuitBreaker(ctx context.Context, acquirer string, bankClient BankClient, payment payment_gateway.Payment, callBackFn func(payment_gateway.Payment)) (http.Response, error) {
+	out, err := d.callBankUsingCircuitBreaker(ctx, acquirer, breaker.OperationCapture, func(ctx context.Context) http.Response {
+		return bankClient.CapturePayment(ctx, payment, callBackFn)
+	})
+	if err != nil {
+		return out, err
+	}
+	if out.StatusCode < 299 {
+		return out, nil
+	}
+	return out, fmt.Errorf("payment failed to get captured on acquring bank, status: %d", out.StatusCode)
+}
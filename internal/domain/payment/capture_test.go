@@ -0,0 +1,174 @@
+package payment_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/acquiringbank"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
+	"github.com/marioarizaj/payment-gateway/internal/dependencies"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"github.com/marioarizaj/payment-gateway/kit/responses"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDomain_CapturePayment(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	succeededMockConfig := config.MockBankConfig{
+		StatusCode:                  202,
+		UpdateToStatus:              "succeeded",
+		SleepIntervalInitialRequest: 10,
+		SleepIntervalForCallback:    50,
+		ShouldRunCallback:           true,
+	}
+
+	cases := []struct {
+		name                   string
+		paymentStatus          status.PaymentStatus
+		amountToCapture        int64
+		mockConfig             config.MockBankConfig
+		expectedError          error
+		expectedStatus         status.PaymentStatus
+		expectedAmountCaptured int64
+	}{
+		{
+			name:                   "capture_full_amount_success",
+			paymentStatus:          "authorized",
+			mockConfig:             succeededMockConfig,
+			expectedStatus:         "captured",
+			expectedAmountCaptured: 2000,
+		},
+		{
+			name:                   "capture_partial_amount_success",
+			paymentStatus:          "authorized",
+			amountToCapture:        1500,
+			mockConfig:             succeededMockConfig,
+			expectedStatus:         "captured",
+			expectedAmountCaptured: 1500,
+		},
+		{
+			name:            "capture_failed_keeps_authorization",
+			paymentStatus:   "authorized",
+			amountToCapture: 1500,
+			mockConfig: config.MockBankConfig{
+				StatusCode:                  202,
+				UpdateToStatus:              "failed",
+				SleepIntervalInitialRequest: 10,
+				SleepIntervalForCallback:    50,
+				ShouldRunCallback:           true,
+				DeclineResponseCode:         "54",
+			},
+			expectedStatus: "authorized",
+		},
+		{
+			name:            "capture_rejected_by_acquiring_bank",
+			paymentStatus:   "authorized",
+			amountToCapture: 1500,
+			mockConfig: config.MockBankConfig{
+				StatusCode:                  400,
+				SleepIntervalInitialRequest: 10,
+			},
+			expectedError: decline.Error{StatusCode: 400, Err: errors.New("payment failed to get captured on acquring bank, status: 400"), Decline: decline.FromStatusCode(400)},
+			// The captured amount is committed before the capture is sent, and cleared once the acquirer does not accept it
+			expectedStatus: "authorized",
+		},
+		{
+			name:            "capture_amount_exceeds_authorized",
+			paymentStatus:   "authorized",
+			amountToCapture: 2001,
+			mockConfig:      succeededMockConfig,
+			expectedError:   responses.BadRequestError{Err: errors.New("capture amount exceeds the authorized amount of 2000")},
+		},
+		{
+			name:          "capture_payment_not_authorized",
+			paymentStatus: "succeeded",
+			mockConfig:    succeededMockConfig,
+			expectedError: responses.BadRequestError{Err: errors.New("payment with status succeeded can not be captured")},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			deps.BankClient = acquiringbank.NewMockClient(c.mockConfig)
+			d, repo, cleanFn, err := getDomainWithRepo(deps)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer cleanFn()
+			p := baseTestPayment
+			p.CaptureMethod = payment_gateway.CaptureMethodManual
+			p.PaymentStatus = c.paymentStatus
+			err = repo.CreatePayment(context.Background(), p.GetStoragePayment())
+			if !assert.NoError(t, err) {
+				return
+			}
+			_, err = d.CapturePayment(context.Background(), p.MerchantID, p.ID, c.amountToCapture)
+			if c.expectedError != nil {
+				assert.Equal(t, c.expectedError, err)
+			} else if !assert.NoError(t, err) {
+				return
+			}
+			if c.expectedStatus == "" {
+				return
+			}
+			// Let's wait for the callback to update the database
+			time.Sleep(time.Second)
+			captured, err := d.GetPayment(context.Background(), p.MerchantID, p.ID)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, c.expectedStatus, captured.PaymentStatus)
+			assert.Equal(t, c.expectedAmountCaptured, captured.AmountCaptured)
+		})
+	}
+}
+
+func TestDomain_CreatePaymentManualCapture(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps.BankClient = acquiringbank.NewMockClient(config.MockBankConfig{
+		StatusCode:                  202,
+		UpdateToStatus:              "succeeded",
+		SleepIntervalInitialRequest: 10,
+		SleepIntervalForCallback:    50,
+		ShouldRunCallback:           true,
+	})
+	d, cleanFn, err := getDomain(deps)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cleanFn()
+	p := baseTestPayment
+	p.CaptureMethod = payment_gateway.CaptureMethodManual
+	_, err = d.CreatePayment(context.Background(), p)
+	if !assert.NoError(t, err) {
+		return
+	}
+	// Let's wait for the callback to update the database
+	time.Sleep(time.Second)
+	authorized, err := d.GetPayment(context.Background(), p.MerchantID, p.ID)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, status.PaymentAuthorized, authorized.PaymentStatus)
+
+	p.CaptureMethod = "later"
+	_, err = d.CreatePayment(context.Background(), p)
+	assert.Equal(t, responses.BadRequestError{Err: errors.New("capture_method must be either automatic or manual")}, err)
+}
@@ -0,0 +1,173 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/internal/routing"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	kitctx "github.com/marioarizaj/payment-gateway/kit/ctx"
+	"github.com/marioarizaj/payment-gateway/kit/responses"
+	"go.uber.org/zap"
+)
+
+// sendPayment sends the payment to the acquirer of its route, recording the attempt. When the acquirer does not accept
+// the payment because of a soft decline, and the cascade policy of the merchant allows it, the payment is sent again to
+// the fallback acquirer. Attempted are the acquirers the payment was already sent to, in order.
+// Payments whose outcome is unknown are not sent anywhere else, they are kept with an unknown status until they are resolved.
+func (d *Domain) sendPayment(ctx context.Context, repo repositiory.Repository, payment payment_gateway.Payment, attempted []string) error {
+	// Once the payment was sent, its result is written even if the caller is gone, since the acquirer may have processed it
+	dbCtx := kitctx.WithoutCancel(ctx)
+	for {
+		attempted = append(attempted, payment.Acquirer())
+		err := repo.CreatePaymentAttempt(ctx, &repositiory.PaymentAttempt{
+			PaymentID: payment.ID,
+			Attempt:   len(attempted),
+			Acquirer:  payment.Acquirer(),
+			Status:    status.PaymentProcessing,
+		})
+		if err != nil {
+			d.logger.Error("Database unexpected error", zap.Error(err))
+			return responses.InternalServerError{Err: err}
+		}
+		err = d.CreatePaymentOnAcquiringBank(ctx, payment)
+		if err == nil {
+			return nil
+		}
+		var outcomeErr OutcomeUnknownError
+		if errors.As(err, &outcomeErr) {
+			return d.markOutcomeUnknown(dbCtx, repo, payment, outcomeErr)
+		}
+		var declineErr decline.Error
+		if !errors.As(err, &declineErr) {
+			return err
+		}
+		updateErr := repo.UpdatePaymentAttempt(dbCtx, &repositiory.PaymentAttempt{
+			PaymentID: payment.ID,
+			Acquirer:  payment.Acquirer(),
+			Status:    status.PaymentFailed,
+			Decline:   declineErr.Decline,
+		})
+		if updateErr != nil {
+			d.logger.Error("Database unexpected error", zap.Error(updateErr))
+			return responses.InternalServerError{Err: updateErr}
+		}
+		if declineErr.Decline == nil || !declineErr.Decline.Soft {
+			return err
+		}
+		var cascaded bool
+		payment, cascaded, updateErr = d.cascade(dbCtx, repo, payment, attempted)
+		if updateErr != nil {
+			return updateErr
+		}
+		if !cascaded {
+			return err
+		}
+	}
+}
+
+// cascade moves the payment to the fallback of the last acquirer it was sent to, when the policy of the merchant allows it.
+func (d *Domain) cascade(ctx context.Context, repo repositiory.Repository, payment payment_gateway.Payment, attempted []string) (payment_gateway.Payment, bool, error) {
+	fallback, ok := d.router.Fallback(payment.MerchantID, attempted)
+	if !ok {
+		return payment, false, nil
+	}
+	d.logger.Info("Cascading payment to fallback acquirer",
+		zap.String("id", payment.ID.String()),
+		zap.String("from", attempted[len(attempted)-1]),
+		zap.String("to", fallback))
+	// The rule is kept, so the route still tells why the payment was sent to the first acquirer
+	route := routing.Route{Acquirer: fallback}
+	if payment.Route != nil {
+		route.Rule = payment.Route.Rule
+	}
+	payment.Route = &route
+	err := repo.UpdateRoute(ctx, payment.GetStoragePayment())
+	if err != nil {
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return payment, false, responses.InternalServerError{Err: err}
+	}
+	return payment, true, nil
+}
+
+// applyAttemptResult records the result of an acquirer on its attempt, and cascades payments declined with a soft decline
+// to the fallback acquirer. It returns true when the result does not decide the status of the payment anymore, because the
+// payment cascaded to another acquirer. Cascaded payments are submitted to the fallback acquirer by the outbox.
+func (d *Domain) applyAttemptResult(ctx context.Context, repo repositiory.Repository, payment *payment_gateway.Payment) (bool, error) {
+	storedPayment, err := repo.GetPaymentByID(ctx, payment.ID)
+	if err != nil {
+		return false, err
+	}
+	// Payments created before routing do not have attempts
+	if storedPayment.Acquirer == "" {
+		return false, nil
+	}
+	acquirer := payment.Acquirer()
+	err = repo.UpdatePaymentAttempt(ctx, &repositiory.PaymentAttempt{
+		PaymentID: payment.ID,
+		Acquirer:  acquirer,
+		Status:    payment.PaymentStatus,
+		Decline:   payment.Decline,
+	})
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	if acquirer != storedPayment.Acquirer {
+		d.logger.Warn("Result from an acquirer the payment cascaded away from",
+			zap.String("id", payment.ID.String()),
+			zap.String("acquirer", acquirer))
+		return true, nil
+	}
+	if storedPayment.PaymentStatus != status.PaymentProcessing || payment.PaymentStatus != status.PaymentFailed ||
+		payment.Decline == nil || !payment.Decline.Soft {
+		return false, nil
+	}
+
+	attempts, err := repo.GetPaymentAttempts(ctx, payment.ID)
+	if err != nil {
+		return false, err
+	}
+	attempted := make([]string, 0, len(attempts))
+	for _, a := range attempts {
+		attempted = append(attempted, a.Acquirer)
+	}
+	next, cascaded, err := d.cascade(ctx, repo, *payment, attempted)
+	if err != nil || !cascaded {
+		return false, err
+	}
+	// The outbox submits the payment to the fallback acquirer, once the result is committed. Results do not carry the
+	// cvv, so the fallback acquirer only gets it when this instance still keeps it in memory
+	err = d.enqueuePayment(ctx, repo, next)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetPaymentAttempts returns the attempts of a payment that belongs to the given merchant, one for each acquirer it was sent to.
+func (d *Domain) GetPaymentAttempts(ctx context.Context, merchantID uuid.UUID, paymentID uuid.UUID) ([]payment_gateway.PaymentAttempt, error) {
+	_, err := d.repo.GetMerchantPaymentByID(ctx, merchantID, paymentID)
+	if err != nil && err != sql.ErrNoRows {
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return nil, responses.InternalServerError{Err: err}
+	}
+	if err == sql.ErrNoRows {
+		d.logger.Info("Payment not found", zap.String("id", paymentID.String()))
+		return nil, responses.NotFoundError{}
+	}
+	storedAttempts, err := d.repo.GetPaymentAttempts(ctx, paymentID)
+	if err != nil {
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return nil, responses.InternalServerError{Err: err}
+	}
+	attempts := make([]payment_gateway.PaymentAttempt, 0, len(storedAttempts))
+	for i := range storedAttempts {
+		attempts = append(attempts, payment_gateway.GetPaymentAttemptFromStoredAttempt(&storedAttempts[i]))
+	}
+	return attempts, nil
+}
@@ -0,0 +1,222 @@
+package payment_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway/internal/acquiringbank"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
+	"github.com/marioarizaj/payment-gateway/internal/dependencies"
+	"github.com/marioarizaj/payment-gateway/internal/domain/payment"
+	"github.com/marioarizaj/payment-gateway/internal/routing"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDomain_CreatePayment_Cascade(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	primary := cfg.BankConfig.Name
+	approve := config.MockBankConfig{StatusCode: 202, UpdateToStatus: "succeeded", ShouldRunCallback: true}
+	cascade := routing.CascadeConfig{
+		Fallbacks: map[string]string{primary: "secondary"},
+		Policy:    routing.CascadePolicy{Enabled: true},
+	}
+
+	cases := []struct {
+		name               string
+		primaryConfig      config.MockBankConfig
+		cascade            routing.CascadeConfig
+		expectedStatus     status.PaymentStatus
+		expectedAcquirer   string
+		expectedAttempts   []string
+		expectedFirstCode  decline.Code
+		expectedFirstState status.PaymentStatus
+	}{
+		{
+			name:               "unavailable_acquirer_cascades",
+			primaryConfig:      config.MockBankConfig{StatusCode: 503},
+			cascade:            cascade,
+			expectedStatus:     status.PaymentSucceeded,
+			expectedAcquirer:   "secondary",
+			expectedAttempts:   []string{primary, "secondary"},
+			expectedFirstCode:  decline.CodeAcquirerUnavailable,
+			expectedFirstState: status.PaymentFailed,
+		},
+		{
+			name:               "soft_decline_cascades",
+			primaryConfig:      config.MockBankConfig{StatusCode: 202, UpdateToStatus: "failed", DeclineResponseCode: "51", ShouldRunCallback: true},
+			cascade:            cascade,
+			expectedStatus:     status.PaymentSucceeded,
+			expectedAcquirer:   "secondary",
+			expectedAttempts:   []string{primary, "secondary"},
+			expectedFirstCode:  decline.CodeInsufficientFunds,
+			expectedFirstState: status.PaymentFailed,
+		},
+		{
+			name:               "hard_decline_does_not_cascade",
+			primaryConfig:      config.MockBankConfig{StatusCode: 202, UpdateToStatus: "failed", DeclineResponseCode: "43", ShouldRunCallback: true},
+			cascade:            cascade,
+			expectedStatus:     status.PaymentFailed,
+			expectedAcquirer:   primary,
+			expectedAttempts:   []string{primary},
+			expectedFirstCode:  decline.CodeStolenCard,
+			expectedFirstState: status.PaymentFailed,
+		},
+		{
+			name:          "merchant_without_cascade",
+			primaryConfig: config.MockBankConfig{StatusCode: 202, UpdateToStatus: "failed", DeclineResponseCode: "51", ShouldRunCallback: true},
+			cascade: routing.CascadeConfig{
+				Fallbacks:        cascade.Fallbacks,
+				Policy:           cascade.Policy,
+				MerchantPolicies: map[uuid.UUID]routing.CascadePolicy{baseTestPayment.MerchantID: {}},
+			},
+			expectedStatus:     status.PaymentFailed,
+			expectedAcquirer:   primary,
+			expectedAttempts:   []string{primary},
+			expectedFirstCode:  decline.CodeInsufficientFunds,
+			expectedFirstState: status.PaymentFailed,
+		},
+		{
+			name:               "approved_on_first_acquirer",
+			primaryConfig:      approve,
+			cascade:            cascade,
+			expectedStatus:     status.PaymentSucceeded,
+			expectedAcquirer:   primary,
+			expectedAttempts:   []string{primary},
+			expectedFirstState: status.PaymentSucceeded,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			deps.BankClient = acquiringbank.NewMockClient(c.primaryConfig)
+			deps.Acquirers = map[string]payment.BankClient{"secondary": acquiringbank.NewMockClient(approve)}
+			deps.Router, err = routing.NewRouter(primary, routing.Config{
+				Acquirers: []routing.Acquirer{{Name: "secondary"}},
+				Cascade:   c.cascade,
+			})
+			if !assert.NoError(t, err) {
+				return
+			}
+			d, cleanFn, err := getDomain(deps)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer cleanFn()
+			ctx := context.Background()
+			_, err = d.CreatePayment(ctx, baseTestPayment)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			// Let's wait a second, for the callbacks of both acquirers to update the database
+			time.Sleep(time.Second)
+			p, err := d.GetPayment(ctx, baseTestPayment.MerchantID, baseTestPayment.ID)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, c.expectedStatus, p.PaymentStatus)
+			assert.Equal(t, c.expectedAcquirer, p.Acquirer())
+
+			attempts, err := d.GetPaymentAttempts(ctx, baseTestPayment.MerchantID, baseTestPayment.ID)
+			if !assert.NoError(t, err) {
+				return
+			}
+			acquirers := make([]string, 0, len(attempts))
+			for i, a := range attempts {
+				assert.Equal(t, i+1, a.Attempt)
+				acquirers = append(acquirers, a.Acquirer)
+			}
+			assert.Equal(t, c.expectedAttempts, acquirers)
+			assert.Equal(t, c.expectedFirstState, attempts[0].Status)
+			if c.expectedFirstCode != "" && assert.NotNil(t, attempts[0].Decline) {
+				assert.Equal(t, c.expectedFirstCode, attempts[0].Decline.Code)
+			}
+			if len(attempts) > 1 {
+				assert.Equal(t, status.PaymentSucceeded, attempts[1].Status)
+			}
+		})
+	}
+}
+
+func TestDomain_ReceiveAcquirerResult_Cascaded(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	primary := cfg.BankConfig.Name
+	// The payment cascades to the secondary acquirer, which does not send its result on its own
+	deps.BankClient = acquiringbank.NewMockClient(config.MockBankConfig{StatusCode: 503})
+	deps.Acquirers = map[string]payment.BankClient{"secondary": acquiringbank.NewMockClient(config.MockBankConfig{StatusCode: 202})}
+	deps.Router, err = routing.NewRouter(primary, routing.Config{
+		Acquirers: []routing.Acquirer{{Name: "secondary"}},
+		Cascade: routing.CascadeConfig{
+			Fallbacks: map[string]string{primary: "secondary"},
+			Policy:    routing.CascadePolicy{Enabled: true},
+		},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	d, cleanFn, err := getDomain(deps)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cleanFn()
+	ctx := context.Background()
+	_, err = d.CreatePayment(ctx, baseTestPayment)
+	if !assert.NoError(t, err) {
+		return
+	}
+	time.Sleep(time.Second)
+
+	steps := []struct {
+		name           string
+		acquirer       string
+		expectedStatus status.PaymentStatus
+	}{
+		{
+			name:           "result_of_previous_acquirer_does_not_decide_the_payment",
+			acquirer:       primary,
+			expectedStatus: status.PaymentProcessing,
+		},
+		{
+			name:           "result_of_current_acquirer",
+			acquirer:       "secondary",
+			expectedStatus: status.PaymentSucceeded,
+		},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			_, err := d.ReceiveAcquirerResult(ctx, payment.AcquirerResult{
+				EventID:   "evt_" + step.acquirer,
+				Acquirer:  step.acquirer,
+				Operation: payment.AcquirerOperationPayment,
+				ID:        baseTestPayment.ID,
+				Status:    "succeeded",
+			})
+			if !assert.NoError(t, err) {
+				return
+			}
+			p, err := d.GetPayment(ctx, baseTestPayment.MerchantID, baseTestPayment.ID)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, step.expectedStatus, p.PaymentStatus)
+			assert.Equal(t, "secondary", p.Acquirer())
+		})
+	}
+}
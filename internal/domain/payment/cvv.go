@@ -0,0 +1,60 @@
+package payment
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// cvvExpiration is how long the cvv of a payment is kept, waiting for the payment to be authorized.
+const cvvExpiration = 10 * time.Minute
+
+// cvvStore keeps the cvv of the payments that are being submitted, only in the memory of the instance that created them.
+// The cvv can not be stored once the payment is authorized, so it is never written to the database, the cache or the
+// logs. Payments submitted by another instance, after a restart or once their cvv expired, are sent without it, which
+// acquirers read as a cvv that was not provided.
+type cvvStore struct {
+	mu      sync.Mutex
+	entries map[uuid.UUID]cvvEntry
+}
+
+type cvvEntry struct {
+	cvv       string
+	expiresAt time.Time
+}
+
+func newCVVStore() *cvvStore {
+	return &cvvStore{entries: make(map[uuid.UUID]cvvEntry)}
+}
+
+// put keeps the cvv of the payment until it expires, and drops the ones that already expired.
+func (s *cvvStore) put(id uuid.UUID, cvv string) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, e := range s.entries {
+		if now.After(e.expiresAt) {
+			delete(s.entries, k)
+		}
+	}
+	s.entries[id] = cvvEntry{cvv: cvv, expiresAt: now.Add(cvvExpiration)}
+}
+
+// get returns the cvv of the payment, or an empty string when it is not kept by this instance.
+func (s *cvvStore) get(id uuid.UUID) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[id]
+	if !ok || time.Now().After(e.expiresAt) {
+		return ""
+	}
+	return e.cvv
+}
+
+// delete drops the cvv of the payment, once the payment does not need it anymore.
+func (s *cvvStore) delete(id uuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, id)
+}
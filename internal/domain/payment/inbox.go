@@ -0,0 +1,256 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/eapache/go-resiliency/retrier"
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	kitctx "github.com/marioarizaj/payment-gateway/kit/ctx"
+	"go.uber.org/zap"
+)
+
+// receiveResult stores the message on the inbox, deduplicated by its event id, and applies it right away. A message
+// that can not be applied is left pending on the inbox, so the Inbox applies it later.
+func (d *Domain) receiveResult(ctx context.Context, message *repositiory.InboxMessage) (Receipt, error) {
+	created, err := d.repo.CreateInboxMessage(ctx, message)
+	if err != nil {
+		return Receipt{}, err
+	}
+	if !created {
+		return Receipt{Duplicate: true}, nil
+	}
+	// The result is stored, so it is applied even when the acquirer stops waiting for the answer
+	ctx = kitctx.WithoutCancel(ctx)
+	var applied bool
+	// The result can arrive before the transaction that created the payment or refund is committed
+	err = retryUntilFound(func() error {
+		var processErr error
+		applied, processErr = d.processInboxMessage(ctx, message.ID)
+		return processErr
+	})
+	if err != nil {
+		d.logger.Warn("Acquirer result could not be applied, leaving it on the inbox",
+			zap.String("event_id", message.EventID),
+			zap.String("operation", message.Operation),
+			zap.String("id", message.ResourceID.String()),
+			zap.Error(err))
+		return Receipt{Pending: true}, nil
+	}
+	return Receipt{Duplicate: !applied}, nil
+}
+
+// receiveCallbackResult receives the result given to the callback of a bank client. The bank clients do not give an
+// event id, so every result is its own event. Storing it is retried, since nothing sends the result again.
+func (d *Domain) receiveCallbackResult(message *repositiory.InboxMessage) {
+	message.ID = uuid.New()
+	message.EventID = message.ID.String()
+	r := retrier.New(retrier.ExponentialBackoff(callbackRetries, 100*time.Millisecond), nil)
+	err := r.Run(func() error {
+		_, err := d.receiveResult(context.Background(), message)
+		return err
+	})
+	if err != nil {
+		d.logger.Error("Could not store acquirer result",
+			zap.String("operation", message.Operation),
+			zap.String("id", message.ResourceID.String()),
+			zap.String("status", message.ResultStatus),
+			zap.Error(err))
+	}
+}
+
+// processInboxMessage applies the message, invalidates the cached payment and finishes the message. It returns false
+// when the message was finished before, or its result was applied before the message was received.
+func (d *Domain) processInboxMessage(ctx context.Context, id uuid.UUID) (bool, error) {
+	message, applied, err := d.applyInboxMessage(ctx, id)
+	if err != nil || !applied {
+		return false, err
+	}
+	if message.Operation != AcquirerOperationRefund {
+		// The event of the payment, and the job of a payment that cascaded, are committed along with the result
+		d.events.NotifyDispatcher()
+		d.notifyOutbox()
+	}
+	// The cache is invalidated once the result is committed, so the old status is not cached again in between. A cache
+	// that can not be invalidated leaves the message pending, instead of serving the old status
+	if message.Operation != AcquirerOperationRefund {
+		storedPayment, err := d.repo.GetPaymentByID(ctx, message.ResourceID)
+		if err != nil {
+			return false, err
+		}
+		err = d.cache.DeleteKey(ctx, getPaymentCacheKey(storedPayment.MerchantID, storedPayment.ID))
+		if err != nil {
+			return false, err
+		}
+		// The cvv is kept while the payment may still cascade to another acquirer
+		if storedPayment.PaymentStatus != status.PaymentProcessing {
+			d.cvvs.delete(storedPayment.ID)
+		}
+	}
+	finishInboxMessage(message)
+	err = d.repo.UpdateInboxMessage(ctx, message)
+	if err == sql.ErrNoRows {
+		// The message was claimed again, and the call that claimed it finishes it
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// applyInboxMessage stores the event and applies the result of the message on a single transaction, which holds the
+// lock of the message, so a result is applied once however many times its message is processed. Transitions rejected
+// by the status tables are applied as well. It returns the message as it is stored, and true when its result was
+// applied, on this or an earlier call, and the message still needs to be finished.
+func (d *Domain) applyInboxMessage(ctx context.Context, id uuid.UUID) (*repositiory.InboxMessage, bool, error) {
+	txRepo, err := d.repo.Begin(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	message, err := txRepo.GetInboxMessageForUpdate(ctx, id)
+	if err != nil {
+		d.rollback(ctx, &txRepo)
+		return nil, false, err
+	}
+	if message.Status != repositiory.InboxMessagePending || message.AppliedAt != nil {
+		d.rollback(ctx, &txRepo)
+		return message, message.Status == repositiory.InboxMessagePending, nil
+	}
+	// Results posted before the inbox existed were only stored as events
+	created, err := txRepo.CreateAcquirerEvent(ctx, &repositiory.AcquirerEvent{
+		ID:         message.EventID,
+		Operation:  message.Operation,
+		ResourceID: message.ResourceID,
+	})
+	if err != nil {
+		d.rollback(ctx, &txRepo)
+		return nil, false, err
+	}
+	if !created {
+		finishInboxMessage(message)
+		err = txRepo.UpdateInboxMessage(ctx, message)
+		if err != nil {
+			d.rollback(ctx, &txRepo)
+			return nil, false, err
+		}
+		return message, false, txRepo.Commit(ctx)
+	}
+	err = d.applyAcquirerResult(ctx, &txRepo, message)
+	if err != nil && !errors.Is(err, status.ErrIllegalTransition) {
+		d.rollback(ctx, &txRepo)
+		return nil, false, err
+	}
+	d.logStatusUpdateError(message.ResourceID, err)
+	err = txRepo.MarkInboxMessageApplied(ctx, message)
+	if err != nil {
+		d.rollback(ctx, &txRepo)
+		return nil, false, err
+	}
+	return message, true, txRepo.Commit(ctx)
+}
+
+// finishInboxMessage moves the message to done.
+func finishInboxMessage(message *repositiory.InboxMessage) {
+	now := time.Now()
+	message.Status = repositiory.InboxMessageDone
+	message.FinishedAt = &now
+	message.LastError = ""
+}
+
+// Inbox applies the results of the acquirers that could not be applied when they arrived, like results for payments
+// that were not committed yet, or whose cached payment could not be invalidated. Messages that fail are applied again
+// later, waiting twice as long after each attempt, until they are dead lettered.
+type Inbox struct {
+	domain *Domain
+	cfg    config.InboxConfig
+}
+
+func NewInbox(domain *Domain, cfg config.InboxConfig) *Inbox {
+	return &Inbox{
+		domain: domain,
+		cfg:    cfg,
+	}
+}
+
+// Run applies the messages that are due on every poll interval, until the context is done.
+func (i *Inbox) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(i.cfg.PollInterval) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			i.ProcessDue(ctx)
+		}
+	}
+}
+
+// ProcessDue applies every message that is visible, and returns how many of them it claimed.
+func (i *Inbox) ProcessDue(ctx context.Context) int {
+	processed := 0
+	for ctx.Err() == nil {
+		ok, err := i.processNext(ctx)
+		if err != nil {
+			i.domain.logger.Error("Could not process inbox message", zap.Error(err))
+			return processed
+		}
+		if !ok {
+			return processed
+		}
+		processed++
+	}
+	return processed
+}
+
+// processNext claims the message that is visible the longest and processes it, writing the error when it fails. It
+// returns false when no message is visible.
+func (i *Inbox) processNext(ctx context.Context) (bool, error) {
+	d := i.domain
+	message, err := d.repo.ClaimInboxMessage(ctx, time.Now(), time.Duration(i.cfg.VisibilityTimeout)*time.Millisecond)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	ctx = kitctx.WithoutCancel(ctx)
+	_, processErr := d.processInboxMessage(ctx, message.ID)
+	if processErr == nil {
+		return true, nil
+	}
+	message.LastError = processErr.Error()
+	if message.Attempts >= i.cfg.MaxAttempts {
+		d.logger.Error("Inbox message failed on every attempt, dead lettering it",
+			zap.String("id", message.ID.String()),
+			zap.String("event_id", message.EventID),
+			zap.String("operation", message.Operation),
+			zap.String("resource_id", message.ResourceID.String()),
+			zap.Int("attempts", message.Attempts),
+			zap.Error(processErr))
+		now := time.Now()
+		message.Status = repositiory.InboxMessageDead
+		message.FinishedAt = &now
+	} else {
+		d.logger.Warn("Inbox message failed, applying it again later",
+			zap.String("id", message.ID.String()),
+			zap.Int("attempts", message.Attempts),
+			zap.Error(processErr))
+		message.VisibleAt = time.Now().Add(backoff(i.cfg.InitialBackoff, i.cfg.MaxBackoff, message.Attempts))
+	}
+	err = d.repo.UpdateInboxMessage(ctx, message)
+	if err == sql.ErrNoRows {
+		d.logger.Warn("Inbox message was claimed again while it was processed", zap.String("id", message.ID.String()))
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
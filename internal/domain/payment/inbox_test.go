@@ -0,0 +1,181 @@
+package payment_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/acquiringbank"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/dependencies"
+	"github.com/marioarizaj/payment-gateway/internal/domain/payment"
+	"github.com/marioarizaj/payment-gateway/internal/domain/webhook"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"github.com/marioarizaj/payment-gateway/kit/rediscache"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// flakyCache fails to delete the keys while failDeletes is set, like a cache that can not be reached.
+type flakyCache struct {
+	payment.Cache
+	failDeletes bool
+}
+
+func (c *flakyCache) DeleteKey(ctx context.Context, k string) error {
+	if c.failDeletes {
+		return errors.New("dial tcp: connection refused")
+	}
+	return c.Cache.DeleteKey(ctx, k)
+}
+
+func TestInbox_ProcessDue(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	// The results are only posted by the tests
+	deps.BankClient = acquiringbank.NewMockClient(config.MockBankConfig{StatusCode: http.StatusAccepted})
+
+	cases := []struct {
+		name string
+		// createdBefore and createdAfter tell whether the payment is committed before or after its result arrives
+		createdBefore         bool
+		createdAfter          bool
+		failDeletes           bool
+		maxAttempts           int
+		expectedReceipt       payment.Receipt
+		expectedProcessed     int
+		expectedPaymentStatus status.PaymentStatus
+		expectedMessageStatus string
+	}{
+		{
+			name:                  "applied_when_received",
+			createdBefore:         true,
+			maxAttempts:           3,
+			expectedPaymentStatus: status.PaymentSucceeded,
+			expectedMessageStatus: repositiory.InboxMessageDone,
+		},
+		{
+			name:                  "payment_committed_after_result",
+			createdAfter:          true,
+			maxAttempts:           3,
+			expectedReceipt:       payment.Receipt{Pending: true},
+			expectedProcessed:     1,
+			expectedPaymentStatus: status.PaymentSucceeded,
+			expectedMessageStatus: repositiory.InboxMessageDone,
+		},
+		{
+			name:                  "cache_not_invalidated",
+			createdBefore:         true,
+			failDeletes:           true,
+			maxAttempts:           3,
+			expectedReceipt:       payment.Receipt{Pending: true},
+			expectedProcessed:     1,
+			expectedPaymentStatus: status.PaymentSucceeded,
+			expectedMessageStatus: repositiory.InboxMessageDone,
+		},
+		{
+			name:                  "dead_lettered",
+			maxAttempts:           1,
+			expectedReceipt:       payment.Receipt{Pending: true},
+			expectedProcessed:     1,
+			expectedMessageStatus: repositiory.InboxMessageDead,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cache := &flakyCache{Cache: rediscache.NewRedisClient(deps.Redis)}
+			d, repo, cleanFn, err := getDomainWithCache(deps, cache)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer cleanFn()
+			ctx := context.Background()
+			p := baseTestPayment
+			p.ID = uuid.New()
+			if c.createdBefore && !assert.NoError(t, repo.CreatePayment(ctx, p.GetStoragePayment())) {
+				return
+			}
+
+			// The merchant is notified once, whichever attempt applied the result
+			endpoint := &repositiory.WebhookEndpoint{
+				ID:         uuid.New(),
+				MerchantID: p.MerchantID,
+				URL:        "https://merchant.example.com/webhooks",
+				EventTypes: []string{payment_gateway.EventPaymentSucceeded},
+				Secret:     "whsec_test",
+				Enabled:    true,
+			}
+			if !assert.NoError(t, repo.CreateWebhookEndpoint(ctx, endpoint)) {
+				return
+			}
+
+			cache.failDeletes = c.failDeletes
+			result := payment.AcquirerResult{EventID: "evt_" + p.ID.String(), Acquirer: cfg.BankConfig.Name, Operation: payment.AcquirerOperationPayment, ID: p.ID, Status: "succeeded"}
+			receipt, err := d.ReceiveAcquirerResult(ctx, result)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, c.expectedReceipt, receipt)
+			cache.failDeletes = false
+
+			if c.createdAfter && !assert.NoError(t, repo.CreatePayment(ctx, p.GetStoragePayment())) {
+				return
+			}
+			inbox := payment.NewInbox(d, config.InboxConfig{VisibilityTimeout: 10000, InitialBackoff: 60000, MaxBackoff: 60000, MaxAttempts: c.maxAttempts})
+			assert.Equal(t, c.expectedProcessed, inbox.ProcessDue(ctx))
+			// The same event is received once, however many times the acquirer delivers it
+			receipt, err = d.ReceiveAcquirerResult(ctx, result)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, payment.Receipt{Duplicate: true}, receipt)
+
+			messages, err := repo.GetInboxMessages(ctx, p.ID)
+			if !assert.NoError(t, err) || !assert.Len(t, messages, 1) {
+				return
+			}
+			assert.Equal(t, c.expectedMessageStatus, messages[0].Status)
+			if c.expectedPaymentStatus == "" {
+				return
+			}
+			assert.NotNil(t, messages[0].AppliedAt)
+			events, err := repo.GetWebhookEvents(ctx, endpoint.ID)
+			if !assert.NoError(t, err) || !assert.Len(t, events, 1) {
+				return
+			}
+			assert.Equal(t, payment_gateway.EventPaymentSucceeded, events[0].EventType)
+			stored, err := d.GetPayment(ctx, p.MerchantID, p.ID)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, c.expectedPaymentStatus, stored.PaymentStatus)
+		})
+	}
+}
+
+// getDomainWithCache returns the domain and its repository, using the given cache.
+func getDomainWithCache(deps dependencies.Dependencies, cache payment.Cache) (*payment.Domain, repositiory.Repository, func(), error) {
+	ctx := context.Background()
+	tx, err := deps.DB.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	repo := repositiory.NewRepository(tx)
+	webhooks := webhook.NewDomain(repo, http.DefaultClient, zap.NewNop(), config.WebhookConfig{})
+	d := payment.NewDomain(repo, cache, zap.NewNop(), deps.BankClients(), deps.Router, webhooks)
+	return d, repo, func() {
+		_ = tx.Rollback()
+		deps.Redis.FlushAll(ctx)
+	}, nil
+}
@@ -0,0 +1,152 @@
+package payment
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"github.com/marioarizaj/payment-gateway/kit/responses"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+var errInvalidCursor = errors.New("cursor is not valid")
+
+// ListPaymentsFilter contains the filters a merchant can use to search their payments.
+// Zero values are not used as filters.
+type ListPaymentsFilter struct {
+	PaymentStatus status.PaymentStatus
+	CurrencyCode  string
+	AmountFrom    *int64
+	AmountTo      *int64
+	CreatedFrom   *time.Time
+	CreatedTo     *time.Time
+	CardLast4     string
+	Metadata      map[string]string
+	Description   string
+	// Cursor is the next_cursor returned with the previous page
+	Cursor string
+	Limit  int
+}
+
+// ListPayments returns a page of the merchant's payments, newest first, along with the cursor of the next page.
+// The cursor is empty when there are no more payments.
+func (d *Domain) ListPayments(ctx context.Context, merchantID uuid.UUID, filter ListPaymentsFilter) ([]payment_gateway.Payment, string, error) {
+	repoFilter, err := filter.toRepositoryFilter(merchantID)
+	if err != nil {
+		return nil, "", responses.BadRequestError{Err: err}
+	}
+	limit := repoFilter.Limit
+	// Ask for one more payment than needed, to know if there is a next page
+	repoFilter.Limit++
+	storedPayments, err := d.repo.ListPayments(ctx, repoFilter)
+	if err != nil {
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return nil, "", responses.InternalServerError{Err: err}
+	}
+
+	var nextCursor string
+	if len(storedPayments) > limit {
+		storedPayments = storedPayments[:limit]
+		last := storedPayments[limit-1]
+		nextCursor = encodeCursor(repositiory.PaymentCursor{CreatedAt: *last.CreatedAt, ID: last.ID})
+	}
+	payments := make([]payment_gateway.Payment, 0, len(storedPayments))
+	for i := range storedPayments {
+		payments = append(payments, payment_gateway.GetPaymentFromStoredPayment(&storedPayments[i]))
+	}
+	return payments, nextCursor, nil
+}
+
+func (f ListPaymentsFilter) toRepositoryFilter(merchantID uuid.UUID) (repositiory.PaymentFilter, error) {
+	if f.PaymentStatus != "" && !f.PaymentStatus.IsValid() {
+		return repositiory.PaymentFilter{}, fmt.Errorf("status %s is not valid", f.PaymentStatus)
+	}
+	if f.AmountFrom != nil && f.AmountTo != nil && *f.AmountFrom > *f.AmountTo {
+		return repositiory.PaymentFilter{}, errors.New("amount_from can not be greater than amount_to")
+	}
+	if f.CreatedFrom != nil && f.CreatedTo != nil && f.CreatedFrom.After(*f.CreatedTo) {
+		return repositiory.PaymentFilter{}, errors.New("created_from can not be after created_to")
+	}
+	if f.CardLast4 != "" && !isLast4(f.CardLast4) {
+		return repositiory.PaymentFilter{}, errors.New("card_last4 must contain exactly 4 digits")
+	}
+	if f.Limit < 0 || f.Limit > maxListLimit {
+		return repositiory.PaymentFilter{}, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+	}
+	if f.Limit == 0 {
+		f.Limit = defaultListLimit
+	}
+	repoFilter := repositiory.PaymentFilter{
+		MerchantID:    merchantID,
+		PaymentStatus: f.PaymentStatus,
+		CurrencyCode:  f.CurrencyCode,
+		AmountFrom:    f.AmountFrom,
+		AmountTo:      f.AmountTo,
+		CreatedFrom:   f.CreatedFrom,
+		CreatedTo:     f.CreatedTo,
+		CardLast4:     f.CardLast4,
+		Metadata:      f.Metadata,
+		Description:   f.Description,
+		Limit:         f.Limit,
+	}
+	if f.Cursor != "" {
+		cursor, err := decodeCursor(f.Cursor)
+		if err != nil {
+			return repositiory.PaymentFilter{}, err
+		}
+		repoFilter.After = &cursor
+	}
+	return repoFilter, nil
+}
+
+func isLast4(s string) bool {
+	if len(s) != 4 {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeCursor returns an opaque cursor pointing to the given payment.
+// Merchants should not rely on its format, it may change at any time.
+func encodeCursor(c repositiory.PaymentCursor) string {
+	raw := fmt.Sprintf("%d_%s", c.CreatedAt.UnixNano(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (repositiory.PaymentCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return repositiory.PaymentCursor{}, errInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), "_", 2)
+	if len(parts) != 2 {
+		return repositiory.PaymentCursor{}, errInvalidCursor
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return repositiory.PaymentCursor{}, errInvalidCursor
+	}
+	id, err := uuid.Parse(parts[1])
+	if err != nil {
+		return repositiory.PaymentCursor{}, errInvalidCursor
+	}
+	return repositiory.PaymentCursor{CreatedAt: time.Unix(0, nanos).UTC(), ID: id}, nil
+}
@@ -0,0 +1,131 @@
+package payment_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/dependencies"
+	"github.com/marioarizaj/payment-gateway/internal/domain/payment"
+	"github.com/marioarizaj/payment-gateway/kit/responses"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDomain_ListPayments(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	d, repo, cleanFn, err := getDomainWithRepo(deps)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cleanFn()
+
+	// Insert 5 payments, one minute apart, so the expected order is known
+	createdAt := time.Date(2022, 7, 28, 10, 0, 0, 0, time.UTC)
+	ids := make([]uuid.UUID, 5)
+	for i := range ids {
+		p := baseTestPayment
+		p.ID = uuid.New()
+		ids[len(ids)-1-i] = p.ID
+		stored := p.GetStoragePayment()
+		created := createdAt.Add(time.Duration(i) * time.Minute)
+		stored.CreatedAt = &created
+		stored.UpdatedAt = &created
+		err = repo.CreatePayment(context.Background(), stored)
+		if !assert.NoError(t, err) {
+			return
+		}
+	}
+
+	// Walk all pages, following the cursor
+	var actualIDs []uuid.UUID
+	filter := payment.ListPaymentsFilter{Limit: 2}
+	pages := 0
+	for {
+		payments, nextCursor, err := d.ListPayments(context.Background(), baseTestPayment.MerchantID, filter)
+		if !assert.NoError(t, err) {
+			return
+		}
+		pages++
+		for _, p := range payments {
+			assert.Equal(t, baseTestPayment.MerchantID, p.MerchantID)
+			actualIDs = append(actualIDs, p.ID)
+		}
+		if nextCursor == "" {
+			break
+		}
+		filter.Cursor = nextCursor
+	}
+	assert.Equal(t, 3, pages)
+	assert.Equal(t, ids, actualIDs)
+
+	// A page that exactly fits the remaining payments should not return a cursor
+	payments, nextCursor, err := d.ListPayments(context.Background(), baseTestPayment.MerchantID, payment.ListPaymentsFilter{Limit: 5})
+	assert.NoError(t, err)
+	assert.Len(t, payments, 5)
+	assert.Empty(t, nextCursor)
+}
+
+func TestDomain_ListPayments_InvalidFilter(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	amountFrom, amountTo := int64(2000), int64(1000)
+
+	cases := []struct {
+		name          string
+		filter        payment.ListPaymentsFilter
+		expectedError error
+	}{
+		{
+			name:          "invalid_status",
+			filter:        payment.ListPaymentsFilter{PaymentStatus: "success"},
+			expectedError: responses.BadRequestError{Err: errors.New("status success is not valid")},
+		},
+		{
+			name:          "invalid_cursor",
+			filter:        payment.ListPaymentsFilter{Cursor: "not-a-cursor"},
+			expectedError: responses.BadRequestError{Err: errors.New("cursor is not valid")},
+		},
+		{
+			name:          "limit_too_high",
+			filter:        payment.ListPaymentsFilter{Limit: 101},
+			expectedError: responses.BadRequestError{Err: errors.New("limit must be between 1 and 100")},
+		},
+		{
+			name:          "invalid_card_last4",
+			filter:        payment.ListPaymentsFilter{CardLast4: "12a4"},
+			expectedError: responses.BadRequestError{Err: errors.New("card_last4 must contain exactly 4 digits")},
+		},
+		{
+			name:          "invalid_amount_range",
+			filter:        payment.ListPaymentsFilter{AmountFrom: &amountFrom, AmountTo: &amountTo},
+			expectedError: responses.BadRequestError{Err: errors.New("amount_from can not be greater than amount_to")},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d, cleanFn, err := getDomain(deps)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer cleanFn()
+			_, _, err = d.ListPayments(context.Background(), baseTestPayment.MerchantID, c.filter)
+			assert.Equal(t, c.expectedError, err)
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	kitctx "github.com/marioarizaj/payment-gateway/kit/ctx"
+	"go.uber.org/zap"
+)
+
+// enqueuePayment writes the job that submits the payment to its acquirer. The job never has the cvv.
+func (d *Domain) enqueuePayment(ctx context.Context, repo repositiory.Repository, payment payment_gateway.Payment) error {
+	return repo.CreateOutboxJob(ctx, &repositiory.OutboxJob{
+		ID:         uuid.New(),
+		Kind:       repositiory.OutboxJobSubmitPayment,
+		ResourceID: payment.ID,
+	})
+}
+
+// enqueueCancel writes the job that sends the cancellation of the payment to its acquirer.
+func (d *Domain) enqueueCancel(ctx context.Context, repo repositiory.Repository, paymentID uuid.UUID) error {
+	return repo.CreateOutboxJob(ctx, &repositiory.OutboxJob{
+		ID:         uuid.New(),
+		Kind:       repositiory.OutboxJobCancelPayment,
+		ResourceID: paymentID,
+	})
+}
+
+// notifyOutbox wakes up an idle worker of the outbox, without blocking when none of them is idle.
+func (d *Domain) notifyOutbox() {
+	select {
+	case d.outboxJobs <- struct{}{}:
+	default:
+	}
+}
+
+// Outbox is the pool of workers that run the jobs of the outbox, like submitting new payments to their acquirer.
+// Jobs that fail are run again later, waiting twice as long after each attempt, until they are dead lettered.
+type Outbox struct {
+	domain *Domain
+	cfg    config.OutboxConfig
+}
+
+func NewOutbox(domain *Domain, cfg config.OutboxConfig) *Outbox {
+	return &Outbox{
+		domain: domain,
+		cfg:    cfg,
+	}
+}
+
+// Run starts the workers, and waits for them to stop once the context is done.
+func (o *Outbox) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < o.cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			o.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// work runs the jobs that are due, and then waits for a new job or for the next poll, until the context is done.
+func (o *Outbox) work(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(o.cfg.PollInterval) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		o.ProcessDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-o.domain.outboxJobs:
+		}
+	}
+}
+
+// ProcessDue runs every job that is visible, and returns how many of them it ran.
+func (o *Outbox) ProcessDue(ctx context.Context) int {
+	processed := 0
+	for ctx.Err() == nil {
+		ok, err := o.processNext(ctx)
+		if err != nil {
+			o.domain.logger.Error("Could not run outbox job", zap.Error(err))
+			return processed
+		}
+		if !ok {
+			return processed
+		}
+		processed++
+	}
+	return processed
+}
+
+// processNext claims the job that is visible the longest, and runs it. It returns false when no job is visible.
+func (o *Outbox) processNext(ctx context.Context) (bool, error) {
+	d := o.domain
+	job, err := d.repo.ClaimOutboxJob(ctx, time.Now(), time.Duration(o.cfg.VisibilityTimeout)*time.Millisecond)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// A job that was claimed runs to the end when the workers are stopped, since the acquirer may be processing it
+	ctx = kitctx.WithoutCancel(ctx)
+	runErr := o.run(ctx, job)
+	switch {
+	case runErr == nil:
+		o.finish(job, repositiory.OutboxJobDone, nil)
+	case job.Attempts >= o.cfg.MaxAttempts:
+		d.logger.Error("Outbox job failed on every attempt, dead lettering it",
+			zap.String("id", job.ID.String()),
+			zap.String("kind", job.Kind),
+			zap.String("resource_id", job.ResourceID.String()),
+			zap.Int("attempts", job.Attempts),
+			zap.Error(runErr))
+		o.finish(job, repositiory.OutboxJobDead, runErr)
+	default:
+		o.retry(job, runErr)
+	}
+	err = d.repo.UpdateOutboxJob(ctx, job)
+	if err == sql.ErrNoRows {
+		d.logger.Warn("Outbox job was claimed again while it was running", zap.String("id", job.ID.String()))
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (o *Outbox) run(ctx context.Context, job *repositiory.OutboxJob) error {
+	switch job.Kind {
+	case repositiory.OutboxJobSubmitPayment:
+		return o.submitPayment(ctx, job)
+	case repositiory.OutboxJobCancelPayment:
+		return o.cancelPayment(ctx, job)
+	default:
+		return fmt.Errorf("outbox job kind %s is not supported", job.Kind)
+	}
+}
+
+// submitPayment sends the payment of the job to its acquirer, and fails the payment when the acquirer declines it.
+func (o *Outbox) submitPayment(ctx context.Context, job *repositiory.OutboxJob) error {
+	d := o.domain
+	storedPayment, err := d.repo.GetPaymentByID(ctx, job.ResourceID)
+	if err != nil {
+		return err
+	}
+	// The payment was canceled, or its result arrived, after the job was written or on an earlier delivery of the job
+	if storedPayment.PaymentStatus != status.PaymentProcessing {
+		d.cvvs.delete(storedPayment.ID)
+		return nil
+	}
+	payment := payment_gateway.GetPaymentFromStoredPayment(storedPayment)
+	// The acquirer gets the card number, which is masked on the payment shown to the merchant
+	payment.CardInfo.CardNumber = storedPayment.CardNumber
+	payment.CardInfo.CVV = d.cvvs.get(payment.ID)
+	attempts, err := d.repo.GetPaymentAttempts(ctx, payment.ID)
+	if err != nil {
+		return err
+	}
+	// A job that is delivered again sends the payment again to the same acquirer, which keeps its attempt
+	attempted := make([]string, 0, len(attempts))
+	for _, a := range attempts {
+		if a.Acquirer != payment.Acquirer() {
+			attempted = append(attempted, a.Acquirer)
+		}
+	}
+	err = d.sendPayment(ctx, d.repo, payment, attempted)
+	var declineErr decline.Error
+	if !errors.As(err, &declineErr) {
+		return err
+	}
+	retry := declineErr.Decline != nil && declineErr.Decline.RetryAdvised
+	if retry && job.Attempts < o.cfg.MaxAttempts {
+		return err
+	}
+	// The route of the payment may have changed while it cascaded
+	storedPayment, failErr := d.repo.GetPaymentByID(ctx, payment.ID)
+	if failErr != nil {
+		return failErr
+	}
+	failErr = d.failPayment(ctx, payment_gateway.GetPaymentFromStoredPayment(storedPayment), declineErr.Decline)
+	if failErr != nil {
+		return failErr
+	}
+	if retry {
+		return err
+	}
+	return nil
+}
+
+// cancelPayment sends the cancellation of the payment of the job, already canceled on the gateway, to its acquirer.
+func (o *Outbox) cancelPayment(ctx context.Context, job *repositiory.OutboxJob) error {
+	d := o.domain
+	storedPayment, err := d.repo.GetPaymentByID(ctx, job.ResourceID)
+	if err != nil {
+		return err
+	}
+	return d.CancelPaymentOnAcquiringBank(ctx, payment_gateway.GetPaymentFromStoredPayment(storedPayment))
+}
+
+// failPayment fails the payment with the decline of the acquirer that did not accept it, and notifies the merchant.
+func (d *Domain) failPayment(ctx context.Context, payment payment_gateway.Payment, paymentDecline *decline.Decline) error {
+	d.cvvs.delete(payment.ID)
+	payment.PaymentStatus = status.PaymentFailed
+	payment.Decline = paymentDecline
+	txRepo, err := d.repo.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	err = d.applyPaymentResult(ctx, &txRepo, payment)
+	if errors.Is(err, status.ErrIllegalTransition) {
+		d.rollback(ctx, &txRepo)
+		d.logStatusUpdateError(payment.ID, err)
+		return nil
+	}
+	if err != nil {
+		d.rollback(ctx, &txRepo)
+		return err
+	}
+	err = txRepo.Commit(ctx)
+	if err != nil {
+		return err
+	}
+	d.events.NotifyDispatcher()
+	return nil
+}
+
+// finish ends the job with the given status.
+func (o *Outbox) finish(job *repositiory.OutboxJob, jobStatus string, err error) {
+	now := time.Now()
+	job.Status = jobStatus
+	job.FinishedAt = &now
+	job.LastError = ""
+	if err != nil {
+		job.LastError = err.Error()
+	}
+}
+
+// retry records the error of the attempt, and makes the job visible again after a backoff that doubles with every attempt.
+func (o *Outbox) retry(job *repositiory.OutboxJob, err error) {
+	o.domain.logger.Warn("Outbox job failed, running it again later",
+		zap.String("id", job.ID.String()),
+		zap.String("kind", job.Kind),
+		zap.Int("attempts", job.Attempts),
+		zap.Error(err))
+	job.VisibleAt = time.Now().Add(backoff(o.cfg.InitialBackoff, o.cfg.MaxBackoff, job.Attempts))
+	job.LastError = err.Error()
+}
@@ -0,0 +1,188 @@
+package payment_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/acquiringbank"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
+	"github.com/marioarizaj/payment-gateway/internal/dependencies"
+	"github.com/marioarizaj/payment-gateway/internal/domain/payment"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingClient keeps the payments that reach the bank, so the tests can check what the acquirer is sent.
+type recordingClient struct {
+	payment.BankClient
+	mu       sync.Mutex
+	payments []payment_gateway.Payment
+}
+
+func (c *recordingClient) CreatePayment(ctx context.Context, p payment_gateway.Payment, callBack func(payment payment_gateway.Payment)) http.Response {
+	c.mu.Lock()
+	c.payments = append(c.payments, p)
+	c.mu.Unlock()
+	return c.BankClient.CreatePayment(ctx, p, callBack)
+}
+
+func (c *recordingClient) received() []payment_gateway.Payment {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]payment_gateway.Payment(nil), c.payments...)
+}
+
+func TestOutbox_ProcessDue(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	// The bank accepts the payments, and the tests do not wait for their result
+	accept := config.MockBankConfig{StatusCode: http.StatusAccepted, SleepIntervalInitialRequest: 10}
+	failing := config.MockBankConfig{StatusCode: http.StatusInternalServerError, SleepIntervalInitialRequest: 10}
+
+	cases := []struct {
+		name              string
+		mockConfig        config.MockBankConfig
+		maxAttempts       int
+		cancel            bool
+		expectedProcessed int
+		expectedStatus    status.PaymentStatus
+		expectedDecline   *decline.Decline
+		expectedJobStatus string
+		expectedAttempts  int
+	}{
+		{
+			name:              "payment_submitted",
+			mockConfig:        accept,
+			maxAttempts:       2,
+			expectedProcessed: 1,
+			expectedStatus:    status.PaymentProcessing,
+			expectedJobStatus: repositiory.OutboxJobDone,
+			expectedAttempts:  1,
+		},
+		{
+			name:              "payment_declined",
+			mockConfig:        config.MockBankConfig{StatusCode: http.StatusBadRequest, SleepIntervalInitialRequest: 10},
+			maxAttempts:       2,
+			expectedProcessed: 1,
+			expectedStatus:    status.PaymentFailed,
+			expectedDecline:   decline.FromStatusCode(http.StatusBadRequest),
+			expectedJobStatus: repositiory.OutboxJobDone,
+			expectedAttempts:  1,
+		},
+		{
+			name:              "failing_acquirer_is_retried",
+			mockConfig:        failing,
+			maxAttempts:       2,
+			expectedProcessed: 1,
+			expectedStatus:    status.PaymentProcessing,
+			expectedJobStatus: repositiory.OutboxJobPending,
+			expectedAttempts:  1,
+		},
+		{
+			name:              "dead_lettered",
+			mockConfig:        failing,
+			maxAttempts:       1,
+			expectedProcessed: 1,
+			expectedStatus:    status.PaymentFailed,
+			expectedDecline:   decline.FromStatusCode(0),
+			expectedJobStatus: repositiory.OutboxJobDead,
+			expectedAttempts:  1,
+		},
+		{
+			// The job that sends the cancellation runs along with the job of the payment
+			name:              "canceled_before_submitted",
+			mockConfig:        accept,
+			maxAttempts:       2,
+			cancel:            true,
+			expectedProcessed: 2,
+			expectedStatus:    status.PaymentCanceled,
+			expectedJobStatus: repositiory.OutboxJobDone,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			bank := &recordingClient{BankClient: acquiringbank.NewMockClient(c.mockConfig)}
+			deps.BankClient = bank
+			d, repo, cleanFn, err := getDomainWithoutOutbox(deps)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer cleanFn()
+			ctx := context.Background()
+			_, err = d.CreatePayment(ctx, baseTestPayment)
+			if !assert.NoError(t, err) {
+				return
+			}
+			if c.cancel {
+				_, err = d.CancelPayment(ctx, baseTestPayment.MerchantID, baseTestPayment.ID)
+				if !assert.NoError(t, err) {
+					return
+				}
+			}
+
+			outboxConfig := testOutboxConfig
+			outboxConfig.MaxAttempts = c.maxAttempts
+			outboxConfig.InitialBackoff = int(time.Minute / time.Millisecond)
+			outboxConfig.MaxBackoff = outboxConfig.InitialBackoff
+			outbox := payment.NewOutbox(d, outboxConfig)
+			assert.Equal(t, c.expectedProcessed, outbox.ProcessDue(ctx))
+			// Jobs that are retried are only visible again after the backoff
+			assert.Equal(t, 0, outbox.ProcessDue(ctx))
+
+			stored, err := repo.GetPaymentByID(ctx, baseTestPayment.ID)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, c.expectedStatus, stored.PaymentStatus)
+			assert.Equal(t, c.expectedDecline, stored.Decline)
+
+			jobs, err := repo.GetOutboxJobs(ctx, baseTestPayment.ID)
+			if !assert.NoError(t, err) || !assert.Len(t, jobs, c.expectedProcessed) {
+				return
+			}
+			for _, job := range jobs {
+				if job.Kind != repositiory.OutboxJobSubmitPayment {
+					continue
+				}
+				assert.Equal(t, c.expectedJobStatus, job.Status)
+				assert.Equal(t, 1, job.Attempts)
+				if c.expectedJobStatus == repositiory.OutboxJobPending {
+					assert.NotEmpty(t, job.LastError)
+				}
+			}
+
+			attempts, err := repo.GetPaymentAttempts(ctx, baseTestPayment.ID)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Len(t, attempts, c.expectedAttempts)
+
+			// The acquirer gets the card number that was given, not the masked one shown to the merchant
+			received := bank.received()
+			if c.cancel {
+				assert.Empty(t, received)
+				return
+			}
+			if !assert.NotEmpty(t, received) {
+				return
+			}
+			for _, p := range received {
+				assert.Equal(t, baseTestPayment.CardInfo.CardNumber, p.CardInfo.CardNumber)
+				// The cvv is kept in memory by the instance that created the payment, and never stored
+				assert.Equal(t, baseTestPayment.CardInfo.CVV, p.CardInfo.CVV)
+			}
+		})
+	}
+}
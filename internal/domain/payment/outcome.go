@@ -0,0 +1,42 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/afex/hystrix-go/hystrix"
+	"github.com/marioarizaj/payment-gateway/kit/responses"
+)
+
+// errOutcomeUnknown is returned inside the circuit breaker when the acquirer answered with 504 Gateway Timeout,
+// so the call is not retried.
+var errOutcomeUnknown = errors.New("the acquirer did not answer in time")
+
+// OutcomeUnknownError is returned when a call to the acquirer timed out or was canceled after it was sent. The acquirer
+// may have processed the operation, so it is neither a decline nor safe to send again to this or another acquirer.
+type OutcomeUnknownError struct {
+	Err error
+}
+
+func (e OutcomeUnknownError) Error() string {
+	return "outcome of the acquirer operation is unknown: " + e.Err.Error()
+}
+
+func (e OutcomeUnknownError) Unwrap() error {
+	return e.Err
+}
+
+func (e OutcomeUnknownError) Response(w http.ResponseWriter) {
+	responses.RespondWithJSON(w, http.StatusGatewayTimeout, map[string]string{
+		"error":   e.Error(),
+		"outcome": "unknown",
+	})
+}
+
+// isOutcomeUnknown tells whether the error of a call to the acquirer leaves its outcome unknown: the acquirer answered
+// with 504 Gateway Timeout, the breaker timed out while waiting, or the context of the call was done.
+func isOutcomeUnknown(err error) bool {
+	return errors.Is(err, errOutcomeUnknown) || errors.Is(err, hystrix.ErrTimeout) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
@@ -15,8 +15,14 @@ import (
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/breaker"
 	"github.com/marioarizaj/payment-gateway/internal/creditcard"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
 	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/internal/routing"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	kitctx "github.com/marioarizaj/payment-gateway/kit/ctx"
+	"github.com/marioarizaj/payment-gateway/kit/prometheus"
 	"github.com/marioarizaj/payment-gateway/kit/responses"
 	"github.com/uptrace/bun/driver/pgdriver"
 )
@@ -25,13 +31,30 @@ const (
 	deduplicationCacheKey = "deduplication"
 	paymentCacheKey       = "payment"
 
-	createPaymentAcquiringBank = "create_payment_in_acquiring_bank"
-
-	retries = 3
+	retries         = 3
+	callbackRetries = 5
 )
 
+// BankClient sends the operations to an acquirer. The context carries the deadline of the call, and the request id and
+// merchant that are sent along to the acquirer. Callbacks run after the call returns, so they must not use its context.
+// Calls that time out or are canceled after being sent return 504 Gateway Timeout, since their outcome is unknown.
 type BankClient interface {
-	CreatePayment(payment payment_gateway.Payment, callBack func(payment payment_gateway.Payment)) http.Response
+	CreatePayment(ctx context.Context, payment payment_gateway.Payment, callBack func(payment payment_gateway.Payment)) http.Response
+	// CreateRefund returns the amount of the refund to the card of the payment, which carries the unmasked card number.
+	CreateRefund(ctx context.Context, payment payment_gateway.Payment, refund payment_gateway.Refund, callBack func(refund payment_gateway.Refund)) http.Response
+	CapturePayment(ctx context.Context, payment payment_gateway.Payment, callBack func(payment payment_gateway.Payment)) http.Response
+	CancelPayment(ctx context.Context, payment payment_gateway.Payment) http.Response
+	// InquirePayment answers with the payment as the acquirer knows it, 404 Not Found when it never received the payment,
+	// or 501 Not Implemented when the acquirer does not support status inquiries.
+	InquirePayment(ctx context.Context, payment payment_gateway.Payment) http.Response
+}
+
+// Router chooses the acquirer of each payment, out of the registered bank clients, and the fallback acquirer
+// of the payments that are declined with a soft decline.
+type Router interface {
+	Route(payment routing.Payment) routing.Route
+	DefaultAcquirer() string
+	Fallback(merchantID uuid.UUID, attempted []string) (string, bool)
 }
 
 type Cache interface {
@@ -40,32 +63,77 @@ type Cache interface {
 	DeleteKey(ctx context.Context, k string) error
 }
 
+// EventPublisher notifies merchants about changes on their payments. Events are stored with the repository that
+// changes the payment, and NotifyDispatcher is called once that transaction is committed.
+type EventPublisher interface {
+	PublishPaymentEvent(ctx context.Context, repo repositiory.Repository, eventType string, payment payment_gateway.Payment) error
+	NotifyDispatcher()
+}
+
+// Domain runs the payment operations. Every change is committed before it is sent to an acquirer, so no transaction is
+// open while an acquirer is called, and whatever an acquirer accepted is always stored.
 type Domain struct {
-	repo       repositiory.Repository
-	cache      Cache
-	logger     *zap.Logger
-	bankClient BankClient
+	repo        repositiory.Repository
+	cache       Cache
+	logger      *zap.Logger
+	bankClients map[string]BankClient
+	router      Router
+	events      EventPublisher
+	// outboxJobs wakes up a worker of the outbox when a payment is created
+	outboxJobs chan struct{}
+	// cvvs keeps the cvv of the payments this instance created, until they are submitted
+	cvvs *cvvStore
 }
 
-func NewDomain(repo repositiory.Repository, cache Cache, l *zap.Logger, bankClient BankClient) *Domain {
+// NewDomain returns the payment domain. The bank clients are the registered acquirers by their name,
+// and the default acquirer of the router needs to be one of them.
+func NewDomain(repo repositiory.Repository, cache Cache, l *zap.Logger, bankClients map[string]BankClient, router Router, events EventPublisher) *Domain {
 	return &Domain{
-		repo:       repo,
-		cache:      cache,
-		logger:     l,
-		bankClient: bankClient,
+		repo:        repo,
+		cache:       cache,
+		logger:      l,
+		bankClients: bankClients,
+		router:      router,
+		events:      events,
+		outboxJobs:  make(chan struct{}, 1),
+		cvvs:        newCVVStore(),
 	}
 }
 
+// callbackFromAcquiringBank receives the result of a payment from the bank client. The acquirer is kept, since the
+// payment may have cascaded to another acquirer in the meantime, along with the reference of the authorization, which
+// its completion and reversal refer to. Results never carry card data.
 func (d *Domain) callbackFromAcquiringBank(payment payment_gateway.Payment) {
+	d.receiveCallbackResult(&repositiory.InboxMessage{
+		Operation:         AcquirerOperationPayment,
+		ResourceID:        payment.ID,
+		Acquirer:          payment.Acquirer(),
+		ResultStatus:      string(payment.PaymentStatus),
+		Decline:           payment.Decline,
+		AcquirerReference: payment.AcquirerReference,
+	})
+}
+
+// applyPaymentResult writes the status the acquiring bank gave to the payment, using the given repository.
+func (d *Domain) applyPaymentResult(ctx context.Context, repo repositiory.Repository, payment payment_gateway.Payment) error {
 	// First let's invalidate the cache
-	err := d.cache.DeleteKey(context.Background(), fmt.Sprintf("%s_%s", paymentCacheKey, payment.ID))
+	err := d.cache.DeleteKey(ctx, getPaymentCacheKey(payment.MerchantID, payment.ID))
 	if err != nil {
 		d.logger.Error("Unexpected redis error", zap.Error(err))
 	}
-	err = d.repo.UpdateStatus(context.Background(), payment.GetStoragePayment())
+	cascaded, err := d.applyAttemptResult(ctx, repo, &payment)
+	if err != nil || cascaded {
+		return err
+	}
+	// For manual capture payments, an approval from the bank only means that the amount is authorized
+	if payment.CaptureMethod == payment_gateway.CaptureMethodManual && payment.PaymentStatus == status.PaymentSucceeded {
+		payment.PaymentStatus = status.PaymentAuthorized
+	}
+	err = repo.UpdateStatus(ctx, payment.GetStoragePayment())
 	if err != nil {
-		d.logger.Error("Unexpected internal database error", zap.Error(err))
+		return err
 	}
+	return d.publishFinalStatus(ctx, repo, payment.ID)
 }
 
 func (d *Domain) CreatePayment(ctx context.Context, payment payment_gateway.Payment) (payment_gateway.Payment, error) {
@@ -75,6 +143,24 @@ func (d *Domain) CreatePayment(ctx context.Context, payment payment_gateway.Paym
 			Err: err,
 		}
 	}
+	err = payment.ValidateCaptureMethod()
+	if err != nil {
+		return payment_gateway.Payment{}, responses.BadRequestError{
+			Err: err,
+		}
+	}
+	err = payment.ValidateReference()
+	if err != nil {
+		return payment_gateway.Payment{}, responses.BadRequestError{
+			Err: err,
+		}
+	}
+	err = payment.ValidateMetadata()
+	if err != nil {
+		return payment_gateway.Payment{}, responses.BadRequestError{
+			Err: err,
+		}
+	}
 	cacheDedupKey := fmt.Sprintf("%s_%s_%d_%s", deduplicationCacheKey, payment.CardInfo.CardNumber, payment.Amount.AmountFractional, payment.Amount.CurrencyCode)
 	err = d.isPaymentValid(ctx, cacheDedupKey)
 	if err != nil {
@@ -82,7 +168,15 @@ func (d *Domain) CreatePayment(ctx context.Context, payment payment_gateway.Paym
 		return payment_gateway.Payment{}, err
 	}
 
-	payment.PaymentStatus = "processing"
+	route := d.router.Route(routing.Payment{
+		MerchantID:   payment.MerchantID,
+		CardNumber:   payment.CardInfo.CardNumber,
+		CurrencyCode: payment.Amount.CurrencyCode,
+		Amount:       payment.Amount.AmountFractional,
+	})
+	payment.Route = &route
+	payment.PaymentStatus = status.PaymentProcessing
+	// The payment is written along with the job that submits it to the acquirer
 	txRepo, err := d.repo.Begin(ctx)
 	if err != nil {
 		d.logger.Error("Error initialising transaction")
@@ -91,6 +185,7 @@ func (d *Domain) CreatePayment(ctx context.Context, payment payment_gateway.Paym
 	// Create the payment on our internal system, with a status of processing
 	err = txRepo.CreatePayment(ctx, payment.GetStoragePayment())
 	if err != nil {
+		d.rollback(ctx, &txRepo)
 		var pgErr pgdriver.Error
 		if errors.As(err, &pgErr) {
 			if pgErr.IntegrityViolation() {
@@ -101,16 +196,17 @@ func (d *Domain) CreatePayment(ctx context.Context, payment payment_gateway.Paym
 		d.logger.Error("Database unexpected error", zap.Error(err))
 		return payment_gateway.Payment{}, responses.InternalServerError{Err: err}
 	}
-	err = d.CreatePaymentOnAcquiringBank(payment)
+	err = d.enqueuePayment(ctx, &txRepo, payment)
 	if err != nil {
-		internalErr := txRepo.Rollback(ctx)
-		if internalErr != nil {
-			d.logger.Error("Internal database unexpected error", zap.Error(err))
-		}
-		return payment_gateway.Payment{}, err
+		d.rollback(ctx, &txRepo)
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return payment_gateway.Payment{}, responses.InternalServerError{Err: err}
 	}
+	// The cvv is only kept in memory, so the workers of this instance submit the payment with it once it is committed
+	d.cvvs.put(payment.ID, payment.CardInfo.CVV)
 	err = txRepo.Commit(ctx)
 	if err != nil {
+		d.cvvs.delete(payment.ID)
 		d.logger.Error("Could not commit transaction", zap.Error(err))
 		return payment_gateway.Payment{}, err
 	}
@@ -126,22 +222,31 @@ func (d *Domain) CreatePayment(ctx context.Context, payment payment_gateway.Paym
 		return payment_gateway.Payment{}, responses.InternalServerError{Err: err}
 	}
 	p := payment_gateway.GetPaymentFromStoredPayment(storedPayment)
+	// The worker is only woken up once the payment was read, so it is always returned with a processing status
+	d.notifyOutbox()
 	return p, nil
 }
 
-func (d *Domain) CreatePaymentOnAcquiringBank(payment payment_gateway.Payment) error {
+func (d *Domain) CreatePaymentOnAcquiringBank(ctx context.Context, payment payment_gateway.Payment) error {
+	acquirer := d.acquirerName(payment.Acquirer())
+	bankClient, err := d.bankClientFor(acquirer)
+	if err != nil {
+		return err
+	}
 	// Use a circuit breaking library in cases when the acquiring bank is offline
-	res, err := d.CreatePaymentUsingCircuitBreaker(payment, d.callbackFromAcquiringBank)
+	res, err := d.CreatePaymentUsingCircuitBreaker(bankContext(ctx, payment.MerchantID), acquirer, bankClient, payment, d.callbackFromAcquiringBank)
 	if err != nil {
-		return responses.GetErrorResponseFromStatusCode(res.StatusCode, err)
+		return bankError(res.StatusCode, err)
 	}
 	_ = res.Body.Close()
 	return nil
 }
 
-func (d *Domain) GetPayment(ctx context.Context, id uuid.UUID) (payment_gateway.Payment, error) {
+// GetPayment returns the payment with the given id, only if it belongs to the given merchant.
+// Payments of other merchants are reported as not found, so their ids can not be probed.
+func (d *Domain) GetPayment(ctx context.Context, merchantID uuid.UUID, id uuid.UUID) (payment_gateway.Payment, error) {
 	var payment payment_gateway.Payment
-	key := fmt.Sprintf("%s_%s", paymentCacheKey, id)
+	key := getPaymentCacheKey(merchantID, id)
 	err := d.cache.GetValue(ctx, key, &payment)
 	if err != nil && err != redis.Nil {
 		// Here we need to log that cache is down/ maybe even alert but don't stop serving customers
@@ -151,7 +256,7 @@ func (d *Domain) GetPayment(ctx context.Context, id uuid.UUID) (payment_gateway.
 		return payment, nil
 	}
 
-	storedPayment, err := d.repo.GetPaymentByID(ctx, id)
+	storedPayment, err := d.repo.GetMerchantPaymentByID(ctx, merchantID, id)
 	if err != nil && err != sql.ErrNoRows {
 		d.logger.Error("Database unexpected error", zap.Error(err))
 		return payment_gateway.Payment{}, responses.InternalServerError{}
@@ -169,6 +274,73 @@ func (d *Domain) GetPayment(ctx context.Context, id uuid.UUID) (payment_gateway.
 	return payment, nil
 }
 
+// GetPaymentByReference returns the payment that the merchant created with the given reference.
+func (d *Domain) GetPaymentByReference(ctx context.Context, merchantID uuid.UUID, reference string) (payment_gateway.Payment, error) {
+	storedPayment, err := d.repo.GetPaymentByReference(ctx, merchantID, reference)
+	if err != nil && err != sql.ErrNoRows {
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return payment_gateway.Payment{}, responses.InternalServerError{Err: err}
+	}
+	if err == sql.ErrNoRows {
+		d.logger.Info("Payment not found", zap.String("reference", reference))
+		return payment_gateway.Payment{}, responses.NotFoundError{}
+	}
+	return payment_gateway.GetPaymentFromStoredPayment(storedPayment), nil
+}
+
+// publishFinalStatus stores the event of the payment, once it reaches a final status, using the given repository.
+func (d *Domain) publishFinalStatus(ctx context.Context, repo repositiory.Repository, id uuid.UUID) error {
+	storedPayment, err := repo.GetPaymentByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !storedPayment.PaymentStatus.IsFinal() {
+		return nil
+	}
+	eventType := payment_gateway.GetPaymentEventType(storedPayment.PaymentStatus)
+	return d.events.PublishPaymentEvent(ctx, repo, eventType, payment_gateway.GetPaymentFromStoredPayment(storedPayment))
+}
+
+// retryUntilFound runs the update of a result from the acquiring bank, retrying it for a short while when the payment
+// or refund is not found. Banks that answer synchronously can send the result before the transaction that created it is committed.
+func retryUntilFound(update func() error) error {
+	r := retrier.New(retrier.ExponentialBackoff(callbackRetries, 20*time.Millisecond), retrier.WhitelistClassifier{sql.ErrNoRows})
+	return r.Run(update)
+}
+
+// backoff returns how long to wait before the next attempt of a job that failed the given number of times.
+// It starts at the initial backoff, and doubles with every attempt up to the max backoff, both in milliseconds.
+func backoff(initialBackoff, maxBackoff, attempts int) time.Duration {
+	wait := time.Duration(initialBackoff) * time.Millisecond
+	limit := time.Duration(maxBackoff) * time.Millisecond
+	for i := 1; i < attempts && wait < limit; i++ {
+		wait *= 2
+	}
+	if wait > limit {
+		wait = limit
+	}
+	return wait
+}
+
+// logStatusUpdateError logs the result of a status update coming from the acquiring bank.
+// Transitions rejected by the status tables are expected, like a late callback for a canceled payment, so they are only warnings.
+func (d *Domain) logStatusUpdateError(id uuid.UUID, err error) {
+	if err == nil {
+		return
+	}
+	if errors.Is(err, status.ErrIllegalTransition) {
+		d.logger.Warn("Rejected status update from acquiring bank", zap.String("id", id.String()), zap.Error(err))
+		return
+	}
+	d.logger.Error("Unexpected internal database error", zap.Error(err))
+}
+
+// getPaymentCacheKey returns the cache key of a payment. The key contains the merchant id,
+// so a cached payment can only be served to the merchant that owns it.
+func getPaymentCacheKey(merchantID uuid.UUID, id uuid.UUID) string {
+	return fmt.Sprintf("%s_%s_%s", paymentCacheKey, merchantID, id)
+}
+
 func (d *Domain) isPaymentValid(ctx context.Context, cacheDedupKey string) error {
 	// Check cache if this payment was attempted a few minutes ago
 	var exists bool
@@ -196,21 +368,92 @@ func validateCard(cardInfo payment_gateway.CardInfo) error {
 	return card.Validate()
 }
 
-func (d *Domain) CreatePaymentUsingCircuitBreaker(payment payment_gateway.Payment, callBackFn func(payment_gateway.Payment)) (http.Response, error) {
-	output := make(chan http.Response, 1)          // Declare the channel where the hystrix goroutine will put success responses.
-	errs := hystrix.Go(createPaymentAcquiringBank, // Pass the name of the circuit breaker as first parameter.
+func (d *Domain) CreatePaymentUsingCircuitBreaker(ctx context.Context, acquirer string, bankClient BankClient, payment payment_gateway.Payment, callBackFn func(payment_gateway.Payment)) (http.Response, error) {
+	out, err := d.callBankUsingCircuitBreaker(ctx, acquirer, breaker.OperationAuthorize, func(ctx context.Context) http.Response {
+		return bankClient.CreatePayment(ctx, payment, callBackFn)
+	})
+	if err != nil {
+		return out, err
+	}
+	if out.StatusCode < 299 {
+		return out, nil
+	}
+	return out, fmt.Errorf("payment failed to get created on acquring bank, status: %d", out.StatusCode)
+}
+
+// acquirerName returns the name of the acquirer of an operation. An empty name stands for the default acquirer,
+// which processes the payments created before routing.
+func (d *Domain) acquirerName(acquirer string) string {
+	if acquirer == "" {
+		return d.router.DefaultAcquirer()
+	}
+	return acquirer
+}
 
-		// 2nd parameter, the inlined func to run inside the breaker.
-		func() error {
+// bankClientFor returns the client of the acquirer with the given name.
+func (d *Domain) bankClientFor(acquirer string) (BankClient, error) {
+	acquirer = d.acquirerName(acquirer)
+	bankClient, ok := d.bankClients[acquirer]
+	if !ok {
+		d.logger.Error("Acquirer is not registered", zap.String("acquirer", acquirer))
+		return nil, responses.InternalServerError{Err: fmt.Errorf("acquirer %s is not registered", acquirer)}
+	}
+	return bankClient, nil
+}
+
+// bankContext returns the context of a call to the acquiring bank, with the merchant the operation is made for.
+// Results applied from callbacks do not run on a merchant request, so the merchant is not always on the context yet.
+func bankContext(ctx context.Context, merchantID uuid.UUID) context.Context {
+	if _, err := kitctx.GetMerchantID(ctx); err == nil {
+		return ctx
+	}
+	return kitctx.AddMerchantID(ctx, merchantID)
+}
+
+// bankError returns the error of an operation the acquiring bank did not accept. Calls whose outcome is unknown are not
+// declines, since the bank may have processed them.
+func bankError(statusCode int, err error) error {
+	var outcomeErr OutcomeUnknownError
+	if errors.As(err, &outcomeErr) {
+		return err
+	}
+	return decline.NewError(statusCode, err)
+}
+
+// callBankUsingCircuitBreaker runs the given call to the acquiring bank, inside the circuit breaker of the operation on
+// the acquirer. All operations on the acquiring bank should go through here, so they get the same retries and circuit
+// breaking. The context given to the call is canceled when this returns, so a call the breaker timed out does not keep
+// running. Calls that time out, or whose context is done, return OutcomeUnknownError.
+func (d *Domain) callBankUsingCircuitBreaker(ctx context.Context, acquirer, operation string, call func(ctx context.Context) http.Response) (http.Response, error) {
+	breakerName := breaker.Name(acquirer, operation)
+	callCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// hystrix formats the errors it gives to the errors channel, so the error of the call is kept here.
+	// It is written before the errors channel gets its error, so it can be read after it.
+	var runErr error
+	// Declare the channel where the hystrix goroutine will put success responses.
+	output := make(chan http.Response, 1)
+	// Pass the context as first parameter, and the name of the circuit breaker as second parameter. Hystrix gets the
+	// context of the caller, so canceling the call once it succeeded is not reported as a failure of the breaker.
+	errs := hystrix.GoC(ctx, breakerName,
+
+		// 3rd parameter, the inlined func to run inside the breaker.
+		func(context.Context) error {
 			// For hystrix, forward the err from the retrier. It's nil if successful.
-			return d.callBankWithRetries(payment, callBackFn, output)
+			return d.callBankWithRetries(callCtx, call, output)
 		},
 
-		// 3rd parameter, the fallback func. In this case, we just do a bit of logging and return the error.
-		func(err error) error {
-			d.logger.Error("In fallback function for breaker", zap.String("breaker_name", createPaymentAcquiringBank), zap.Error(err))
-			circuit, _, _ := hystrix.GetCircuit(createPaymentAcquiringBank)
-			d.logger.Info("Circuit state is", zap.Bool("is_open", circuit.IsOpen()))
+		// 4th parameter, the fallback func. In this case, we count the fallback and the rejections, and return the error.
+		func(ctx context.Context, err error) error {
+			d.logger.Error("In fallback function for breaker", zap.String("breaker_name", breakerName), zap.Error(err))
+			prometheus.BreakerFallback(acquirer, operation)
+			switch {
+			case errors.Is(err, hystrix.ErrCircuitOpen):
+				prometheus.BreakerRejected(acquirer, operation, prometheus.RejectionCircuitOpen)
+			case errors.Is(err, hystrix.ErrMaxConcurrency):
+				prometheus.BreakerRejected(acquirer, operation, prometheus.RejectionMaxConcurrency)
+			}
+			runErr = err
 			return err
 		})
 
@@ -218,34 +461,40 @@ func (d *Domain) CreatePaymentUsingCircuitBreaker(payment payment_gateway.Paymen
 	// the errors channel gives us the error.
 	select {
 	case out := <-output:
-		d.logger.Info("Call in breaker successful", zap.String("breaker name", createPaymentAcquiringBank))
-		if out.StatusCode < 299 {
-			return out, nil
-		}
-		return out, fmt.Errorf("payment failed to get created on acquring bank, status: %d", out.StatusCode)
+		d.logger.Info("Call in breaker successful", zap.String("breaker name", breakerName))
+		return out, nil
 	case err := <-errs:
+		if isOutcomeUnknown(runErr) {
+			return http.Response{}, OutcomeUnknownError{Err: err}
+		}
 		return http.Response{}, err
 	}
 }
 
-func (d *Domain) callBankWithRetries(payment payment_gateway.Payment, callBackFn func(payment_gateway.Payment), output chan http.Response) error {
+func (d *Domain) callBankWithRetries(ctx context.Context, call func(ctx context.Context) http.Response, output chan http.Response) error {
 	// Create a retrier with constant backoff, RETRIES number of attempts (3) with a 100ms sleep between retries.
-	r := retrier.New(retrier.ConstantBackoff(retries, 100*time.Millisecond), nil)
+	// Calls whose outcome is unknown are not retried, as the bank may have processed them already.
+	r := retrier.New(retrier.ConstantBackoff(retries, 100*time.Millisecond),
+		retrier.BlacklistClassifier{errOutcomeUnknown, context.Canceled, context.DeadlineExceeded})
 
 	// This counter is just for getting some logging for showcasing, remove in production code.
 	attempt := 0
 
 	// Retrier works similar to hystrix, we pass the actual work (doing the HTTP request) in a func.
-	err := r.Run(func() error {
+	err := r.RunCtx(ctx, func(ctx context.Context) error {
 		attempt++
 		var err error
 		// Do the mock request and handle response. If successful, pass resp over output channel,
 		// otherwise, do a bit of error logging and return to err.
-		resp := d.bankClient.CreatePayment(payment, callBackFn)
-		// Retry only for 500 codes as it is not almost impossible to recover from a 4xx
-		if resp.StatusCode < 299 || (resp.StatusCode > 399 && resp.StatusCode < 500) {
+		resp := call(ctx)
+		// Retry only for 500 codes as it is not almost impossible to recover from a 4xx, nor from an operation
+		// the acquirer does not implement
+		if resp.StatusCode < 299 || (resp.StatusCode > 399 && resp.StatusCode < 500) || resp.StatusCode == http.StatusNotImplemented {
 			output <- resp
 			return nil
+		} else if resp.StatusCode == http.StatusGatewayTimeout {
+			_ = resp.Body.Close()
+			err = errOutcomeUnknown
 		} else {
 			err = fmt.Errorf("status was %v", resp.StatusCode)
 		}
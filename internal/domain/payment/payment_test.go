@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"net/http"
+	"strings"
 	"testing"
 	"time"
 
@@ -15,9 +17,13 @@ import (
 	"github.com/google/uuid"
 	"github.com/marioarizaj/payment-gateway"
 	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
 	"github.com/marioarizaj/payment-gateway/internal/dependencies"
 	"github.com/marioarizaj/payment-gateway/internal/domain/payment"
+	"github.com/marioarizaj/payment-gateway/internal/domain/webhook"
 	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/internal/routing"
+	"github.com/marioarizaj/payment-gateway/internal/status"
 	"github.com/marioarizaj/payment-gateway/kit/rediscache"
 	"github.com/marioarizaj/payment-gateway/kit/responses"
 	"github.com/stretchr/testify/assert"
@@ -27,6 +33,7 @@ var baseTestPayment = payment_gateway.Payment{
 	ID:            uuid.Must(uuid.Parse("b5f9c307-5202-4c52-aba9-752167eef9bf")),
 	MerchantID:    uuid.Must(uuid.Parse("6c5a19d0-f132-4a55-93d3-2c00db06d41b")),
 	PaymentStatus: "processing",
+	CaptureMethod: payment_gateway.CaptureMethodAutomatic,
 	Amount: payment_gateway.Amount{
 		AmountFractional: 2000,
 		CurrencyCode:     "USD",
@@ -52,14 +59,14 @@ func TestDomain_CreatePayment(t *testing.T) {
 	}
 
 	cases := []struct {
-		name                 string
-		payment              func(domain payment.Domain) (payment_gateway.Payment, error)
-		expectedError        error
-		sleepTime            time.Duration
-		mockConfig           config.MockBankConfig
-		expectedStatus       string
-		expectedFailedReason string
-		shouldCreateRecord   bool
+		name               string
+		payment            func(domain payment.Domain) (payment_gateway.Payment, error)
+		expectedError      error
+		sleepTime          time.Duration
+		mockConfig         config.MockBankConfig
+		expectedStatus     status.PaymentStatus
+		expectedDecline    *decline.Decline
+		shouldCreateRecord bool
 	}{
 		{
 			name:      "create_payment_success",
@@ -88,19 +95,19 @@ func TestDomain_CreatePayment(t *testing.T) {
 				SleepIntervalInitialRequest: 10,
 				SleepIntervalForCallback:    50,
 				ShouldRunCallback:           true,
-				FailedReason:                "no sufficient funds",
+				DeclineResponseCode:         "51",
 			},
 			payment: func(domain payment.Domain) (payment_gateway.Payment, error) {
 				p := baseTestPayment
 				return p, nil
 			},
-			expectedStatus:       "failed",
-			expectedFailedReason: "no sufficient funds",
+			expectedStatus:  "failed",
+			expectedDecline: decline.FromResponseCode("51"),
 		},
 		{
 			name:               "create_payment_success_failing_acquiring_bank_sync",
 			sleepTime:          3 * time.Second,
-			shouldCreateRecord: false,
+			shouldCreateRecord: true,
 			mockConfig: config.MockBankConfig{
 				StatusCode:                  400,
 				UpdateToStatus:              "failed",
@@ -108,44 +115,46 @@ func TestDomain_CreatePayment(t *testing.T) {
 				SleepIntervalForCallback:    50,
 				ShouldRunCallback:           false,
 			},
-			expectedError: responses.BadRequestError{Err: errors.New("payment failed to get created on acquring bank, status: 400")},
+			// The payment is submitted by the outbox, so it is created and then fails with the decline of the bank
 			payment: func(domain payment.Domain) (payment_gateway.Payment, error) {
 				p := baseTestPayment
 				return p, nil
 			},
+			expectedStatus:  "failed",
+			expectedDecline: decline.FromStatusCode(400),
 		},
 		{
-			name:               "create_payment_timeout_circuit_breaker",
-			sleepTime:          3 * time.Second,
-			shouldCreateRecord: false,
+			name:      "create_payment_timeout_circuit_breaker",
+			sleepTime: 3 * time.Second,
 			mockConfig: config.MockBankConfig{
 				UpdateToStatus:              "failed",
 				SleepIntervalInitialRequest: 100000,
 				ShouldRunCallback:           false,
 			},
-			expectedError: responses.InternalServerError{Err: errors.New("fallback failed with 'hystrix: timeout'. run error was 'hystrix: timeout'")},
+			// The bank may have processed the payment, so it is kept with an unknown status until it is resolved
 			payment: func(domain payment.Domain) (payment_gateway.Payment, error) {
 				p := baseTestPayment
 				return p, nil
 			},
-			expectedStatus: "failed",
+			expectedStatus: status.PaymentUnknown,
 		},
 		{
 			name:               "create_payment_timeout_circuit_breaker_retry",
 			sleepTime:          3 * time.Second,
-			shouldCreateRecord: false,
+			shouldCreateRecord: true,
 			mockConfig: config.MockBankConfig{
 				StatusCode:                  500,
 				UpdateToStatus:              "failed",
 				SleepIntervalInitialRequest: 10,
 				ShouldRunCallback:           false,
 			},
-			expectedError: responses.InternalServerError{Err: errors.New("fallback failed with 'status was 500'. run error was 'status was 500'")},
+			// The outbox submits the payment again, and it fails once the job runs out of attempts
 			payment: func(domain payment.Domain) (payment_gateway.Payment, error) {
 				p := baseTestPayment
 				return p, nil
 			},
-			expectedStatus: "failed",
+			expectedStatus:  "failed",
+			expectedDecline: decline.FromStatusCode(0),
 		},
 		{
 			name:               "create_payment_invalid_card",
@@ -163,6 +172,85 @@ func TestDomain_CreatePayment(t *testing.T) {
 			},
 			expectedError: responses.BadRequestError{Err: errors.New("credit card has expired")},
 		},
+		{
+			name:      "create_payment_with_metadata_success",
+			sleepTime: time.Second,
+			mockConfig: config.MockBankConfig{
+				StatusCode:                  202,
+				UpdateToStatus:              "succeeded",
+				SleepIntervalInitialRequest: 10,
+				SleepIntervalForCallback:    50,
+				ShouldRunCallback:           true,
+			},
+			shouldCreateRecord: true,
+			payment: func(domain payment.Domain) (payment_gateway.Payment, error) {
+				p := baseTestPayment
+				p.Metadata = map[string]string{"cart_id": "cart-1", "campaign": "summer"}
+				return p, nil
+			},
+			expectedStatus: "succeeded",
+		},
+		{
+			name:               "create_payment_too_many_metadata_keys",
+			shouldCreateRecord: false,
+			mockConfig:         cfg.MockBankConfig,
+			payment: func(domain payment.Domain) (payment_gateway.Payment, error) {
+				p := baseTestPayment
+				p.Metadata = make(map[string]string)
+				for i := 0; i <= payment_gateway.MaxMetadataKeys; i++ {
+					p.Metadata[fmt.Sprintf("key_%d", i)] = "value"
+				}
+				return p, nil
+			},
+			expectedError: responses.BadRequestError{Err: errors.New("metadata can not have more than 20 keys")},
+		},
+		{
+			name:               "create_payment_metadata_value_too_long",
+			shouldCreateRecord: false,
+			mockConfig:         cfg.MockBankConfig,
+			payment: func(domain payment.Domain) (payment_gateway.Payment, error) {
+				p := baseTestPayment
+				p.Metadata = map[string]string{"note": strings.Repeat("a", payment_gateway.MaxMetadataValueLength+1)}
+				return p, nil
+			},
+			expectedError: responses.BadRequestError{Err: errors.New("metadata value of key note is longer than 500 characters")},
+		},
+		{
+			name:               "create_payment_reference_too_long",
+			shouldCreateRecord: false,
+			mockConfig:         cfg.MockBankConfig,
+			payment: func(domain payment.Domain) (payment_gateway.Payment, error) {
+				p := baseTestPayment
+				p.Reference = strings.Repeat("a", 256)
+				return p, nil
+			},
+			expectedError: responses.BadRequestError{Err: errors.New("reference can not be longer than 255 characters")},
+		},
+		{
+			name:               "create_payment_duplicate_reference",
+			sleepTime:          2 * time.Second,
+			shouldCreateRecord: false,
+			mockConfig: config.MockBankConfig{
+				StatusCode:                  202,
+				UpdateToStatus:              "succeeded",
+				SleepIntervalInitialRequest: 10,
+				SleepIntervalForCallback:    50,
+				ShouldRunCallback:           true,
+			},
+			payment: func(domain payment.Domain) (payment_gateway.Payment, error) {
+				p := baseTestPayment
+				p.Reference = "order-1001"
+				_, err := domain.CreatePayment(context.Background(), p)
+				if err != nil {
+					return payment_gateway.Payment{}, err
+				}
+				// A new payment, that would pass redis validation, using the same reference
+				p.ID = uuid.Must(uuid.Parse("c5693980-e5f1-4a20-8a2b-bd13ce9f460f"))
+				p.Amount.AmountFractional = 3000
+				return p, nil
+			},
+			expectedError: responses.ConflictError{},
+		},
 		{
 			name:               "create_payment_duplicate_transaction",
 			sleepTime:          2 * time.Second,
@@ -230,7 +318,7 @@ func TestDomain_CreatePayment(t *testing.T) {
 				assert.Equal(t, c.expectedError, err)
 				if !c.shouldCreateRecord {
 					// Check that the record is not on database
-					_, err = d.GetPayment(context.Background(), p.ID)
+					_, err = d.GetPayment(context.Background(), p.MerchantID, p.ID)
 					assert.Equal(t, responses.NotFoundError{}, err)
 				}
 
@@ -245,21 +333,74 @@ func TestDomain_CreatePayment(t *testing.T) {
 			p.UpdatedAt = createdPayment.UpdatedAt
 			// Expected masked card
 			p.CardInfo.CardNumber = payment_gateway.MaskCreditCard(p.CardInfo.CardNumber)
+			// Without routing rules, payments go to the default acquirer
+			p.Route = &routing.Route{Acquirer: cfg.BankConfig.Name}
 
 			assert.Equal(t, p, createdPayment)
 
-			time.Sleep(time.Second)
-			// Let's wait a second, for the callback to update the database
-			newPayment, err := d.GetPayment(context.Background(), p.ID)
+			// Let's wait for the outbox to submit the payment, and for the callback to update the database
+			time.Sleep(c.sleepTime)
+			newPayment, err := d.GetPayment(context.Background(), p.MerchantID, p.ID)
 			if !assert.NoError(t, err) {
 				return
 			}
 			assert.Equal(t, c.expectedStatus, newPayment.PaymentStatus)
-			assert.Equal(t, c.expectedFailedReason, newPayment.FailedReason)
+			assert.Equal(t, c.expectedDecline, newPayment.Decline)
 		})
 	}
 }
 
+func TestDomain_CreatePayment_Routing(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	// The default acquirer rejects everything, so the payment and its refund only succeed on the routed acquirer
+	deps.BankClient = acquiringbank.NewMockClient(config.MockBankConfig{StatusCode: 500})
+	deps.Acquirers = map[string]payment.BankClient{
+		"secondary": acquiringbank.NewMockClient(config.MockBankConfig{
+			StatusCode:        202,
+			UpdateToStatus:    "succeeded",
+			ShouldRunCallback: true,
+		}),
+	}
+	deps.Router, err = routing.NewRouter(cfg.BankConfig.Name, routing.Config{
+		Acquirers: []routing.Acquirer{{Name: "secondary"}},
+		Rules: []routing.Rule{{
+			Name:    "amex",
+			Brands:  []string{"amex"},
+			Targets: []routing.Target{{Acquirer: "secondary"}},
+		}},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	d, cleanFn, err := getDomain(deps)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cleanFn()
+
+	createdPayment, err := d.CreatePayment(context.Background(), baseTestPayment)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, &routing.Route{Acquirer: "secondary", Rule: "amex"}, createdPayment.Route)
+
+	time.Sleep(time.Second)
+	_, err = d.CreateRefund(context.Background(), payment_gateway.Refund{
+		ID:         uuid.New(),
+		PaymentID:  baseTestPayment.ID,
+		MerchantID: baseTestPayment.MerchantID,
+		Amount:     payment_gateway.Amount{AmountFractional: 500},
+	})
+	assert.NoError(t, err)
+}
+
 func TestDomain_GetPayment(t *testing.T) {
 	cfg, err := config.LoadConfig()
 	if !assert.NoError(t, err) {
@@ -272,6 +413,7 @@ func TestDomain_GetPayment(t *testing.T) {
 	cases := []struct {
 		name          string
 		idToSearch    uuid.UUID
+		merchantID    uuid.UUID
 		payment       payment_gateway.Payment
 		expectedError error
 	}{
@@ -279,10 +421,18 @@ func TestDomain_GetPayment(t *testing.T) {
 			name:       "get_payment_success",
 			payment:    baseTestPayment,
 			idToSearch: uuid.Must(uuid.Parse("b5f9c307-5202-4c52-aba9-752167eef9bf")),
+			merchantID: baseTestPayment.MerchantID,
 		}, {
 			name:          "get_payment_not_found",
 			payment:       baseTestPayment,
 			idToSearch:    uuid.Must(uuid.Parse("c5693980-e5f1-4a20-8a2b-bd13ce9f460f")),
+			merchantID:    baseTestPayment.MerchantID,
+			expectedError: responses.NotFoundError{},
+		}, {
+			name:          "get_payment_of_other_merchant",
+			payment:       baseTestPayment,
+			idToSearch:    uuid.Must(uuid.Parse("b5f9c307-5202-4c52-aba9-752167eef9bf")),
+			merchantID:    uuid.Must(uuid.Parse("a1e3f405-44f0-44b4-a584-b0b3c80bc8ac")),
 			expectedError: responses.NotFoundError{},
 		},
 	}
@@ -298,7 +448,7 @@ func TestDomain_GetPayment(t *testing.T) {
 			if !assert.NoError(t, err) {
 				return
 			}
-			p, err := d.GetPayment(context.Background(), c.idToSearch)
+			p, err := d.GetPayment(context.Background(), c.merchantID, c.idToSearch)
 			if c.expectedError != nil {
 				assert.Equal(t, c.expectedError, err)
 				return
@@ -311,11 +461,12 @@ func TestDomain_GetPayment(t *testing.T) {
 			p.CardInfo.CVV = c.payment.CardInfo.CVV
 			// Expected masked card
 			expectedPayment.CardInfo.CardNumber = payment_gateway.MaskCreditCard(p.CardInfo.CardNumber)
+			expectedPayment.Route = &routing.Route{Acquirer: cfg.BankConfig.Name}
 
 			assert.Equal(t, expectedPayment, p)
 			// Verify that when we search for a second time, and cache returns the result,
 			// it returns the correct result
-			p, err = d.GetPayment(context.Background(), c.idToSearch)
+			p, err = d.GetPayment(context.Background(), c.merchantID, c.idToSearch)
 			if c.expectedError != nil {
 				assert.Equal(t, c.expectedError, err)
 				return
@@ -328,16 +479,49 @@ func TestDomain_GetPayment(t *testing.T) {
 	}
 }
 
+// testOutboxConfig submits the payments of the tests with a single worker, and runs out of attempts quickly
+var testOutboxConfig = config.OutboxConfig{
+	Workers:           1,
+	PollInterval:      200,
+	VisibilityTimeout: 10000,
+	InitialBackoff:    100,
+	MaxBackoff:        100,
+	MaxAttempts:       2,
+}
+
 func getDomain(deps dependencies.Dependencies) (*payment.Domain, func(), error) {
+	d, _, cleanFn, err := getDomainWithRepo(deps)
+	return d, cleanFn, err
+}
+
+// getDomainWithRepo returns the domain along with the repository it uses, so tests can insert data directly.
+// The outbox runs in the background, so payments are submitted to the bank like they are on the server.
+func getDomainWithRepo(deps dependencies.Dependencies) (*payment.Domain, repositiory.Repository, func(), error) {
+	d, repo, cleanFn, err := getDomainWithoutOutbox(deps)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx, stopOutbox := context.WithCancel(context.Background())
+	go payment.NewOutbox(d, testOutboxConfig).Run(ctx)
+	return d, repo, func() {
+		stopOutbox()
+		cleanFn()
+	}, nil
+}
+
+// getDomainWithoutOutbox returns the domain and its repository, without running the outbox, so the tests submit
+// the payments themselves.
+func getDomainWithoutOutbox(deps dependencies.Dependencies) (*payment.Domain, repositiory.Repository, func(), error) {
 	ctx := context.Background()
 	tx, err := deps.DB.BeginTx(ctx, &sql.TxOptions{})
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	repo := repositiory.NewRepository(tx)
 	redisCache := rediscache.NewRedisClient(deps.Redis)
-	d := payment.NewDomain(repo, redisCache, zap.NewNop(), deps.BankClient)
-	return d, func() {
+	webhooks := webhook.NewDomain(repo, http.DefaultClient, zap.NewNop(), config.WebhookConfig{})
+	d := payment.NewDomain(repo, redisCache, zap.NewNop(), deps.BankClients(), deps.Router, webhooks)
+	return d, repo, func() {
 		_ = tx.Rollback()
 		deps.Redis.FlushAll(ctx)
 	}, nil
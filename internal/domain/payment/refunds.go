@@ -0,0 +1,210 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/breaker"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	kitctx "github.com/marioarizaj/payment-gateway/kit/ctx"
+	"github.com/marioarizaj/payment-gateway/kit/responses"
+	"github.com/uptrace/bun/driver/pgdriver"
+	"go.uber.org/zap"
+)
+
+func (d *Domain) callbackFromAcquiringBankForRefund(refund payment_gateway.Refund) {
+	d.receiveCallbackResult(&repositiory.InboxMessage{
+		Operation:    AcquirerOperationRefund,
+		ResourceID:   refund.ID,
+		ResultStatus: string(refund.RefundStatus),
+		Decline:      refund.Decline,
+	})
+}
+
+// CreateRefund returns the given amount back to the card of a succeeded or captured payment.
+// A payment can be refunded multiple times, as long as the sum of its refunds does not exceed the charged amount.
+func (d *Domain) CreateRefund(ctx context.Context, refund payment_gateway.Refund) (payment_gateway.Refund, error) {
+	if refund.ID == uuid.Nil {
+		return payment_gateway.Refund{}, responses.BadRequestError{Err: errors.New("refund id is required")}
+	}
+	if refund.Amount.AmountFractional <= 0 {
+		return payment_gateway.Refund{}, responses.BadRequestError{Err: errors.New("refund amount must be greater than 0")}
+	}
+
+	txRepo, err := d.repo.Begin(ctx)
+	if err != nil {
+		d.logger.Error("Error initialising transaction")
+		return payment_gateway.Refund{}, responses.InternalServerError{Err: err}
+	}
+	// The payment row is locked until we commit, so two refunds for the same payment
+	// can not both pass the amount validation.
+	storedPayment, err := txRepo.GetPaymentByIDForUpdate(ctx, refund.PaymentID)
+	if err != nil && err != sql.ErrNoRows {
+		d.rollback(ctx, &txRepo)
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return payment_gateway.Refund{}, responses.InternalServerError{Err: err}
+	}
+	if err == sql.ErrNoRows || storedPayment.MerchantID != refund.MerchantID {
+		d.rollback(ctx, &txRepo)
+		d.logger.Info("Payment not found", zap.String("id", refund.PaymentID.String()))
+		return payment_gateway.Refund{}, responses.NotFoundError{}
+	}
+	// Only money that was actually charged can be returned
+	refundableAmount := storedPayment.Amount
+	switch storedPayment.PaymentStatus {
+	case status.PaymentSucceeded:
+	case status.PaymentCaptured:
+		refundableAmount = storedPayment.AmountCaptured
+	default:
+		d.rollback(ctx, &txRepo)
+		return payment_gateway.Refund{}, responses.BadRequestError{Err: fmt.Errorf("payment with status %s can not be refunded", storedPayment.PaymentStatus)}
+	}
+	if refund.Amount.CurrencyCode == "" {
+		refund.Amount.CurrencyCode = storedPayment.CurrencyCode
+	}
+	if refund.Amount.CurrencyCode != storedPayment.CurrencyCode {
+		d.rollback(ctx, &txRepo)
+		return payment_gateway.Refund{}, responses.BadRequestError{Err: errors.New("refund currency does not match payment currency")}
+	}
+	refundedAmount, err := txRepo.GetRefundedAmount(ctx, refund.PaymentID)
+	if err != nil {
+		d.rollback(ctx, &txRepo)
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return payment_gateway.Refund{}, responses.InternalServerError{Err: err}
+	}
+	if refundedAmount+refund.Amount.AmountFractional > refundableAmount {
+		d.rollback(ctx, &txRepo)
+		return payment_gateway.Refund{}, responses.BadRequestError{Err: fmt.Errorf("refund amount exceeds the remaining refundable amount of %d", refundableAmount-refundedAmount)}
+	}
+
+	refund.RefundStatus = status.RefundProcessing
+	err = txRepo.CreateRefund(ctx, refund.GetStorageRefund())
+	if err != nil {
+		d.rollback(ctx, &txRepo)
+		var pgErr pgdriver.Error
+		if errors.As(err, &pgErr) {
+			if pgErr.IntegrityViolation() {
+				d.logger.Error("Pg Integrity violation", zap.Error(err))
+				return payment_gateway.Refund{}, responses.ConflictError{}
+			}
+		}
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return payment_gateway.Refund{}, responses.InternalServerError{Err: err}
+	}
+	err = txRepo.Commit(ctx)
+	if err != nil {
+		d.logger.Error("Could not commit transaction", zap.Error(err))
+		return payment_gateway.Refund{}, responses.InternalServerError{Err: err}
+	}
+
+	// Refunds go to the card of the payment, on the acquirer that processed it. The card number is masked on the payment
+	// shown to the merchant
+	payment := payment_gateway.GetPaymentFromStoredPayment(storedPayment)
+	payment.CardInfo.CardNumber = storedPayment.CardNumber
+	err = d.CreateRefundOnAcquiringBank(ctx, payment, refund)
+	if err != nil {
+		// The refund was sent, so its result is written even if the caller is gone
+		d.failRefund(kitctx.WithoutCancel(ctx), refund, err)
+		return payment_gateway.Refund{}, err
+	}
+
+	storedRefund, err := d.repo.GetRefundByID(ctx, refund.ID)
+	if err != nil {
+		d.logger.Error("Database Unexpected error", zap.Error(err))
+		return payment_gateway.Refund{}, responses.InternalServerError{Err: err}
+	}
+	return payment_gateway.GetRefundFromStoredRefund(storedRefund), nil
+}
+
+// failRefund fails the refund that the acquirer did not accept, and keeps it processing when its outcome is unknown.
+func (d *Domain) failRefund(ctx context.Context, refund payment_gateway.Refund, err error) {
+	var outcomeErr OutcomeUnknownError
+	if errors.As(err, &outcomeErr) {
+		d.logger.Warn("Outcome of the refund is unknown, leaving it processing", zap.String("id", refund.ID.String()), zap.Error(err))
+		return
+	}
+	refund.RefundStatus = status.RefundFailed
+	var declineErr decline.Error
+	if errors.As(err, &declineErr) {
+		refund.Decline = declineErr.Decline
+	}
+	d.logStatusUpdateError(refund.ID, d.repo.UpdateRefundStatus(ctx, refund.GetStorageRefund()))
+}
+
+func (d *Domain) CreateRefundOnAcquiringBank(ctx context.Context, payment payment_gateway.Payment, refund payment_gateway.Refund) error {
+	acquirer := d.acquirerName(payment.Acquirer())
+	bankClient, err := d.bankClientFor(acquirer)
+	if err != nil {
+		return err
+	}
+	res, err := d.CreateRefundUsingCircuitBreaker(bankContext(ctx, refund.MerchantID), acquirer, bankClient, payment, refund, d.callbackFromAcquiringBankForRefund)
+	if err != nil {
+		return bankError(res.StatusCode, err)
+	}
+	_ = res.Body.Close()
+	return nil
+}
+
+func (d *Domain) CreateRefundUsingCircuitBreaker(ctx context.Context, acquirer string, bankClient BankClient, payment payment_gateway.Payment, refund payment_gateway.Refund, callBackFn func(payment_gateway.Refund)) (http.Response, error) {
+	out, err := d.callBankUsingCircuitBreaker(ctx, acquirer, breaker.OperationRefund, func(ctx context.Context) http.Response {
+		return bankClient.CreateRefund(ctx, payment, refund, callBackFn)
+	})
+	if err != nil {
+		return out, err
+	}
+	if out.StatusCode < 299 {
+		return out, nil
+	}
+	return out, fmt.Errorf("refund failed to get created on acquring bank, status: %d", out.StatusCode)
+}
+
+// GetRefund returns the refund with the given id, only if it belongs to the given merchant.
+func (d *Domain) GetRefund(ctx context.Context, merchantID uuid.UUID, id uuid.UUID) (payment_gateway.Refund, error) {
+	storedRefund, err := d.repo.GetRefundByID(ctx, id)
+	if err != nil && err != sql.ErrNoRows {
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return payment_gateway.Refund{}, responses.InternalServerError{Err: err}
+	}
+	if err == sql.ErrNoRows || storedRefund.MerchantID != merchantID {
+		d.logger.Info("Refund not found", zap.String("id", id.String()))
+		return payment_gateway.Refund{}, responses.NotFoundError{}
+	}
+	return payment_gateway.GetRefundFromStoredRefund(storedRefund), nil
+}
+
+// GetRefunds returns all the refunds of a payment that belongs to the given merchant.
+func (d *Domain) GetRefunds(ctx context.Context, merchantID uuid.UUID, paymentID uuid.UUID) ([]payment_gateway.Refund, error) {
+	_, err := d.repo.GetMerchantPaymentByID(ctx, merchantID, paymentID)
+	if err != nil && err != sql.ErrNoRows {
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return nil, responses.InternalServerError{Err: err}
+	}
+	if err == sql.ErrNoRows {
+		d.logger.Info("Payment not found", zap.String("id", paymentID.String()))
+		return nil, responses.NotFoundError{}
+	}
+	storedRefunds, err := d.repo.GetRefundsByPaymentID(ctx, paymentID)
+	if err != nil {
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return nil, responses.InternalServerError{Err: err}
+	}
+	refunds := make([]payment_gateway.Refund, 0, len(storedRefunds))
+	for i := range storedRefunds {
+		refunds = append(refunds, payment_gateway.GetRefundFromStoredRefund(&storedRefunds[i]))
+	}
+	return refunds, nil
+}
+
+func (d *Domain) rollback(ctx context.Context, txRepo repositiory.Repository) {
+	err := txRepo.Rollback(ctx)
+	if err != nil {
+		d.logger.Error("Internal database unexpected error", zap.Error(err))
+	}
+}
@@ -0,0 +1,179 @@
+package payment_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/acquiringbank"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
+	"github.com/marioarizaj/payment-gateway/internal/dependencies"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"github.com/marioarizaj/payment-gateway/kit/responses"
+	"github.com/stretchr/testify/assert"
+)
+
+var baseTestRefund = payment_gateway.Refund{
+	ID:         uuid.Must(uuid.Parse("0d3bd1f2-0b8e-4c3a-8d43-67b1dfd4c1a2")),
+	PaymentID:  baseTestPayment.ID,
+	MerchantID: baseTestPayment.MerchantID,
+	Amount: payment_gateway.Amount{
+		AmountFractional: 500,
+		CurrencyCode:     "USD",
+	},
+	Reason: "Item returned",
+}
+
+func TestDomain_CreateRefund(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	succeededMockConfig := config.MockBankConfig{
+		StatusCode:                  202,
+		UpdateToStatus:              "succeeded",
+		SleepIntervalInitialRequest: 10,
+		SleepIntervalForCallback:    50,
+		ShouldRunCallback:           true,
+	}
+
+	cases := []struct {
+		name           string
+		paymentStatus  status.PaymentStatus
+		amountCaptured int64
+		refunds        []payment_gateway.Refund
+		mockConfig     config.MockBankConfig
+		expectedError  error
+		expectedStatus status.RefundStatus
+	}{
+		{
+			name:           "create_refund_success",
+			paymentStatus:  "succeeded",
+			mockConfig:     succeededMockConfig,
+			refunds:        []payment_gateway.Refund{baseTestRefund},
+			expectedStatus: "succeeded",
+		},
+		{
+			name:          "create_multiple_partial_refunds_success",
+			paymentStatus: "succeeded",
+			mockConfig:    succeededMockConfig,
+			refunds: func() []payment_gateway.Refund {
+				second := baseTestRefund
+				second.ID = uuid.Must(uuid.Parse("9f1c6a0e-7d55-4d6e-9a52-0c3b3f7d2c11"))
+				second.Amount.AmountFractional = 1500
+				return []payment_gateway.Refund{baseTestRefund, second}
+			}(),
+			expectedStatus: "succeeded",
+		},
+		{
+			name:          "create_refund_exceeds_payment_amount",
+			paymentStatus: "succeeded",
+			mockConfig:    succeededMockConfig,
+			refunds: func() []payment_gateway.Refund {
+				second := baseTestRefund
+				second.ID = uuid.Must(uuid.Parse("9f1c6a0e-7d55-4d6e-9a52-0c3b3f7d2c11"))
+				second.Amount.AmountFractional = 1501
+				return []payment_gateway.Refund{baseTestRefund, second}
+			}(),
+			expectedError: responses.BadRequestError{Err: errors.New("refund amount exceeds the remaining refundable amount of 1500")},
+		},
+		{
+			name:           "create_refund_captured_payment_exceeds_captured_amount",
+			paymentStatus:  "captured",
+			amountCaptured: 400,
+			mockConfig:     succeededMockConfig,
+			refunds:        []payment_gateway.Refund{baseTestRefund},
+			expectedError:  responses.BadRequestError{Err: errors.New("refund amount exceeds the remaining refundable amount of 400")},
+		},
+		{
+			name:          "create_refund_payment_not_succeeded",
+			paymentStatus: "processing",
+			mockConfig:    succeededMockConfig,
+			refunds:       []payment_gateway.Refund{baseTestRefund},
+			expectedError: responses.BadRequestError{Err: errors.New("payment with status processing can not be refunded")},
+		},
+		{
+			name:          "create_refund_currency_mismatch",
+			paymentStatus: "succeeded",
+			mockConfig:    succeededMockConfig,
+			refunds: func() []payment_gateway.Refund {
+				r := baseTestRefund
+				r.Amount.CurrencyCode = "EUR"
+				return []payment_gateway.Refund{r}
+			}(),
+			expectedError: responses.BadRequestError{Err: errors.New("refund currency does not match payment currency")},
+		},
+		{
+			name:          "create_refund_other_merchant",
+			paymentStatus: "succeeded",
+			mockConfig:    succeededMockConfig,
+			refunds: func() []payment_gateway.Refund {
+				r := baseTestRefund
+				r.MerchantID = uuid.Must(uuid.Parse("a1e3f405-44f0-44b4-a584-b0b3c80bc8ac"))
+				return []payment_gateway.Refund{r}
+			}(),
+			expectedError: responses.NotFoundError{},
+		},
+		{
+			name:          "create_refund_failing_acquiring_bank_sync",
+			paymentStatus: "succeeded",
+			mockConfig: config.MockBankConfig{
+				StatusCode:                  400,
+				SleepIntervalInitialRequest: 10,
+				ShouldRunCallback:           false,
+			},
+			refunds:       []payment_gateway.Refund{baseTestRefund},
+			expectedError: decline.Error{StatusCode: 400, Err: errors.New("refund failed to get created on acquring bank, status: 400"), Decline: decline.FromStatusCode(400)},
+			// The refund is committed before it is sent, and it fails once the acquirer does not accept it
+			expectedStatus: "failed",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			deps.BankClient = acquiringbank.NewMockClient(c.mockConfig)
+			d, repo, cleanFn, err := getDomainWithRepo(deps)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer cleanFn()
+			p := baseTestPayment
+			p.PaymentStatus = c.paymentStatus
+			p.AmountCaptured = c.amountCaptured
+			err = repo.CreatePayment(context.Background(), p.GetStoragePayment())
+			if !assert.NoError(t, err) {
+				return
+			}
+			var lastErr error
+			for _, r := range c.refunds {
+				_, lastErr = d.CreateRefund(context.Background(), r)
+			}
+			if c.expectedError != nil {
+				assert.Equal(t, c.expectedError, lastErr)
+			} else if !assert.NoError(t, lastErr) {
+				return
+			}
+			if c.expectedStatus == "" {
+				return
+			}
+
+			// Let's wait for the callback to update the database
+			time.Sleep(time.Second)
+			refunds, err := d.GetRefunds(context.Background(), p.MerchantID, p.ID)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Len(t, refunds, len(c.refunds))
+			for _, r := range refunds {
+				assert.Equal(t, c.expectedStatus, r.RefundStatus)
+			}
+		})
+	}
+}
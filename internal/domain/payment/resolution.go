@@ -0,0 +1,268 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/breaker"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"go.uber.org/zap"
+)
+
+var (
+	// errPaymentNotReceived is returned by a status inquiry when the acquirer has no record of the payment
+	errPaymentNotReceived = errors.New("the acquirer did not receive the payment")
+	// errInquiryNotSupported is returned by a status inquiry when the acquirer can not tell the status of a payment
+	errInquiryNotSupported = errors.New("the acquirer does not support status inquiries")
+)
+
+// markOutcomeUnknown keeps the payment with an unknown status, and schedules its resolution. The acquirer may have
+// processed the payment, so it is neither failed nor sent to another acquirer.
+func (d *Domain) markOutcomeUnknown(ctx context.Context, repo repositiory.Repository, payment payment_gateway.Payment, outcomeErr OutcomeUnknownError) error {
+	d.logger.Warn("Outcome of the payment is unknown, scheduling its resolution",
+		zap.String("id", payment.ID.String()),
+		zap.String("acquirer", payment.Acquirer()),
+		zap.Error(outcomeErr))
+	err := repo.UpdatePaymentAttempt(ctx, &repositiory.PaymentAttempt{
+		PaymentID: payment.ID,
+		Acquirer:  payment.Acquirer(),
+		Status:    status.PaymentUnknown,
+	})
+	if err != nil && err != sql.ErrNoRows {
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return outcomeErr
+	}
+	payment.PaymentStatus = status.PaymentUnknown
+	err = repo.UpdateStatus(ctx, payment.GetStoragePayment())
+	if err != nil {
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return outcomeErr
+	}
+	err = repo.CreatePaymentResolution(ctx, &repositiory.PaymentResolution{
+		PaymentID:     payment.ID,
+		NextAttemptAt: time.Now(),
+	})
+	if err != nil {
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return outcomeErr
+	}
+	return nil
+}
+
+// InquirePaymentOnAcquiringBank asks the acquirer of the payment for its status, and returns the payment as the acquirer knows it.
+func (d *Domain) InquirePaymentOnAcquiringBank(ctx context.Context, payment payment_gateway.Payment) (payment_gateway.Payment, error) {
+	acquirer := d.acquirerName(payment.Acquirer())
+	bankClient, err := d.bankClientFor(acquirer)
+	if err != nil {
+		return payment_gateway.Payment{}, err
+	}
+	res, err := d.callBankUsingCircuitBreaker(bankContext(ctx, payment.MerchantID), acquirer, breaker.OperationInquiry, func(ctx context.Context) http.Response {
+		return bankClient.InquirePayment(ctx, payment)
+	})
+	if err != nil {
+		return payment_gateway.Payment{}, err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return payment_gateway.Payment{}, errPaymentNotReceived
+	case http.StatusNotImplemented:
+		return payment_gateway.Payment{}, errInquiryNotSupported
+	default:
+		return payment_gateway.Payment{}, fmt.Errorf("payment inquiry failed on acquring bank, status: %d", res.StatusCode)
+	}
+	var inquired payment_gateway.Payment
+	err = json.NewDecoder(res.Body).Decode(&inquired)
+	if err != nil {
+		return payment_gateway.Payment{}, fmt.Errorf("could not decode the payment inquiry: %w", err)
+	}
+	return inquired, nil
+}
+
+// Resolver finds out the result of the payments whose outcome is unknown. It sends status inquiries to the acquirer
+// of each payment, and reverses the payment when the inquiries are not answered, or when the acquirer does not
+// support them. Attempts that fail are sent again later, waiting twice as long after each of them.
+type Resolver struct {
+	domain *Domain
+	cfg    config.ResolutionConfig
+}
+
+func NewResolver(domain *Domain, cfg config.ResolutionConfig) *Resolver {
+	return &Resolver{
+		domain: domain,
+		cfg:    cfg,
+	}
+}
+
+// Run resolves the payments that are due on every interval, until the context is done.
+func (r *Resolver) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(r.cfg.Interval) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.ResolveDue(ctx)
+		}
+	}
+}
+
+// ResolveDue makes the next attempt to resolve every payment that is due, and returns how many of them it attempted.
+func (r *Resolver) ResolveDue(ctx context.Context) int {
+	attempted := 0
+	for ctx.Err() == nil {
+		ok, err := r.resolveNext(ctx)
+		if err != nil {
+			r.domain.logger.Error("Could not resolve payment", zap.Error(err))
+			return attempted
+		}
+		if !ok {
+			return attempted
+		}
+		attempted++
+	}
+	return attempted
+}
+
+// resolveNext claims the resolution that is due the longest, and makes its next attempt. It returns false when no
+// resolution is due.
+func (r *Resolver) resolveNext(ctx context.Context) (bool, error) {
+	d := r.domain
+	resolution, err := d.repo.ClaimDuePaymentResolution(ctx, time.Now(), time.Duration(r.cfg.VisibilityTimeout)*time.Millisecond)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	storedPayment, err := d.repo.GetPaymentByID(ctx, resolution.PaymentID)
+	if err != nil {
+		return false, err
+	}
+	payment := r.resolve(ctx, storedPayment, resolution)
+	err = r.finish(ctx, resolution, payment)
+	if err == sql.ErrNoRows {
+		// The resolution was claimed again, and the call that claimed it finishes it
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if resolution.ResolvedAt != nil {
+		d.logger.Info("Resolved payment with unknown outcome",
+			zap.String("id", resolution.PaymentID.String()),
+			zap.String("resolution", resolution.Resolution))
+		d.events.NotifyDispatcher()
+	}
+	return true, nil
+}
+
+// resolve makes the next attempt to resolve the payment, and returns the status it finds out, or nil when it does not
+// find it out. Calls to the acquirer that fail are written to the resolution, to be sent again later.
+func (r *Resolver) resolve(ctx context.Context, storedPayment *repositiory.Payment, resolution *repositiory.PaymentResolution) *payment_gateway.Payment {
+	// The result of the payment arrived from the acquirer, before the resolution was due
+	if storedPayment.PaymentStatus != status.PaymentUnknown {
+		r.resolved(resolution, repositiory.ResolutionResult)
+		return nil
+	}
+	d := r.domain
+	payment := payment_gateway.GetPaymentFromStoredPayment(storedPayment)
+	if resolution.Attempts > r.cfg.MaxInquiries {
+		return r.reverse(ctx, payment, resolution)
+	}
+	inquired, err := d.InquirePaymentOnAcquiringBank(ctx, payment)
+	switch {
+	case err == nil && !inquired.PaymentStatus.IsValid():
+		r.reschedule(resolution, fmt.Errorf("acquirer answered the inquiry with status %q", inquired.PaymentStatus))
+		return nil
+	case err == nil:
+		payment.PaymentStatus = inquired.PaymentStatus
+		payment.Decline = inquired.Decline
+	case errors.Is(err, errPaymentNotReceived):
+		payment.PaymentStatus = status.PaymentFailed
+		payment.Decline = decline.FromStatusCode(http.StatusServiceUnavailable)
+	case errors.Is(err, errInquiryNotSupported):
+		return r.reverse(ctx, payment, resolution)
+	default:
+		r.reschedule(resolution, err)
+		return nil
+	}
+	r.resolved(resolution, repositiory.ResolutionInquiry)
+	return &payment
+}
+
+// reverse cancels the payment on its acquirer, so the shopper is not charged for a payment whose result is not known.
+func (r *Resolver) reverse(ctx context.Context, payment payment_gateway.Payment, resolution *repositiory.PaymentResolution) *payment_gateway.Payment {
+	err := r.domain.CancelPaymentOnAcquiringBank(ctx, payment)
+	if err != nil {
+		r.reschedule(resolution, err)
+		return nil
+	}
+	payment.PaymentStatus = status.PaymentCanceled
+	payment.Decline = nil
+	r.resolved(resolution, repositiory.ResolutionReversal)
+	return &payment
+}
+
+// finish writes the resolution, along with the status the attempt found out while the payment is still unknown.
+func (r *Resolver) finish(ctx context.Context, resolution *repositiory.PaymentResolution, payment *payment_gateway.Payment) error {
+	d := r.domain
+	if payment == nil {
+		return d.repo.UpdatePaymentResolution(ctx, resolution)
+	}
+	txRepo, err := d.repo.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	err = d.applyPaymentResult(ctx, &txRepo, *payment)
+	if errors.Is(err, status.ErrIllegalTransition) {
+		d.rollback(ctx, &txRepo)
+		if resolution.Resolution == repositiory.ResolutionReversal {
+			d.logger.Error("Payment was reversed on the acquirer after its result arrived",
+				zap.String("id", payment.ID.String()),
+				zap.Error(err))
+		}
+		r.resolved(resolution, repositiory.ResolutionResult)
+		return d.repo.UpdatePaymentResolution(ctx, resolution)
+	}
+	if err != nil {
+		d.rollback(ctx, &txRepo)
+		return err
+	}
+	err = txRepo.UpdatePaymentResolution(ctx, resolution)
+	if err != nil {
+		d.rollback(ctx, &txRepo)
+		return err
+	}
+	return txRepo.Commit(ctx)
+}
+
+func (r *Resolver) resolved(resolution *repositiory.PaymentResolution, how string) {
+	now := time.Now()
+	resolution.Resolution = how
+	resolution.ResolvedAt = &now
+	resolution.LastError = ""
+}
+
+// reschedule records the error of the attempt, and schedules the next one after a backoff that doubles with every attempt.
+func (r *Resolver) reschedule(resolution *repositiory.PaymentResolution, err error) {
+	r.domain.logger.Warn("Could not resolve payment, trying again later",
+		zap.String("id", resolution.PaymentID.String()),
+		zap.Int("attempts", resolution.Attempts),
+		zap.Error(err))
+	resolution.NextAttemptAt = time.Now().Add(backoff(r.cfg.InitialBackoff, r.cfg.MaxBackoff, resolution.Attempts))
+	resolution.LastError = err.Error()
+}
@@ -0,0 +1,136 @@
+package payment_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/acquiringbank"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
+	"github.com/marioarizaj/payment-gateway/internal/dependencies"
+	"github.com/marioarizaj/payment-gateway/internal/domain/payment"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"github.com/stretchr/testify/assert"
+)
+
+// resolutionBank answers the inquiries and reversals with the given status codes, instead of asking the mock bank.
+type resolutionBank struct {
+	*acquiringbank.MockClient
+	inquiryStatusCode int
+	cancelStatusCode  int
+}
+
+func (b resolutionBank) InquirePayment(ctx context.Context, p payment_gateway.Payment) http.Response {
+	if b.inquiryStatusCode == 0 {
+		return b.MockClient.InquirePayment(ctx, p)
+	}
+	return statusResponse(b.inquiryStatusCode)
+}
+
+func (b resolutionBank) CancelPayment(context.Context, payment_gateway.Payment) http.Response {
+	return statusResponse(b.cancelStatusCode)
+}
+
+func statusResponse(statusCode int) http.Response {
+	return http.Response{StatusCode: statusCode, Body: io.NopCloser(strings.NewReader(""))}
+}
+
+func TestResolver_ResolveDue(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	// The bank approves the payments, but its answer is lost
+	answerLost := config.MockBankConfig{StatusCode: http.StatusGatewayTimeout, SleepIntervalInitialRequest: 10}
+	resolutionConfig := config.ResolutionConfig{InitialBackoff: 1000, MaxBackoff: 60000, MaxInquiries: 2}
+
+	cases := []struct {
+		name               string
+		bank               resolutionBank
+		expectedStatus     status.PaymentStatus
+		expectedDecline    *decline.Decline
+		expectedResolution string
+		expectedAttempts   int
+	}{
+		{
+			name:               "inquiry_finds_approval",
+			bank:               resolutionBank{cancelStatusCode: http.StatusOK},
+			expectedStatus:     status.PaymentSucceeded,
+			expectedResolution: repositiory.ResolutionInquiry,
+			expectedAttempts:   1,
+		},
+		{
+			name:               "payment_not_received",
+			bank:               resolutionBank{inquiryStatusCode: http.StatusNotFound, cancelStatusCode: http.StatusOK},
+			expectedStatus:     status.PaymentFailed,
+			expectedDecline:    decline.FromStatusCode(http.StatusServiceUnavailable),
+			expectedResolution: repositiory.ResolutionInquiry,
+			expectedAttempts:   1,
+		},
+		{
+			name:               "inquiry_not_supported",
+			bank:               resolutionBank{inquiryStatusCode: http.StatusNotImplemented, cancelStatusCode: http.StatusOK},
+			expectedStatus:     status.PaymentCanceled,
+			expectedResolution: repositiory.ResolutionReversal,
+			expectedAttempts:   1,
+		},
+		{
+			name:             "inquiry_fails",
+			bank:             resolutionBank{inquiryStatusCode: http.StatusServiceUnavailable, cancelStatusCode: http.StatusOK},
+			expectedStatus:   status.PaymentUnknown,
+			expectedAttempts: 1,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.bank.MockClient = acquiringbank.NewMockClient(answerLost)
+			deps.BankClient = c.bank
+			d, repo, cleanFn, err := getDomainWithRepo(deps)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer cleanFn()
+			ctx := context.Background()
+			_, err = d.CreatePayment(ctx, baseTestPayment)
+			if !assert.NoError(t, err) {
+				return
+			}
+			// The answer of the bank is lost once the outbox submitted the payment
+			assert.Eventually(t, func() bool {
+				stored, err := repo.GetPaymentByID(ctx, baseTestPayment.ID)
+				return err == nil && stored.PaymentStatus == status.PaymentUnknown
+			}, 3*time.Second, 50*time.Millisecond)
+
+			assert.Equal(t, 1, payment.NewResolver(d, resolutionConfig).ResolveDue(ctx))
+			p, err := d.GetPayment(ctx, baseTestPayment.MerchantID, baseTestPayment.ID)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, c.expectedStatus, p.PaymentStatus)
+			assert.Equal(t, c.expectedDecline, p.Decline)
+
+			resolution, err := repo.GetPaymentResolution(ctx, baseTestPayment.ID)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, c.expectedResolution, resolution.Resolution)
+			assert.Equal(t, c.expectedAttempts, resolution.Attempts)
+			if c.expectedResolution == "" {
+				// The next inquiry is only due after the backoff
+				assert.Nil(t, resolution.ResolvedAt)
+				assert.NotEmpty(t, resolution.LastError)
+				assert.Equal(t, 0, payment.NewResolver(d, resolutionConfig).ResolveDue(ctx))
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"github.com/marioarizaj/payment-gateway/kit/prometheus"
+	"go.uber.org/zap"
+)
+
+// Sweeper finds the payments that are stuck processing for longer than the SLA, because the result of the acquirer
+// never arrived, and sends status inquiries to their acquirer. Payments whose status is still not known after the
+// inquiries are flagged for manual review. Inquiries that fail are sent again later, waiting twice as long after each of them.
+type Sweeper struct {
+	domain *Domain
+	cfg    config.SweeperConfig
+}
+
+func NewSweeper(domain *Domain, cfg config.SweeperConfig) *Sweeper {
+	return &Sweeper{
+		domain: domain,
+		cfg:    cfg,
+	}
+}
+
+// Run sweeps the stuck payments on every interval, until the context is done.
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(s.cfg.Interval) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.SweepDue(ctx)
+		}
+	}
+}
+
+// SweepDue schedules a sweep for the payments that became stuck, sends the next inquiry of every sweep that is due,
+// and exports how many payments are stuck. It returns how many sweeps it attempted.
+func (s *Sweeper) SweepDue(ctx context.Context) int {
+	d := s.domain
+	stuckSince := time.Now().Add(-time.Duration(s.cfg.SLA) * time.Millisecond)
+	scheduled, err := d.repo.CreatePaymentSweeps(ctx, stuckSince)
+	if err != nil {
+		d.logger.Error("Could not find stuck payments", zap.Error(err))
+		return 0
+	}
+	if scheduled > 0 {
+		d.logger.Warn("Found payments stuck processing", zap.Int("count", scheduled))
+	}
+	attempted := 0
+	for ctx.Err() == nil {
+		ok, err := s.sweepNext(ctx)
+		if err != nil {
+			d.logger.Error("Could not sweep stuck payment", zap.Error(err))
+			break
+		}
+		if !ok {
+			break
+		}
+		attempted++
+	}
+	stuck, review, err := d.repo.CountStuckPayments(ctx, stuckSince)
+	if err != nil {
+		d.logger.Error("Could not count stuck payments", zap.Error(err))
+		return attempted
+	}
+	prometheus.SetStuckPayments(stuck, review)
+	return attempted
+}
+
+// sweepNext claims the sweep that is due the longest, and sends its next inquiry. It returns false when no sweep is due.
+func (s *Sweeper) sweepNext(ctx context.Context) (bool, error) {
+	d := s.domain
+	sweep, err := d.repo.ClaimDuePaymentSweep(ctx, time.Now(), time.Duration(s.cfg.VisibilityTimeout)*time.Millisecond)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	storedPayment, err := d.repo.GetPaymentByID(ctx, sweep.PaymentID)
+	if err != nil {
+		return false, err
+	}
+	payment := s.sweep(ctx, storedPayment, sweep)
+	err = s.finish(ctx, sweep, payment)
+	if err == sql.ErrNoRows {
+		// The sweep was claimed again, and the call that claimed it finishes it
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if sweep.Outcome == repositiory.SweepInquiry {
+		d.logger.Info("Resolved stuck payment", zap.String("id", sweep.PaymentID.String()))
+		prometheus.StuckPaymentSwept(prometheus.SweepResolved)
+		d.events.NotifyDispatcher()
+		// A payment that cascaded has a job waiting to submit it to the fallback acquirer
+		d.notifyOutbox()
+	}
+	return true, nil
+}
+
+// sweep sends the next inquiry about the stuck payment, and returns the status it finds out, or nil when it does not
+// find it out. Inquiries that fail are written to the sweep, to be sent again later.
+func (s *Sweeper) sweep(ctx context.Context, storedPayment *repositiory.Payment, sweep *repositiory.PaymentSweep) *payment_gateway.Payment {
+	// The result of the payment arrived from the acquirer, or the payment cascaded to another acquirer, before it was swept
+	if storedPayment.PaymentStatus != status.PaymentProcessing || storedPayment.Acquirer != sweep.Acquirer {
+		s.finished(sweep, repositiory.SweepResult, nil)
+		return nil
+	}
+	payment := payment_gateway.GetPaymentFromStoredPayment(storedPayment)
+	inquired, err := s.domain.InquirePaymentOnAcquiringBank(ctx, payment)
+	switch {
+	case err == nil && inquired.PaymentStatus == status.PaymentProcessing:
+		s.reschedule(sweep, errors.New("acquirer is still processing the payment"))
+		return nil
+	case err == nil && !inquired.PaymentStatus.IsValid():
+		s.reschedule(sweep, fmt.Errorf("acquirer answered the inquiry with status %q", inquired.PaymentStatus))
+		return nil
+	case err == nil:
+		payment.PaymentStatus = inquired.PaymentStatus
+		payment.Decline = inquired.Decline
+	case errors.Is(err, errPaymentNotReceived):
+		payment.PaymentStatus = status.PaymentFailed
+		payment.Decline = decline.FromStatusCode(http.StatusServiceUnavailable)
+	case errors.Is(err, errInquiryNotSupported):
+		// Sending the inquiry again does not tell anything more
+		s.review(sweep, err)
+		return nil
+	default:
+		s.reschedule(sweep, err)
+		return nil
+	}
+	s.finished(sweep, repositiory.SweepInquiry, nil)
+	return &payment
+}
+
+// finish writes the sweep, along with the status the inquiry found out while the payment is still processing.
+func (s *Sweeper) finish(ctx context.Context, sweep *repositiory.PaymentSweep, payment *payment_gateway.Payment) error {
+	d := s.domain
+	if payment == nil {
+		return d.repo.UpdatePaymentSweep(ctx, sweep)
+	}
+	txRepo, err := d.repo.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	err = d.applyPaymentResult(ctx, &txRepo, *payment)
+	if errors.Is(err, status.ErrIllegalTransition) {
+		d.rollback(ctx, &txRepo)
+		s.finished(sweep, repositiory.SweepResult, nil)
+		return d.repo.UpdatePaymentSweep(ctx, sweep)
+	}
+	if err != nil {
+		d.rollback(ctx, &txRepo)
+		return err
+	}
+	err = txRepo.UpdatePaymentSweep(ctx, sweep)
+	if err != nil {
+		d.rollback(ctx, &txRepo)
+		return err
+	}
+	return txRepo.Commit(ctx)
+}
+
+func (s *Sweeper) finished(sweep *repositiory.PaymentSweep, outcome string, err error) {
+	now := time.Now()
+	sweep.Outcome = outcome
+	sweep.FinishedAt = &now
+	sweep.LastError = ""
+	if err != nil {
+		sweep.LastError = err.Error()
+	}
+}
+
+// review flags the stuck payment for manual review, since the inquiries did not tell its status. It is kept processing,
+// so the result of the acquirer is still applied if it ever arrives.
+func (s *Sweeper) review(sweep *repositiory.PaymentSweep, err error) {
+	s.domain.logger.Error("Payment is stuck processing, flagging it for manual review",
+		zap.String("id", sweep.PaymentID.String()),
+		zap.String("acquirer", sweep.Acquirer),
+		zap.Int("attempts", sweep.Attempts),
+		zap.Error(err))
+	s.finished(sweep, repositiory.SweepReview, err)
+	prometheus.StuckPaymentSwept(prometheus.SweepReview)
+}
+
+// reschedule records the error of the inquiry, and schedules the next one after a backoff that doubles with every
+// attempt. Once every inquiry was sent, the payment is flagged for review instead.
+func (s *Sweeper) reschedule(sweep *repositiory.PaymentSweep, err error) {
+	if sweep.Attempts >= s.cfg.MaxAttempts {
+		s.review(sweep, err)
+		return
+	}
+	s.domain.logger.Warn("Could not resolve stuck payment, trying again later",
+		zap.String("id", sweep.PaymentID.String()),
+		zap.Int("attempts", sweep.Attempts),
+		zap.Error(err))
+	sweep.NextAttemptAt = time.Now().Add(backoff(s.cfg.InitialBackoff, s.cfg.MaxBackoff, sweep.Attempts))
+	sweep.LastError = err.Error()
+	prometheus.StuckPaymentSwept(prometheus.SweepRetried)
+}
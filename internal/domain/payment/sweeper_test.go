@@ -0,0 +1,144 @@
+package payment_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/acquiringbank"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
+	"github.com/marioarizaj/payment-gateway/internal/dependencies"
+	"github.com/marioarizaj/payment-gateway/internal/domain/payment"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"github.com/stretchr/testify/assert"
+)
+
+// sweepBank answers the inquiries with the given status code, or with the payment with the given status, instead of
+// asking the mock bank.
+type sweepBank struct {
+	*acquiringbank.MockClient
+	inquiryStatusCode int
+	inquiryStatus     status.PaymentStatus
+}
+
+func (b sweepBank) InquirePayment(ctx context.Context, p payment_gateway.Payment) http.Response {
+	if b.inquiryStatusCode != 0 {
+		return statusResponse(b.inquiryStatusCode)
+	}
+	if b.inquiryStatus == "" {
+		return b.MockClient.InquirePayment(ctx, p)
+	}
+	p.PaymentStatus = b.inquiryStatus
+	bts, _ := json.Marshal(p)
+	return http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(bts))}
+}
+
+func TestSweeper_SweepDue(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	// The bank accepts the payments, but their result never arrives
+	noCallback := config.MockBankConfig{StatusCode: http.StatusAccepted, SleepIntervalInitialRequest: 10, ShouldRunCallback: false}
+
+	cases := []struct {
+		name             string
+		bank             sweepBank
+		maxAttempts      int
+		expectedStatus   status.PaymentStatus
+		expectedDecline  *decline.Decline
+		expectedOutcome  string
+		expectedAttempts int
+	}{
+		{
+			name:             "inquiry_finds_approval",
+			bank:             sweepBank{inquiryStatus: status.PaymentSucceeded},
+			maxAttempts:      2,
+			expectedStatus:   status.PaymentSucceeded,
+			expectedOutcome:  repositiory.SweepInquiry,
+			expectedAttempts: 1,
+		},
+		{
+			name:             "payment_not_received",
+			bank:             sweepBank{inquiryStatusCode: http.StatusNotFound},
+			maxAttempts:      2,
+			expectedStatus:   status.PaymentFailed,
+			expectedDecline:  decline.FromStatusCode(http.StatusServiceUnavailable),
+			expectedOutcome:  repositiory.SweepInquiry,
+			expectedAttempts: 1,
+		},
+		{
+			name:             "still_processing",
+			maxAttempts:      2,
+			expectedStatus:   status.PaymentProcessing,
+			expectedAttempts: 1,
+		},
+		{
+			name:             "flagged_for_review",
+			bank:             sweepBank{inquiryStatusCode: http.StatusServiceUnavailable},
+			maxAttempts:      1,
+			expectedStatus:   status.PaymentProcessing,
+			expectedOutcome:  repositiory.SweepReview,
+			expectedAttempts: 1,
+		},
+		{
+			name:             "inquiry_not_supported",
+			bank:             sweepBank{inquiryStatusCode: http.StatusNotImplemented},
+			maxAttempts:      2,
+			expectedStatus:   status.PaymentProcessing,
+			expectedOutcome:  repositiory.SweepReview,
+			expectedAttempts: 1,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.bank.MockClient = acquiringbank.NewMockClient(noCallback)
+			deps.BankClient = c.bank
+			d, repo, cleanFn, err := getDomainWithoutOutbox(deps)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer cleanFn()
+			ctx := context.Background()
+			_, err = d.CreatePayment(ctx, baseTestPayment)
+			if !assert.NoError(t, err) {
+				return
+			}
+			// Payments are not stuck while they are being submitted to the acquirer
+			sweeper := payment.NewSweeper(d, config.SweeperConfig{InitialBackoff: 60000, MaxBackoff: 60000, MaxAttempts: c.maxAttempts})
+			assert.Equal(t, 0, sweeper.SweepDue(ctx))
+			assert.Equal(t, 1, payment.NewOutbox(d, testOutboxConfig).ProcessDue(ctx))
+
+			assert.Equal(t, 1, sweeper.SweepDue(ctx))
+			stored, err := repo.GetPaymentByID(ctx, baseTestPayment.ID)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, c.expectedStatus, stored.PaymentStatus)
+			assert.Equal(t, c.expectedDecline, stored.Decline)
+
+			sweeps, err := repo.GetPaymentSweeps(ctx, baseTestPayment.ID)
+			if !assert.NoError(t, err) || !assert.Len(t, sweeps, 1) {
+				return
+			}
+			assert.Equal(t, c.expectedOutcome, sweeps[0].Outcome)
+			assert.Equal(t, c.expectedAttempts, sweeps[0].Attempts)
+			if c.expectedOutcome == "" {
+				assert.Nil(t, sweeps[0].FinishedAt)
+				assert.NotEmpty(t, sweeps[0].LastError)
+			}
+			// Finished sweeps are not swept again, and the next inquiry is only due after the backoff
+			assert.Equal(t, 0, sweeper.SweepDue(ctx))
+		})
+	}
+}
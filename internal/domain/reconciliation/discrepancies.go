@@ -0,0 +1,137 @@
+package reconciliation
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/kit/responses"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+var discrepancyKinds = map[string]bool{
+	repositiory.DiscrepancyMissingInGateway:  true,
+	repositiory.DiscrepancyMissingAtAcquirer: true,
+	repositiory.DiscrepancyAmountMismatch:    true,
+	repositiory.DiscrepancyStatusMismatch:    true,
+	repositiory.DiscrepancyAcquirerMismatch:  true,
+	repositiory.DiscrepancyDuplicateLine:     true,
+}
+
+// DiscrepancyFilter contains the filters an operator can use to search the discrepancies. Zero values are not used as filters.
+type DiscrepancyFilter struct {
+	Status           string
+	Kind             string
+	SettlementFileID uuid.UUID
+	Limit            int
+}
+
+// Resolution is how an operator resolved a discrepancy.
+type Resolution struct {
+	Note string `json:"note"`
+}
+
+// GetFiles returns the last imported settlement files.
+func (d *Domain) GetFiles(ctx context.Context) ([]payment_gateway.SettlementFile, error) {
+	storedFiles, err := d.repo.GetSettlementFiles(ctx, maxListLimit)
+	if err != nil {
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return nil, responses.InternalServerError{Err: err}
+	}
+	files := make([]payment_gateway.SettlementFile, 0, len(storedFiles))
+	for i := range storedFiles {
+		files = append(files, payment_gateway.GetSettlementFileFromStoredFile(&storedFiles[i]))
+	}
+	return files, nil
+}
+
+// ListDiscrepancies returns the discrepancies that match the filter, the oldest first.
+func (d *Domain) ListDiscrepancies(ctx context.Context, filter DiscrepancyFilter) ([]payment_gateway.SettlementDiscrepancy, error) {
+	repoFilter, err := filter.toRepositoryFilter()
+	if err != nil {
+		return nil, responses.BadRequestError{Err: err}
+	}
+	storedDiscrepancies, err := d.repo.ListSettlementDiscrepancies(ctx, repoFilter)
+	if err != nil {
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return nil, responses.InternalServerError{Err: err}
+	}
+	discrepancies := make([]payment_gateway.SettlementDiscrepancy, 0, len(storedDiscrepancies))
+	for i := range storedDiscrepancies {
+		discrepancies = append(discrepancies, payment_gateway.GetSettlementDiscrepancyFromStoredDiscrepancy(&storedDiscrepancies[i]))
+	}
+	return discrepancies, nil
+}
+
+func (f DiscrepancyFilter) toRepositoryFilter() (repositiory.DiscrepancyFilter, error) {
+	if f.Status != "" && f.Status != repositiory.DiscrepancyOpen && f.Status != repositiory.DiscrepancyResolved {
+		return repositiory.DiscrepancyFilter{}, fmt.Errorf("status %s is not valid", f.Status)
+	}
+	if f.Kind != "" && !discrepancyKinds[f.Kind] {
+		return repositiory.DiscrepancyFilter{}, fmt.Errorf("kind %s is not valid", f.Kind)
+	}
+	if f.Limit < 0 || f.Limit > maxListLimit {
+		return repositiory.DiscrepancyFilter{}, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+	}
+	limit := f.Limit
+	if limit == 0 {
+		limit = defaultListLimit
+	}
+	return repositiory.DiscrepancyFilter{
+		Status:           f.Status,
+		Kind:             f.Kind,
+		SettlementFileID: f.SettlementFileID,
+		Limit:            limit,
+	}, nil
+}
+
+func (d *Domain) GetDiscrepancy(ctx context.Context, id uuid.UUID) (payment_gateway.SettlementDiscrepancy, error) {
+	storedDiscrepancy, err := d.getStoredDiscrepancy(ctx, id)
+	if err != nil {
+		return payment_gateway.SettlementDiscrepancy{}, err
+	}
+	return payment_gateway.GetSettlementDiscrepancyFromStoredDiscrepancy(storedDiscrepancy), nil
+}
+
+// ResolveDiscrepancy marks the discrepancy as resolved, with a note of the operator about how it was resolved.
+// Discrepancies that were already resolved are a conflict.
+func (d *Domain) ResolveDiscrepancy(ctx context.Context, id uuid.UUID, resolution Resolution) (payment_gateway.SettlementDiscrepancy, error) {
+	if resolution.Note == "" {
+		return payment_gateway.SettlementDiscrepancy{}, responses.BadRequestError{Err: errors.New("note is required")}
+	}
+	storedDiscrepancy, err := d.getStoredDiscrepancy(ctx, id)
+	if err != nil {
+		return payment_gateway.SettlementDiscrepancy{}, err
+	}
+	storedDiscrepancy.ResolutionNote = resolution.Note
+	err = d.repo.ResolveSettlementDiscrepancy(ctx, storedDiscrepancy)
+	if err == sql.ErrNoRows {
+		return payment_gateway.SettlementDiscrepancy{}, responses.ConflictError{}
+	}
+	if err != nil {
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return payment_gateway.SettlementDiscrepancy{}, responses.InternalServerError{Err: err}
+	}
+	return payment_gateway.GetSettlementDiscrepancyFromStoredDiscrepancy(storedDiscrepancy), nil
+}
+
+func (d *Domain) getStoredDiscrepancy(ctx context.Context, id uuid.UUID) (*repositiory.SettlementDiscrepancy, error) {
+	storedDiscrepancy, err := d.repo.GetSettlementDiscrepancy(ctx, id)
+	if err == sql.ErrNoRows {
+		return nil, responses.NotFoundError{}
+	}
+	if err != nil {
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return nil, responses.InternalServerError{Err: err}
+	}
+	return storedDiscrepancy, nil
+}
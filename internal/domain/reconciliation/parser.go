@@ -0,0 +1,92 @@
+package reconciliation
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Line is a payment on the settlement file of an acquirer.
+type Line struct {
+	PaymentID uuid.UUID
+	// Amount is the amount the acquirer settled, in minor units
+	Amount       int64
+	CurrencyCode string
+	// Status is the status the acquirer gave to the payment, like succeeded or captured
+	Status string
+}
+
+// Parser reads the lines of a settlement file. The files of acquirers that use other formats are read by registering
+// a parser for their extension on the domain.
+type Parser interface {
+	Parse(r io.Reader) ([]Line, error)
+}
+
+// csvColumns are the columns every CSV settlement file needs to have on its header
+var csvColumns = []string{"payment_id", "amount", "currency", "status"}
+
+// CSVParser reads settlement files with a header, and the payment_id, amount, currency and status columns in any
+// order. Other columns are not read.
+type CSVParser struct{}
+
+func (CSVParser) Parse(r io.Reader) ([]Line, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("settlement file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range csvColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("settlement file does not have the %s column", name)
+		}
+	}
+	lines := make([]Line, 0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, err := parseCSVRecord(record, columns)
+		if err != nil {
+			row, _ := reader.FieldPos(0)
+			return nil, fmt.Errorf("line %d: %w", row, err)
+		}
+		lines = append(lines, line)
+	}
+}
+
+func parseCSVRecord(record []string, columns map[string]int) (Line, error) {
+	field := func(name string) string {
+		return strings.TrimSpace(record[columns[name]])
+	}
+	paymentID, err := uuid.Parse(field("payment_id"))
+	if err != nil {
+		return Line{}, fmt.Errorf("payment_id is not valid: %w", err)
+	}
+	amount, err := strconv.ParseInt(field("amount"), 10, 64)
+	if err != nil || amount < 0 {
+		return Line{}, fmt.Errorf("amount %q is not a positive amount in minor units", field("amount"))
+	}
+	return Line{
+		PaymentID:    paymentID,
+		Amount:       amount,
+		CurrencyCode: strings.ToUpper(field("currency")),
+		Status:       strings.ToLower(field("status")),
+	}, nil
+}
@@ -0,0 +1,142 @@
+package reconciliation_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway/internal/domain/reconciliation"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSVParser_Parse(t *testing.T) {
+	cases := []struct {
+		name          string
+		file          string
+		expectedLines []reconciliation.Line
+		expectedError error
+	}{
+		{
+			name: "parse_success",
+			file: "payment_id,amount,currency,status\n" +
+				"b5f9c307-5202-4c52-aba9-752167eef9bf,2000,usd,Succeeded\n" +
+				"f53718ed-cce8-4e4f-89e0-44626069e9cf, 1500, EUR, captured\n",
+			expectedLines: []reconciliation.Line{
+				{
+					PaymentID:    uuid.Must(uuid.Parse("b5f9c307-5202-4c52-aba9-752167eef9bf")),
+					Amount:       2000,
+					CurrencyCode: "USD",
+					Status:       "succeeded",
+				},
+				{
+					PaymentID:    uuid.Must(uuid.Parse("f53718ed-cce8-4e4f-89e0-44626069e9cf")),
+					Amount:       1500,
+					CurrencyCode: "EUR",
+					Status:       "captured",
+				},
+			},
+		},
+		{
+			name: "columns_in_other_order",
+			file: "status,fee,currency,amount,payment_id\n" +
+				"succeeded,20,USD,2000,b5f9c307-5202-4c52-aba9-752167eef9bf\n",
+			expectedLines: []reconciliation.Line{
+				{
+					PaymentID:    uuid.Must(uuid.Parse("b5f9c307-5202-4c52-aba9-752167eef9bf")),
+					Amount:       2000,
+					CurrencyCode: "USD",
+					Status:       "succeeded",
+				},
+			},
+		},
+		{
+			name:          "only_header",
+			file:          "payment_id,amount,currency,status\n",
+			expectedLines: []reconciliation.Line{},
+		},
+		{
+			name:          "empty_file",
+			expectedError: errors.New("settlement file is empty"),
+		},
+		{
+			name:          "missing_column",
+			file:          "payment_id,amount,status\n",
+			expectedError: errors.New("settlement file does not have the currency column"),
+		},
+		{
+			name: "invalid_amount",
+			file: "payment_id,amount,currency,status\n" +
+				"b5f9c307-5202-4c52-aba9-752167eef9bf,20.00,USD,succeeded\n",
+			expectedError: errors.New("line 2: amount \"20.00\" is not a positive amount in minor units"),
+		},
+		{
+			name: "invalid_payment_id",
+			file: "payment_id,amount,currency,status\n" +
+				"b5f9c307-5202-4c52-aba9-752167eef9bf,2000,USD,succeeded\n" +
+				"payment-1,2000,USD,succeeded\n",
+			expectedError: errors.New("line 3: payment_id is not valid: invalid UUID length: 9"),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			lines, err := reconciliation.CSVParser{}.Parse(strings.NewReader(c.file))
+			if c.expectedError != nil {
+				assert.EqualError(t, err, c.expectedError.Error())
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, c.expectedLines, lines)
+		})
+	}
+}
+
+func TestParseFileName(t *testing.T) {
+	cases := []struct {
+		name             string
+		fileName         string
+		expectedAcquirer string
+		expectedDate     time.Time
+		expectError      bool
+	}{
+		{
+			name:             "parse_success",
+			fileName:         "mock_2022-09-01.csv",
+			expectedAcquirer: "mock",
+			expectedDate:     time.Date(2022, 9, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:             "acquirer_with_underscore",
+			fileName:         "mock_eu_2022-09-01.csv",
+			expectedAcquirer: "mock_eu",
+			expectedDate:     time.Date(2022, 9, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:        "missing_acquirer",
+			fileName:    "2022-09-01.csv",
+			expectError: true,
+		},
+		{
+			name:        "invalid_date",
+			fileName:    "mock_2022-13-01.csv",
+			expectError: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			acquirer, date, err := reconciliation.ParseFileName(c.fileName)
+			if c.expectError {
+				assert.Error(t, err)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, c.expectedAcquirer, acquirer)
+			assert.Equal(t, c.expectedDate, date)
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package reconciliation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/uptrace/bun/driver/pgdriver"
+	"go.uber.org/zap"
+)
+
+// settlementDateLayout is the layout of the date on the name of the settlement files
+const settlementDateLayout = "2006-01-02"
+
+// Domain imports the settlement files of the acquirers, and matches them to the payments. Every difference between
+// them is a discrepancy, which the operators look at and resolve.
+type Domain struct {
+	repo   repositiory.Repository
+	logger *zap.Logger
+	cfg    config.ReconciliationConfig
+	// defaultAcquirer settles the payments created before routing, which have an empty acquirer
+	defaultAcquirer string
+	parsers         map[string]Parser
+}
+
+func NewDomain(repo repositiory.Repository, l *zap.Logger, cfg config.ReconciliationConfig, defaultAcquirer string) *Domain {
+	return &Domain{
+		repo:            repo,
+		logger:          l,
+		cfg:             cfg,
+		defaultAcquirer: defaultAcquirer,
+		parsers: map[string]Parser{
+			".csv": CSVParser{},
+		},
+	}
+}
+
+// RegisterParser reads the settlement files with the given extension, like ".csv", with the parser. It should be
+// called before the files are imported.
+func (d *Domain) RegisterParser(extension string, parser Parser) {
+	d.parsers[strings.ToLower(extension)] = parser
+}
+
+// ParseFileName returns the acquirer and the settlement date of a settlement file, which is named
+// <acquirer>_<YYYY-MM-DD>.<extension>.
+func ParseFileName(name string) (string, time.Time, error) {
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	i := strings.LastIndex(base, "_")
+	if i <= 0 {
+		return "", time.Time{}, fmt.Errorf("settlement file %s is not named <acquirer>_<YYYY-MM-DD>", name)
+	}
+	settlementDate, err := time.Parse(settlementDateLayout, base[i+1:])
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("settlement file %s does not have a valid date: %w", name, err)
+	}
+	return base[:i], settlementDate, nil
+}
+
+// Run imports the new settlement files on every interval, until the context is done.
+func (d *Domain) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(d.cfg.Interval) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.ImportFiles(ctx)
+		}
+	}
+}
+
+// ImportFiles imports the settlement files of the directory that were not imported yet, and returns how many of them
+// it imported. Files without a parser for their extension are left out, and files that can not be imported are
+// tried again on the next run.
+func (d *Domain) ImportFiles(ctx context.Context) int {
+	entries, err := os.ReadDir(d.cfg.Directory)
+	if errors.Is(err, fs.ErrNotExist) {
+		d.logger.Debug("Settlement directory does not exist", zap.String("directory", d.cfg.Directory))
+		return 0
+	}
+	if err != nil {
+		d.logger.Error("Could not read settlement directory", zap.String("directory", d.cfg.Directory), zap.Error(err))
+		return 0
+	}
+	imported := 0
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return imported
+		}
+		parser, ok := d.parsers[strings.ToLower(filepath.Ext(entry.Name()))]
+		if entry.IsDir() || !ok {
+			continue
+		}
+		ok, err = d.importFile(ctx, entry.Name(), parser)
+		if err != nil {
+			d.logger.Error("Could not import settlement file", zap.String("name", entry.Name()), zap.Error(err))
+			continue
+		}
+		if ok {
+			imported++
+		}
+	}
+	return imported
+}
+
+// importFile reads the settlement file, and stores it along with its discrepancies. It returns false when the file
+// was already imported.
+func (d *Domain) importFile(ctx context.Context, name string, parser Parser) (bool, error) {
+	exists, err := d.repo.SettlementFileExists(ctx, name)
+	if err != nil || exists {
+		return false, err
+	}
+	acquirer, settlementDate, err := ParseFileName(name)
+	if err != nil {
+		return false, err
+	}
+	f, err := os.Open(filepath.Join(d.cfg.Directory, name))
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	lines, err := parser.Parse(f)
+	if err != nil {
+		return false, err
+	}
+	discrepancies, err := d.reconcile(ctx, acquirer, settlementDate, lines)
+	if err != nil {
+		return false, err
+	}
+	file := &repositiory.SettlementFile{
+		ID:               uuid.New(),
+		Name:             name,
+		Acquirer:         acquirer,
+		SettlementDate:   settlementDate,
+		LineCount:        len(lines),
+		DiscrepancyCount: len(discrepancies),
+	}
+	txRepo, err := d.repo.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	err = txRepo.CreateSettlementFile(ctx, file, discrepancies)
+	if err != nil {
+		_ = txRepo.Rollback(ctx)
+		// Another gateway instance imported the file at the same time
+		var pgErr pgdriver.Error
+		if errors.As(err, &pgErr) && pgErr.IntegrityViolation() {
+			return false, nil
+		}
+		return false, err
+	}
+	err = txRepo.Commit(ctx)
+	if err != nil {
+		return false, err
+	}
+	d.logger.Info("Imported settlement file",
+		zap.String("name", name),
+		zap.String("acquirer", acquirer),
+		zap.Int("lines", len(lines)),
+		zap.Int("discrepancies", len(discrepancies)))
+	return true, nil
+}
+
+// reconcile matches every line of the settlement file to the payment with the same id, and looks for the payments
+// that the acquirer should have settled on the day of the file, but are not on it. Only the first line of a payment
+// is matched, and the payment must have been sent to the acquirer of the file.
+func (d *Domain) reconcile(ctx context.Context, acquirer string, settlementDate time.Time, lines []Line) ([]repositiory.SettlementDiscrepancy, error) {
+	ids := make([]uuid.UUID, 0, len(lines))
+	onFile := make(map[uuid.UUID]bool, len(lines))
+	for _, line := range lines {
+		ids = append(ids, line.PaymentID)
+		onFile[line.PaymentID] = true
+	}
+	storedPayments, err := d.repo.GetPaymentsByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	payments := make(map[uuid.UUID]*repositiory.Payment, len(storedPayments))
+	for i := range storedPayments {
+		payments[storedPayments[i].ID] = &storedPayments[i]
+	}
+
+	discrepancies := make([]repositiory.SettlementDiscrepancy, 0)
+	matched := make(map[uuid.UUID]bool, len(lines))
+	for i := range lines {
+		line := &lines[i]
+		payment := payments[line.PaymentID]
+		// The acquirer would settle the payment twice
+		if matched[line.PaymentID] {
+			discrepancies = append(discrepancies, newDiscrepancy(repositiory.DiscrepancyDuplicateLine, line.PaymentID, payment, line))
+			continue
+		}
+		matched[line.PaymentID] = true
+		if payment == nil {
+			discrepancies = append(discrepancies, newDiscrepancy(repositiory.DiscrepancyMissingInGateway, line.PaymentID, nil, line))
+			continue
+		}
+		// The payment was not sent to this acquirer, so its amount and status are not the ones it settled
+		if d.paymentAcquirer(payment) != acquirer {
+			discrepancies = append(discrepancies, newDiscrepancy(repositiory.DiscrepancyAcquirerMismatch, line.PaymentID, payment, line))
+			continue
+		}
+		if line.Amount != settledAmount(payment) || line.CurrencyCode != payment.CurrencyCode {
+			discrepancies = append(discrepancies, newDiscrepancy(repositiory.DiscrepancyAmountMismatch, line.PaymentID, payment, line))
+		}
+		if line.Status != string(payment.PaymentStatus) {
+			discrepancies = append(discrepancies, newDiscrepancy(repositiory.DiscrepancyStatusMismatch, line.PaymentID, payment, line))
+		}
+	}
+
+	acquirers := []string{acquirer}
+	if acquirer == d.defaultAcquirer {
+		acquirers = append(acquirers, "")
+	}
+	settled, err := d.repo.GetSettledPayments(ctx, acquirers, settlementDate, settlementDate.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+	for i := range settled {
+		if !onFile[settled[i].ID] {
+			discrepancies = append(discrepancies, newDiscrepancy(repositiory.DiscrepancyMissingAtAcquirer, settled[i].ID, &settled[i], nil))
+		}
+	}
+	return discrepancies, nil
+}
+
+// paymentAcquirer returns the acquirer the payment was sent to.
+func (d *Domain) paymentAcquirer(payment *repositiory.Payment) string {
+	if payment.Acquirer == "" {
+		return d.defaultAcquirer
+	}
+	return payment.Acquirer
+}
+
+// settledAmount returns the amount the acquirer should settle for the payment, which is the captured amount of
+// manual capture payments.
+func settledAmount(payment *repositiory.Payment) int64 {
+	if payment.AmountCaptured > 0 {
+		return payment.AmountCaptured
+	}
+	return payment.Amount
+}
+
+// newDiscrepancy returns a discrepancy between the payment and the line of the settlement file, either of which is
+// nil when it is missing.
+func newDiscrepancy(kind string, paymentID uuid.UUID, payment *repositiory.Payment, line *Line) repositiory.SettlementDiscrepancy {
+	discrepancy := repositiory.SettlementDiscrepancy{
+		ID:        uuid.New(),
+		PaymentID: paymentID,
+		Kind:      kind,
+		Status:    repositiory.DiscrepancyOpen,
+	}
+	if payment != nil {
+		discrepancy.GatewayAmount = settledAmount(payment)
+		discrepancy.GatewayCurrency = payment.CurrencyCode
+		discrepancy.GatewayStatus = string(payment.PaymentStatus)
+	}
+	if line != nil {
+		discrepancy.AcquirerAmount = line.Amount
+		discrepancy.AcquirerCurrency = line.CurrencyCode
+		discrepancy.AcquirerStatus = line.Status
+	}
+	return discrepancy
+}
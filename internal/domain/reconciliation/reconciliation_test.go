@@ -0,0 +1,198 @@
+package reconciliation_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/dependencies"
+	"github.com/marioarizaj/payment-gateway/internal/domain/reconciliation"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"github.com/marioarizaj/payment-gateway/kit/responses"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+var testMerchantID = uuid.Must(uuid.Parse("6c5a19d0-f132-4a55-93d3-2c00db06d41b"))
+
+func TestDomain_ImportFiles(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	dir := t.TempDir()
+	d, repo, cleanFn, err := getDomain(deps, dir)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cleanFn()
+	ctx := context.Background()
+
+	settledAt := time.Date(2021, 1, 15, 12, 0, 0, 0, time.UTC)
+	dayBefore := settledAt.AddDate(0, 0, -1)
+	matching := insertTestPayment(t, repo, "mock", status.PaymentSucceeded, 2000, 0, settledAt, settledAt)
+	partiallyCaptured := insertTestPayment(t, repo, "mock", status.PaymentCaptured, 2000, 1500, settledAt, settledAt)
+	wrongStatus := insertTestPayment(t, repo, "mock", status.PaymentSucceeded, 2000, 0, settledAt, settledAt)
+	notSettled := insertTestPayment(t, repo, "mock", status.PaymentSucceeded, 2000, 0, settledAt, settledAt)
+	// Manual capture payments are settled on the day they were captured
+	capturedNotSettled := insertTestPayment(t, repo, "mock", status.PaymentCaptured, 2000, 2000, dayBefore, settledAt)
+	otherAcquirer := insertTestPayment(t, repo, "secondary", status.PaymentSucceeded, 2000, 0, settledAt, settledAt)
+	// Payments that were not taken from the shopper, or on other days, are not on the file
+	insertTestPayment(t, repo, "mock", status.PaymentProcessing, 2000, 0, settledAt, settledAt)
+	insertTestPayment(t, repo, "mock", status.PaymentSucceeded, 2000, 0, settledAt.AddDate(0, 0, 1), settledAt.AddDate(0, 0, 1))
+	insertTestPayment(t, repo, "mock", status.PaymentCaptured, 2000, 2000, settledAt, settledAt.AddDate(0, 0, 1))
+	unknown := uuid.New()
+
+	writeFile(t, dir, "mock_2021-01-15.csv", "payment_id,amount,currency,status\n"+
+		matching.String()+",2000,USD,succeeded\n"+
+		partiallyCaptured.String()+",2000,USD,captured\n"+
+		wrongStatus.String()+",2000,USD,failed\n"+
+		otherAcquirer.String()+",2000,USD,succeeded\n"+
+		unknown.String()+",500,USD,succeeded\n"+
+		matching.String()+",2000,USD,succeeded\n")
+	// Files that can not be read, and files of other formats, are not imported
+	writeFile(t, dir, "mock_2021-01-16.csv", "payment_id,amount\n")
+	writeFile(t, dir, "notes.txt", "not a settlement file")
+
+	assert.Equal(t, 1, d.ImportFiles(ctx))
+	// The files are only imported once
+	assert.Equal(t, 0, d.ImportFiles(ctx))
+
+	files, err := d.GetFiles(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var file *payment_gateway.SettlementFile
+	for i := range files {
+		if files[i].Name == "mock_2021-01-15.csv" {
+			file = &files[i]
+		}
+	}
+	if !assert.NotNil(t, file) {
+		return
+	}
+	assert.Equal(t, "mock", file.Acquirer)
+	assert.Equal(t, "2021-01-15", file.SettlementDate)
+	assert.Equal(t, 6, file.LineCount)
+	assert.Equal(t, 7, file.DiscrepancyCount)
+
+	discrepancies, err := d.ListDiscrepancies(ctx, reconciliation.DiscrepancyFilter{SettlementFileID: file.ID})
+	if !assert.NoError(t, err) {
+		return
+	}
+	kinds := make(map[uuid.UUID]string, len(discrepancies))
+	for _, discrepancy := range discrepancies {
+		assert.Equal(t, repositiory.DiscrepancyOpen, discrepancy.Status)
+		kinds[discrepancy.PaymentID] = discrepancy.Kind
+	}
+	// The first line of the matching payment is matched, and the second one is a duplicate
+	assert.Equal(t, map[uuid.UUID]string{
+		matching:           repositiory.DiscrepancyDuplicateLine,
+		partiallyCaptured:  repositiory.DiscrepancyAmountMismatch,
+		wrongStatus:        repositiory.DiscrepancyStatusMismatch,
+		otherAcquirer:      repositiory.DiscrepancyAcquirerMismatch,
+		notSettled:         repositiory.DiscrepancyMissingAtAcquirer,
+		capturedNotSettled: repositiory.DiscrepancyMissingAtAcquirer,
+		unknown:            repositiory.DiscrepancyMissingInGateway,
+	}, kinds)
+}
+
+func TestDomain_ResolveDiscrepancy(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	dir := t.TempDir()
+	d, _, cleanFn, err := getDomain(deps, dir)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cleanFn()
+	ctx := context.Background()
+
+	unknown := uuid.New()
+	writeFile(t, dir, "mock_2021-02-15.csv", "payment_id,amount,currency,status\n"+unknown.String()+",500,USD,succeeded\n")
+	if !assert.Equal(t, 1, d.ImportFiles(ctx)) {
+		return
+	}
+	discrepancies, err := d.ListDiscrepancies(ctx, reconciliation.DiscrepancyFilter{Status: repositiory.DiscrepancyOpen, Limit: 100})
+	if !assert.NoError(t, err) {
+		return
+	}
+	var id uuid.UUID
+	for _, discrepancy := range discrepancies {
+		if discrepancy.PaymentID == unknown {
+			id = discrepancy.ID
+		}
+	}
+
+	_, err = d.ResolveDiscrepancy(ctx, id, reconciliation.Resolution{})
+	assert.IsType(t, responses.BadRequestError{}, err)
+
+	resolved, err := d.ResolveDiscrepancy(ctx, id, reconciliation.Resolution{Note: "Refunded by the acquirer"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, repositiory.DiscrepancyResolved, resolved.Status)
+	assert.Equal(t, "Refunded by the acquirer", resolved.ResolutionNote)
+	assert.NotNil(t, resolved.ResolvedAt)
+
+	_, err = d.ResolveDiscrepancy(ctx, id, reconciliation.Resolution{Note: "Resolved again"})
+	assert.Equal(t, responses.ConflictError{}, err)
+
+	_, err = d.ResolveDiscrepancy(ctx, uuid.New(), reconciliation.Resolution{Note: "Not found"})
+	assert.Equal(t, responses.NotFoundError{}, err)
+}
+
+func getDomain(deps dependencies.Dependencies, dir string) (*reconciliation.Domain, repositiory.Repository, func(), error) {
+	tx, err := deps.DB.BeginTx(context.Background(), &sql.TxOptions{})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	repo := repositiory.NewRepository(tx)
+	d := reconciliation.NewDomain(repo, zap.NewNop(), config.ReconciliationConfig{Directory: dir}, "mock")
+	return d, repo, func() {
+		_ = tx.Rollback()
+	}, nil
+}
+
+// insertTestPayment inserts a payment of the acquirer that was created at createdAt, and moved to its status at settledAt.
+func insertTestPayment(t *testing.T, repo repositiory.Repository, acquirer string, paymentStatus status.PaymentStatus, amount int64, amountCaptured int64, createdAt time.Time, settledAt time.Time) uuid.UUID {
+	payment := &repositiory.Payment{
+		ID:              uuid.New(),
+		Amount:          amount,
+		AmountCaptured:  amountCaptured,
+		PaymentStatus:   paymentStatus,
+		MerchantID:      testMerchantID,
+		CurrencyCode:    "USD",
+		Description:     "Payment test",
+		Acquirer:        acquirer,
+		CardName:        "Mario Arizaj",
+		CardNumber:      "378282246310005",
+		CardExpiryMonth: 10,
+		CardExpiryYear:  22,
+		CreatedAt:       &createdAt,
+		UpdatedAt:       &settledAt,
+	}
+	assert.NoError(t, repo.CreatePayment(context.Background(), payment))
+	return payment.ID
+}
+
+func writeFile(t *testing.T, dir string, name string, content string) {
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+}
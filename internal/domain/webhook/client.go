@@ -0,0 +1,77 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// ErrAddressNotAllowed is returned when an endpoint resolves to an address of our own network.
+var ErrAddressNotAllowed = errors.New("endpoint address is not allowed")
+
+// NewHTTPClient returns the client used to send events to the endpoints. Endpoint urls are given by merchants, so the
+// client only connects to public addresses: the address is checked after the host is resolved, right before dialing,
+// so a host that resolves to a public address when the endpoint is created and to a private one later is also
+// rejected. Redirects are not followed, and proxies from the environment are not used.
+func NewHTTPClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   checkAddress,
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// checkAddress is called with the resolved address of every connection, before it is made.
+func checkAddress(_ string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return ErrAddressNotAllowed
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return ErrAddressNotAllowed
+	}
+	return nil
+}
+
+// isPublicIP returns false for loopback, private, link-local, unspecified and multicast addresses.
+// Link-local addresses include the metadata service of the cloud providers, 169.254.169.254.
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() &&
+		!ip.IsPrivate() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() &&
+		!ip.IsUnspecified()
+}
+
+// deliveryError returns the error stored on the delivery log when the event could not be sent. Merchants can read
+// the delivery log, so it never contains the error of the connection, which could tell them about our network.
+func deliveryError(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrAddressNotAllowed):
+		return ErrAddressNotAllowed.Error()
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "endpoint did not respond in time"
+	default:
+		return "could not connect to the endpoint"
+	}
+}
@@ -0,0 +1,164 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	kitctx "github.com/marioarizaj/payment-gateway/kit/ctx"
+	"go.uber.org/zap"
+)
+
+// Dispatcher is the pool of workers that send the stored events to the endpoints. Events are sent at least once: an
+// event is hidden from the other workers while it is sent, and it is claimed again once its visibility timeout passes,
+// when the worker that claimed it did not finish it. Events the endpoint does not accept are sent again later, waiting
+// twice as long after each attempt, until they are dead lettered.
+type Dispatcher struct {
+	domain *Domain
+	cfg    config.WebhookConfig
+}
+
+func NewDispatcher(domain *Domain, cfg config.WebhookConfig) *Dispatcher {
+	return &Dispatcher{
+		domain: domain,
+		cfg:    cfg,
+	}
+}
+
+// Run starts the workers, and waits for them to stop once the context is done.
+func (p *Dispatcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < p.cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// work sends the events that are due, and then waits for a new event or for the next poll, until the context is done.
+func (p *Dispatcher) work(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(p.cfg.PollInterval) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		p.ProcessDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-p.domain.pendingEvents:
+		}
+	}
+}
+
+// ProcessDue sends every event that is due, and returns how many of them it sent.
+func (p *Dispatcher) ProcessDue(ctx context.Context) int {
+	processed := 0
+	for ctx.Err() == nil {
+		ok, err := p.processNext(ctx)
+		if err != nil {
+			p.domain.logger.Error("Could not send webhook event", zap.Error(err))
+			return processed
+		}
+		if !ok {
+			return processed
+		}
+		processed++
+	}
+	return processed
+}
+
+// processNext claims the event that is due the longest, sends it, and writes the result. The event is claimed on its
+// own statement, so no transaction is open while the endpoint is called. It returns false when no event is due.
+func (p *Dispatcher) processNext(ctx context.Context) (bool, error) {
+	d := p.domain
+	event, err := d.repo.ClaimWebhookEvent(ctx, time.Now(), time.Duration(p.cfg.VisibilityTimeout)*time.Millisecond)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// An event that was claimed is sent to the end when the workers are stopped, so its attempt is stored
+	ctx = kitctx.WithoutCancel(ctx)
+	sendErr := p.send(ctx, event)
+	switch {
+	case sendErr == nil:
+		p.finish(event, repositiory.WebhookEventDelivered, nil)
+	case errors.Is(sendErr, errEndpointDisabled) || event.Attempts >= p.cfg.MaxAttempts:
+		d.logger.Warn("Webhook event was not delivered",
+			zap.String("endpoint_id", event.EndpointID.String()),
+			zap.String("event_id", event.EventID.String()),
+			zap.Int("attempts", event.Attempts),
+			zap.Error(sendErr))
+		p.finish(event, repositiory.WebhookEventDead, sendErr)
+	default:
+		p.retry(event, sendErr)
+	}
+	err = d.repo.UpdateWebhookEvent(ctx, event)
+	if err == sql.ErrNoRows {
+		d.logger.Warn("Webhook event was claimed again while it was sent", zap.String("id", event.ID.String()))
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// errEndpointDisabled is returned for the events of endpoints that were disabled after the event was published.
+var errEndpointDisabled = errors.New("endpoint is disabled")
+
+// send makes one attempt to send the event to its endpoint, and returns why the endpoint did not accept it.
+func (p *Dispatcher) send(ctx context.Context, event *repositiory.WebhookEvent) error {
+	d := p.domain
+	endpoint, err := d.repo.GetWebhookEndpoint(ctx, event.MerchantID, event.EndpointID)
+	if err != nil {
+		return err
+	}
+	if !endpoint.Enabled {
+		return errEndpointDisabled
+	}
+	delivery := d.deliver(ctx, *endpoint, event.EventID, event.EventType, event.Body, event.Attempts)
+	if !delivery.Succeeded {
+		return errors.New(delivery.Error)
+	}
+	return nil
+}
+
+// finish ends the event with the given status.
+func (p *Dispatcher) finish(event *repositiory.WebhookEvent, eventStatus string, err error) {
+	now := time.Now()
+	event.Status = eventStatus
+	event.FinishedAt = &now
+	event.LastError = ""
+	if err != nil {
+		event.LastError = err.Error()
+	}
+}
+
+// retry records the error of the attempt, and makes the event due again after a backoff that doubles with every attempt.
+func (p *Dispatcher) retry(event *repositiory.WebhookEvent, err error) {
+	event.NextAttemptAt = time.Now().Add(backoff(p.cfg.InitialBackoff, p.cfg.MaxBackoff, event.Attempts))
+	event.LastError = err.Error()
+}
+
+// backoff returns how long to wait before the next attempt of an event that failed the given number of times.
+// It starts at the initial backoff, and doubles with every attempt up to the max backoff, both in milliseconds.
+func backoff(initialBackoff, maxBackoff, attempts int) time.Duration {
+	wait := time.Duration(initialBackoff) * time.Millisecond
+	limit := time.Duration(maxBackoff) * time.Millisecond
+	for i := 1; i < attempts && wait < limit; i++ {
+		wait *= 2
+	}
+	if wait > limit {
+		wait = limit
+	}
+	return wait
+}
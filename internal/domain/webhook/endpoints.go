@@ -0,0 +1,206 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/kit/responses"
+	"go.uber.org/zap"
+)
+
+const (
+	secretPrefix  = "whsec_"
+	deliveryLimit = 100
+)
+
+// CreateEndpoint registers a new webhook endpoint for the merchant. The returned endpoint contains the secret
+// used to sign its events, which is not returned again.
+func (d *Domain) CreateEndpoint(ctx context.Context, endpoint payment_gateway.WebhookEndpoint) (payment_gateway.WebhookEndpoint, error) {
+	err := validateEndpoint(endpoint)
+	if err != nil {
+		return payment_gateway.WebhookEndpoint{}, responses.BadRequestError{Err: err}
+	}
+	endpoint.ID = uuid.New()
+	endpoint.Enabled = true
+	endpoint.Secret, err = generateSecret()
+	if err != nil {
+		d.logger.Error("Could not generate webhook secret", zap.Error(err))
+		return payment_gateway.WebhookEndpoint{}, responses.InternalServerError{Err: err}
+	}
+	storedEndpoint := endpoint.GetStorageWebhookEndpoint()
+	err = d.repo.CreateWebhookEndpoint(ctx, storedEndpoint)
+	if err != nil {
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return payment_gateway.WebhookEndpoint{}, responses.InternalServerError{Err: err}
+	}
+	created := payment_gateway.GetWebhookEndpointFromStoredEndpoint(storedEndpoint)
+	created.Secret = storedEndpoint.Secret
+	return created, nil
+}
+
+func (d *Domain) GetEndpoint(ctx context.Context, merchantID uuid.UUID, id uuid.UUID) (payment_gateway.WebhookEndpoint, error) {
+	storedEndpoint, err := d.getStoredEndpoint(ctx, merchantID, id)
+	if err != nil {
+		return payment_gateway.WebhookEndpoint{}, err
+	}
+	return payment_gateway.GetWebhookEndpointFromStoredEndpoint(storedEndpoint), nil
+}
+
+func (d *Domain) GetEndpoints(ctx context.Context, merchantID uuid.UUID) ([]payment_gateway.WebhookEndpoint, error) {
+	storedEndpoints, err := d.repo.GetWebhookEndpoints(ctx, merchantID)
+	if err != nil {
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return nil, responses.InternalServerError{Err: err}
+	}
+	endpoints := make([]payment_gateway.WebhookEndpoint, 0, len(storedEndpoints))
+	for i := range storedEndpoints {
+		endpoints = append(endpoints, payment_gateway.GetWebhookEndpointFromStoredEndpoint(&storedEndpoints[i]))
+	}
+	return endpoints, nil
+}
+
+// EndpointUpdate contains the fields of an endpoint to update. Fields that are not set keep their current value.
+type EndpointUpdate struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	Enabled    *bool    `json:"enabled"`
+}
+
+// UpdateEndpoint updates the url, event types and enabled flag of the endpoint. The secret does not change.
+func (d *Domain) UpdateEndpoint(ctx context.Context, merchantID uuid.UUID, id uuid.UUID, update EndpointUpdate) (payment_gateway.WebhookEndpoint, error) {
+	storedEndpoint, err := d.getStoredEndpoint(ctx, merchantID, id)
+	if err != nil {
+		return payment_gateway.WebhookEndpoint{}, err
+	}
+	if update.URL != "" {
+		storedEndpoint.URL = update.URL
+	}
+	if update.EventTypes != nil {
+		storedEndpoint.EventTypes = update.EventTypes
+	}
+	if update.Enabled != nil {
+		storedEndpoint.Enabled = *update.Enabled
+	}
+	err = validateEndpoint(payment_gateway.GetWebhookEndpointFromStoredEndpoint(storedEndpoint))
+	if err != nil {
+		return payment_gateway.WebhookEndpoint{}, responses.BadRequestError{Err: err}
+	}
+	err = d.repo.UpdateWebhookEndpoint(ctx, storedEndpoint)
+	if err == sql.ErrNoRows {
+		return payment_gateway.WebhookEndpoint{}, responses.NotFoundError{}
+	}
+	if err != nil {
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return payment_gateway.WebhookEndpoint{}, responses.InternalServerError{Err: err}
+	}
+	return payment_gateway.GetWebhookEndpointFromStoredEndpoint(storedEndpoint), nil
+}
+
+func (d *Domain) DeleteEndpoint(ctx context.Context, merchantID uuid.UUID, id uuid.UUID) error {
+	err := d.repo.DeleteWebhookEndpoint(ctx, merchantID, id)
+	if err == sql.ErrNoRows {
+		return responses.NotFoundError{}
+	}
+	if err != nil {
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return responses.InternalServerError{Err: err}
+	}
+	return nil
+}
+
+// GetDeliveries returns the latest delivery attempts of the endpoint, newest first.
+func (d *Domain) GetDeliveries(ctx context.Context, merchantID uuid.UUID, endpointID uuid.UUID) ([]payment_gateway.WebhookDelivery, error) {
+	_, err := d.getStoredEndpoint(ctx, merchantID, endpointID)
+	if err != nil {
+		return nil, err
+	}
+	storedDeliveries, err := d.repo.GetWebhookDeliveries(ctx, endpointID, deliveryLimit)
+	if err != nil {
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return nil, responses.InternalServerError{Err: err}
+	}
+	deliveries := make([]payment_gateway.WebhookDelivery, 0, len(storedDeliveries))
+	for i := range storedDeliveries {
+		deliveries = append(deliveries, payment_gateway.GetWebhookDeliveryFromStoredDelivery(&storedDeliveries[i]))
+	}
+	return deliveries, nil
+}
+
+// SendTestEvent sends a test event to the endpoint, even when it is disabled, and returns the result of the attempt.
+// The test event is not retried.
+func (d *Domain) SendTestEvent(ctx context.Context, merchantID uuid.UUID, endpointID uuid.UUID) (payment_gateway.WebhookDelivery, error) {
+	storedEndpoint, err := d.getStoredEndpoint(ctx, merchantID, endpointID)
+	if err != nil {
+		return payment_gateway.WebhookDelivery{}, err
+	}
+	event := payment_gateway.WebhookEvent{
+		ID:        uuid.New(),
+		Type:      payment_gateway.EventWebhookTest,
+		CreatedAt: time.Now().UTC(),
+		Data:      map[string]string{"endpoint_id": endpointID.String()},
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		d.logger.Error("Could not encode webhook event", zap.Error(err))
+		return payment_gateway.WebhookDelivery{}, responses.InternalServerError{Err: err}
+	}
+	delivery := d.deliver(ctx, *storedEndpoint, event.ID, event.Type, body, 1)
+	return payment_gateway.GetWebhookDeliveryFromStoredDelivery(&delivery), nil
+}
+
+func (d *Domain) getStoredEndpoint(ctx context.Context, merchantID uuid.UUID, id uuid.UUID) (*repositiory.WebhookEndpoint, error) {
+	storedEndpoint, err := d.repo.GetWebhookEndpoint(ctx, merchantID, id)
+	if err != nil && err != sql.ErrNoRows {
+		d.logger.Error("Database unexpected error", zap.Error(err))
+		return nil, responses.InternalServerError{Err: err}
+	}
+	if err == sql.ErrNoRows {
+		d.logger.Info("Webhook endpoint not found", zap.String("id", id.String()))
+		return nil, responses.NotFoundError{}
+	}
+	return storedEndpoint, nil
+}
+
+func validateEndpoint(endpoint payment_gateway.WebhookEndpoint) error {
+	u, err := url.Parse(endpoint.URL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return errors.New("url must be an absolute https url")
+	}
+	if len(endpoint.EventTypes) == 0 {
+		return errors.New("at least one event type is required")
+	}
+	for _, eventType := range endpoint.EventTypes {
+		if !isEventType(eventType) {
+			return fmt.Errorf("event type %s is not valid", eventType)
+		}
+	}
+	return nil
+}
+
+func isEventType(eventType string) bool {
+	for _, e := range payment_gateway.EventTypes {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func generateSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return secretPrefix + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,148 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/kit/auth"
+	"go.uber.org/zap"
+)
+
+const (
+	// SignatureHeader contains the time the event was signed and the signature, as t=<unix time>,v1=<hex hmac>
+	SignatureHeader = "Webhook-Signature"
+	// EventIDHeader contains the id of the event, so merchants can ignore events they already received
+	EventIDHeader = "Webhook-Event-Id"
+)
+
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+type Domain struct {
+	repo   repositiory.Repository
+	client HTTPClient
+	logger *zap.Logger
+	cfg    config.WebhookConfig
+	// pendingEvents wakes up a worker of the dispatcher when an event is published
+	pendingEvents chan struct{}
+}
+
+func NewDomain(repo repositiory.Repository, client HTTPClient, l *zap.Logger, cfg config.WebhookConfig) *Domain {
+	return &Domain{
+		repo:          repo,
+		client:        client,
+		logger:        l,
+		cfg:           cfg,
+		pendingEvents: make(chan struct{}, 1),
+	}
+}
+
+// PublishPaymentEvent stores the event for every endpoint of the merchant that is subscribed to it, using the given
+// repository, which should be the transaction that changed the payment. Each endpoint has its own event, so a slow
+// endpoint does not delay the others.
+func (d *Domain) PublishPaymentEvent(ctx context.Context, repo repositiory.Repository, eventType string, payment payment_gateway.Payment) error {
+	endpoints, err := repo.GetWebhookEndpointsForEvent(ctx, payment.MerchantID, eventType)
+	if err != nil || len(endpoints) == 0 {
+		return err
+	}
+	event := payment_gateway.WebhookEvent{
+		ID:        uuid.New(),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data:      payment,
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	events := make([]repositiory.WebhookEvent, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		events = append(events, repositiory.WebhookEvent{
+			ID:         uuid.New(),
+			EndpointID: endpoint.ID,
+			MerchantID: endpoint.MerchantID,
+			EventID:    event.ID,
+			EventType:  event.Type,
+			Body:       body,
+		})
+	}
+	return repo.CreateWebhookEvents(ctx, events)
+}
+
+// NotifyDispatcher wakes up a worker of the dispatcher that is waiting for events, without blocking when none of them
+// is. Workers that are busy look for events once they are done, so the event is not missed.
+func (d *Domain) NotifyDispatcher() {
+	select {
+	case d.pendingEvents <- struct{}{}:
+	default:
+	}
+}
+
+// deliver makes a single attempt to send the event to the endpoint, and stores the attempt on the delivery log.
+func (d *Domain) deliver(ctx context.Context, endpoint repositiory.WebhookEndpoint, eventID uuid.UUID, eventType string, body []byte, attempt int) repositiory.WebhookDelivery {
+	delivery := repositiory.WebhookDelivery{
+		ID:         uuid.New(),
+		EndpointID: endpoint.ID,
+		MerchantID: endpoint.MerchantID,
+		EventID:    eventID,
+		EventType:  eventType,
+		Attempt:    attempt,
+	}
+	start := time.Now()
+	statusCode, err := d.send(ctx, endpoint, eventID, body)
+	delivery.DurationMs = time.Since(start).Milliseconds()
+	delivery.ResponseStatusCode = statusCode
+	if err != nil {
+		d.logger.Warn("Could not send webhook event",
+			zap.String("endpoint_id", endpoint.ID.String()),
+			zap.String("event_id", eventID.String()),
+			zap.Error(err))
+		delivery.Error = deliveryError(err)
+	} else if statusCode < 200 || statusCode > 299 {
+		delivery.Error = fmt.Sprintf("endpoint responded with status %d", statusCode)
+	} else {
+		delivery.Succeeded = true
+	}
+
+	err = d.repo.CreateWebhookDelivery(ctx, &delivery)
+	if err != nil {
+		d.logger.Error("Could not store webhook delivery", zap.Error(err))
+	}
+	return delivery
+}
+
+func (d *Domain) send(ctx context.Context, endpoint repositiory.WebhookEndpoint, eventID uuid.UUID, body []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(d.cfg.Timeout)*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventIDHeader, eventID.String())
+	req.Header.Set(SignatureHeader, Sign(endpoint.Secret, time.Now(), body))
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = res.Body.Close() }()
+	// The response is not used, but reading it allows the connection to be reused
+	_, _ = io.Copy(io.Discard, res.Body)
+	return res.StatusCode, nil
+}
+
+// Sign returns the signature header of the body. The signature is an HMAC-SHA256 of the unix time,
+// a dot and the body, so merchants can reject old events that are replayed.
+func Sign(secret string, t time.Time, body []byte) string {
+	return auth.SignPayload(secret, t, body)
+}
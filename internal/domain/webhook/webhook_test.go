@@ -0,0 +1,458 @@
+package webhook_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/dependencies"
+	"github.com/marioarizaj/payment-gateway/internal/domain/webhook"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/kit/responses"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+var testMerchantID = uuid.Must(uuid.Parse("6c5a19d0-f132-4a55-93d3-2c00db06d41b"))
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	signedAt := time.Unix(1659945600, 0)
+	h := hmac.New(sha256.New, []byte("whsec_test"))
+	h.Write([]byte("1659945600." + string(body)))
+	expected := "t=1659945600,v1=" + hex.EncodeToString(h.Sum(nil))
+	assert.Equal(t, expected, webhook.Sign("whsec_test", signedAt, body))
+	assert.NotEqual(t, expected, webhook.Sign("whsec_other", signedAt, body))
+}
+
+func TestDomain_CreateEndpoint(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	cases := []struct {
+		name          string
+		endpoint      payment_gateway.WebhookEndpoint
+		expectedError error
+	}{
+		{
+			name: "create_endpoint_success",
+			endpoint: payment_gateway.WebhookEndpoint{
+				URL:        "https://merchant.example.com/webhooks",
+				EventTypes: []string{payment_gateway.EventPaymentSucceeded},
+			},
+		},
+		{
+			name: "create_endpoint_relative_url",
+			endpoint: payment_gateway.WebhookEndpoint{
+				URL:        "/webhooks",
+				EventTypes: []string{payment_gateway.EventPaymentSucceeded},
+			},
+			expectedError: responses.BadRequestError{Err: errors.New("url must be an absolute https url")},
+		},
+		{
+			name: "create_endpoint_http_url",
+			endpoint: payment_gateway.WebhookEndpoint{
+				URL:        "http://merchant.example.com/webhooks",
+				EventTypes: []string{payment_gateway.EventPaymentSucceeded},
+			},
+			expectedError: responses.BadRequestError{Err: errors.New("url must be an absolute https url")},
+		},
+		{
+			name: "create_endpoint_no_event_types",
+			endpoint: payment_gateway.WebhookEndpoint{
+				URL: "https://merchant.example.com/webhooks",
+			},
+			expectedError: responses.BadRequestError{Err: errors.New("at least one event type is required")},
+		},
+		{
+			name: "create_endpoint_unknown_event_type",
+			endpoint: payment_gateway.WebhookEndpoint{
+				URL:        "https://merchant.example.com/webhooks",
+				EventTypes: []string{payment_gateway.EventWebhookTest},
+			},
+			expectedError: responses.BadRequestError{Err: errors.New("event type webhook.test is not valid")},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d, _, cleanFn, err := getDomain(deps, http.DefaultClient)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer cleanFn()
+			endpoint := c.endpoint
+			endpoint.MerchantID = testMerchantID
+			created, err := d.CreateEndpoint(context.Background(), endpoint)
+			if c.expectedError != nil {
+				assert.EqualError(t, err, c.expectedError.Error())
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.True(t, created.Enabled)
+			assert.True(t, strings.HasPrefix(created.Secret, "whsec_"))
+			stored, err := d.GetEndpoint(context.Background(), testMerchantID, created.ID)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, c.endpoint.URL, stored.URL)
+			assert.Equal(t, c.endpoint.EventTypes, stored.EventTypes)
+			assert.Empty(t, stored.Secret)
+		})
+	}
+}
+
+func TestDomain_SendTestEvent(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	cases := []struct {
+		name               string
+		responseStatusCode int
+		// guardedClient sends the event with the client used by the gateway, which does not connect to the test server
+		guardedClient      bool
+		expectedSucceeded  bool
+		expectedStatusCode int
+		expectedError      string
+	}{
+		{
+			name:               "test_event_delivered",
+			responseStatusCode: http.StatusOK,
+			expectedSucceeded:  true,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "test_event_rejected",
+			responseStatusCode: http.StatusInternalServerError,
+			expectedStatusCode: http.StatusInternalServerError,
+			expectedError:      "endpoint responded with status 500",
+		},
+		{
+			name:               "test_event_to_loopback_address",
+			responseStatusCode: http.StatusOK,
+			guardedClient:      true,
+			expectedError:      "endpoint address is not allowed",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var secret string
+			var signatureErr error
+			var received payment_gateway.WebhookEvent
+			var requests int
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				body, _ := io.ReadAll(r.Body)
+				signatureErr = verifySignature(secret, r.Header.Get(webhook.SignatureHeader), body)
+				_ = json.Unmarshal(body, &received)
+				w.WriteHeader(c.responseStatusCode)
+			}))
+			defer server.Close()
+
+			var client webhook.HTTPClient = server.Client()
+			if c.guardedClient {
+				client = webhook.NewHTTPClient()
+			}
+			d, repo, cleanFn, err := getDomain(deps, client)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer cleanFn()
+			created, err := d.CreateEndpoint(context.Background(), payment_gateway.WebhookEndpoint{
+				MerchantID: testMerchantID,
+				URL:        server.URL,
+				EventTypes: []string{payment_gateway.EventPaymentSucceeded},
+			})
+			if !assert.NoError(t, err) {
+				return
+			}
+			secret = created.Secret
+
+			delivery, err := d.SendTestEvent(context.Background(), testMerchantID, created.ID)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, c.expectedSucceeded, delivery.Succeeded)
+			assert.Equal(t, c.expectedStatusCode, delivery.ResponseStatusCode)
+			assert.Equal(t, c.expectedError, delivery.Error)
+			if c.guardedClient {
+				assert.Zero(t, requests)
+			} else {
+				assert.NoError(t, signatureErr)
+				assert.Equal(t, payment_gateway.EventWebhookTest, received.Type)
+				assert.Equal(t, received.ID, delivery.EventID)
+			}
+
+			deliveries, err := repo.GetWebhookDeliveries(context.Background(), created.ID, 10)
+			if !assert.NoError(t, err) {
+				return
+			}
+			if assert.Len(t, deliveries, 1) {
+				assert.Equal(t, delivery.ID, deliveries[0].ID)
+			}
+		})
+	}
+}
+
+func TestDomain_SendTestEvent_OtherMerchant(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	d, _, cleanFn, err := getDomain(deps, http.DefaultClient)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cleanFn()
+	created, err := d.CreateEndpoint(context.Background(), payment_gateway.WebhookEndpoint{
+		MerchantID: testMerchantID,
+		URL:        "https://merchant.example.com/webhooks",
+		EventTypes: []string{payment_gateway.EventPaymentSucceeded},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	otherMerchantID := uuid.Must(uuid.Parse("a1e3f405-44f0-44b4-a584-b0b3c80bc8ac"))
+	_, err = d.SendTestEvent(context.Background(), otherMerchantID, created.ID)
+	assert.Equal(t, responses.NotFoundError{}, err)
+}
+
+func TestDispatcher_ProcessDue(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	cases := []struct {
+		name               string
+		responseStatusCode int
+		maxAttempts        int
+		disabled           bool
+		expectedRequests   int
+		expectedStatus     string
+		expectedError      string
+	}{
+		{
+			name:               "event_delivered",
+			responseStatusCode: http.StatusOK,
+			maxAttempts:        2,
+			expectedRequests:   1,
+			expectedStatus:     repositiory.WebhookEventDelivered,
+		},
+		{
+			name:               "rejected_event_is_retried",
+			responseStatusCode: http.StatusInternalServerError,
+			maxAttempts:        2,
+			expectedRequests:   1,
+			expectedStatus:     repositiory.WebhookEventPending,
+			expectedError:      "endpoint responded with status 500",
+		},
+		{
+			name:               "dead_lettered",
+			responseStatusCode: http.StatusInternalServerError,
+			maxAttempts:        1,
+			expectedRequests:   1,
+			expectedStatus:     repositiory.WebhookEventDead,
+			expectedError:      "endpoint responded with status 500",
+		},
+		{
+			name:               "endpoint_disabled",
+			responseStatusCode: http.StatusOK,
+			maxAttempts:        2,
+			disabled:           true,
+			expectedStatus:     repositiory.WebhookEventDead,
+			expectedError:      "endpoint is disabled",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var requests int
+			var received payment_gateway.WebhookEvent
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				body, _ := io.ReadAll(r.Body)
+				_ = json.Unmarshal(body, &received)
+				w.WriteHeader(c.responseStatusCode)
+			}))
+			defer server.Close()
+
+			d, repo, cleanFn, err := getDomain(deps, server.Client())
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer cleanFn()
+			ctx := context.Background()
+			created, err := d.CreateEndpoint(ctx, payment_gateway.WebhookEndpoint{
+				MerchantID: testMerchantID,
+				URL:        server.URL,
+				EventTypes: []string{payment_gateway.EventPaymentSucceeded},
+			})
+			if !assert.NoError(t, err) {
+				return
+			}
+			payment := payment_gateway.Payment{ID: uuid.New(), MerchantID: testMerchantID}
+			if !assert.NoError(t, d.PublishPaymentEvent(ctx, repo, payment_gateway.EventPaymentSucceeded, payment)) {
+				return
+			}
+			// Events are stored, and nothing is sent until the dispatcher runs
+			assert.Zero(t, requests)
+			if c.disabled {
+				disabled := false
+				_, err = d.UpdateEndpoint(ctx, testMerchantID, created.ID, webhook.EndpointUpdate{Enabled: &disabled})
+				if !assert.NoError(t, err) {
+					return
+				}
+			}
+
+			dispatcher := webhook.NewDispatcher(d, config.WebhookConfig{
+				MaxAttempts:       c.maxAttempts,
+				InitialBackoff:    int(time.Minute / time.Millisecond),
+				MaxBackoff:        int(time.Minute / time.Millisecond),
+				VisibilityTimeout: 30000,
+				Timeout:           1000,
+			})
+			assert.Equal(t, 1, dispatcher.ProcessDue(ctx))
+			// Events that are retried are only due again after the backoff
+			assert.Equal(t, 0, dispatcher.ProcessDue(ctx))
+			assert.Equal(t, c.expectedRequests, requests)
+
+			events, err := repo.GetWebhookEvents(ctx, created.ID)
+			if !assert.NoError(t, err) || !assert.Len(t, events, 1) {
+				return
+			}
+			assert.Equal(t, c.expectedStatus, events[0].Status)
+			assert.Equal(t, 1, events[0].Attempts)
+			assert.Equal(t, c.expectedError, events[0].LastError)
+
+			deliveries, err := repo.GetWebhookDeliveries(ctx, created.ID, 10)
+			if !assert.NoError(t, err) || !assert.Len(t, deliveries, c.expectedRequests) {
+				return
+			}
+			if c.expectedRequests > 0 {
+				assert.Equal(t, events[0].EventID, received.ID)
+				assert.Equal(t, events[0].EventID, deliveries[0].EventID)
+				assert.Equal(t, 1, deliveries[0].Attempt)
+			}
+		})
+	}
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	port := server.URL[strings.LastIndex(server.URL, ":"):]
+	cases := []struct {
+		name string
+		url  string
+	}{
+		{
+			name: "loopback_address",
+			url:  server.URL,
+		},
+		{
+			name: "localhost",
+			url:  "http://localhost" + port,
+		},
+		{
+			name: "private_address",
+			url:  "http://10.0.0.1" + port,
+		},
+		{
+			name: "link_local_address",
+			url:  "http://169.254.169.254/latest/meta-data",
+		},
+		{
+			name: "unspecified_address",
+			url:  "http://0.0.0.0" + port,
+		},
+		{
+			name: "ipv6_loopback_address",
+			url:  "http://[::1]" + port,
+		},
+	}
+	client := webhook.NewHTTPClient()
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, nil)
+			if !assert.NoError(t, err) {
+				return
+			}
+			res, err := client.Do(req)
+			if err == nil {
+				_ = res.Body.Close()
+			}
+			assert.ErrorIs(t, err, webhook.ErrAddressNotAllowed)
+		})
+	}
+}
+
+// verifySignature checks the signature header the same way merchants are expected to.
+func verifySignature(secret, header string, body []byte) error {
+	var timestamp, signature string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			timestamp = kv[1]
+		case "v1":
+			signature = kv[1]
+		}
+	}
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp + "."))
+	h.Write(body)
+	if !hmac.Equal([]byte(signature), []byte(hex.EncodeToString(h.Sum(nil)))) {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
+
+func getDomain(deps dependencies.Dependencies, client webhook.HTTPClient) (*webhook.Domain, repositiory.Repository, func(), error) {
+	tx, err := deps.DB.BeginTx(context.Background(), &sql.TxOptions{})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	repo := repositiory.NewRepository(tx)
+	d := webhook.NewDomain(repo, client, zap.NewNop(), config.WebhookConfig{MaxAttempts: 1, Timeout: 1000})
+	return d, repo, func() {
+		_ = tx.Rollback()
+	}, nil
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/marioarizaj/payment-gateway/internal/acquiringbank"
+	"github.com/marioarizaj/payment-gateway/internal/domain/payment"
+	"github.com/marioarizaj/payment-gateway/kit/auth"
+	"github.com/marioarizaj/payment-gateway/kit/responses"
+)
+
+// AcquirerCallbackResponse tells the acquiring bank whether the result was applied, it was already received before, or
+// it is stored and will be applied later.
+type AcquirerCallbackResponse struct {
+	EventID   string `json:"event_id"`
+	Duplicate bool   `json:"duplicate"`
+	Pending   bool   `json:"pending"`
+}
+
+// AcquirerCallback receives the results posted by the acquiring bank. It is not authenticated with the merchant
+// credentials, but with the signature of the body, made with the secret shared with the acquirer.
+// Every callback is rejected while the secret is not set.
+func (h *Handler) AcquirerCallback(w http.ResponseWriter, r *http.Request) {
+	if h.acquirerCallback.Secret == "" {
+		log.Printf("rejected acquirer callback: ACQUIRER_CALLBACK_SECRET is not set")
+		responses.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		responses.RespondWithError(w, http.StatusBadRequest, "could not read request body")
+		return
+	}
+	tolerance := time.Duration(h.acquirerCallback.Tolerance) * time.Second
+	err = auth.VerifyPayload(h.acquirerCallback.Secret, r.Header.Get(acquiringbank.CallbackSignatureHeader), body, time.Now(), tolerance)
+	if err != nil {
+		log.Printf("rejected acquirer callback: %v", err)
+		responses.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	var callback acquiringbank.Callback
+	err = json.Unmarshal(body, &callback)
+	if err != nil {
+		log.Printf("could not decode request body: %v", err)
+		responses.RespondWithError(w, http.StatusBadRequest, "could not decode request body")
+		return
+	}
+	result := payment.AcquirerResult{
+		EventID:   callback.EventID,
+		Acquirer:  callback.Acquirer,
+		Operation: callback.Operation,
+	}
+	switch {
+	case callback.Operation == acquiringbank.OperationRefund && callback.Refund != nil:
+		result.Operation = payment.AcquirerOperationRefund
+		result.ID = callback.Refund.ID
+		result.Status = string(callback.Refund.RefundStatus)
+		result.Decline = callback.Refund.Decline
+	case callback.Operation == acquiringbank.OperationPayment && callback.Payment != nil,
+		callback.Operation == acquiringbank.OperationCapture && callback.Payment != nil:
+		result.Operation = payment.AcquirerOperationPayment
+		if callback.Operation == acquiringbank.OperationCapture {
+			result.Operation = payment.AcquirerOperationCapture
+		}
+		result.ID = callback.Payment.ID
+		result.Status = string(callback.Payment.PaymentStatus)
+		result.Decline = callback.Payment.Decline
+	default:
+		responses.RespondWithError(w, http.StatusBadRequest, "callback does not contain the result of a known operation")
+		return
+	}
+	receipt, err := h.domain.ReceiveAcquirerResult(r.Context(), result)
+	if err != nil {
+		respondWithDomainError(w, err)
+		return
+	}
+	// A result that is stored is not lost, so the acquirer does not need to send it again while it is pending
+	statusCode := http.StatusOK
+	if receipt.Pending {
+		statusCode = http.StatusAccepted
+	}
+	responses.RespondWithJSON(w, statusCode, AcquirerCallbackResponse{EventID: result.EventID, Duplicate: receipt.Duplicate, Pending: receipt.Pending})
+}
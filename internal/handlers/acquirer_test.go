@@ -0,0 +1,243 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
+	"github.com/marioarizaj/payment-gateway/internal/dependencies"
+	"github.com/marioarizaj/payment-gateway/internal/handlers"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"github.com/marioarizaj/payment-gateway/kit/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun"
+	"go.uber.org/zap"
+)
+
+func acquirerCallbackRequest(t *testing.T, secret string, signedAt time.Time, body string) *http.Request {
+	req, err := http.NewRequest(http.MethodPost, "/internal/acquirer/callbacks", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Acquirer-Signature", auth.SignPayload(secret, signedAt, []byte(body)))
+	return req
+}
+
+func TestHandler_AcquirerCallback(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	succeeded := fmt.Sprintf(`{"event_id":"evt_1","acquirer":"primary","operation":"payment","payment":{"id":"%s","payment_status":"succeeded"}}`, baseTestPayment.ID)
+
+	cases := []struct {
+		name                 string
+		secret               string
+		signedAt             time.Time
+		body                 string
+		expectedCode         int
+		expectedErrorMessage string
+		expectedStatus       status.PaymentStatus
+	}{
+		{
+			name:           "applies_payment_result",
+			secret:         cfg.AcquirerCallback.Secret,
+			signedAt:       time.Now(),
+			body:           succeeded,
+			expectedCode:   http.StatusOK,
+			expectedStatus: status.PaymentSucceeded,
+		},
+		{
+			name:                 "wrong_secret",
+			secret:               "not-the-acquirer",
+			signedAt:             time.Now(),
+			body:                 succeeded,
+			expectedCode:         http.StatusUnauthorized,
+			expectedErrorMessage: "signature is not valid",
+			expectedStatus:       status.PaymentProcessing,
+		},
+		{
+			name:                 "stale_signature",
+			secret:               cfg.AcquirerCallback.Secret,
+			signedAt:             time.Now().Add(-time.Hour),
+			body:                 succeeded,
+			expectedCode:         http.StatusUnauthorized,
+			expectedErrorMessage: "signature is too old or too far in the future",
+			expectedStatus:       status.PaymentProcessing,
+		},
+		{
+			name:                 "missing_event_id",
+			secret:               cfg.AcquirerCallback.Secret,
+			signedAt:             time.Now(),
+			body:                 fmt.Sprintf(`{"acquirer":"primary","operation":"payment","payment":{"id":"%s","payment_status":"succeeded"}}`, baseTestPayment.ID),
+			expectedCode:         http.StatusBadRequest,
+			expectedErrorMessage: "event id is required",
+			expectedStatus:       status.PaymentProcessing,
+		},
+		{
+			name:                 "missing_acquirer",
+			secret:               cfg.AcquirerCallback.Secret,
+			signedAt:             time.Now(),
+			body:                 fmt.Sprintf(`{"event_id":"evt_1","operation":"payment","payment":{"id":"%s","payment_status":"succeeded"}}`, baseTestPayment.ID),
+			expectedCode:         http.StatusBadRequest,
+			expectedErrorMessage: "acquirer is required",
+			expectedStatus:       status.PaymentProcessing,
+		},
+		{
+			name:                 "unknown_acquirer",
+			secret:               cfg.AcquirerCallback.Secret,
+			signedAt:             time.Now(),
+			body:                 fmt.Sprintf(`{"event_id":"evt_1","acquirer":"unknown","operation":"payment","payment":{"id":"%s","payment_status":"succeeded"}}`, baseTestPayment.ID),
+			expectedCode:         http.StatusBadRequest,
+			expectedErrorMessage: "acquirer unknown is not registered",
+			expectedStatus:       status.PaymentProcessing,
+		},
+		{
+			name:                 "unknown_operation",
+			secret:               cfg.AcquirerCallback.Secret,
+			signedAt:             time.Now(),
+			body:                 fmt.Sprintf(`{"event_id":"evt_1","acquirer":"primary","operation":"payout","payment":{"id":"%s","payment_status":"succeeded"}}`, baseTestPayment.ID),
+			expectedCode:         http.StatusBadRequest,
+			expectedErrorMessage: "callback does not contain the result of a known operation",
+			expectedStatus:       status.PaymentProcessing,
+		},
+		{
+			// The result is kept on the inbox, since the payment may not be committed yet
+			name:           "unknown_payment",
+			secret:         cfg.AcquirerCallback.Secret,
+			signedAt:       time.Now(),
+			body:           fmt.Sprintf(`{"event_id":"evt_1","acquirer":"primary","operation":"payment","payment":{"id":"%s","payment_status":"succeeded"}}`, uuid.New()),
+			expectedCode:   http.StatusAccepted,
+			expectedStatus: status.PaymentProcessing,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			deps, err := dependencies.InitDependencies(cfg)
+			if !assert.NoError(t, err) {
+				return
+			}
+			deps.DB, err = deps.DB.BeginTx(context.Background(), &sql.TxOptions{})
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer func() { _ = cleanupFunc(deps.DB.(bun.Tx), deps.Redis) }()
+			err = InsertTestPayment(deps.DB, baseTestPayment)
+			if !assert.NoError(t, err) {
+				return
+			}
+			r := handlers.NewRouter(cfg, deps, zap.NewNop())
+			res := executeRequest(r, acquirerCallbackRequest(t, c.secret, c.signedAt, c.body))
+			assert.Equal(t, c.expectedCode, res.Code)
+			var resBody map[string]interface{}
+			err = json.NewDecoder(res.Body).Decode(&resBody)
+			if !assert.NoError(t, err) {
+				return
+			}
+			if c.expectedErrorMessage != "" {
+				assert.Equal(t, c.expectedErrorMessage, resBody["error"])
+			} else {
+				assert.Equal(t, false, resBody["duplicate"])
+			}
+			storedPayment, err := repositiory.NewRepository(deps.DB).GetPaymentByID(context.Background(), baseTestPayment.ID)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, c.expectedStatus, storedPayment.PaymentStatus)
+		})
+	}
+}
+
+func TestHandler_AcquirerCallback_NoSecret(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps.DB, err = deps.DB.BeginTx(context.Background(), &sql.TxOptions{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = cleanupFunc(deps.DB.(bun.Tx), deps.Redis) }()
+	err = InsertTestPayment(deps.DB, baseTestPayment)
+	if !assert.NoError(t, err) {
+		return
+	}
+	// A callback signed with an empty secret is rejected when the gateway has no secret either
+	cfg.AcquirerCallback.Secret = ""
+	r := handlers.NewRouter(cfg, deps, zap.NewNop())
+	body := fmt.Sprintf(`{"event_id":"evt_1","acquirer":"primary","operation":"payment","payment":{"id":"%s","payment_status":"succeeded"}}`, baseTestPayment.ID)
+	res := executeRequest(r, acquirerCallbackRequest(t, "", time.Now(), body))
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	storedPayment, err := repositiory.NewRepository(deps.DB).GetPaymentByID(context.Background(), baseTestPayment.ID)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, status.PaymentProcessing, storedPayment.PaymentStatus)
+}
+
+func TestHandler_AcquirerCallback_Duplicate(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps.DB, err = deps.DB.BeginTx(context.Background(), &sql.TxOptions{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = cleanupFunc(deps.DB.(bun.Tx), deps.Redis) }()
+	err = InsertTestPayment(deps.DB, baseTestPayment)
+	if !assert.NoError(t, err) {
+		return
+	}
+	r := handlers.NewRouter(cfg, deps, zap.NewNop())
+
+	deliveries := []struct {
+		body              string
+		expectedDuplicate bool
+	}{
+		{
+			body:              fmt.Sprintf(`{"event_id":"evt_1","acquirer":"primary","operation":"payment","payment":{"id":"%s","payment_status":"failed","decline":{"response_code":"51"}}}`, baseTestPayment.ID),
+			expectedDuplicate: false,
+		},
+		{
+			// The same event is delivered again, signed at a different time
+			body:              fmt.Sprintf(`{"event_id":"evt_1","acquirer":"primary","operation":"payment","payment":{"id":"%s","payment_status":"failed","decline":{"response_code":"51"}}}`, baseTestPayment.ID),
+			expectedDuplicate: true,
+		},
+	}
+	for i, delivery := range deliveries {
+		signedAt := time.Now().Add(time.Duration(i) * time.Second)
+		res := executeRequest(r, acquirerCallbackRequest(t, cfg.AcquirerCallback.Secret, signedAt, delivery.body))
+		assert.Equal(t, http.StatusOK, res.Code)
+		var resBody handlers.AcquirerCallbackResponse
+		err = json.NewDecoder(res.Body).Decode(&resBody)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "evt_1", resBody.EventID)
+		assert.Equal(t, delivery.expectedDuplicate, resBody.Duplicate)
+	}
+	storedPayment, err := repositiory.NewRepository(deps.DB).GetPaymentByID(context.Background(), baseTestPayment.ID)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, status.PaymentFailed, storedPayment.PaymentStatus)
+	assert.Equal(t, decline.FromResponseCode("51"), storedPayment.Decline)
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"net/http"
+
+	ctx2 "github.com/marioarizaj/payment-gateway/kit/ctx"
+	"github.com/marioarizaj/payment-gateway/kit/responses"
+)
+
+func (h *Handler) CancelPayment(w http.ResponseWriter, r *http.Request) {
+	paymentID, ok := getIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	merchantID, err := ctx2.GetMerchantID(ctx)
+	if err != nil {
+		responses.AuthenticationError(w)
+		return
+	}
+	payment, err := h.domain.CancelPayment(ctx, merchantID, paymentID)
+	if err != nil {
+		respondWithDomainError(w, err)
+		return
+	}
+	responses.RespondWithJSON(w, http.StatusOK, payment)
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+
+	ctx2 "github.com/marioarizaj/payment-gateway/kit/ctx"
+	"github.com/marioarizaj/payment-gateway/kit/responses"
+)
+
+// captureRequest is the optional body of a capture. When it is not sent, the full authorized amount is captured.
+type captureRequest struct {
+	AmountFractional int64 `json:"amount_fractional"`
+}
+
+func (h *Handler) CapturePayment(w http.ResponseWriter, r *http.Request) {
+	paymentID, ok := getIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	var req captureRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && err != io.EOF {
+		log.Printf("could not decode request body: %v", err)
+		responses.RespondWithError(w, http.StatusBadRequest, "could not decode request body")
+		return
+	}
+	ctx := r.Context()
+	merchantID, err := ctx2.GetMerchantID(ctx)
+	if err != nil {
+		responses.AuthenticationError(w)
+		return
+	}
+	payment, err := h.domain.CapturePayment(ctx, merchantID, paymentID, req.AmountFractional)
+	if err != nil {
+		respondWithDomainError(w, err)
+		return
+	}
+	responses.RespondWithJSON(w, http.StatusOK, payment)
+}
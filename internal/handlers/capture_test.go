@@ -0,0 +1,106 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/dependencies"
+	"github.com/marioarizaj/payment-gateway/internal/handlers"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun"
+	"go.uber.org/zap"
+)
+
+func TestHandler_CapturePayment(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	cases := []struct {
+		name                   string
+		paymentStatus          status.PaymentStatus
+		payload                []byte
+		expectedCode           int
+		expectedAmountCaptured int64
+		expectedErrorMessage   string
+	}{
+		{
+			name:                   "capture_full_amount_without_body",
+			paymentStatus:          "authorized",
+			expectedCode:           http.StatusOK,
+			expectedAmountCaptured: 2010,
+		},
+		{
+			name:                   "capture_partial_amount",
+			paymentStatus:          "authorized",
+			payload:                []byte(`{"amount_fractional": 1000}`),
+			expectedCode:           http.StatusOK,
+			expectedAmountCaptured: 1000,
+		},
+		{
+			name:                 "capture_not_authorized_payment",
+			paymentStatus:        "processing",
+			expectedCode:         http.StatusBadRequest,
+			expectedErrorMessage: "payment with status processing can not be captured",
+		},
+		{
+			name:                 "capture_invalid_body",
+			paymentStatus:        "authorized",
+			payload:              []byte(`{"amount_fractional": "all"}`),
+			expectedCode:         http.StatusBadRequest,
+			expectedErrorMessage: "could not decode request body",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			deps, err := dependencies.InitDependencies(cfg)
+			if !assert.NoError(t, err) {
+				return
+			}
+			deps.DB, err = deps.DB.BeginTx(context.Background(), &sql.TxOptions{})
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer func() { _ = cleanupFunc(deps.DB.(bun.Tx), deps.Redis) }()
+			p := baseTestPayment
+			p.CaptureMethod = payment_gateway.CaptureMethodManual
+			p.PaymentStatus = c.paymentStatus
+			err = InsertTestPayment(deps.DB, p)
+			if !assert.NoError(t, err) {
+				return
+			}
+			r := handlers.NewRouter(cfg, deps, zap.NewNop())
+			req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("/v1/payments/%s/capture", p.ID), bytes.NewBuffer(c.payload))
+			if !assert.NoError(t, err) {
+				return
+			}
+			req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuth("6c5a19d0-f132-4a55-93d3-2c00db06d41b", "a7898e515691064b49a15a01e69503f83cd918594e643cc3e949adef273b309f")))
+			res := executeRequest(r, req)
+			assert.Equal(t, c.expectedCode, res.Code)
+			if res.Code > 300 {
+				var resBody map[string]interface{}
+				err = json.NewDecoder(res.Body).Decode(&resBody)
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Equal(t, c.expectedErrorMessage, resBody["error"].(string))
+				return
+			}
+			var actual payment_gateway.Payment
+			err = json.NewDecoder(res.Body).Decode(&actual)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, c.expectedAmountCaptured, actual.AmountCaptured)
+		})
+	}
+}
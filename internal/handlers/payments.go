@@ -3,12 +3,19 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/domain/payment"
+	"github.com/marioarizaj/payment-gateway/internal/status"
 	ctx2 "github.com/marioarizaj/payment-gateway/kit/ctx"
 	"github.com/marioarizaj/payment-gateway/kit/responses"
 )
@@ -30,28 +37,11 @@ func (h *Handler) CreatePayment(w http.ResponseWriter, r *http.Request) {
 	}
 	payment.MerchantID = merchantID
 
-	p, err := h.domain.GetPayment(ctx, payment.ID)
-	if err == nil {
-		// This means that this payment was created some time in the past
-		responses.RespondWithJSON(w, http.StatusOK, p)
-		return
-	}
-	var notFoundErr responses.NotFoundError
-	if !errors.As(err, &notFoundErr) {
-		var resErr responses.ResponseError
-		if errors.As(err, &resErr) {
-			resErr.Response(w)
-			return
-		}
-	}
-
+	// Retries are handled by the idempotency middleware, using the Idempotency-Key header
 	payment, err = h.domain.CreatePayment(ctx, payment)
 	if err != nil {
-		var resErr responses.ResponseError
-		if errors.As(err, &resErr) {
-			resErr.Response(w)
-			return
-		}
+		respondWithDomainError(w, err)
+		return
 	}
 	responses.RespondWithJSON(w, http.StatusCreated, payment)
 }
@@ -68,7 +58,12 @@ func (h *Handler) GetPayment(w http.ResponseWriter, r *http.Request) {
 		responses.RespondWithError(w, http.StatusBadRequest, "id format not accurate")
 		return
 	}
-	payment, err := h.domain.GetPayment(ctx, uid)
+	merchantID, err := ctx2.GetMerchantID(ctx)
+	if err != nil {
+		responses.AuthenticationError(w)
+		return
+	}
+	payment, err := h.domain.GetPayment(ctx, merchantID, uid)
 	if err != nil {
 		var resErr responses.ResponseError
 		if errors.As(err, &resErr) {
@@ -78,3 +73,135 @@ func (h *Handler) GetPayment(w http.ResponseWriter, r *http.Request) {
 	}
 	responses.RespondWithJSON(w, http.StatusOK, payment)
 }
+
+func (h *Handler) GetPaymentByReference(w http.ResponseWriter, r *http.Request) {
+	reference, exists := mux.Vars(r)["reference"]
+	if !exists || reference == "" {
+		responses.RespondWithError(w, http.StatusBadRequest, "reference not found in request")
+		return
+	}
+	ctx := r.Context()
+	merchantID, err := ctx2.GetMerchantID(ctx)
+	if err != nil {
+		responses.AuthenticationError(w)
+		return
+	}
+	payment, err := h.domain.GetPaymentByReference(ctx, merchantID, reference)
+	if err != nil {
+		respondWithDomainError(w, err)
+		return
+	}
+	responses.RespondWithJSON(w, http.StatusOK, payment)
+}
+
+// GetPaymentAttempts returns the acquirers a payment was sent to, with the result of each one.
+func (h *Handler) GetPaymentAttempts(w http.ResponseWriter, r *http.Request) {
+	paymentID, ok := getIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	merchantID, err := ctx2.GetMerchantID(ctx)
+	if err != nil {
+		responses.AuthenticationError(w)
+		return
+	}
+	attempts, err := h.domain.GetPaymentAttempts(ctx, merchantID, paymentID)
+	if err != nil {
+		respondWithDomainError(w, err)
+		return
+	}
+	responses.RespondWithJSON(w, http.StatusOK, attempts)
+}
+
+func (h *Handler) ListPayments(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	merchantID, err := ctx2.GetMerchantID(ctx)
+	if err != nil {
+		responses.AuthenticationError(w)
+		return
+	}
+	filter, err := getListPaymentsFilter(r.URL.Query())
+	if err != nil {
+		responses.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	payments, nextCursor, err := h.domain.ListPayments(ctx, merchantID, filter)
+	if err != nil {
+		respondWithDomainError(w, err)
+		return
+	}
+	responses.RespondWithPage(w, payments, nextCursor)
+}
+
+// getListPaymentsFilter parses the filters of the payments list from the query parameters.
+// Times are expected in RFC 3339 format.
+func getListPaymentsFilter(query url.Values) (payment.ListPaymentsFilter, error) {
+	filter := payment.ListPaymentsFilter{
+		PaymentStatus: status.PaymentStatus(query.Get("status")),
+		CurrencyCode:  query.Get("currency_code"),
+		CardLast4:     query.Get("card_last4"),
+		Description:   query.Get("description"),
+		Cursor:        query.Get("cursor"),
+		Metadata:      getMetadataParams(query),
+	}
+	var err error
+	if filter.AmountFrom, err = getInt64Param(query, "amount_from"); err != nil {
+		return payment.ListPaymentsFilter{}, err
+	}
+	if filter.AmountTo, err = getInt64Param(query, "amount_to"); err != nil {
+		return payment.ListPaymentsFilter{}, err
+	}
+	if filter.CreatedFrom, err = getTimeParam(query, "created_from"); err != nil {
+		return payment.ListPaymentsFilter{}, err
+	}
+	if filter.CreatedTo, err = getTimeParam(query, "created_to"); err != nil {
+		return payment.ListPaymentsFilter{}, err
+	}
+	if limit := query.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			return payment.ListPaymentsFilter{}, errors.New("limit must be an integer")
+		}
+	}
+	return filter, nil
+}
+
+// getMetadataParams returns the metadata filters, sent as metadata[key]=value query parameters.
+func getMetadataParams(query url.Values) map[string]string {
+	var metadata map[string]string
+	for name := range query {
+		if !strings.HasPrefix(name, "metadata[") || !strings.HasSuffix(name, "]") {
+			continue
+		}
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		metadata[strings.TrimSuffix(strings.TrimPrefix(name, "metadata["), "]")] = query.Get(name)
+	}
+	return metadata
+}
+
+func getInt64Param(query url.Values, name string) (*int64, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an integer", name)
+	}
+	return &i, nil
+}
+
+func getTimeParam(query url.Values, name string) (*time.Time, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a RFC 3339 timestamp", name)
+	}
+	return &t, nil
+}
@@ -28,6 +28,7 @@ var baseTestPayment = payment_gateway.Payment{
 	ID:            uuid.Must(uuid.Parse("b5f9c307-5202-4c52-aba9-752167eef9bf")),
 	MerchantID:    uuid.Must(uuid.Parse("6c5a19d0-f132-4a55-93d3-2c00db06d41b")),
 	PaymentStatus: "processing",
+	CaptureMethod: payment_gateway.CaptureMethodAutomatic,
 	Amount: payment_gateway.Amount{
 		AmountFractional: 2010,
 		CurrencyCode:     "USD",
@@ -135,6 +136,78 @@ func TestHandler_CreatePayment(t *testing.T) {
 	}
 }
 
+func TestHandler_CreatePayment_IdempotencyKey(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	cases := []struct {
+		name                 string
+		secondPayload        func() payment_gateway.Payment
+		expectedCode         int
+		expectedReplay       bool
+		expectedErrorMessage string
+	}{
+		{
+			name: "replay_same_request",
+			secondPayload: func() payment_gateway.Payment {
+				return baseTestPayment
+			},
+			expectedCode:   http.StatusCreated,
+			expectedReplay: true,
+		},
+		{
+			name: "same_key_different_request",
+			secondPayload: func() payment_gateway.Payment {
+				p := baseTestPayment
+				p.Amount.AmountFractional = 3000
+				return p
+			},
+			expectedCode:         http.StatusUnprocessableEntity,
+			expectedErrorMessage: "idempotency key was already used with a different request",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			deps, err := dependencies.InitDependencies(cfg)
+			if !assert.NoError(t, err) {
+				return
+			}
+			deps.DB, err = deps.DB.BeginTx(context.Background(), &sql.TxOptions{})
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer func() { _ = cleanupFunc(deps.DB.(bun.Tx), deps.Redis) }()
+			r := handlers.NewRouter(cfg, deps, zap.NewNop())
+			send := func(p payment_gateway.Payment) *httptest.ResponseRecorder {
+				bts, _ := json.Marshal(p)
+				req, _ := http.NewRequest(http.MethodPost, "/v1/payments", bytes.NewBuffer(bts))
+				req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuth("6c5a19d0-f132-4a55-93d3-2c00db06d41b", "a7898e515691064b49a15a01e69503f83cd918594e643cc3e949adef273b309f")))
+				req.Header.Add("Idempotency-Key", "order-1001-attempt")
+				return executeRequest(r, req)
+			}
+			first := send(baseTestPayment)
+			if !assert.Equal(t, http.StatusCreated, first.Code) {
+				return
+			}
+			second := send(c.secondPayload())
+			assert.Equal(t, c.expectedCode, second.Code)
+			if c.expectedErrorMessage != "" {
+				var resBody map[string]interface{}
+				err = json.NewDecoder(second.Body).Decode(&resBody)
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Equal(t, c.expectedErrorMessage, resBody["error"].(string))
+				return
+			}
+			assert.Equal(t, "true", second.Header().Get("Idempotent-Replayed"))
+			assert.Equal(t, first.Body.String(), second.Body.String())
+		})
+	}
+}
+
 func TestHandler_GetPayment(t *testing.T) {
 	cfg, err := config.LoadConfig()
 	if !assert.NoError(t, err) {
@@ -176,6 +249,19 @@ func TestHandler_GetPayment(t *testing.T) {
 			username:             "test-username",
 			password:             "test-password",
 		},
+		{
+			name: "get_payment_of_other_merchant",
+			testPayment: func() payment_gateway.Payment {
+				p := baseTestPayment
+				p.MerchantID = uuid.Must(uuid.Parse("a1e3f405-44f0-44b4-a584-b0b3c80bc8ac"))
+				return p
+			}(),
+			idToSearch:           "b5f9c307-5202-4c52-aba9-752167eef9bf",
+			expectedCode:         http.StatusNotFound,
+			expectedErrorMessage: "not found",
+			username:             "6c5a19d0-f132-4a55-93d3-2c00db06d41b",
+			password:             "a7898e515691064b49a15a01e69503f83cd918594e643cc3e949adef273b309f",
+		},
 		{
 			name:                 "get_payment_not_found",
 			testPayment:          baseTestPayment,
@@ -255,6 +341,220 @@ func basicAuth(username, password string) string {
 	return base64.StdEncoding.EncodeToString([]byte(auth))
 }
 
+func TestHandler_GetPaymentByReference(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	cases := []struct {
+		name                 string
+		testPayment          payment_gateway.Payment
+		reference            string
+		expectedCode         int
+		expectedErrorMessage string
+	}{
+		{
+			name: "get_payment_by_reference_success",
+			testPayment: func() payment_gateway.Payment {
+				p := baseTestPayment
+				p.Reference = "order-1001"
+				return p
+			}(),
+			reference:    "order-1001",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name: "get_payment_by_reference_not_found",
+			testPayment: func() payment_gateway.Payment {
+				p := baseTestPayment
+				p.Reference = "order-1001"
+				return p
+			}(),
+			reference:            "order-1002",
+			expectedCode:         http.StatusNotFound,
+			expectedErrorMessage: "not found",
+		},
+		{
+			name: "get_payment_by_reference_of_other_merchant",
+			testPayment: func() payment_gateway.Payment {
+				p := baseTestPayment
+				p.Reference = "order-1001"
+				p.MerchantID = uuid.Must(uuid.Parse("a1e3f405-44f0-44b4-a584-b0b3c80bc8ac"))
+				return p
+			}(),
+			reference:            "order-1001",
+			expectedCode:         http.StatusNotFound,
+			expectedErrorMessage: "not found",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			deps, err := dependencies.InitDependencies(cfg)
+			if !assert.NoError(t, err) {
+				return
+			}
+			deps.DB, err = deps.DB.BeginTx(context.Background(), &sql.TxOptions{})
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer func() { _ = cleanupFunc(deps.DB.(bun.Tx), deps.Redis) }()
+			err = InsertTestPayment(deps.DB, c.testPayment)
+			if !assert.NoError(t, err) {
+				return
+			}
+			r := handlers.NewRouter(cfg, deps, zap.NewNop())
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("/v1/payments/reference/%s", c.reference), nil)
+			if !assert.NoError(t, err) {
+				return
+			}
+			req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuth("6c5a19d0-f132-4a55-93d3-2c00db06d41b", "a7898e515691064b49a15a01e69503f83cd918594e643cc3e949adef273b309f")))
+			res := executeRequest(r, req)
+			assert.Equal(t, c.expectedCode, res.Code)
+
+			if res.Code > 300 {
+				var resBody map[string]interface{}
+				err = json.NewDecoder(res.Body).Decode(&resBody)
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Equal(t, c.expectedErrorMessage, resBody["error"].(string))
+				return
+			}
+			var actual payment_gateway.Payment
+			err = json.NewDecoder(res.Body).Decode(&actual)
+			if !assert.NoError(t, err) {
+				return
+			}
+			compareResults(t, c.testPayment, actual)
+		})
+	}
+}
+
+func TestHandler_ListPayments(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	cases := []struct {
+		name                 string
+		query                string
+		expectedCode         int
+		expectedPayments     int
+		expectedErrorMessage string
+	}{
+		{
+			name:             "list_payments_success",
+			query:            "",
+			expectedCode:     http.StatusOK,
+			expectedPayments: 1,
+		},
+		{
+			name:             "list_payments_with_filters",
+			query:            "?status=processing&currency_code=USD&amount_from=1000&amount_to=3000&card_last4=0005&description=test",
+			expectedCode:     http.StatusOK,
+			expectedPayments: 1,
+		},
+		{
+			name:             "list_payments_with_metadata_filter",
+			query:            "?metadata[cart_id]=cart-1",
+			expectedCode:     http.StatusOK,
+			expectedPayments: 1,
+		},
+		{
+			name:             "list_payments_no_metadata_match",
+			query:            "?metadata[cart_id]=cart-2",
+			expectedCode:     http.StatusOK,
+			expectedPayments: 0,
+		},
+		{
+			name:             "list_payments_no_match",
+			query:            "?status=failed",
+			expectedCode:     http.StatusOK,
+			expectedPayments: 0,
+		},
+		{
+			name:                 "list_payments_wrong_amount_format",
+			query:                "?amount_from=ten",
+			expectedCode:         http.StatusBadRequest,
+			expectedErrorMessage: "amount_from must be an integer",
+		},
+		{
+			name:                 "list_payments_wrong_time_format",
+			query:                "?created_from=yesterday",
+			expectedCode:         http.StatusBadRequest,
+			expectedErrorMessage: "created_from must be a RFC 3339 timestamp",
+		},
+		{
+			name:                 "list_payments_wrong_cursor",
+			query:                "?cursor=abc",
+			expectedCode:         http.StatusBadRequest,
+			expectedErrorMessage: "cursor is not valid",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			deps, err := dependencies.InitDependencies(cfg)
+			if !assert.NoError(t, err) {
+				return
+			}
+			deps.DB, err = deps.DB.BeginTx(context.Background(), &sql.TxOptions{})
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer func() { _ = cleanupFunc(deps.DB.(bun.Tx), deps.Redis) }()
+			testPayment := baseTestPayment
+			testPayment.Metadata = map[string]string{"cart_id": "cart-1"}
+			err = InsertTestPayment(deps.DB, testPayment)
+			if !assert.NoError(t, err) {
+				return
+			}
+			// The payment of another merchant should never be listed
+			otherPayment := baseTestPayment
+			otherPayment.ID = uuid.Must(uuid.Parse("c5693980-e5f1-4a20-8a2b-bd13ce9f460f"))
+			otherPayment.MerchantID = uuid.Must(uuid.Parse("a1e3f405-44f0-44b4-a584-b0b3c80bc8ac"))
+			err = InsertTestPayment(deps.DB, otherPayment)
+			if !assert.NoError(t, err) {
+				return
+			}
+			r := handlers.NewRouter(cfg, deps, zap.NewNop())
+			req, err := http.NewRequest(http.MethodGet, "/v1/payments"+c.query, nil)
+			if !assert.NoError(t, err) {
+				return
+			}
+			req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuth("6c5a19d0-f132-4a55-93d3-2c00db06d41b", "a7898e515691064b49a15a01e69503f83cd918594e643cc3e949adef273b309f")))
+			res := executeRequest(r, req)
+			assert.Equal(t, c.expectedCode, res.Code)
+
+			if res.Code > 300 {
+				var resBody map[string]interface{}
+				err = json.NewDecoder(res.Body).Decode(&resBody)
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Equal(t, c.expectedErrorMessage, resBody["error"].(string))
+				return
+			}
+			var actual struct {
+				Data       []payment_gateway.Payment `json:"data"`
+				HasMore    bool                      `json:"has_more"`
+				NextCursor string                    `json:"next_cursor"`
+			}
+			err = json.NewDecoder(res.Body).Decode(&actual)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Len(t, actual.Data, c.expectedPayments)
+			assert.False(t, actual.HasMore)
+			assert.Empty(t, actual.NextCursor)
+			for _, p := range actual.Data {
+				compareResults(t, testPayment, p)
+			}
+		})
+	}
+}
+
 func InsertTestPayment(db bun.IDB, payment payment_gateway.Payment) error {
 	_, err := db.NewInsert().Model(payment.GetStoragePayment()).Exec(context.Background())
 	return err
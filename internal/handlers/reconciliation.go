@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway/internal/domain/reconciliation"
+	"github.com/marioarizaj/payment-gateway/kit/responses"
+)
+
+func (h *Handler) GetSettlementFiles(w http.ResponseWriter, r *http.Request) {
+	files, err := h.reconciliation.GetFiles(r.Context())
+	if err != nil {
+		respondWithDomainError(w, err)
+		return
+	}
+	responses.RespondWithJSON(w, http.StatusOK, files)
+}
+
+func (h *Handler) ListDiscrepancies(w http.ResponseWriter, r *http.Request) {
+	filter, err := getDiscrepancyFilter(r.URL.Query())
+	if err != nil {
+		responses.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	discrepancies, err := h.reconciliation.ListDiscrepancies(r.Context(), filter)
+	if err != nil {
+		respondWithDomainError(w, err)
+		return
+	}
+	responses.RespondWithJSON(w, http.StatusOK, discrepancies)
+}
+
+// getDiscrepancyFilter parses the filters of the discrepancies list from the query parameters.
+func getDiscrepancyFilter(query url.Values) (reconciliation.DiscrepancyFilter, error) {
+	filter := reconciliation.DiscrepancyFilter{
+		Status: query.Get("status"),
+		Kind:   query.Get("kind"),
+	}
+	var err error
+	if fileID := query.Get("settlement_file_id"); fileID != "" {
+		filter.SettlementFileID, err = uuid.Parse(fileID)
+		if err != nil {
+			return reconciliation.DiscrepancyFilter{}, errors.New("settlement_file_id format not accurate")
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			return reconciliation.DiscrepancyFilter{}, errors.New("limit must be an integer")
+		}
+	}
+	return filter, nil
+}
+
+func (h *Handler) GetDiscrepancy(w http.ResponseWriter, r *http.Request) {
+	id, ok := getIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	discrepancy, err := h.reconciliation.GetDiscrepancy(r.Context(), id)
+	if err != nil {
+		respondWithDomainError(w, err)
+		return
+	}
+	responses.RespondWithJSON(w, http.StatusOK, discrepancy)
+}
+
+func (h *Handler) ResolveDiscrepancy(w http.ResponseWriter, r *http.Request) {
+	id, ok := getIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	var resolution reconciliation.Resolution
+	err := json.NewDecoder(r.Body).Decode(&resolution)
+	if err != nil {
+		log.Printf("could not decode request body: %v", err)
+		responses.RespondWithError(w, http.StatusBadRequest, "could not decode request body")
+		return
+	}
+	discrepancy, err := h.reconciliation.ResolveDiscrepancy(r.Context(), id, resolution)
+	if err != nil {
+		respondWithDomainError(w, err)
+		return
+	}
+	responses.RespondWithJSON(w, http.StatusOK, discrepancy)
+}
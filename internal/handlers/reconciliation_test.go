@@ -0,0 +1,140 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/dependencies"
+	"github.com/marioarizaj/payment-gateway/internal/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun"
+	"go.uber.org/zap"
+)
+
+func TestHandler_ReconciliationRoutes(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	cfg.Auth.OperatorApiKey = "operator-key"
+
+	cases := []struct {
+		name                 string
+		method               string
+		path                 string
+		payload              string
+		apiKey               string
+		expectedCode         int
+		expectedErrorMessage string
+	}{
+		{
+			name:         "list_discrepancies_success",
+			method:       http.MethodGet,
+			path:         "/ops/reconciliation/discrepancies?status=open&kind=amount_mismatch&limit=10",
+			apiKey:       "operator-key",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:                 "list_discrepancies_wrong_api_key",
+			method:               http.MethodGet,
+			path:                 "/ops/reconciliation/discrepancies",
+			apiKey:               "other-key",
+			expectedCode:         http.StatusUnauthorized,
+			expectedErrorMessage: "unauthorized",
+		},
+		{
+			name:                 "list_discrepancies_invalid_status",
+			method:               http.MethodGet,
+			path:                 "/ops/reconciliation/discrepancies?status=closed",
+			apiKey:               "operator-key",
+			expectedCode:         http.StatusBadRequest,
+			expectedErrorMessage: "status closed is not valid",
+		},
+		{
+			name:                 "list_discrepancies_invalid_settlement_file_id",
+			method:               http.MethodGet,
+			path:                 "/ops/reconciliation/discrepancies?settlement_file_id=file-1",
+			apiKey:               "operator-key",
+			expectedCode:         http.StatusBadRequest,
+			expectedErrorMessage: "settlement_file_id format not accurate",
+		},
+		{
+			name:                 "list_discrepancies_limit_too_big",
+			method:               http.MethodGet,
+			path:                 "/ops/reconciliation/discrepancies?limit=500",
+			apiKey:               "operator-key",
+			expectedCode:         http.StatusBadRequest,
+			expectedErrorMessage: "limit must be between 1 and 100",
+		},
+		{
+			name:         "list_files_success",
+			method:       http.MethodGet,
+			path:         "/ops/reconciliation/files",
+			apiKey:       "operator-key",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:                 "get_discrepancy_not_found",
+			method:               http.MethodGet,
+			path:                 "/ops/reconciliation/discrepancies/b5f9c307-5202-4c52-aba9-752167eef9bf",
+			apiKey:               "operator-key",
+			expectedCode:         http.StatusNotFound,
+			expectedErrorMessage: "not found",
+		},
+		{
+			name:                 "resolve_discrepancy_without_note",
+			method:               http.MethodPost,
+			path:                 "/ops/reconciliation/discrepancies/b5f9c307-5202-4c52-aba9-752167eef9bf/resolve",
+			payload:              `{}`,
+			apiKey:               "operator-key",
+			expectedCode:         http.StatusBadRequest,
+			expectedErrorMessage: "note is required",
+		},
+		{
+			name:                 "resolve_discrepancy_not_found",
+			method:               http.MethodPost,
+			path:                 "/ops/reconciliation/discrepancies/b5f9c307-5202-4c52-aba9-752167eef9bf/resolve",
+			payload:              `{"note":"Refunded by the acquirer"}`,
+			apiKey:               "operator-key",
+			expectedCode:         http.StatusNotFound,
+			expectedErrorMessage: "not found",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			deps, err := dependencies.InitDependencies(cfg)
+			if !assert.NoError(t, err) {
+				return
+			}
+			deps.DB, err = deps.DB.BeginTx(context.Background(), &sql.TxOptions{})
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer func() { _ = cleanupFunc(deps.DB.(bun.Tx), deps.Redis) }()
+			r := handlers.NewRouter(cfg, deps, zap.NewNop())
+			req, err := http.NewRequest(c.method, c.path, bytes.NewBufferString(c.payload))
+			if !assert.NoError(t, err) {
+				return
+			}
+			req.Header.Add("Authorization", "Bearer "+c.apiKey)
+			res := executeRequest(r, req)
+			assert.Equal(t, c.expectedCode, res.Code)
+			if res.Code > 300 {
+				var resBody map[string]interface{}
+				err = json.NewDecoder(res.Body).Decode(&resBody)
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Equal(t, c.expectedErrorMessage, resBody["error"].(string))
+				return
+			}
+			var actual []map[string]interface{}
+			assert.NoError(t, json.NewDecoder(res.Body).Decode(&actual))
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/marioarizaj/payment-gateway"
+	ctx2 "github.com/marioarizaj/payment-gateway/kit/ctx"
+	"github.com/marioarizaj/payment-gateway/kit/responses"
+)
+
+func (h *Handler) CreateRefund(w http.ResponseWriter, r *http.Request) {
+	paymentID, ok := getIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	var refund payment_gateway.Refund
+	err := json.NewDecoder(r.Body).Decode(&refund)
+	if err != nil {
+		log.Printf("could not decode request body: %v", err)
+		responses.RespondWithError(w, http.StatusBadRequest, "could not decode request body")
+		return
+	}
+	ctx := r.Context()
+
+	merchantID, err := ctx2.GetMerchantID(ctx)
+	if err != nil {
+		responses.AuthenticationError(w)
+		return
+	}
+	refund.MerchantID = merchantID
+	refund.PaymentID = paymentID
+
+	existing, err := h.domain.GetRefund(ctx, merchantID, refund.ID)
+	if err == nil {
+		// This means that this refund was created some time in the past
+		if existing.PaymentID != paymentID {
+			responses.ConflictError{}.Response(w)
+			return
+		}
+		responses.RespondWithJSON(w, http.StatusOK, existing)
+		return
+	}
+	var notFoundErr responses.NotFoundError
+	if !errors.As(err, &notFoundErr) {
+		respondWithDomainError(w, err)
+		return
+	}
+
+	refund, err = h.domain.CreateRefund(ctx, refund)
+	if err != nil {
+		respondWithDomainError(w, err)
+		return
+	}
+	responses.RespondWithJSON(w, http.StatusCreated, refund)
+}
+
+func (h *Handler) GetRefunds(w http.ResponseWriter, r *http.Request) {
+	paymentID, ok := getIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	merchantID, err := ctx2.GetMerchantID(ctx)
+	if err != nil {
+		responses.AuthenticationError(w)
+		return
+	}
+	refunds, err := h.domain.GetRefunds(ctx, merchantID, paymentID)
+	if err != nil {
+		respondWithDomainError(w, err)
+		return
+	}
+	responses.RespondWithJSON(w, http.StatusOK, refunds)
+}
+
+// getIDFromPath parses the id of the resource from the request path. When the id is not valid,
+// it writes the error response and returns false.
+func getIDFromPath(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	id, exists := mux.Vars(r)["id"]
+	if !exists {
+		responses.RespondWithError(w, http.StatusBadRequest, "id not found in request")
+		return uuid.UUID{}, false
+	}
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		responses.RespondWithError(w, http.StatusBadRequest, "id format not accurate")
+		return uuid.UUID{}, false
+	}
+	return uid, true
+}
+
+// respondWithDomainError writes the response of an error returned from the domain.
+// Errors that are not response errors are treated as internal server errors.
+func respondWithDomainError(w http.ResponseWriter, err error) {
+	var resErr responses.ResponseError
+	if errors.As(err, &resErr) {
+		resErr.Response(w)
+		return
+	}
+	responses.InternalServerError{Err: err}.Response(w)
+}
@@ -0,0 +1,230 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/dependencies"
+	"github.com/marioarizaj/payment-gateway/internal/handlers"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun"
+	"go.uber.org/zap"
+)
+
+var baseTestRefund = payment_gateway.Refund{
+	ID: uuid.Must(uuid.Parse("0d3bd1f2-0b8e-4c3a-8d43-67b1dfd4c1a2")),
+	Amount: payment_gateway.Amount{
+		AmountFractional: 1000,
+		CurrencyCode:     "USD",
+	},
+	Reason: "Item returned",
+}
+
+func TestHandler_CreateRefund(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	cases := []struct {
+		name                 string
+		paymentStatus        status.PaymentStatus
+		payload              func() []byte
+		paymentID            string
+		expectedCode         int
+		expectedErrorMessage string
+		username             string
+		password             string
+	}{
+		{
+			name:          "create_refund_success",
+			paymentStatus: "succeeded",
+			payload: func() []byte {
+				bts, _ := json.Marshal(baseTestRefund)
+				return bts
+			},
+			paymentID:    "b5f9c307-5202-4c52-aba9-752167eef9bf",
+			expectedCode: http.StatusCreated,
+			username:     "6c5a19d0-f132-4a55-93d3-2c00db06d41b",
+			password:     "a7898e515691064b49a15a01e69503f83cd918594e643cc3e949adef273b309f",
+		},
+		{
+			name:          "create_refund_exceeds_amount",
+			paymentStatus: "succeeded",
+			payload: func() []byte {
+				r := baseTestRefund
+				r.Amount.AmountFractional = 3000
+				bts, _ := json.Marshal(r)
+				return bts
+			},
+			paymentID:            "b5f9c307-5202-4c52-aba9-752167eef9bf",
+			expectedCode:         http.StatusBadRequest,
+			expectedErrorMessage: "refund amount exceeds the remaining refundable amount of 2010",
+			username:             "6c5a19d0-f132-4a55-93d3-2c00db06d41b",
+			password:             "a7898e515691064b49a15a01e69503f83cd918594e643cc3e949adef273b309f",
+		},
+		{
+			name:          "create_refund_payment_not_found",
+			paymentStatus: "succeeded",
+			payload: func() []byte {
+				bts, _ := json.Marshal(baseTestRefund)
+				return bts
+			},
+			paymentID:            "b5f9c307-5202-4c52-aba9-752167eef8bf",
+			expectedCode:         http.StatusNotFound,
+			expectedErrorMessage: "not found",
+			username:             "6c5a19d0-f132-4a55-93d3-2c00db06d41b",
+			password:             "a7898e515691064b49a15a01e69503f83cd918594e643cc3e949adef273b309f",
+		},
+		{
+			name:          "create_refund_wrong_id_format",
+			paymentStatus: "succeeded",
+			payload: func() []byte {
+				bts, _ := json.Marshal(baseTestRefund)
+				return bts
+			},
+			paymentID:            "1234",
+			expectedCode:         http.StatusBadRequest,
+			expectedErrorMessage: "id format not accurate",
+			username:             "6c5a19d0-f132-4a55-93d3-2c00db06d41b",
+			password:             "a7898e515691064b49a15a01e69503f83cd918594e643cc3e949adef273b309f",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			deps, err := dependencies.InitDependencies(cfg)
+			if !assert.NoError(t, err) {
+				return
+			}
+			deps.DB, err = deps.DB.BeginTx(context.Background(), &sql.TxOptions{})
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer func() { _ = cleanupFunc(deps.DB.(bun.Tx), deps.Redis) }()
+			p := baseTestPayment
+			p.PaymentStatus = c.paymentStatus
+			err = InsertTestPayment(deps.DB, p)
+			if !assert.NoError(t, err) {
+				return
+			}
+			r := handlers.NewRouter(cfg, deps, zap.NewNop())
+			req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("/v1/payments/%s/refunds", c.paymentID), bytes.NewBuffer(c.payload()))
+			if !assert.NoError(t, err) {
+				return
+			}
+			req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuth(c.username, c.password)))
+			res := executeRequest(r, req)
+			assert.Equal(t, c.expectedCode, res.Code)
+			if res.Code > 300 {
+				var resBody map[string]interface{}
+				err = json.NewDecoder(res.Body).Decode(&resBody)
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Equal(t, c.expectedErrorMessage, resBody["error"].(string))
+				return
+			}
+			var actual payment_gateway.Refund
+			err = json.NewDecoder(res.Body).Decode(&actual)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, status.RefundProcessing, actual.RefundStatus)
+			assert.Equal(t, p.ID, actual.PaymentID)
+			assert.Equal(t, baseTestRefund.Amount, actual.Amount)
+		})
+	}
+}
+
+func TestHandler_GetRefunds(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	cases := []struct {
+		name                 string
+		paymentID            string
+		expectedCode         int
+		expectedRefunds      int
+		expectedErrorMessage string
+		username             string
+		password             string
+	}{
+		{
+			name:            "get_refunds_success",
+			paymentID:       "b5f9c307-5202-4c52-aba9-752167eef9bf",
+			expectedCode:    http.StatusOK,
+			expectedRefunds: 1,
+			username:        "6c5a19d0-f132-4a55-93d3-2c00db06d41b",
+			password:        "a7898e515691064b49a15a01e69503f83cd918594e643cc3e949adef273b309f",
+		},
+		{
+			name:                 "get_refunds_payment_not_found",
+			paymentID:            "b5f9c307-5202-4c52-aba9-752167eef8bf",
+			expectedCode:         http.StatusNotFound,
+			expectedErrorMessage: "not found",
+			username:             "6c5a19d0-f132-4a55-93d3-2c00db06d41b",
+			password:             "a7898e515691064b49a15a01e69503f83cd918594e643cc3e949adef273b309f",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			deps, err := dependencies.InitDependencies(cfg)
+			if !assert.NoError(t, err) {
+				return
+			}
+			deps.DB, err = deps.DB.BeginTx(context.Background(), &sql.TxOptions{})
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer func() { _ = cleanupFunc(deps.DB.(bun.Tx), deps.Redis) }()
+			p := baseTestPayment
+			p.PaymentStatus = "succeeded"
+			err = InsertTestPayment(deps.DB, p)
+			if !assert.NoError(t, err) {
+				return
+			}
+			refund := baseTestRefund
+			refund.PaymentID = p.ID
+			refund.MerchantID = p.MerchantID
+			refund.RefundStatus = "succeeded"
+			_, err = deps.DB.NewInsert().Model(refund.GetStorageRefund()).Exec(context.Background())
+			if !assert.NoError(t, err) {
+				return
+			}
+			r := handlers.NewRouter(cfg, deps, zap.NewNop())
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("/v1/payments/%s/refunds", c.paymentID), nil)
+			if !assert.NoError(t, err) {
+				return
+			}
+			req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuth(c.username, c.password)))
+			res := executeRequest(r, req)
+			assert.Equal(t, c.expectedCode, res.Code)
+			if res.Code > 300 {
+				var resBody map[string]interface{}
+				err = json.NewDecoder(res.Body).Decode(&resBody)
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Equal(t, c.expectedErrorMessage, resBody["error"].(string))
+				return
+			}
+			var actual []payment_gateway.Refund
+			err = json.NewDecoder(res.Body).Decode(&actual)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Len(t, actual, c.expectedRefunds)
+		})
+	}
+}
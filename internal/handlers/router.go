@@ -5,40 +5,109 @@ import (
 
 	"github.com/marioarizaj/payment-gateway/internal/config"
 	"github.com/marioarizaj/payment-gateway/kit/auth"
+	"github.com/marioarizaj/payment-gateway/kit/idempotency"
 	"github.com/marioarizaj/payment-gateway/kit/limiter"
 	"github.com/marioarizaj/payment-gateway/kit/logging"
 	"github.com/marioarizaj/payment-gateway/kit/prometheus"
+	"github.com/marioarizaj/payment-gateway/kit/requestid"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 
 	"github.com/gorilla/mux"
 	"github.com/marioarizaj/payment-gateway/internal/dependencies"
 	"github.com/marioarizaj/payment-gateway/internal/domain/payment"
+	"github.com/marioarizaj/payment-gateway/internal/domain/reconciliation"
+	"github.com/marioarizaj/payment-gateway/internal/domain/webhook"
 	"github.com/marioarizaj/payment-gateway/internal/repositiory"
 	"github.com/marioarizaj/payment-gateway/kit/rediscache"
 )
 
 type Handler struct {
-	domain *payment.Domain
+	domain           *payment.Domain
+	webhooks         *webhook.Domain
+	reconciliation   *reconciliation.Domain
+	acquirerCallback config.AcquirerCallbackConfig
+}
+
+// Domains are the domains behind the handlers, which the workers running next to the server share with them.
+type Domains struct {
+	Payments       *payment.Domain
+	Webhooks       *webhook.Domain
+	Reconciliation *reconciliation.Domain
+}
+
+func NewDomains(cfg config.Config, deps dependencies.Dependencies, l *zap.Logger) Domains {
+	cache := rediscache.NewRedisClient(deps.Redis)
+	repo := repositiory.NewRepository(deps.DB)
+	webhooks := webhook.NewDomain(repo, webhook.NewHTTPClient(), l, cfg.WebhookConfig)
+	return Domains{
+		Payments:       payment.NewDomain(repo, cache, l, deps.BankClients(), deps.Router, webhooks),
+		Webhooks:       webhooks,
+		Reconciliation: reconciliation.NewDomain(repo, l, cfg.ReconciliationConfig, deps.Router.DefaultAcquirer()),
+	}
 }
 
 func NewRouter(cfg config.Config, deps dependencies.Dependencies, l *zap.Logger) *mux.Router {
+	return NewRouterWithDomains(cfg, deps, NewDomains(cfg, deps, l), l)
+}
+
+// NewRouterWithDomains returns the router of the given domains, so they can be shared with the workers.
+func NewRouterWithDomains(cfg config.Config, deps dependencies.Dependencies, domains Domains, l *zap.Logger) *mux.Router {
+	cache := rediscache.NewRedisClient(deps.Redis)
 	h := &Handler{
-		domain: payment.NewDomain(repositiory.NewRepository(deps.DB), rediscache.NewRedisClient(deps.Redis), l, deps.BankClient),
+		domain:           domains.Payments,
+		webhooks:         domains.Webhooks,
+		reconciliation:   domains.Reconciliation,
+		acquirerCallback: cfg.AcquirerCallback,
 	}
 
 	r := mux.NewRouter()
 	r.Handle("/metrics", promhttp.Handler())
 
+	// Routes called by the acquiring bank, which authenticate each request on their own instead of using the merchant credentials
+	internalR := r.PathPrefix("/internal").Subrouter()
+	internalR.Use(requestid.Middleware)
+	internalR.Use(prometheus.Middleware)
+	internalR.Use(logging.Middleware(l))
+	internalR.HandleFunc("/acquirer/callbacks", h.AcquirerCallback).Methods(http.MethodPost)
+
+	// Routes used by the operators of the gateway, which are authenticated with the operator api key
+	opsR := r.PathPrefix("/ops").Subrouter()
+	opsR.Use(requestid.Middleware)
+	opsR.Use(auth.OperatorMiddleware(cfg.Auth.OperatorApiKey))
+	opsR.Use(prometheus.Middleware)
+	opsR.Use(logging.Middleware(l))
+	opsR.HandleFunc("/reconciliation/files", h.GetSettlementFiles).Methods(http.MethodGet)
+	opsR.HandleFunc("/reconciliation/discrepancies", h.ListDiscrepancies).Methods(http.MethodGet)
+	opsR.HandleFunc("/reconciliation/discrepancies/{id}", h.GetDiscrepancy).Methods(http.MethodGet)
+	opsR.HandleFunc("/reconciliation/discrepancies/{id}/resolve", h.ResolveDiscrepancy).Methods(http.MethodPost)
+
 	v1R := r.PathPrefix("/v1").Subrouter()
 
+	v1R.Use(requestid.Middleware)
 	v1R.Use(auth.Middleware(cfg.Auth.ApiKeySecret))
 	v1R.Use(limiter.Middleware(deps.Limiter, cfg.RateLimiter.AllowedReqsPerSecond))
 	v1R.Use(prometheus.Middleware)
 	v1R.Use(logging.Middleware(l))
+	v1R.Use(idempotency.Middleware(cache, l))
 
 	v1R.HandleFunc("/payments", h.CreatePayment).Methods(http.MethodPost)
+	v1R.HandleFunc("/payments", h.ListPayments).Methods(http.MethodGet)
+	// Registered before the routes with a payment id, so /payments/reference/refunds is not read as the refunds of a payment
+	v1R.HandleFunc("/payments/reference/{reference}", h.GetPaymentByReference).Methods(http.MethodGet)
 	v1R.HandleFunc("/payments/{id}", h.GetPayment).Methods(http.MethodGet)
+	v1R.HandleFunc("/payments/{id}/attempts", h.GetPaymentAttempts).Methods(http.MethodGet)
+	v1R.HandleFunc("/payments/{id}/capture", h.CapturePayment).Methods(http.MethodPost)
+	v1R.HandleFunc("/payments/{id}/cancel", h.CancelPayment).Methods(http.MethodPost)
+	v1R.HandleFunc("/payments/{id}/refunds", h.CreateRefund).Methods(http.MethodPost)
+	v1R.HandleFunc("/payments/{id}/refunds", h.GetRefunds).Methods(http.MethodGet)
+	v1R.HandleFunc("/webhooks", h.CreateWebhookEndpoint).Methods(http.MethodPost)
+	v1R.HandleFunc("/webhooks", h.GetWebhookEndpoints).Methods(http.MethodGet)
+	v1R.HandleFunc("/webhooks/{id}", h.GetWebhookEndpoint).Methods(http.MethodGet)
+	v1R.HandleFunc("/webhooks/{id}", h.UpdateWebhookEndpoint).Methods(http.MethodPatch)
+	v1R.HandleFunc("/webhooks/{id}", h.DeleteWebhookEndpoint).Methods(http.MethodDelete)
+	v1R.HandleFunc("/webhooks/{id}/test", h.SendTestWebhookEvent).Methods(http.MethodPost)
+	v1R.HandleFunc("/webhooks/{id}/deliveries", h.GetWebhookDeliveries).Methods(http.MethodGet)
 
 	return r
 }
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/domain/webhook"
+	ctx2 "github.com/marioarizaj/payment-gateway/kit/ctx"
+	"github.com/marioarizaj/payment-gateway/kit/responses"
+)
+
+func (h *Handler) CreateWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	var endpoint payment_gateway.WebhookEndpoint
+	err := json.NewDecoder(r.Body).Decode(&endpoint)
+	if err != nil {
+		log.Printf("could not decode request body: %v", err)
+		responses.RespondWithError(w, http.StatusBadRequest, "could not decode request body")
+		return
+	}
+	ctx := r.Context()
+	merchantID, err := ctx2.GetMerchantID(ctx)
+	if err != nil {
+		responses.AuthenticationError(w)
+		return
+	}
+	endpoint.MerchantID = merchantID
+	endpoint, err = h.webhooks.CreateEndpoint(ctx, endpoint)
+	if err != nil {
+		respondWithDomainError(w, err)
+		return
+	}
+	responses.RespondWithJSON(w, http.StatusCreated, endpoint)
+}
+
+func (h *Handler) GetWebhookEndpoints(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	merchantID, err := ctx2.GetMerchantID(ctx)
+	if err != nil {
+		responses.AuthenticationError(w)
+		return
+	}
+	endpoints, err := h.webhooks.GetEndpoints(ctx, merchantID)
+	if err != nil {
+		respondWithDomainError(w, err)
+		return
+	}
+	responses.RespondWithJSON(w, http.StatusOK, endpoints)
+}
+
+func (h *Handler) GetWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	id, ok := getIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	merchantID, err := ctx2.GetMerchantID(ctx)
+	if err != nil {
+		responses.AuthenticationError(w)
+		return
+	}
+	endpoint, err := h.webhooks.GetEndpoint(ctx, merchantID, id)
+	if err != nil {
+		respondWithDomainError(w, err)
+		return
+	}
+	responses.RespondWithJSON(w, http.StatusOK, endpoint)
+}
+
+func (h *Handler) UpdateWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	id, ok := getIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	var update webhook.EndpointUpdate
+	err := json.NewDecoder(r.Body).Decode(&update)
+	if err != nil {
+		log.Printf("could not decode request body: %v", err)
+		responses.RespondWithError(w, http.StatusBadRequest, "could not decode request body")
+		return
+	}
+	ctx := r.Context()
+	merchantID, err := ctx2.GetMerchantID(ctx)
+	if err != nil {
+		responses.AuthenticationError(w)
+		return
+	}
+	endpoint, err := h.webhooks.UpdateEndpoint(ctx, merchantID, id, update)
+	if err != nil {
+		respondWithDomainError(w, err)
+		return
+	}
+	responses.RespondWithJSON(w, http.StatusOK, endpoint)
+}
+
+func (h *Handler) DeleteWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	id, ok := getIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	merchantID, err := ctx2.GetMerchantID(ctx)
+	if err != nil {
+		responses.AuthenticationError(w)
+		return
+	}
+	err = h.webhooks.DeleteEndpoint(ctx, merchantID, id)
+	if err != nil {
+		respondWithDomainError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) SendTestWebhookEvent(w http.ResponseWriter, r *http.Request) {
+	id, ok := getIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	merchantID, err := ctx2.GetMerchantID(ctx)
+	if err != nil {
+		responses.AuthenticationError(w)
+		return
+	}
+	delivery, err := h.webhooks.SendTestEvent(ctx, merchantID, id)
+	if err != nil {
+		respondWithDomainError(w, err)
+		return
+	}
+	responses.RespondWithJSON(w, http.StatusOK, delivery)
+}
+
+func (h *Handler) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, ok := getIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	merchantID, err := ctx2.GetMerchantID(ctx)
+	if err != nil {
+		responses.AuthenticationError(w)
+		return
+	}
+	deliveries, err := h.webhooks.GetDeliveries(ctx, merchantID, id)
+	if err != nil {
+		respondWithDomainError(w, err)
+		return
+	}
+	responses.RespondWithJSON(w, http.StatusOK, deliveries)
+}
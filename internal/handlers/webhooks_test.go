@@ -0,0 +1,180 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/dependencies"
+	"github.com/marioarizaj/payment-gateway/internal/handlers"
+	"github.com/marioarizaj/payment-gateway/kit/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun"
+	"go.uber.org/zap"
+)
+
+func TestHandler_CreateWebhookEndpoint(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	cases := []struct {
+		name                 string
+		payload              string
+		expectedCode         int
+		expectedErrorMessage string
+		username             string
+		password             string
+	}{
+		{
+			name:         "create_webhook_endpoint_success",
+			payload:      `{"url":"https://merchant.example.com/webhooks","event_types":["payment.succeeded","payment.failed"]}`,
+			expectedCode: http.StatusCreated,
+			username:     "6c5a19d0-f132-4a55-93d3-2c00db06d41b",
+			password:     "a7898e515691064b49a15a01e69503f83cd918594e643cc3e949adef273b309f",
+		},
+		{
+			name:                 "create_webhook_endpoint_invalid_url",
+			payload:              `{"url":"merchant.example.com","event_types":["payment.succeeded"]}`,
+			expectedCode:         http.StatusBadRequest,
+			expectedErrorMessage: "url must be an absolute https url",
+			username:             "6c5a19d0-f132-4a55-93d3-2c00db06d41b",
+			password:             "a7898e515691064b49a15a01e69503f83cd918594e643cc3e949adef273b309f",
+		},
+		{
+			name:                 "create_webhook_endpoint_invalid_event_type",
+			payload:              `{"url":"https://merchant.example.com/webhooks","event_types":["payment.refunded"]}`,
+			expectedCode:         http.StatusBadRequest,
+			expectedErrorMessage: "event type payment.refunded is not valid",
+			username:             "6c5a19d0-f132-4a55-93d3-2c00db06d41b",
+			password:             "a7898e515691064b49a15a01e69503f83cd918594e643cc3e949adef273b309f",
+		},
+		{
+			name:                 "create_webhook_endpoint_invalid_body",
+			payload:              `{"url":`,
+			expectedCode:         http.StatusBadRequest,
+			expectedErrorMessage: "could not decode request body",
+			username:             "6c5a19d0-f132-4a55-93d3-2c00db06d41b",
+			password:             "a7898e515691064b49a15a01e69503f83cd918594e643cc3e949adef273b309f",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			deps, err := dependencies.InitDependencies(cfg)
+			if !assert.NoError(t, err) {
+				return
+			}
+			deps.DB, err = deps.DB.BeginTx(context.Background(), &sql.TxOptions{})
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer func() { _ = cleanupFunc(deps.DB.(bun.Tx), deps.Redis) }()
+			r := handlers.NewRouter(cfg, deps, zap.NewNop())
+			req, err := http.NewRequest(http.MethodPost, "/v1/webhooks", bytes.NewBufferString(c.payload))
+			if !assert.NoError(t, err) {
+				return
+			}
+			req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuth(c.username, c.password)))
+			res := executeRequest(r, req)
+			assert.Equal(t, c.expectedCode, res.Code)
+			if res.Code > 300 {
+				var resBody map[string]interface{}
+				err = json.NewDecoder(res.Body).Decode(&resBody)
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Equal(t, c.expectedErrorMessage, resBody["error"].(string))
+				return
+			}
+			var actual payment_gateway.WebhookEndpoint
+			err = json.NewDecoder(res.Body).Decode(&actual)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, "https://merchant.example.com/webhooks", actual.URL)
+			assert.Equal(t, []string{"payment.succeeded", "payment.failed"}, actual.EventTypes)
+			assert.True(t, actual.Enabled)
+			assert.NotEmpty(t, actual.Secret)
+		})
+	}
+}
+
+func TestHandler_WebhookEndpointLifecycle(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps.DB, err = deps.DB.BeginTx(context.Background(), &sql.TxOptions{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = cleanupFunc(deps.DB.(bun.Tx), deps.Redis) }()
+	r := handlers.NewRouter(cfg, deps, zap.NewNop())
+	do := func(method, path, body, username, password string) (int, []byte) {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuth(username, password)))
+		res := executeRequest(r, req)
+		return res.Code, res.Body.Bytes()
+	}
+	username := "6c5a19d0-f132-4a55-93d3-2c00db06d41b"
+	password := "a7898e515691064b49a15a01e69503f83cd918594e643cc3e949adef273b309f"
+
+	code, body := do(http.MethodPost, "/v1/webhooks", `{"url":"https://merchant.example.com/webhooks","event_types":["payment.succeeded"]}`, username, password)
+	if !assert.Equal(t, http.StatusCreated, code) {
+		return
+	}
+	var created payment_gateway.WebhookEndpoint
+	err = json.Unmarshal(body, &created)
+	if !assert.NoError(t, err) {
+		return
+	}
+	path := fmt.Sprintf("/v1/webhooks/%s", created.ID)
+
+	code, body = do(http.MethodPatch, path, `{"enabled":false,"event_types":["payment.canceled"]}`, username, password)
+	if !assert.Equal(t, http.StatusOK, code) {
+		return
+	}
+	var updated payment_gateway.WebhookEndpoint
+	err = json.Unmarshal(body, &updated)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.False(t, updated.Enabled)
+	assert.Equal(t, []string{"payment.canceled"}, updated.EventTypes)
+	assert.Equal(t, created.URL, updated.URL)
+	assert.Empty(t, updated.Secret)
+
+	code, body = do(http.MethodGet, "/v1/webhooks", "", username, password)
+	assert.Equal(t, http.StatusOK, code)
+	var endpoints []payment_gateway.WebhookEndpoint
+	err = json.Unmarshal(body, &endpoints)
+	if assert.NoError(t, err) && assert.Len(t, endpoints, 1) {
+		assert.Equal(t, created.ID, endpoints[0].ID)
+	}
+
+	// Endpoints of other merchants are not found
+	otherMerchantID := uuid.Must(uuid.Parse("a1e3f405-44f0-44b4-a584-b0b3c80bc8ac"))
+	code, _ = do(http.MethodGet, path, "", otherMerchantID.String(), auth.GetHMAC(otherMerchantID, cfg.Auth.ApiKeySecret))
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, body = do(http.MethodGet, path+"/deliveries", "", username, password)
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, "[]", string(body))
+
+	code, _ = do(http.MethodDelete, path, "", username, password)
+	assert.Equal(t, http.StatusNoContent, code)
+	code, _ = do(http.MethodGet, path, "", username, password)
+	assert.Equal(t, http.StatusNotFound, code)
+}
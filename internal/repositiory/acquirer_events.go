@@ -0,0 +1,32 @@
+package repositiory
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AcquirerEvent is a result posted by the acquiring bank. It is stored when the result is applied,
+// so a result delivered more than once is only applied the first time.
+type AcquirerEvent struct {
+	// ID is the event id given by the acquiring bank, the same on every delivery of the result
+	ID        string
+	Operation string
+	// ResourceID is the payment or refund the result belongs to
+	ResourceID uuid.UUID
+	CreatedAt  *time.Time
+}
+
+// CreateAcquirerEvent stores the event, and returns false without an error when it was already stored.
+func (r *repo) CreateAcquirerEvent(ctx context.Context, event *AcquirerEvent) (bool, error) {
+	res, err := r.db.NewInsert().Model(event).On("CONFLICT (id) DO NOTHING").Exec(ctx)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
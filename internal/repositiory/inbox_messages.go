@@ -0,0 +1,151 @@
+package repositiory
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
+)
+
+const (
+	// InboxMessagePending is the status of the messages that still need to be applied, or whose cache was not invalidated
+	InboxMessagePending = "pending"
+	// InboxMessageDone is the status of the messages that were applied
+	InboxMessageDone = "done"
+	// InboxMessageDead is the status of the messages that failed on every attempt, and are left for an operator
+	InboxMessageDead = "dead"
+)
+
+// InboxMessage is a result of an acquirer, stored before it is applied so it is not lost when it can not be applied
+// right away. A message is applied at least once, and the AcquirerEvent stored along with the update makes sure the
+// result itself is only applied the first time.
+type InboxMessage struct {
+	ID uuid.UUID
+	// EventID is given by the acquirer, or by the gateway for the results of the bank clients, and it is unique
+	EventID   string
+	Operation string
+	// ResourceID is the payment or refund the result belongs to
+	ResourceID uuid.UUID
+	// Acquirer is the acquirer that sent the result, empty when the result belongs to the current acquirer of the payment
+	Acquirer     string
+	ResultStatus string
+	Decline      *decline.Decline
+	// AcquirerReference identifies the authorization on the acquirer, for the payments it authorized
+	AcquirerReference *AcquirerReference
+	Status            string
+	// Attempts is the number of times the message was claimed by the inbox
+	Attempts int
+	// VisibleAt is when the message can be claimed, either for the first time or again
+	VisibleAt time.Time
+	LastError string
+	// AppliedAt is when the result was written, which can be before the message is done
+	AppliedAt  *time.Time
+	FinishedAt *time.Time
+	CreatedAt  *time.Time
+	UpdatedAt  *time.Time
+}
+
+// CreateInboxMessage stores the message, and returns false without an error when a message with the same event id was
+// already stored.
+func (r *repo) CreateInboxMessage(ctx context.Context, message *InboxMessage) (bool, error) {
+	if message.Status == "" {
+		message.Status = InboxMessagePending
+	}
+	if message.VisibleAt.IsZero() {
+		message.VisibleAt = time.Now().UTC()
+	}
+	res, err := r.db.NewInsert().Model(message).On("CONFLICT (event_id) DO NOTHING").Exec(ctx)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// GetInboxMessageForUpdate locks the message until the transaction is finished, so it is applied by one
+// transaction at a time.
+func (r *repo) GetInboxMessageForUpdate(ctx context.Context, id uuid.UUID) (*InboxMessage, error) {
+	var message InboxMessage
+	err := r.db.NewSelect().Model(&message).Where("id = ?", id).For("UPDATE").Scan(ctx)
+	return &message, err
+}
+
+// ClaimInboxMessage claims the pending message that is visible the longest, and hides it from the other workers for
+// the visibility timeout. It returns sql.ErrNoRows when no message is visible.
+func (r *repo) ClaimInboxMessage(ctx context.Context, now time.Time, visibilityTimeout time.Duration) (*InboxMessage, error) {
+	var message InboxMessage
+	visible := r.db.NewSelect().Model((*InboxMessage)(nil)).
+		Column("id").
+		Where("status = ?", InboxMessagePending).
+		Where("visible_at <= ?", now.UTC()).
+		Order("visible_at ASC").
+		Limit(1).
+		For("UPDATE SKIP LOCKED")
+	res, err := r.db.NewUpdate().Model(&message).
+		Set("attempts = attempts + 1").
+		Set("visible_at = ?", now.Add(visibilityTimeout).UTC()).
+		Set("updated_at = ?", now.UTC()).
+		Where("id = (?)", visible).
+		Returning("*").
+		Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &message, nil
+}
+
+// MarkInboxMessageApplied records that the result of the message was written. It should be called on the transaction
+// that writes the result.
+func (r *repo) MarkInboxMessageApplied(ctx context.Context, message *InboxMessage) error {
+	now := time.Now()
+	message.AppliedAt = &now
+	message.UpdatedAt = &now
+	_, err := r.db.NewUpdate().Model(message).
+		Where("id = ?", message.ID).
+		Column("applied_at", "updated_at").
+		Exec(ctx)
+	return err
+}
+
+// UpdateInboxMessage writes the result of the attempt the message was claimed for. It returns sql.ErrNoRows when the
+// message was claimed again since then, or it is not pending anymore.
+func (r *repo) UpdateInboxMessage(ctx context.Context, message *InboxMessage) error {
+	now := time.Now()
+	message.UpdatedAt = &now
+	res, err := r.db.NewUpdate().Model(message).
+		Where("id = ?", message.ID).
+		Where("attempts = ?", message.Attempts).
+		Where("status = ?", InboxMessagePending).
+		Column("status", "visible_at", "last_error", "finished_at", "updated_at").
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetInboxMessages returns the messages of the payment or refund, in the order they were received.
+func (r *repo) GetInboxMessages(ctx context.Context, resourceID uuid.UUID) ([]InboxMessage, error) {
+	messages := make([]InboxMessage, 0)
+	err := r.db.NewSelect().Model(&messages).Where("resource_id = ?", resourceID).Order("created_at ASC").Scan(ctx)
+	return messages, err
+}
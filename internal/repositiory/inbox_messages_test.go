@@ -0,0 +1,104 @@
+package repositiory_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/dependencies"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepo_InboxMessages(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	tx, err := deps.DB.BeginTx(context.Background(), &sql.TxOptions{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+	repo := repositiory.NewRepository(tx)
+	ctx := context.Background()
+	now := time.Now()
+
+	message := &repositiory.InboxMessage{
+		ID:           uuid.Must(uuid.Parse("9a1d6f4e-2c3b-4d5e-8f60-7a8b9c0d1e01")),
+		EventID:      "evt_1",
+		Operation:    "payment",
+		ResourceID:   testRefundPayment.ID,
+		Acquirer:     "primary",
+		ResultStatus: "succeeded",
+		VisibleAt:    now,
+	}
+	created, err := repo.CreateInboxMessage(ctx, message)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, created)
+	// A message with the same event id is not stored again
+	created, err = repo.CreateInboxMessage(ctx, &repositiory.InboxMessage{
+		ID:           uuid.New(),
+		EventID:      "evt_1",
+		Operation:    "payment",
+		ResourceID:   testRefundPayment.ID,
+		ResultStatus: "failed",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.False(t, created)
+
+	claimed, err := repo.ClaimInboxMessage(ctx, now, time.Minute)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, message.ID, claimed.ID)
+	assert.Equal(t, 1, claimed.Attempts)
+	_, err = repo.ClaimInboxMessage(ctx, now, time.Minute)
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	// A message can fail after its result was applied, like when the cache can not be invalidated
+	locked, err := repo.GetInboxMessageForUpdate(ctx, message.ID)
+	if !assert.NoError(t, err) || !assert.NoError(t, repo.MarkInboxMessageApplied(ctx, locked)) {
+		return
+	}
+	claimed.LastError = "dial tcp: connection refused"
+	claimed.VisibleAt = now.Add(time.Minute)
+	if !assert.NoError(t, repo.UpdateInboxMessage(ctx, claimed)) {
+		return
+	}
+	messages, err := repo.GetInboxMessages(ctx, testRefundPayment.ID)
+	if !assert.NoError(t, err) || !assert.Len(t, messages, 1) {
+		return
+	}
+	assert.Equal(t, repositiory.InboxMessagePending, messages[0].Status)
+	assert.NotNil(t, messages[0].AppliedAt)
+	assert.Equal(t, "dial tcp: connection refused", messages[0].LastError)
+
+	// Only the last claim of the message can finish it
+	reclaimed, err := repo.ClaimInboxMessage(ctx, now.Add(2*time.Minute), time.Minute)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 2, reclaimed.Attempts)
+	claimed.Status = repositiory.InboxMessageDone
+	assert.Equal(t, sql.ErrNoRows, repo.UpdateInboxMessage(ctx, claimed))
+	reclaimed.Status = repositiory.InboxMessageDone
+	reclaimed.FinishedAt = &now
+	if !assert.NoError(t, repo.UpdateInboxMessage(ctx, reclaimed)) {
+		return
+	}
+	// Finished messages are never claimed again
+	_, err = repo.ClaimInboxMessage(ctx, now.Add(time.Hour), time.Minute)
+	assert.Equal(t, sql.ErrNoRows, err)
+}
@@ -0,0 +1,114 @@
+package repositiory
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// OutboxJobSubmitPayment sends a payment to the acquirer of its route
+	OutboxJobSubmitPayment = "submit_payment"
+	// OutboxJobCancelPayment sends the cancellation of a canceled payment to its acquirer
+	OutboxJobCancelPayment = "cancel_payment"
+
+	// OutboxJobPending is the status of the jobs that still need to run, including the ones claimed by a worker
+	OutboxJobPending = "pending"
+	// OutboxJobDone is the status of the jobs that ran
+	OutboxJobDone = "done"
+	// OutboxJobDead is the status of the jobs that failed on every attempt, and are left for an operator
+	OutboxJobDead = "dead"
+)
+
+// OutboxJob is work that is written on the same transaction as the data it is about, and done afterwards by a worker.
+// A job is delivered at least once: a worker that does not finish it before VisibleAt lets another worker claim it again.
+type OutboxJob struct {
+	ID   uuid.UUID
+	Kind string
+	// ResourceID is the payment the job is about
+	ResourceID uuid.UUID
+	Status     string
+	// Attempts is the number of times the job was claimed
+	Attempts int
+	// VisibleAt is when the job can be claimed, either for the first time or again
+	VisibleAt  time.Time
+	LastError  string
+	FinishedAt *time.Time
+	CreatedAt  *time.Time
+	UpdatedAt  *time.Time
+}
+
+// CreateOutboxJob stores the job, which is pending and visible right away unless it says otherwise.
+func (r *repo) CreateOutboxJob(ctx context.Context, job *OutboxJob) error {
+	if job.Status == "" {
+		job.Status = OutboxJobPending
+	}
+	if job.VisibleAt.IsZero() {
+		job.VisibleAt = time.Now().UTC()
+	}
+	_, err := r.db.NewInsert().Model(job).Exec(ctx)
+	return err
+}
+
+// ClaimOutboxJob claims the pending job that is visible the longest, and hides it for the visibility timeout.
+// It returns sql.ErrNoRows when no job is visible.
+func (r *repo) ClaimOutboxJob(ctx context.Context, now time.Time, visibilityTimeout time.Duration) (*OutboxJob, error) {
+	var job OutboxJob
+	visible := r.db.NewSelect().Model((*OutboxJob)(nil)).
+		Column("id").
+		Where("status = ?", OutboxJobPending).
+		Where("visible_at <= ?", now.UTC()).
+		Order("visible_at ASC").
+		Limit(1).
+		For("UPDATE SKIP LOCKED")
+	res, err := r.db.NewUpdate().Model(&job).
+		Set("attempts = attempts + 1").
+		Set("visible_at = ?", now.Add(visibilityTimeout).UTC()).
+		Set("updated_at = ?", now.UTC()).
+		Where("id = (?)", visible).
+		Returning("*").
+		Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &job, nil
+}
+
+// UpdateOutboxJob writes the result of the attempt the job was claimed for. It returns sql.ErrNoRows when the job was
+// claimed again since then.
+func (r *repo) UpdateOutboxJob(ctx context.Context, job *OutboxJob) error {
+	now := time.Now()
+	job.UpdatedAt = &now
+	res, err := r.db.NewUpdate().Model(job).
+		Where("id = ?", job.ID).
+		Where("attempts = ?", job.Attempts).
+		Column("status", "visible_at", "last_error", "finished_at", "updated_at").
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetOutboxJobs returns the jobs of the payment, in the order they were created.
+func (r *repo) GetOutboxJobs(ctx context.Context, resourceID uuid.UUID) ([]OutboxJob, error) {
+	jobs := make([]OutboxJob, 0)
+	err := r.db.NewSelect().Model(&jobs).Where("resource_id = ?", resourceID).Order("created_at ASC").Scan(ctx)
+	return jobs, err
+}
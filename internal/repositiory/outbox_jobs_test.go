@@ -0,0 +1,96 @@
+package repositiory_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/dependencies"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepo_ClaimOutboxJob(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	tx, err := deps.DB.BeginTx(context.Background(), &sql.TxOptions{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+	repo := repositiory.NewRepository(tx)
+	ctx := context.Background()
+	now := time.Now()
+
+	later := &repositiory.OutboxJob{
+		ID:         uuid.Must(uuid.Parse("3f0c2b8e-8a4c-4f57-b1f2-4b1f0a6c9d01")),
+		Kind:       repositiory.OutboxJobSubmitPayment,
+		ResourceID: testRefundPayment.ID,
+		VisibleAt:  now.Add(time.Hour),
+	}
+	first := &repositiory.OutboxJob{
+		ID:         uuid.Must(uuid.Parse("3f0c2b8e-8a4c-4f57-b1f2-4b1f0a6c9d02")),
+		Kind:       repositiory.OutboxJobSubmitPayment,
+		ResourceID: testRefundPayment.ID,
+		VisibleAt:  now,
+	}
+	for _, job := range []*repositiory.OutboxJob{later, first} {
+		if !assert.NoError(t, repo.CreateOutboxJob(ctx, job)) {
+			return
+		}
+	}
+
+	// Only the job that is visible is claimed, and it is hidden for the visibility timeout
+	claimed, err := repo.ClaimOutboxJob(ctx, now, time.Minute)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, first.ID, claimed.ID)
+	assert.Equal(t, 1, claimed.Attempts)
+	_, err = repo.ClaimOutboxJob(ctx, now, time.Minute)
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	// A worker that did not finish the job before its visibility timeout can not finish it anymore
+	reclaimed, err := repo.ClaimOutboxJob(ctx, now.Add(2*time.Minute), time.Minute)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, first.ID, reclaimed.ID)
+	assert.Equal(t, 2, reclaimed.Attempts)
+	claimed.Status = repositiory.OutboxJobDone
+	assert.Equal(t, sql.ErrNoRows, repo.UpdateOutboxJob(ctx, claimed))
+
+	finishedAt := now
+	reclaimed.Status = repositiory.OutboxJobDone
+	reclaimed.FinishedAt = &finishedAt
+	if !assert.NoError(t, repo.UpdateOutboxJob(ctx, reclaimed)) {
+		return
+	}
+	// Finished jobs are never claimed again, while the other job is once it is visible
+	next, err := repo.ClaimOutboxJob(ctx, now.Add(2*time.Hour), time.Minute)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, later.ID, next.ID)
+	_, err = repo.ClaimOutboxJob(ctx, now.Add(2*time.Hour), time.Minute)
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	jobs, err := repo.GetOutboxJobs(ctx, testRefundPayment.ID)
+	if !assert.NoError(t, err) || !assert.Len(t, jobs, 2) {
+		return
+	}
+	for _, job := range jobs {
+		if job.ID == first.ID {
+			assert.Equal(t, repositiory.OutboxJobDone, job.Status)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package repositiory
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+)
+
+// PaymentAttempt is a payment sent to an acquirer. A payment has more than one attempt when it cascades
+// to a fallback acquirer, and a payment is never sent twice to the same acquirer.
+type PaymentAttempt struct {
+	PaymentID uuid.UUID
+	// Attempt is the number of the attempt, starting from 1
+	Attempt  int
+	Acquirer string
+	// Status is processing until the acquirer answers, and then succeeded or failed
+	Status    status.PaymentStatus
+	Decline   *decline.Decline
+	CreatedAt *time.Time
+	UpdatedAt *time.Time
+}
+
+// CreatePaymentAttempt stores the attempt with a processing status. A payment that is sent again to the same acquirer,
+// because its job was delivered again, keeps its attempt, which goes back to processing.
+func (r *repo) CreatePaymentAttempt(ctx context.Context, attempt *PaymentAttempt) error {
+	now := time.Now()
+	attempt.UpdatedAt = &now
+	_, err := r.db.NewInsert().Model(attempt).
+		On("CONFLICT (payment_id, acquirer) DO UPDATE").
+		Set("status = EXCLUDED.status").
+		Set("decline = EXCLUDED.decline").
+		Set("updated_at = EXCLUDED.updated_at").
+		Exec(ctx)
+	return err
+}
+
+// UpdatePaymentAttempt writes the result the acquirer gave to the attempt, finding it by the payment and the acquirer.
+// It returns sql.ErrNoRows when the attempt does not exist.
+func (r *repo) UpdatePaymentAttempt(ctx context.Context, attempt *PaymentAttempt) error {
+	now := time.Now()
+	attempt.UpdatedAt = &now
+	res, err := r.db.NewUpdate().Model(attempt).
+		Where("payment_id = ?", attempt.PaymentID).
+		Where("acquirer = ?", attempt.Acquirer).
+		Column("status", "decline", "updated_at").Exec(ctx)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetPaymentAttempts returns the attempts of a payment, in the order they were made.
+func (r *repo) GetPaymentAttempts(ctx context.Context, paymentID uuid.UUID) ([]PaymentAttempt, error) {
+	attempts := make([]PaymentAttempt, 0)
+	err := r.db.NewSelect().Model(&attempts).Where("payment_id = ?", paymentID).Order("attempt ASC").Scan(ctx)
+	return attempts, err
+}
@@ -0,0 +1,98 @@
+package repositiory
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// ResolutionInquiry means that a status inquiry told the status of the payment
+	ResolutionInquiry = "inquiry"
+	// ResolutionReversal means that the payment was reversed, after the inquiries did not get an answer
+	ResolutionReversal = "reversal"
+	// ResolutionResult means that the result of the payment arrived from the acquirer before it was resolved
+	ResolutionResult = "result"
+)
+
+// PaymentResolution schedules the resolution of a payment whose outcome is unknown. It is resolved once
+// ResolvedAt is set, and Resolution tells how.
+type PaymentResolution struct {
+	PaymentID uuid.UUID
+	// Attempts is the number of times the resolution was claimed, to send an inquiry or a reversal to the acquirer
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	Resolution    string
+	ResolvedAt    *time.Time
+	CreatedAt     *time.Time
+	UpdatedAt     *time.Time
+}
+
+func (r *repo) CreatePaymentResolution(ctx context.Context, resolution *PaymentResolution) error {
+	_, err := r.db.NewInsert().Model(resolution).Exec(ctx)
+	return err
+}
+
+// ClaimDuePaymentResolution claims the unresolved resolution that is due the longest, counting the attempt and hiding it
+// from the other resolvers until the visibility timeout passes. Resolutions claimed at the same time by other resolvers
+// are skipped. It returns sql.ErrNoRows when no resolution is due.
+func (r *repo) ClaimDuePaymentResolution(ctx context.Context, now time.Time, visibilityTimeout time.Duration) (*PaymentResolution, error) {
+	var resolution PaymentResolution
+	due := r.db.NewSelect().Model((*PaymentResolution)(nil)).
+		Column("payment_id").
+		Where("resolved_at IS NULL").
+		Where("next_attempt_at <= ?", now.UTC()).
+		Order("next_attempt_at ASC").
+		Limit(1).
+		For("UPDATE SKIP LOCKED")
+	res, err := r.db.NewUpdate().Model(&resolution).
+		Set("attempts = attempts + 1").
+		Set("next_attempt_at = ?", now.Add(visibilityTimeout).UTC()).
+		Set("updated_at = ?", now.UTC()).
+		Where("payment_id = (?)", due).
+		Returning("*").
+		Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &resolution, nil
+}
+
+// UpdatePaymentResolution writes the result of the attempt the resolution was claimed for. It returns sql.ErrNoRows when
+// the resolution was claimed again since then, because the visibility timeout passed.
+func (r *repo) UpdatePaymentResolution(ctx context.Context, resolution *PaymentResolution) error {
+	now := time.Now()
+	resolution.UpdatedAt = &now
+	res, err := r.db.NewUpdate().Model(resolution).
+		Where("payment_id = ?", resolution.PaymentID).
+		Where("attempts = ?", resolution.Attempts).
+		Column("next_attempt_at", "last_error", "resolution", "resolved_at", "updated_at").
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *repo) GetPaymentResolution(ctx context.Context, paymentID uuid.UUID) (*PaymentResolution, error) {
+	var resolution PaymentResolution
+	err := r.db.NewSelect().Model(&resolution).Where("payment_id = ?", paymentID).Scan(ctx)
+	return &resolution, err
+}
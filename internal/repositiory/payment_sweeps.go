@@ -0,0 +1,158 @@
+package repositiory
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"github.com/uptrace/bun"
+)
+
+const (
+	// SweepInquiry means that a status inquiry told the status of the stuck payment
+	SweepInquiry = "inquiry"
+	// SweepResult means that the result of the payment arrived from the acquirer before it was swept
+	SweepResult = "result"
+	// SweepReview means that the inquiries did not tell the status of the payment, so it is left for an operator
+	SweepReview = "review"
+)
+
+// PaymentSweep schedules the status inquiries of a payment that is stuck processing on an acquirer. It is finished
+// once FinishedAt is set, and Outcome tells how.
+type PaymentSweep struct {
+	PaymentID uuid.UUID
+	Acquirer  string
+	// Attempts is the number of times the sweep was claimed, to send an inquiry to the acquirer
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	Outcome       string
+	FinishedAt    *time.Time
+	CreatedAt     *time.Time
+	UpdatedAt     *time.Time
+}
+
+// stuckPayments selects the payments that are processing since before stuckSince. Payments whose job is still
+// submitting them to the acquirer, or finished doing so after stuckSince, are not stuck yet.
+func (r *repo) stuckPayments(stuckSince time.Time) *bun.SelectQuery {
+	submitting := r.db.NewSelect().Model((*OutboxJob)(nil)).
+		ColumnExpr("1").
+		Where("outbox_job.resource_id = payment.id").
+		WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("outbox_job.status = ?", OutboxJobPending).WhereOr("outbox_job.finished_at > ?", stuckSince.UTC())
+		})
+	return r.db.NewSelect().Model((*Payment)(nil)).
+		Where("payment.payment_status = ?", status.PaymentProcessing).
+		Where("payment.updated_at <= ?", stuckSince.UTC()).
+		Where("NOT EXISTS (?)", submitting)
+}
+
+// CreatePaymentSweeps schedules a sweep for every payment that is stuck since before stuckSince, on the acquirer it
+// is processing on. Payments that were already swept on that acquirer are not swept again, even when they were left
+// for review. It returns how many sweeps it scheduled.
+func (r *repo) CreatePaymentSweeps(ctx context.Context, stuckSince time.Time) (int, error) {
+	swept := r.db.NewSelect().Model((*PaymentSweep)(nil)).
+		ColumnExpr("1").
+		Where("payment_sweep.payment_id = payment.id").
+		Where("payment_sweep.acquirer = payment.acquirer")
+	payments := make([]Payment, 0)
+	err := r.stuckPayments(stuckSince).Where("NOT EXISTS (?)", swept).Order("payment.updated_at ASC").Scan(ctx, &payments)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	for _, payment := range payments {
+		_, err = r.db.NewInsert().Model(&PaymentSweep{
+			PaymentID:     payment.ID,
+			Acquirer:      payment.Acquirer,
+			NextAttemptAt: now,
+		}).On("CONFLICT (payment_id, acquirer) DO NOTHING").Exec(ctx)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(payments), nil
+}
+
+// CountStuckPayments returns how many payments are stuck since before stuckSince, and how many of the stuck payments
+// were left for review.
+func (r *repo) CountStuckPayments(ctx context.Context, stuckSince time.Time) (int, int, error) {
+	stuck, err := r.stuckPayments(stuckSince).Count(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	review, err := r.db.NewSelect().Model((*PaymentSweep)(nil)).
+		Join("JOIN payments AS payment ON payment.id = payment_sweep.payment_id AND payment.acquirer = payment_sweep.acquirer").
+		Where("payment_sweep.outcome = ?", SweepReview).
+		Where("payment.payment_status = ?", status.PaymentProcessing).
+		Count(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	return stuck, review, nil
+}
+
+// ClaimDuePaymentSweep claims the unfinished sweep that is due the longest, counting the attempt and hiding it from the
+// other sweepers until the visibility timeout passes. Sweeps claimed at the same time by other sweepers are skipped.
+// It returns sql.ErrNoRows when no sweep is due.
+func (r *repo) ClaimDuePaymentSweep(ctx context.Context, now time.Time, visibilityTimeout time.Duration) (*PaymentSweep, error) {
+	var sweep PaymentSweep
+	due := r.db.NewSelect().Model((*PaymentSweep)(nil)).
+		Column("payment_id", "acquirer").
+		Where("finished_at IS NULL").
+		Where("next_attempt_at <= ?", now.UTC()).
+		Order("next_attempt_at ASC").
+		Limit(1).
+		For("UPDATE SKIP LOCKED")
+	res, err := r.db.NewUpdate().Model(&sweep).
+		Set("attempts = attempts + 1").
+		Set("next_attempt_at = ?", now.Add(visibilityTimeout).UTC()).
+		Set("updated_at = ?", now.UTC()).
+		Where("(payment_id, acquirer) = (?)", due).
+		Returning("*").
+		Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &sweep, nil
+}
+
+// UpdatePaymentSweep writes the result of the inquiry the sweep was claimed for. It returns sql.ErrNoRows when the sweep
+// was claimed again since then, because the visibility timeout passed.
+func (r *repo) UpdatePaymentSweep(ctx context.Context, sweep *PaymentSweep) error {
+	now := time.Now()
+	sweep.UpdatedAt = &now
+	res, err := r.db.NewUpdate().Model(sweep).
+		Where("payment_id = ?", sweep.PaymentID).
+		Where("acquirer = ?", sweep.Acquirer).
+		Where("attempts = ?", sweep.Attempts).
+		Column("next_attempt_at", "last_error", "outcome", "finished_at", "updated_at").
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetPaymentSweeps returns the sweeps of the payment, in the order they were scheduled.
+func (r *repo) GetPaymentSweeps(ctx context.Context, paymentID uuid.UUID) ([]PaymentSweep, error) {
+	sweeps := make([]PaymentSweep, 0)
+	err := r.db.NewSelect().Model(&sweeps).Where("payment_id = ?", paymentID).Order("created_at ASC").Scan(ctx)
+	return sweeps, err
+}
@@ -2,22 +2,40 @@ package repositiory
 
 import (
 	"context"
+	"database/sql"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"github.com/uptrace/bun"
 )
 
 type Payment struct {
 	// ID is the unique Payment ID that globally identifies this Payment, this will be our idempotency key
 	ID uuid.UUID
 	// Amount is the amount that we need to charge to the given card fo this transaction.
-	Amount        int64
-	MerchantID    uuid.UUID
-	PaymentStatus string
-	FailedReason  string
-	CurrencyCode  string
+	Amount     int64
+	MerchantID uuid.UUID
+	// Reference is the id the merchant uses for this payment on their side, unique for each merchant
+	Reference     *string
+	PaymentStatus status.PaymentStatus
+	// Decline is stored as jsonb, and it is only set on failed payments
+	Decline      *decline.Decline
+	CurrencyCode string
+	// CaptureMethod is either automatic or manual
+	CaptureMethod string
+	// AmountCaptured is the amount captured on a manual capture payment
+	AmountCaptured int64
 	// Description describes the reason why we are charging this given card
 	Description string
+	// Metadata is stored as jsonb, so payments can be searched by its keys and values
+	Metadata map[string]string
+	// Acquirer is the name of the acquirer that processes the payment, empty for the default acquirer
+	Acquirer string
+	// RoutingRule is the name of the rule that chose the acquirer, empty when no rule matched the payment
+	RoutingRule string
 	// CardName represents the name displayed on the card
 	CardName string
 	// CardNumber represents the credit/debit card number
@@ -26,19 +44,124 @@ type Payment struct {
 	CardExpiryMonth int
 	// CardExpiryYear is the year that the card expires
 	CardExpiryYear int
-	CreatedAt      *time.Time
-	UpdatedAt      *time.Time
+	// AcquirerReference is stored as jsonb, and it is only set once an acquirer that gives it authorizes the payment
+	AcquirerReference *AcquirerReference
+	CreatedAt         *time.Time
+	UpdatedAt         *time.Time
+}
+
+// AcquirerReference identifies the authorization of a payment on an acquirer that uses ISO 8583, so the completion and
+// the reversal of the payment can refer to it.
+type AcquirerReference struct {
+	// MTI is the message type of the authorization request, 0100 or 0200
+	MTI  string `json:"mti"`
+	STAN string `json:"stan"`
+	RRN  string `json:"rrn"`
+	// TransmissionDateTime is field 7 of the authorization request, MMDDhhmmss in UTC
+	TransmissionDateTime string `json:"transmission_date_time"`
+	AuthorizationCode    string `json:"authorization_code,omitempty"`
 }
 
 func (r *repo) CreatePayment(ctx context.Context, payment *Payment) error {
+	// A nil map would be stored as a json null, instead of an empty object
+	if payment.Metadata == nil {
+		payment.Metadata = map[string]string{}
+	}
 	_, err := r.db.NewInsert().Model(payment).Exec(ctx)
 	return err
 }
 
+// GetPaymentByReference returns the payment of the merchant with the given reference.
+func (r *repo) GetPaymentByReference(ctx context.Context, merchantID uuid.UUID, reference string) (*Payment, error) {
+	var payment Payment
+	_, err := r.db.NewSelect().Model(&payment).
+		Where("merchant_id = ?", merchantID).
+		Where("reference = ?", reference).
+		Exec(ctx, &payment)
+	return &payment, err
+}
+
+// PaymentFilter narrows down the payments returned by ListPayments. Zero values are not used as filters.
+type PaymentFilter struct {
+	MerchantID    uuid.UUID
+	PaymentStatus status.PaymentStatus
+	CurrencyCode  string
+	AmountFrom    *int64
+	AmountTo      *int64
+	CreatedFrom   *time.Time
+	CreatedTo     *time.Time
+	CardLast4     string
+	// Metadata matches payments that contain all of its keys, with the same values
+	Metadata map[string]string
+	// Description matches payments whose description contains it, ignoring the case
+	Description string
+	// After is the last payment of the previous page, when there is one
+	After *PaymentCursor
+	Limit int
+}
+
+// PaymentCursor is the position of a payment when ordering by creation time, newest first.
+type PaymentCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern, so the user input is matched literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// ListPayments returns the payments of a merchant that match the filter, newest first.
+// Payments created at the same time are ordered by their id, so the order is stable across pages.
+func (r *repo) ListPayments(ctx context.Context, filter PaymentFilter) ([]Payment, error) {
+	payments := make([]Payment, 0, filter.Limit)
+	q := r.db.NewSelect().Model(&payments).
+		Where("merchant_id = ?", filter.MerchantID).
+		OrderExpr("created_at DESC, id DESC").
+		Limit(filter.Limit)
+	if filter.PaymentStatus != "" {
+		q = q.Where("payment_status = ?", filter.PaymentStatus)
+	}
+	if filter.CurrencyCode != "" {
+		q = q.Where("currency_code = ?", filter.CurrencyCode)
+	}
+	if filter.AmountFrom != nil {
+		q = q.Where("amount >= ?", *filter.AmountFrom)
+	}
+	if filter.AmountTo != nil {
+		q = q.Where("amount <= ?", *filter.AmountTo)
+	}
+	if filter.CreatedFrom != nil {
+		q = q.Where("created_at >= ?", filter.CreatedFrom.UTC())
+	}
+	if filter.CreatedTo != nil {
+		q = q.Where("created_at < ?", filter.CreatedTo.UTC())
+	}
+	if filter.CardLast4 != "" {
+		q = q.Where("right(card_number, 4) = ?", filter.CardLast4)
+	}
+	if len(filter.Metadata) > 0 {
+		q = q.Where("metadata @> ?", filter.Metadata)
+	}
+	if filter.Description != "" {
+		q = q.Where("description ILIKE ?", "%"+likeEscaper.Replace(filter.Description)+"%")
+	}
+	if filter.After != nil {
+		q = q.Where("(created_at, id) < (?, ?)", filter.After.CreatedAt.UTC(), filter.After.ID)
+	}
+	err := q.Scan(ctx)
+	return payments, err
+}
+
+// UpdateStatus moves the payment to its PaymentStatus, along with its decline and acquirer reference.
+// It returns status.ErrIllegalTransition when the transition table does not allow the move from the current status.
 func (r *repo) UpdateStatus(ctx context.Context, payment *Payment) error {
+	return r.transitionPayment(ctx, payment, nil, "decline", "acquirer_reference")
+}
+
+// UpdateRoute writes the acquirer and routing rule of the payment, when it cascades to a fallback acquirer.
+func (r *repo) UpdateRoute(ctx context.Context, payment *Payment) error {
 	now := time.Now()
 	payment.UpdatedAt = &now
-	_, err := r.db.NewUpdate().Model(payment).Where("id = ? AND payment_status = ?", payment.ID, "processing").Column("payment_status", "updated_at", "failed_reason").Exec(ctx)
+	_, err := r.db.NewUpdate().Model(payment).Where("id = ?", payment.ID).Column("acquirer", "routing_rule", "updated_at").Exec(ctx)
 	return err
 }
 
@@ -47,3 +170,104 @@ func (r *repo) GetPaymentByID(ctx context.Context, id uuid.UUID) (*Payment, erro
 	_, err := r.db.NewSelect().Model(&payment).Where("id = ?", id).Exec(ctx, &payment)
 	return &payment, err
 }
+
+// GetMerchantPaymentByID returns the payment with the given id, only if it belongs to the given merchant.
+func (r *repo) GetMerchantPaymentByID(ctx context.Context, merchantID uuid.UUID, id uuid.UUID) (*Payment, error) {
+	var payment Payment
+	_, err := r.db.NewSelect().Model(&payment).
+		Where("id = ?", id).
+		Where("merchant_id = ?", merchantID).
+		Exec(ctx, &payment)
+	return &payment, err
+}
+
+// GetPaymentByIDForUpdate locks the payment row until the transaction is finished.
+// It should be used on a transaction, when operations depend on the current state of the payment.
+func (r *repo) GetPaymentByIDForUpdate(ctx context.Context, id uuid.UUID) (*Payment, error) {
+	var payment Payment
+	_, err := r.db.NewSelect().Model(&payment).Where("id = ?", id).For("UPDATE").Exec(ctx, &payment)
+	return &payment, err
+}
+
+// CapturePayment records the amount that is being captured for an authorized payment.
+// Only a single capture is allowed, so it returns sql.ErrNoRows when a capture was already submitted.
+func (r *repo) CapturePayment(ctx context.Context, payment *Payment) error {
+	now := time.Now()
+	payment.UpdatedAt = &now
+	res, err := r.db.NewUpdate().Model(payment).Where("id = ? AND payment_status = ? AND amount_captured = 0", payment.ID, status.PaymentAuthorized).Column("amount_captured", "updated_at").Exec(ctx)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// UpdateCaptureStatus moves an authorized payment to captured, after the acquiring bank processed its capture.
+func (r *repo) UpdateCaptureStatus(ctx context.Context, payment *Payment) error {
+	return r.transitionPayment(ctx, payment, nil, "amount_captured", "decline")
+}
+
+// FailCapture clears the captured amount of an authorized payment when the acquiring bank fails to capture it,
+// so the merchant can try to capture it again. The status of the payment does not change.
+func (r *repo) FailCapture(ctx context.Context, payment *Payment) error {
+	now := time.Now()
+	payment.UpdatedAt = &now
+	payment.AmountCaptured = 0
+	_, err := r.db.NewUpdate().Model(payment).Where("id = ? AND payment_status = ?", payment.ID, status.PaymentAuthorized).Column("amount_captured", "updated_at", "decline").Exec(ctx)
+	return err
+}
+
+// CancelPayment moves a payment that is still processing, or an authorized payment that has not been captured, to canceled.
+// It returns status.ErrIllegalTransition when the payment can not be canceled anymore.
+func (r *repo) CancelPayment(ctx context.Context, payment *Payment) error {
+	payment.PaymentStatus = status.PaymentCanceled
+	return r.transitionPayment(ctx, payment, func(q *bun.UpdateQuery) *bun.UpdateQuery {
+		return q.Where("amount_captured = 0")
+	})
+}
+
+// transitionPayment writes the PaymentStatus of the payment along with the given columns, only when the current status
+// is allowed to move to it. The check is done on the update itself, so concurrent writers can not both succeed.
+func (r *repo) transitionPayment(ctx context.Context, payment *Payment, where func(q *bun.UpdateQuery) *bun.UpdateQuery, columns ...string) error {
+	now := time.Now()
+	payment.UpdatedAt = &now
+	from := status.PaymentStatusesBefore(payment.PaymentStatus)
+	if len(from) == 0 {
+		return r.paymentTransitionError(ctx, payment)
+	}
+	q := r.db.NewUpdate().Model(payment).
+		Where("id = ?", payment.ID).
+		Where("payment_status IN (?)", bun.In(from)).
+		Column(append(columns, "payment_status", "updated_at")...)
+	if where != nil {
+		q = where(q)
+	}
+	res, err := q.Exec(ctx)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return r.paymentTransitionError(ctx, payment)
+	}
+	return nil
+}
+
+// paymentTransitionError returns the error for a rejected transition, or sql.ErrNoRows when the payment does not exist.
+func (r *repo) paymentTransitionError(ctx context.Context, payment *Payment) error {
+	var current status.PaymentStatus
+	err := r.db.NewSelect().Model((*Payment)(nil)).Column("payment_status").Where("id = ?", payment.ID).Scan(ctx, &current)
+	if err != nil {
+		return err
+	}
+	return status.PaymentTransitionError(current, payment.PaymentStatus)
+}
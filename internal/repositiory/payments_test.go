@@ -5,11 +5,14 @@ import (
 	"database/sql"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
 	"github.com/marioarizaj/payment-gateway/internal/dependencies"
 	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/internal/status"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -155,6 +158,309 @@ func TestRepo_GetPaymentByID(t *testing.T) {
 	}
 }
 
+func TestRepo_GetMerchantPaymentByID(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	cases := []struct {
+		name          string
+		merchantID    uuid.UUID
+		expectedError error
+	}{
+		{
+			name:       "get_merchant_payment_success",
+			merchantID: uuid.Must(uuid.Parse("6c5a19d0-f132-4a55-93d3-2c00db06d41b")),
+		}, {
+			name:          "get_payment_of_other_merchant",
+			merchantID:    uuid.Must(uuid.Parse("a1e3f405-44f0-44b4-a584-b0b3c80bc8ac")),
+			expectedError: errors.New("sql: no rows in result set"),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			tx, err := deps.DB.BeginTx(ctx, &sql.TxOptions{})
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer func() { _ = tx.Rollback() }()
+			repo := repositiory.NewRepository(tx)
+			testPayment := &repositiory.Payment{
+				ID:              uuid.Must(uuid.Parse("b5f9c307-5202-4c52-aba9-752167eef9bf")),
+				Amount:          2000,
+				MerchantID:      uuid.Must(uuid.Parse("6c5a19d0-f132-4a55-93d3-2c00db06d41b")),
+				CurrencyCode:    "USD",
+				PaymentStatus:   "processing",
+				Description:     "Payment test",
+				CardName:        "Mario Arizaj",
+				CardNumber:      "378282246310005",
+				CardExpiryMonth: 10,
+				CardExpiryYear:  22,
+			}
+			err = InsertTestPayment(repo, testPayment)
+			if !assert.NoError(t, err) {
+				return
+			}
+			payment, err := repo.GetMerchantPaymentByID(ctx, c.merchantID, testPayment.ID)
+			if c.expectedError != nil {
+				if assert.Error(t, err) {
+					assert.Equal(t, c.expectedError.Error(), err.Error())
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testPayment.ID, payment.ID)
+		})
+	}
+}
+
+func TestRepo_GetPaymentByReference(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	reference := "order-1001"
+	newPayment := func(id string, merchantID string) *repositiory.Payment {
+		return &repositiory.Payment{
+			ID:              uuid.Must(uuid.Parse(id)),
+			Amount:          2000,
+			PaymentStatus:   "processing",
+			MerchantID:      uuid.Must(uuid.Parse(merchantID)),
+			Reference:       &reference,
+			CurrencyCode:    "USD",
+			Description:     "Payment test",
+			CardName:        "Mario Arizaj",
+			CardNumber:      "378282246310005",
+			CardExpiryMonth: 10,
+			CardExpiryYear:  22,
+		}
+	}
+	cases := []struct {
+		name              string
+		payments          []*repositiory.Payment
+		merchantID        uuid.UUID
+		expectedID        uuid.UUID
+		expectedError     error
+		expectedInsertErr error
+	}{
+		{
+			name:       "get_payment_by_reference_success",
+			payments:   []*repositiory.Payment{newPayment("b5f9c307-5202-4c52-aba9-752167eef9bf", "6c5a19d0-f132-4a55-93d3-2c00db06d41b")},
+			merchantID: uuid.Must(uuid.Parse("6c5a19d0-f132-4a55-93d3-2c00db06d41b")),
+			expectedID: uuid.Must(uuid.Parse("b5f9c307-5202-4c52-aba9-752167eef9bf")),
+		},
+		{
+			name: "same_reference_for_different_merchants",
+			payments: []*repositiory.Payment{
+				newPayment("b5f9c307-5202-4c52-aba9-752167eef9bf", "6c5a19d0-f132-4a55-93d3-2c00db06d41b"),
+				newPayment("c5693980-e5f1-4a20-8a2b-bd13ce9f460f", "a1e3f405-44f0-44b4-a584-b0b3c80bc8ac"),
+			},
+			merchantID: uuid.Must(uuid.Parse("a1e3f405-44f0-44b4-a584-b0b3c80bc8ac")),
+			expectedID: uuid.Must(uuid.Parse("c5693980-e5f1-4a20-8a2b-bd13ce9f460f")),
+		},
+		{
+			name:          "get_payment_by_reference_of_other_merchant",
+			payments:      []*repositiory.Payment{newPayment("b5f9c307-5202-4c52-aba9-752167eef9bf", "6c5a19d0-f132-4a55-93d3-2c00db06d41b")},
+			merchantID:    uuid.Must(uuid.Parse("a1e3f405-44f0-44b4-a584-b0b3c80bc8ac")),
+			expectedError: errors.New("sql: no rows in result set"),
+		},
+		{
+			name: "duplicate_reference_for_same_merchant",
+			payments: []*repositiory.Payment{
+				newPayment("b5f9c307-5202-4c52-aba9-752167eef9bf", "6c5a19d0-f132-4a55-93d3-2c00db06d41b"),
+				newPayment("c5693980-e5f1-4a20-8a2b-bd13ce9f460f", "6c5a19d0-f132-4a55-93d3-2c00db06d41b"),
+			},
+			expectedInsertErr: errors.New("ERROR: duplicate key value violates unique constraint \"payments_merchant_id_reference_idx\" (SQLSTATE=23505)"),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			tx, err := deps.DB.BeginTx(ctx, &sql.TxOptions{})
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer func() { _ = tx.Rollback() }()
+			repo := repositiory.NewRepository(tx)
+			for _, p := range c.payments {
+				err = InsertTestPayment(repo, p)
+				if err != nil {
+					break
+				}
+			}
+			if c.expectedInsertErr != nil {
+				if assert.Error(t, err) {
+					assert.Equal(t, c.expectedInsertErr.Error(), err.Error())
+				}
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			payment, err := repo.GetPaymentByReference(ctx, c.merchantID, reference)
+			if c.expectedError != nil {
+				if assert.Error(t, err) {
+					assert.Equal(t, c.expectedError.Error(), err.Error())
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, c.expectedID, payment.ID)
+			assert.Equal(t, reference, *payment.Reference)
+		})
+	}
+}
+
+func TestRepo_ListPayments(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	merchantID := uuid.Must(uuid.Parse("6c5a19d0-f132-4a55-93d3-2c00db06d41b"))
+	createdAt := time.Date(2022, 7, 28, 10, 0, 0, 0, time.UTC)
+	newPayment := func(id string, minutes int, paymentStatus status.PaymentStatus, amount int64, currency, description, cardNumber string) *repositiory.Payment {
+		created := createdAt.Add(time.Duration(minutes) * time.Minute)
+		return &repositiory.Payment{
+			ID:              uuid.Must(uuid.Parse(id)),
+			Amount:          amount,
+			PaymentStatus:   paymentStatus,
+			MerchantID:      merchantID,
+			CurrencyCode:    currency,
+			Description:     description,
+			CardName:        "Mario Arizaj",
+			CardNumber:      cardNumber,
+			CardExpiryMonth: 10,
+			CardExpiryYear:  22,
+			CreatedAt:       &created,
+			UpdatedAt:       &created,
+		}
+	}
+	payments := []*repositiory.Payment{
+		newPayment("b5f9c307-5202-4c52-aba9-752167eef9bf", 0, "succeeded", 1000, "USD", "Order 100% cotton shirt", "378282246310005"),
+		newPayment("c5693980-e5f1-4a20-8a2b-bd13ce9f460f", 1, "failed", 2000, "EUR", "Order shoes", "4242424242424242"),
+		newPayment("d1b8a8f5-3c51-4a8b-9d26-1bd1c6b44b7e", 2, "succeeded", 3000, "USD", "Subscription", "4242424242424242"),
+	}
+	payments[0].Metadata = map[string]string{"cart_id": "1", "campaign": "summer"}
+	payments[2].Metadata = map[string]string{"campaign": "summer"}
+	amount := int64(2000)
+	from := createdAt.Add(time.Minute)
+
+	cases := []struct {
+		name        string
+		filter      repositiory.PaymentFilter
+		expectedIDs []uuid.UUID
+	}{
+		{
+			name:        "list_all_newest_first",
+			filter:      repositiory.PaymentFilter{Limit: 10},
+			expectedIDs: []uuid.UUID{payments[2].ID, payments[1].ID, payments[0].ID},
+		},
+		{
+			name:        "list_with_limit",
+			filter:      repositiory.PaymentFilter{Limit: 2},
+			expectedIDs: []uuid.UUID{payments[2].ID, payments[1].ID},
+		},
+		{
+			name: "list_after_cursor",
+			filter: repositiory.PaymentFilter{
+				Limit: 10,
+				After: &repositiory.PaymentCursor{CreatedAt: *payments[1].CreatedAt, ID: payments[1].ID},
+			},
+			expectedIDs: []uuid.UUID{payments[0].ID},
+		},
+		{
+			name:        "filter_by_status_and_currency",
+			filter:      repositiory.PaymentFilter{Limit: 10, PaymentStatus: "succeeded", CurrencyCode: "USD"},
+			expectedIDs: []uuid.UUID{payments[2].ID, payments[0].ID},
+		},
+		{
+			name:        "filter_by_amount_range",
+			filter:      repositiory.PaymentFilter{Limit: 10, AmountFrom: &amount, AmountTo: &amount},
+			expectedIDs: []uuid.UUID{payments[1].ID},
+		},
+		{
+			name:        "filter_by_created_at_range",
+			filter:      repositiory.PaymentFilter{Limit: 10, CreatedFrom: &from},
+			expectedIDs: []uuid.UUID{payments[2].ID, payments[1].ID},
+		},
+		{
+			name:        "filter_by_card_last4",
+			filter:      repositiory.PaymentFilter{Limit: 10, CardLast4: "0005"},
+			expectedIDs: []uuid.UUID{payments[0].ID},
+		},
+		{
+			name:        "filter_by_description_ignores_case",
+			filter:      repositiory.PaymentFilter{Limit: 10, Description: "order"},
+			expectedIDs: []uuid.UUID{payments[1].ID, payments[0].ID},
+		},
+		{
+			name:        "filter_by_description_matches_wildcards_literally",
+			filter:      repositiory.PaymentFilter{Limit: 10, Description: "100%"},
+			expectedIDs: []uuid.UUID{payments[0].ID},
+		},
+		{
+			name:        "filter_by_metadata",
+			filter:      repositiory.PaymentFilter{Limit: 10, Metadata: map[string]string{"campaign": "summer"}},
+			expectedIDs: []uuid.UUID{payments[2].ID, payments[0].ID},
+		},
+		{
+			name:        "filter_by_multiple_metadata_keys",
+			filter:      repositiory.PaymentFilter{Limit: 10, Metadata: map[string]string{"campaign": "summer", "cart_id": "1"}},
+			expectedIDs: []uuid.UUID{payments[0].ID},
+		},
+		{
+			name:        "list_of_other_merchant",
+			filter:      repositiory.PaymentFilter{Limit: 10, MerchantID: uuid.Must(uuid.Parse("a1e3f405-44f0-44b4-a584-b0b3c80bc8ac"))},
+			expectedIDs: []uuid.UUID{},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			tx, err := deps.DB.BeginTx(ctx, &sql.TxOptions{})
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer func() { _ = tx.Rollback() }()
+			repo := repositiory.NewRepository(tx)
+			for _, p := range payments {
+				err = InsertTestPayment(repo, p)
+				if !assert.NoError(t, err) {
+					return
+				}
+			}
+			if c.filter.MerchantID == uuid.Nil {
+				c.filter.MerchantID = merchantID
+			}
+			actual, err := repo.ListPayments(ctx, c.filter)
+			if !assert.NoError(t, err) {
+				return
+			}
+			actualIDs := make([]uuid.UUID, 0, len(actual))
+			for _, p := range actual {
+				actualIDs = append(actualIDs, p.ID)
+			}
+			assert.Equal(t, c.expectedIDs, actualIDs)
+		})
+	}
+}
+
 func TestRepo_UpdateStatus(t *testing.T) {
 	cfg, err := config.LoadConfig()
 	if !assert.NoError(t, err) {
@@ -167,8 +473,9 @@ func TestRepo_UpdateStatus(t *testing.T) {
 	cases := []struct {
 		name               string
 		idToSearch         uuid.UUID
-		newStatus          string
-		newReason          string
+		newStatus          status.PaymentStatus
+		newDecline         *decline.Decline
+		newReference       *repositiory.AcquirerReference
 		payment            *repositiory.Payment
 		expectedError      error
 		shouldUpdateStatus bool
@@ -191,10 +498,36 @@ func TestRepo_UpdateStatus(t *testing.T) {
 			},
 			idToSearch: uuid.Must(uuid.Parse("b5f9c307-5202-4c52-aba9-752167eef9bf")),
 		},
+		{
+			name:      "updates_authorized_payment_with_acquirer_reference",
+			newStatus: "authorized",
+			newReference: &repositiory.AcquirerReference{
+				MTI:                  "0100",
+				STAN:                 "000042",
+				RRN:                  "224509000042",
+				TransmissionDateTime: "0902091530",
+				AuthorizationCode:    "123456",
+			},
+			shouldUpdateStatus: true,
+			payment: &repositiory.Payment{
+				ID:              uuid.Must(uuid.Parse("b5f9c307-5202-4c52-aba9-752167eef9bf")),
+				Amount:          2000,
+				MerchantID:      uuid.Must(uuid.Parse("6c5a19d0-f132-4a55-93d3-2c00db06d41b")),
+				CurrencyCode:    "USD",
+				PaymentStatus:   "processing",
+				CaptureMethod:   "manual",
+				Description:     "Payment test",
+				CardName:        "Mario Arizaj",
+				CardNumber:      "378282246310005",
+				CardExpiryMonth: 10,
+				CardExpiryYear:  22,
+			},
+			idToSearch: uuid.Must(uuid.Parse("b5f9c307-5202-4c52-aba9-752167eef9bf")),
+		},
 		{
 			name:               "updates_payment_to_failed_with_status",
 			newStatus:          "failed",
-			newReason:          "failed...",
+			newDecline:         decline.FromResponseCode("05"),
 			shouldUpdateStatus: true,
 			payment: &repositiory.Payment{
 				ID:              uuid.Must(uuid.Parse("b5f9c307-5202-4c52-aba9-752167eef9bf")),
@@ -210,14 +543,34 @@ func TestRepo_UpdateStatus(t *testing.T) {
 			},
 			idToSearch: uuid.Must(uuid.Parse("b5f9c307-5202-4c52-aba9-752167eef9bf")),
 		},
+		{
+			name:               "does_not_update_payment_to_unknown_status",
+			newStatus:          "success",
+			shouldUpdateStatus: false,
+			expectedError:      errors.New("illegal status transition: payment from processing to success"),
+			payment: &repositiory.Payment{
+				ID:              uuid.Must(uuid.Parse("b5f9c307-5202-4c52-aba9-752167eef9bf")),
+				Amount:          2000,
+				PaymentStatus:   "processing",
+				MerchantID:      uuid.Must(uuid.Parse("6c5a19d0-f132-4a55-93d3-2c00db06d41b")),
+				CurrencyCode:    "USD",
+				Description:     "Payment test",
+				CardName:        "Mario Arizaj",
+				CardNumber:      "378282246310005",
+				CardExpiryMonth: 10,
+				CardExpiryYear:  22,
+			},
+			idToSearch: uuid.Must(uuid.Parse("b5f9c307-5202-4c52-aba9-752167eef9bf")),
+		},
 		{
 			name:               "does_not_update_payment_with_success_status",
 			newStatus:          "failed",
 			shouldUpdateStatus: false,
+			expectedError:      errors.New("illegal status transition: payment from succeeded to failed"),
 			payment: &repositiory.Payment{
 				ID:              uuid.Must(uuid.Parse("b5f9c307-5202-4c52-aba9-752167eef9bf")),
 				Amount:          2000,
-				PaymentStatus:   "success",
+				PaymentStatus:   "succeeded",
 				MerchantID:      uuid.Must(uuid.Parse("6c5a19d0-f132-4a55-93d3-2c00db06d41b")),
 				CurrencyCode:    "USD",
 				Description:     "Payment test",
@@ -245,10 +598,14 @@ func TestRepo_UpdateStatus(t *testing.T) {
 			}
 			updatedPayment := *c.payment
 			updatedPayment.PaymentStatus = c.newStatus
-			updatedPayment.FailedReason = c.newReason
+			updatedPayment.Decline = c.newDecline
+			updatedPayment.AcquirerReference = c.newReference
 			err = repo.UpdateStatus(ctx, &updatedPayment)
 			if c.expectedError != nil {
-				assert.Equal(t, c.expectedError.Error(), err.Error())
+				if assert.Error(t, err) {
+					assert.Equal(t, c.expectedError.Error(), err.Error())
+				}
+			} else if !assert.NoError(t, err) {
 				return
 			}
 			payment, err := repo.GetPaymentByID(ctx, c.payment.ID)
@@ -257,10 +614,11 @@ func TestRepo_UpdateStatus(t *testing.T) {
 			}
 			if c.shouldUpdateStatus {
 				assert.Equal(t, c.newStatus, payment.PaymentStatus)
-				assert.Equal(t, c.newReason, payment.FailedReason)
+				assert.Equal(t, c.newDecline, payment.Decline)
+				assert.Equal(t, c.newReference, payment.AcquirerReference)
 			} else {
 				assert.Equal(t, c.payment.PaymentStatus, payment.PaymentStatus)
-				assert.Equal(t, c.payment.FailedReason, payment.FailedReason)
+				assert.Equal(t, c.payment.Decline, payment.Decline)
 			}
 		})
 	}
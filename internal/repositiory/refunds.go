@@ -0,0 +1,90 @@
+package repositiory
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"github.com/uptrace/bun"
+)
+
+type Refund struct {
+	// ID is the unique Refund ID that globally identifies this Refund, this will be our idempotency key
+	ID uuid.UUID
+	// PaymentID is the Payment that this Refund returns money from
+	PaymentID  uuid.UUID
+	MerchantID uuid.UUID
+	// Amount is the amount that we need to return to the card of the Payment.
+	Amount       int64
+	CurrencyCode string
+	RefundStatus status.RefundStatus
+	Decline      *decline.Decline
+	// Reason describes why the money is being returned
+	Reason    string
+	CreatedAt *time.Time
+	UpdatedAt *time.Time
+}
+
+func (r *repo) CreateRefund(ctx context.Context, refund *Refund) error {
+	_, err := r.db.NewInsert().Model(refund).Exec(ctx)
+	return err
+}
+
+// UpdateRefundStatus moves the refund to its RefundStatus, along with its decline.
+// It returns status.ErrIllegalTransition when the transition table does not allow the move from the current status.
+func (r *repo) UpdateRefundStatus(ctx context.Context, refund *Refund) error {
+	now := time.Now()
+	refund.UpdatedAt = &now
+	from := status.RefundStatusesBefore(refund.RefundStatus)
+	if len(from) == 0 {
+		return r.refundTransitionError(ctx, refund)
+	}
+	res, err := r.db.NewUpdate().Model(refund).
+		Where("id = ?", refund.ID).
+		Where("refund_status IN (?)", bun.In(from)).
+		Column("refund_status", "updated_at", "decline").Exec(ctx)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return r.refundTransitionError(ctx, refund)
+	}
+	return nil
+}
+
+func (r *repo) GetRefundByID(ctx context.Context, id uuid.UUID) (*Refund, error) {
+	var refund Refund
+	_, err := r.db.NewSelect().Model(&refund).Where("id = ?", id).Exec(ctx, &refund)
+	return &refund, err
+}
+
+// GetRefundsByPaymentID returns all refunds of a payment, oldest first.
+func (r *repo) GetRefundsByPaymentID(ctx context.Context, paymentID uuid.UUID) ([]Refund, error) {
+	refunds := make([]Refund, 0)
+	err := r.db.NewSelect().Model(&refunds).Where("payment_id = ?", paymentID).Order("created_at ASC").Scan(ctx)
+	return refunds, err
+}
+
+// GetRefundedAmount returns the sum of all refunds of a payment that have not failed.
+// Refunds that are still processing are counted, since the bank might still accept them.
+func (r *repo) GetRefundedAmount(ctx context.Context, paymentID uuid.UUID) (int64, error) {
+	var amount int64
+	err := r.db.NewSelect().Model((*Refund)(nil)).ColumnExpr("COALESCE(SUM(amount), 0)").Where("payment_id = ? AND refund_status != ?", paymentID, status.RefundFailed).Scan(ctx, &amount)
+	return amount, err
+}
+
+// refundTransitionError returns the error for a rejected transition, or sql.ErrNoRows when the refund does not exist.
+func (r *repo) refundTransitionError(ctx context.Context, refund *Refund) error {
+	var current status.RefundStatus
+	err := r.db.NewSelect().Model((*Refund)(nil)).Column("refund_status").Where("id = ?", refund.ID).Scan(ctx, &current)
+	if err != nil {
+		return err
+	}
+	return status.RefundTransitionError(current, refund.RefundStatus)
+}
@@ -0,0 +1,106 @@
+package repositiory_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/dependencies"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/stretchr/testify/assert"
+)
+
+var testRefundPayment = &repositiory.Payment{
+	ID:              uuid.Must(uuid.Parse("b5f9c307-5202-4c52-aba9-752167eef9bf")),
+	Amount:          2000,
+	PaymentStatus:   "succeeded",
+	MerchantID:      uuid.Must(uuid.Parse("6c5a19d0-f132-4a55-93d3-2c00db06d41b")),
+	CurrencyCode:    "USD",
+	Description:     "Payment test",
+	CardName:        "Mario Arizaj",
+	CardNumber:      "378282246310005",
+	CardExpiryMonth: 10,
+	CardExpiryYear:  22,
+}
+
+func TestRepo_GetRefundedAmount(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	cases := []struct {
+		name           string
+		refunds        []*repositiory.Refund
+		expectedAmount int64
+	}{
+		{
+			name:           "no_refunds",
+			expectedAmount: 0,
+		},
+		{
+			name: "failed_refunds_are_not_counted",
+			refunds: []*repositiory.Refund{
+				{
+					ID:           uuid.Must(uuid.Parse("0d3bd1f2-0b8e-4c3a-8d43-67b1dfd4c1a2")),
+					Amount:       500,
+					CurrencyCode: "USD",
+					RefundStatus: "succeeded",
+				},
+				{
+					ID:           uuid.Must(uuid.Parse("9f1c6a0e-7d55-4d6e-9a52-0c3b3f7d2c11")),
+					Amount:       700,
+					CurrencyCode: "USD",
+					RefundStatus: "processing",
+				},
+				{
+					ID:           uuid.Must(uuid.Parse("3c1e5a4b-51a4-4c0e-9d1c-2a9b7f0e6d55")),
+					Amount:       300,
+					CurrencyCode: "USD",
+					RefundStatus: "failed",
+				},
+			},
+			expectedAmount: 1200,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			tx, err := deps.DB.BeginTx(ctx, &sql.TxOptions{})
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer func() { _ = tx.Rollback() }()
+			repo := repositiory.NewRepository(tx)
+			err = InsertTestPayment(repo, testRefundPayment)
+			if !assert.NoError(t, err) {
+				return
+			}
+			for _, r := range c.refunds {
+				r.PaymentID = testRefundPayment.ID
+				r.MerchantID = testRefundPayment.MerchantID
+				err = repo.CreateRefund(ctx, r)
+				if !assert.NoError(t, err) {
+					return
+				}
+			}
+			amount, err := repo.GetRefundedAmount(ctx, testRefundPayment.ID)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, c.expectedAmount, amount)
+
+			refunds, err := repo.GetRefundsByPaymentID(ctx, testRefundPayment.ID)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Len(t, refunds, len(c.refunds))
+		})
+	}
+}
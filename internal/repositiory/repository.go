@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/uptrace/bun"
@@ -12,7 +13,64 @@ import (
 type Repository interface {
 	CreatePayment(ctx context.Context, payment *Payment) error
 	GetPaymentByID(ctx context.Context, id uuid.UUID) (*Payment, error)
+	GetMerchantPaymentByID(ctx context.Context, merchantID uuid.UUID, id uuid.UUID) (*Payment, error)
+	GetPaymentByReference(ctx context.Context, merchantID uuid.UUID, reference string) (*Payment, error)
+	ListPayments(ctx context.Context, filter PaymentFilter) ([]Payment, error)
+	GetPaymentByIDForUpdate(ctx context.Context, id uuid.UUID) (*Payment, error)
 	UpdateStatus(ctx context.Context, payment *Payment) error
+	CapturePayment(ctx context.Context, payment *Payment) error
+	UpdateCaptureStatus(ctx context.Context, payment *Payment) error
+	FailCapture(ctx context.Context, payment *Payment) error
+	CancelPayment(ctx context.Context, payment *Payment) error
+	UpdateRoute(ctx context.Context, payment *Payment) error
+	CreatePaymentAttempt(ctx context.Context, attempt *PaymentAttempt) error
+	UpdatePaymentAttempt(ctx context.Context, attempt *PaymentAttempt) error
+	GetPaymentAttempts(ctx context.Context, paymentID uuid.UUID) ([]PaymentAttempt, error)
+	CreatePaymentResolution(ctx context.Context, resolution *PaymentResolution) error
+	ClaimDuePaymentResolution(ctx context.Context, now time.Time, visibilityTimeout time.Duration) (*PaymentResolution, error)
+	UpdatePaymentResolution(ctx context.Context, resolution *PaymentResolution) error
+	GetPaymentResolution(ctx context.Context, paymentID uuid.UUID) (*PaymentResolution, error)
+	CreatePaymentSweeps(ctx context.Context, stuckSince time.Time) (int, error)
+	CountStuckPayments(ctx context.Context, stuckSince time.Time) (int, int, error)
+	ClaimDuePaymentSweep(ctx context.Context, now time.Time, visibilityTimeout time.Duration) (*PaymentSweep, error)
+	UpdatePaymentSweep(ctx context.Context, sweep *PaymentSweep) error
+	GetPaymentSweeps(ctx context.Context, paymentID uuid.UUID) ([]PaymentSweep, error)
+	CreateOutboxJob(ctx context.Context, job *OutboxJob) error
+	ClaimOutboxJob(ctx context.Context, now time.Time, visibilityTimeout time.Duration) (*OutboxJob, error)
+	UpdateOutboxJob(ctx context.Context, job *OutboxJob) error
+	GetOutboxJobs(ctx context.Context, resourceID uuid.UUID) ([]OutboxJob, error)
+	CreateSettlementFile(ctx context.Context, file *SettlementFile, discrepancies []SettlementDiscrepancy) error
+	SettlementFileExists(ctx context.Context, name string) (bool, error)
+	GetSettlementFiles(ctx context.Context, limit int) ([]SettlementFile, error)
+	GetPaymentsByIDs(ctx context.Context, ids []uuid.UUID) ([]Payment, error)
+	GetSettledPayments(ctx context.Context, acquirers []string, from time.Time, to time.Time) ([]Payment, error)
+	ListSettlementDiscrepancies(ctx context.Context, filter DiscrepancyFilter) ([]SettlementDiscrepancy, error)
+	GetSettlementDiscrepancy(ctx context.Context, id uuid.UUID) (*SettlementDiscrepancy, error)
+	ResolveSettlementDiscrepancy(ctx context.Context, discrepancy *SettlementDiscrepancy) error
+	CreateRefund(ctx context.Context, refund *Refund) error
+	GetRefundByID(ctx context.Context, id uuid.UUID) (*Refund, error)
+	GetRefundsByPaymentID(ctx context.Context, paymentID uuid.UUID) ([]Refund, error)
+	GetRefundedAmount(ctx context.Context, paymentID uuid.UUID) (int64, error)
+	UpdateRefundStatus(ctx context.Context, refund *Refund) error
+	CreateWebhookEndpoint(ctx context.Context, endpoint *WebhookEndpoint) error
+	GetWebhookEndpoint(ctx context.Context, merchantID uuid.UUID, id uuid.UUID) (*WebhookEndpoint, error)
+	GetWebhookEndpoints(ctx context.Context, merchantID uuid.UUID) ([]WebhookEndpoint, error)
+	GetWebhookEndpointsForEvent(ctx context.Context, merchantID uuid.UUID, eventType string) ([]WebhookEndpoint, error)
+	UpdateWebhookEndpoint(ctx context.Context, endpoint *WebhookEndpoint) error
+	DeleteWebhookEndpoint(ctx context.Context, merchantID uuid.UUID, id uuid.UUID) error
+	CreateWebhookEvents(ctx context.Context, events []WebhookEvent) error
+	ClaimWebhookEvent(ctx context.Context, now time.Time, visibilityTimeout time.Duration) (*WebhookEvent, error)
+	UpdateWebhookEvent(ctx context.Context, event *WebhookEvent) error
+	GetWebhookEvents(ctx context.Context, endpointID uuid.UUID) ([]WebhookEvent, error)
+	CreateWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error
+	GetWebhookDeliveries(ctx context.Context, endpointID uuid.UUID, limit int) ([]WebhookDelivery, error)
+	CreateAcquirerEvent(ctx context.Context, event *AcquirerEvent) (bool, error)
+	CreateInboxMessage(ctx context.Context, message *InboxMessage) (bool, error)
+	GetInboxMessageForUpdate(ctx context.Context, id uuid.UUID) (*InboxMessage, error)
+	ClaimInboxMessage(ctx context.Context, now time.Time, visibilityTimeout time.Duration) (*InboxMessage, error)
+	MarkInboxMessageApplied(ctx context.Context, message *InboxMessage) error
+	UpdateInboxMessage(ctx context.Context, message *InboxMessage) error
+	GetInboxMessages(ctx context.Context, resourceID uuid.UUID) ([]InboxMessage, error)
 	Begin(ctx context.Context) (repo, error)
 	Rollback(ctx context.Context) error
 	Commit(ctx context.Context) error
@@ -0,0 +1,176 @@
+package repositiory
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"github.com/uptrace/bun"
+)
+
+const (
+	// DiscrepancyMissingInGateway is a line of the settlement file whose payment we do not have
+	DiscrepancyMissingInGateway = "missing_in_gateway"
+	// DiscrepancyMissingAtAcquirer is a payment we settled that is not on the settlement file of its acquirer
+	DiscrepancyMissingAtAcquirer = "missing_at_acquirer"
+	// DiscrepancyAmountMismatch is a line of the settlement file whose amount or currency is not the one of its payment
+	DiscrepancyAmountMismatch = "amount_mismatch"
+	// DiscrepancyStatusMismatch is a line of the settlement file whose status is not the one of its payment
+	DiscrepancyStatusMismatch = "status_mismatch"
+	// DiscrepancyAcquirerMismatch is a line of the settlement file whose payment was sent to another acquirer
+	DiscrepancyAcquirerMismatch = "acquirer_mismatch"
+	// DiscrepancyDuplicateLine is a line of the settlement file whose payment is on an earlier line of the file
+	DiscrepancyDuplicateLine = "duplicate_line"
+
+	// DiscrepancyOpen is the status of the discrepancies that an operator still needs to look at
+	DiscrepancyOpen = "open"
+	// DiscrepancyResolved is the status of the discrepancies that an operator resolved
+	DiscrepancyResolved = "resolved"
+)
+
+// SettlementFile is a settlement file of an acquirer that was imported, with the payments it settled on a day.
+type SettlementFile struct {
+	ID uuid.UUID
+	// Name is the name of the file, which is only imported once
+	Name           string
+	Acquirer       string
+	SettlementDate time.Time
+	LineCount      int
+	// DiscrepancyCount is the number of discrepancies found when the file was imported
+	DiscrepancyCount int
+	CreatedAt        *time.Time
+}
+
+// SettlementDiscrepancy is a difference between a settlement file and the payments. The gateway fields are empty for
+// payments that we do not have, and the acquirer fields for payments that are not on the file.
+type SettlementDiscrepancy struct {
+	ID               uuid.UUID
+	SettlementFileID uuid.UUID
+	PaymentID        uuid.UUID
+	Kind             string
+	GatewayAmount    int64
+	GatewayCurrency  string
+	GatewayStatus    string
+	AcquirerAmount   int64
+	AcquirerCurrency string
+	AcquirerStatus   string
+	Status           string
+	// ResolutionNote is written by the operator that resolved the discrepancy
+	ResolutionNote string
+	ResolvedAt     *time.Time
+	CreatedAt      *time.Time
+	UpdatedAt      *time.Time
+}
+
+// DiscrepancyFilter narrows down the discrepancies returned by ListSettlementDiscrepancies. Zero values are not used as filters.
+type DiscrepancyFilter struct {
+	Status           string
+	Kind             string
+	SettlementFileID uuid.UUID
+	Limit            int
+}
+
+// CreateSettlementFile stores the imported file along with its discrepancies, so it should be called on a transaction.
+// It fails with an integrity violation when a file with the same name was already imported.
+func (r *repo) CreateSettlementFile(ctx context.Context, file *SettlementFile, discrepancies []SettlementDiscrepancy) error {
+	_, err := r.db.NewInsert().Model(file).Exec(ctx)
+	if err != nil || len(discrepancies) == 0 {
+		return err
+	}
+	for i := range discrepancies {
+		discrepancies[i].SettlementFileID = file.ID
+		if discrepancies[i].Status == "" {
+			discrepancies[i].Status = DiscrepancyOpen
+		}
+	}
+	_, err = r.db.NewInsert().Model(&discrepancies).Exec(ctx)
+	return err
+}
+
+// SettlementFileExists tells whether the file with the given name was already imported.
+func (r *repo) SettlementFileExists(ctx context.Context, name string) (bool, error) {
+	return r.db.NewSelect().Model((*SettlementFile)(nil)).Where("name = ?", name).Exists(ctx)
+}
+
+// GetSettlementFiles returns the imported files, the last imported first.
+func (r *repo) GetSettlementFiles(ctx context.Context, limit int) ([]SettlementFile, error) {
+	files := make([]SettlementFile, 0)
+	err := r.db.NewSelect().Model(&files).Order("created_at DESC").Limit(limit).Scan(ctx)
+	return files, err
+}
+
+// GetPaymentsByIDs returns the payments with the given ids, leaving out the ids that do not exist.
+func (r *repo) GetPaymentsByIDs(ctx context.Context, ids []uuid.UUID) ([]Payment, error) {
+	payments := make([]Payment, 0, len(ids))
+	if len(ids) == 0 {
+		return payments, nil
+	}
+	err := r.db.NewSelect().Model(&payments).Where("id IN (?)", bun.In(ids)).Scan(ctx)
+	return payments, err
+}
+
+// GetSettledPayments returns the payments of the given acquirers whose money was taken from the shopper between from
+// and to, which are the ones the acquirer settles. A succeeded or captured payment is not updated anymore, so its
+// updated_at is when it moved to that status: the capture of manual capture payments, not their authorization.
+func (r *repo) GetSettledPayments(ctx context.Context, acquirers []string, from time.Time, to time.Time) ([]Payment, error) {
+	payments := make([]Payment, 0)
+	err := r.db.NewSelect().Model(&payments).
+		Where("acquirer IN (?)", bun.In(acquirers)).
+		Where("payment_status IN (?)", bun.In([]status.PaymentStatus{status.PaymentSucceeded, status.PaymentCaptured})).
+		Where("updated_at >= ?", from.UTC()).
+		Where("updated_at < ?", to.UTC()).
+		Scan(ctx)
+	return payments, err
+}
+
+// ListSettlementDiscrepancies returns the discrepancies that match the filter, the oldest first.
+func (r *repo) ListSettlementDiscrepancies(ctx context.Context, filter DiscrepancyFilter) ([]SettlementDiscrepancy, error) {
+	discrepancies := make([]SettlementDiscrepancy, 0)
+	q := r.db.NewSelect().Model(&discrepancies).
+		OrderExpr("created_at ASC, id ASC").
+		Limit(filter.Limit)
+	if filter.Status != "" {
+		q = q.Where("status = ?", filter.Status)
+	}
+	if filter.Kind != "" {
+		q = q.Where("kind = ?", filter.Kind)
+	}
+	if filter.SettlementFileID != uuid.Nil {
+		q = q.Where("settlement_file_id = ?", filter.SettlementFileID)
+	}
+	err := q.Scan(ctx)
+	return discrepancies, err
+}
+
+func (r *repo) GetSettlementDiscrepancy(ctx context.Context, id uuid.UUID) (*SettlementDiscrepancy, error) {
+	var discrepancy SettlementDiscrepancy
+	err := r.db.NewSelect().Model(&discrepancy).Where("id = ?", id).Scan(ctx)
+	return &discrepancy, err
+}
+
+// ResolveSettlementDiscrepancy marks the discrepancy as resolved, with the note of the operator.
+// It returns sql.ErrNoRows when the discrepancy was already resolved.
+func (r *repo) ResolveSettlementDiscrepancy(ctx context.Context, discrepancy *SettlementDiscrepancy) error {
+	now := time.Now()
+	discrepancy.Status = DiscrepancyResolved
+	discrepancy.ResolvedAt = &now
+	discrepancy.UpdatedAt = &now
+	res, err := r.db.NewUpdate().Model(discrepancy).
+		Where("id = ?", discrepancy.ID).
+		Where("status = ?", DiscrepancyOpen).
+		Column("status", "resolution_note", "resolved_at", "updated_at").
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package repositiory
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type WebhookEndpoint struct {
+	ID         uuid.UUID
+	MerchantID uuid.UUID
+	// URL is where the events are sent, using a POST request
+	URL string
+	// EventTypes are the events this endpoint is subscribed to, stored as a jsonb array
+	EventTypes []string
+	// Secret is used to sign the events sent to this endpoint
+	Secret    string
+	Enabled   bool
+	CreatedAt *time.Time
+	UpdatedAt *time.Time
+}
+
+const (
+	// WebhookEventPending is the status of the events that still need to be sent, including the ones claimed by a worker
+	WebhookEventPending = "pending"
+	// WebhookEventDelivered is the status of the events the endpoint accepted
+	WebhookEventDelivered = "delivered"
+	// WebhookEventDead is the status of the events the endpoint did not accept on any attempt
+	WebhookEventDead = "dead"
+)
+
+// WebhookEvent is an event that is sent to an endpoint until the endpoint accepts it. An event sent to many endpoints
+// has one WebhookEvent for each of them, all with the same EventID.
+type WebhookEvent struct {
+	ID         uuid.UUID
+	EndpointID uuid.UUID
+	MerchantID uuid.UUID
+	EventID    uuid.UUID
+	EventType  string
+	// Body is the request body sent to the endpoint, so every attempt sends the same event
+	Body   json.RawMessage `bun:"type:jsonb"`
+	Status string
+	// Attempts is the number of times the event was claimed
+	Attempts int
+	// NextAttemptAt is when the event can be claimed, either for the first time or again
+	NextAttemptAt time.Time
+	LastError     string
+	FinishedAt    *time.Time
+	CreatedAt     *time.Time
+	UpdatedAt     *time.Time
+}
+
+// WebhookDelivery is a single attempt to send an event to a webhook endpoint.
+type WebhookDelivery struct {
+	ID                 uuid.UUID
+	EndpointID         uuid.UUID
+	MerchantID         uuid.UUID
+	EventID            uuid.UUID
+	EventType          string
+	Attempt            int
+	Succeeded          bool
+	ResponseStatusCode int
+	Error              string
+	DurationMs         int64
+	CreatedAt          *time.Time
+}
+
+func (r *repo) CreateWebhookEndpoint(ctx context.Context, endpoint *WebhookEndpoint) error {
+	_, err := r.db.NewInsert().Model(endpoint).Exec(ctx)
+	return err
+}
+
+// GetWebhookEndpoint returns the webhook endpoint with the given id, only if it belongs to the given merchant.
+func (r *repo) GetWebhookEndpoint(ctx context.Context, merchantID uuid.UUID, id uuid.UUID) (*WebhookEndpoint, error) {
+	var endpoint WebhookEndpoint
+	_, err := r.db.NewSelect().Model(&endpoint).
+		Where("id = ?", id).
+		Where("merchant_id = ?", merchantID).
+		Exec(ctx, &endpoint)
+	return &endpoint, err
+}
+
+func (r *repo) GetWebhookEndpoints(ctx context.Context, merchantID uuid.UUID) ([]WebhookEndpoint, error) {
+	endpoints := make([]WebhookEndpoint, 0)
+	err := r.db.NewSelect().Model(&endpoints).
+		Where("merchant_id = ?", merchantID).
+		Order("created_at ASC").
+		Scan(ctx)
+	return endpoints, err
+}
+
+// GetWebhookEndpointsForEvent returns the enabled endpoints of the merchant that are subscribed to the given event type.
+func (r *repo) GetWebhookEndpointsForEvent(ctx context.Context, merchantID uuid.UUID, eventType string) ([]WebhookEndpoint, error) {
+	endpoints := make([]WebhookEndpoint, 0)
+	err := r.db.NewSelect().Model(&endpoints).
+		Where("merchant_id = ?", merchantID).
+		Where("enabled").
+		Where("event_types @> ?", []string{eventType}).
+		Scan(ctx)
+	return endpoints, err
+}
+
+// UpdateWebhookEndpoint updates the url, event types and enabled flag of the endpoint.
+func (r *repo) UpdateWebhookEndpoint(ctx context.Context, endpoint *WebhookEndpoint) error {
+	now := time.Now()
+	endpoint.UpdatedAt = &now
+	res, err := r.db.NewUpdate().Model(endpoint).
+		Column("url", "event_types", "enabled", "updated_at").
+		Where("id = ?", endpoint.ID).
+		Where("merchant_id = ?", endpoint.MerchantID).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(res)
+}
+
+// DeleteWebhookEndpoint deletes the endpoint along with its delivery log.
+func (r *repo) DeleteWebhookEndpoint(ctx context.Context, merchantID uuid.UUID, id uuid.UUID) error {
+	res, err := r.db.NewDelete().Model((*WebhookEndpoint)(nil)).
+		Where("id = ?", id).
+		Where("merchant_id = ?", merchantID).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(res)
+}
+
+// CreateWebhookEvents stores the events, which are pending and due right away unless they say otherwise.
+func (r *repo) CreateWebhookEvents(ctx context.Context, events []WebhookEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	for i := range events {
+		if events[i].Status == "" {
+			events[i].Status = WebhookEventPending
+		}
+		if events[i].NextAttemptAt.IsZero() {
+			events[i].NextAttemptAt = now
+		}
+	}
+	_, err := r.db.NewInsert().Model(&events).Exec(ctx)
+	return err
+}
+
+// ClaimWebhookEvent claims the pending event that is due the longest, and hides it from the other workers for the
+// visibility timeout. Events locked by other transactions are skipped, so many workers can claim events at the same time.
+// It returns sql.ErrNoRows when no event is due.
+func (r *repo) ClaimWebhookEvent(ctx context.Context, now time.Time, visibilityTimeout time.Duration) (*WebhookEvent, error) {
+	var event WebhookEvent
+	due := r.db.NewSelect().Model((*WebhookEvent)(nil)).
+		Column("id").
+		Where("status = ?", WebhookEventPending).
+		Where("next_attempt_at <= ?", now.UTC()).
+		Order("next_attempt_at ASC").
+		Limit(1).
+		For("UPDATE SKIP LOCKED")
+	res, err := r.db.NewUpdate().Model(&event).
+		Set("attempts = attempts + 1").
+		Set("next_attempt_at = ?", now.Add(visibilityTimeout).UTC()).
+		Set("updated_at = ?", now.UTC()).
+		Where("id = (?)", due).
+		Returning("*").
+		Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &event, nil
+}
+
+// UpdateWebhookEvent writes the result of the attempt the event was claimed for. It returns sql.ErrNoRows when the
+// event was claimed again since then, because the visibility timeout passed, so only the last claim can finish it.
+func (r *repo) UpdateWebhookEvent(ctx context.Context, event *WebhookEvent) error {
+	now := time.Now()
+	event.UpdatedAt = &now
+	res, err := r.db.NewUpdate().Model(event).
+		Where("id = ?", event.ID).
+		Where("attempts = ?", event.Attempts).
+		Column("status", "next_attempt_at", "last_error", "finished_at", "updated_at").
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(res)
+}
+
+// GetWebhookEvents returns the events of the endpoint, in the order they were created.
+func (r *repo) GetWebhookEvents(ctx context.Context, endpointID uuid.UUID) ([]WebhookEvent, error) {
+	events := make([]WebhookEvent, 0)
+	err := r.db.NewSelect().Model(&events).Where("endpoint_id = ?", endpointID).Order("created_at ASC").Scan(ctx)
+	return events, err
+}
+
+func (r *repo) CreateWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	_, err := r.db.NewInsert().Model(delivery).Exec(ctx)
+	return err
+}
+
+// GetWebhookDeliveries returns the latest delivery attempts of an endpoint, newest first.
+func (r *repo) GetWebhookDeliveries(ctx context.Context, endpointID uuid.UUID, limit int) ([]WebhookDelivery, error) {
+	deliveries := make([]WebhookDelivery, 0)
+	err := r.db.NewSelect().Model(&deliveries).
+		Where("endpoint_id = ?", endpointID).
+		OrderExpr("created_at DESC, attempt DESC").
+		Limit(limit).
+		Scan(ctx)
+	return deliveries, err
+}
+
+// checkRowsAffected returns sql.ErrNoRows when the query did not change any row.
+func checkRowsAffected(res sql.Result) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
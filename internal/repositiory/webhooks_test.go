@@ -0,0 +1,244 @@
+package repositiory_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway/internal/config"
+	"github.com/marioarizaj/payment-gateway/internal/dependencies"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepo_GetWebhookEndpointsForEvent(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	merchantID := uuid.Must(uuid.Parse("6c5a19d0-f132-4a55-93d3-2c00db06d41b"))
+	endpoints := []*repositiory.WebhookEndpoint{
+		{
+			ID:         uuid.Must(uuid.Parse("1b0ad6a1-3c4d-4c57-9d0f-9c7a0f3e2b01")),
+			MerchantID: merchantID,
+			URL:        "https://merchant.example.com/succeeded",
+			EventTypes: []string{"payment.succeeded", "payment.failed"},
+			Secret:     "whsec_1",
+			Enabled:    true,
+		},
+		{
+			ID:         uuid.Must(uuid.Parse("1b0ad6a1-3c4d-4c57-9d0f-9c7a0f3e2b02")),
+			MerchantID: merchantID,
+			URL:        "https://merchant.example.com/disabled",
+			EventTypes: []string{"payment.succeeded"},
+			Secret:     "whsec_2",
+			Enabled:    false,
+		},
+		{
+			ID:         uuid.Must(uuid.Parse("1b0ad6a1-3c4d-4c57-9d0f-9c7a0f3e2b03")),
+			MerchantID: merchantID,
+			URL:        "https://merchant.example.com/canceled",
+			EventTypes: []string{"payment.canceled"},
+			Secret:     "whsec_3",
+			Enabled:    true,
+		},
+		{
+			ID:         uuid.Must(uuid.Parse("1b0ad6a1-3c4d-4c57-9d0f-9c7a0f3e2b04")),
+			MerchantID: uuid.Must(uuid.Parse("a1e3f405-44f0-44b4-a584-b0b3c80bc8ac")),
+			URL:        "https://other.example.com/succeeded",
+			EventTypes: []string{"payment.succeeded"},
+			Secret:     "whsec_4",
+			Enabled:    true,
+		},
+	}
+	cases := []struct {
+		name        string
+		eventType   string
+		expectedIDs []uuid.UUID
+	}{
+		{
+			name:        "only_enabled_endpoints_of_the_merchant",
+			eventType:   "payment.succeeded",
+			expectedIDs: []uuid.UUID{endpoints[0].ID},
+		},
+		{
+			name:        "endpoint_subscribed_to_many_events",
+			eventType:   "payment.failed",
+			expectedIDs: []uuid.UUID{endpoints[0].ID},
+		},
+		{
+			name:      "no_endpoint_subscribed",
+			eventType: "payment.captured",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tx, err := deps.DB.BeginTx(context.Background(), &sql.TxOptions{})
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer func() { _ = tx.Rollback() }()
+			repo := repositiory.NewRepository(tx)
+			for _, e := range endpoints {
+				endpoint := *e
+				err = repo.CreateWebhookEndpoint(context.Background(), &endpoint)
+				if !assert.NoError(t, err) {
+					return
+				}
+			}
+			found, err := repo.GetWebhookEndpointsForEvent(context.Background(), merchantID, c.eventType)
+			if !assert.NoError(t, err) {
+				return
+			}
+			var ids []uuid.UUID
+			for _, e := range found {
+				ids = append(ids, e.ID)
+			}
+			assert.Equal(t, c.expectedIDs, ids)
+		})
+	}
+}
+
+func TestRepo_DeleteWebhookEndpoint(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	tx, err := deps.DB.BeginTx(context.Background(), &sql.TxOptions{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+	repo := repositiory.NewRepository(tx)
+	endpoint := &repositiory.WebhookEndpoint{
+		ID:         uuid.Must(uuid.Parse("1b0ad6a1-3c4d-4c57-9d0f-9c7a0f3e2b01")),
+		MerchantID: uuid.Must(uuid.Parse("6c5a19d0-f132-4a55-93d3-2c00db06d41b")),
+		URL:        "https://merchant.example.com/webhooks",
+		EventTypes: []string{"payment.succeeded"},
+		Secret:     "whsec_1",
+		Enabled:    true,
+	}
+	err = repo.CreateWebhookEndpoint(context.Background(), endpoint)
+	if !assert.NoError(t, err) {
+		return
+	}
+	err = repo.DeleteWebhookEndpoint(context.Background(), uuid.Must(uuid.Parse("a1e3f405-44f0-44b4-a584-b0b3c80bc8ac")), endpoint.ID)
+	assert.Equal(t, sql.ErrNoRows, err)
+	err = repo.DeleteWebhookEndpoint(context.Background(), endpoint.MerchantID, endpoint.ID)
+	assert.NoError(t, err)
+	_, err = repo.GetWebhookEndpoint(context.Background(), endpoint.MerchantID, endpoint.ID)
+	assert.Equal(t, sql.ErrNoRows, err)
+}
+
+func TestRepo_ClaimWebhookEvent(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	deps, err := dependencies.InitDependencies(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	tx, err := deps.DB.BeginTx(context.Background(), &sql.TxOptions{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+	repo := repositiory.NewRepository(tx)
+	ctx := context.Background()
+	now := time.Now()
+
+	merchantID := uuid.Must(uuid.Parse("6c5a19d0-f132-4a55-93d3-2c00db06d41b"))
+	endpoint := &repositiory.WebhookEndpoint{
+		ID:         uuid.Must(uuid.Parse("1b0ad6a1-3c4d-4c57-9d0f-9c7a0f3e2b05")),
+		MerchantID: merchantID,
+		URL:        "https://merchant.example.com/webhooks",
+		EventTypes: []string{"payment.succeeded"},
+		Secret:     "whsec_5",
+		Enabled:    true,
+	}
+	if !assert.NoError(t, repo.CreateWebhookEndpoint(ctx, endpoint)) {
+		return
+	}
+	eventID := uuid.Must(uuid.Parse("5d1e7c3a-2b6f-4c8e-9a0d-7e4f1b2c3d01"))
+	events := []repositiory.WebhookEvent{
+		{
+			ID:            uuid.Must(uuid.Parse("5d1e7c3a-2b6f-4c8e-9a0d-7e4f1b2c3d02")),
+			EndpointID:    endpoint.ID,
+			MerchantID:    merchantID,
+			EventID:       eventID,
+			EventType:     "payment.succeeded",
+			Body:          []byte(`{"id":"later"}`),
+			NextAttemptAt: now.Add(time.Hour),
+		},
+		{
+			ID:            uuid.Must(uuid.Parse("5d1e7c3a-2b6f-4c8e-9a0d-7e4f1b2c3d03")),
+			EndpointID:    endpoint.ID,
+			MerchantID:    merchantID,
+			EventID:       eventID,
+			EventType:     "payment.succeeded",
+			Body:          []byte(`{"id":"first"}`),
+			NextAttemptAt: now,
+		},
+	}
+	if !assert.NoError(t, repo.CreateWebhookEvents(ctx, events)) {
+		return
+	}
+	later, first := events[0], events[1]
+
+	// Only the event that is due is claimed, and it is hidden for the visibility timeout
+	claimed, err := repo.ClaimWebhookEvent(ctx, now, time.Minute)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, first.ID, claimed.ID)
+	assert.Equal(t, 1, claimed.Attempts)
+	assert.JSONEq(t, `{"id":"first"}`, string(claimed.Body))
+	_, err = repo.ClaimWebhookEvent(ctx, now, time.Minute)
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	// A worker that did not finish the event before its visibility timeout can not finish it anymore
+	reclaimed, err := repo.ClaimWebhookEvent(ctx, now.Add(2*time.Minute), time.Minute)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, first.ID, reclaimed.ID)
+	assert.Equal(t, 2, reclaimed.Attempts)
+	claimed.Status = repositiory.WebhookEventDelivered
+	assert.Equal(t, sql.ErrNoRows, repo.UpdateWebhookEvent(ctx, claimed))
+
+	finishedAt := now
+	reclaimed.Status = repositiory.WebhookEventDelivered
+	reclaimed.FinishedAt = &finishedAt
+	if !assert.NoError(t, repo.UpdateWebhookEvent(ctx, reclaimed)) {
+		return
+	}
+	// Finished events are never claimed again, while the other event is once it is due
+	next, err := repo.ClaimWebhookEvent(ctx, now.Add(2*time.Hour), time.Minute)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, later.ID, next.ID)
+	_, err = repo.ClaimWebhookEvent(ctx, now.Add(2*time.Hour), time.Minute)
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	stored, err := repo.GetWebhookEvents(ctx, endpoint.ID)
+	if !assert.NoError(t, err) || !assert.Len(t, stored, 2) {
+		return
+	}
+	for _, event := range stored {
+		if event.ID == first.ID {
+			assert.Equal(t, repositiory.WebhookEventDelivered, event.Status)
+		}
+	}
+}
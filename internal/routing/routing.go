@@ -0,0 +1,343 @@
+package routing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway/internal/creditcard"
+)
+
+const (
+	// StrategyWeighted splits the payments between the targets of a rule, by their weight
+	StrategyWeighted = "weighted"
+	// StrategyLeastCost sends each payment to the target that charges the least for its amount
+	StrategyLeastCost = "least_cost"
+
+	defaultMaxAttempts = 2
+)
+
+// Route is the acquirer chosen for a payment, along with the rule that chose it.
+// Payments that no rule matches go to the default acquirer, without a rule.
+type Route struct {
+	Acquirer string `json:"acquirer"`
+	Rule     string `json:"rule,omitempty"`
+}
+
+// Payment is what the rules of the router can match on.
+type Payment struct {
+	MerchantID   uuid.UUID
+	CardNumber   string
+	CurrencyCode string
+	Amount       int64
+}
+
+// BINRange matches the card numbers whose first digits are between From and To, both included.
+// Both ends need the same number of digits, like 400000 and 499999.
+type BINRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Target is an acquirer that a rule can send payments to.
+type Target struct {
+	Acquirer string `json:"acquirer"`
+	// Weight is the share of the payments sent to this target, when the rule splits them by weight
+	Weight int `json:"weight"`
+	// FixedCost is charged by the acquirer on every payment, in minor units
+	FixedCost int64 `json:"fixed_cost"`
+	// PercentageCost is charged by the acquirer on the amount of each payment, in basis points
+	PercentageCost int64 `json:"percentage_cost"`
+}
+
+// Cost returns what the acquirer of the target charges for a payment of the given amount.
+func (t Target) Cost(amount int64) int64 {
+	return t.FixedCost + amount*t.PercentageCost/10000
+}
+
+// Rule sends the payments it matches to one of its targets. Empty conditions match every payment.
+type Rule struct {
+	Name        string      `json:"name"`
+	Brands      []string    `json:"brands"`
+	BINRanges   []BINRange  `json:"bin_ranges"`
+	Currencies  []string    `json:"currencies"`
+	MerchantIDs []uuid.UUID `json:"merchant_ids"`
+	// MinAmount and MaxAmount are in minor units and included on the range. A MaxAmount of 0 has no limit
+	MinAmount int64 `json:"min_amount"`
+	MaxAmount int64 `json:"max_amount"`
+	// Strategy is weighted or least_cost, weighted by default
+	Strategy string   `json:"strategy"`
+	Targets  []Target `json:"targets"`
+}
+
+// Acquirer registers an acquirer that the rules can send payments to. The settings that are not given
+// are the same as the ones of the default acquirer.
+type Acquirer struct {
+	Name         string `json:"name"`
+	Client       string `json:"client"`
+	URL          string `json:"url"`
+	CallbackURL  string `json:"callback_url"`
+	ISOTransport string `json:"iso_transport"`
+	TCPAddress   string `json:"tcp_address"`
+}
+
+// CascadePolicy decides whether a payment declined with a soft decline is sent again to a fallback acquirer.
+type CascadePolicy struct {
+	Enabled bool `json:"enabled"`
+	// MaxAttempts is the number of acquirers a payment can be sent to, the first one included. It is 2 when not given
+	MaxAttempts int `json:"max_attempts"`
+}
+
+// CascadeConfig names the fallback of each acquirer, and the policies that allow payments to cascade to them.
+type CascadeConfig struct {
+	// Fallbacks maps each acquirer to the acquirer that gets its soft declined payments
+	Fallbacks map[string]string `json:"fallbacks"`
+	// Policy is used for the merchants that do not have their own policy
+	Policy           CascadePolicy               `json:"policy"`
+	MerchantPolicies map[uuid.UUID]CascadePolicy `json:"merchant_policies"`
+}
+
+// Config is read from the routing file.
+type Config struct {
+	Acquirers []Acquirer    `json:"acquirers"`
+	Rules     []Rule        `json:"rules"`
+	Cascade   CascadeConfig `json:"cascade"`
+}
+
+// LoadConfig reads the routing file at the given path. Without a path there are no rules,
+// so every payment goes to the default acquirer.
+func LoadConfig(path string) (Config, error) {
+	if path == "" {
+		return Config{}, nil
+	}
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	var cfg Config
+	err = json.Unmarshal(bts, &cfg)
+	if err != nil {
+		return Config{}, fmt.Errorf("could not decode routing file: %w", err)
+	}
+	return cfg, nil
+}
+
+// Availability tells whether an acquirer can take payments, like when its circuit breaker is not open.
+type Availability interface {
+	Available(acquirer string) bool
+}
+
+// Router chooses the acquirer of each payment. Rules are checked in order, and the first one that matches the payment
+// decides its acquirer. Payments that no rule matches go to the default acquirer.
+type Router struct {
+	defaultAcquirer string
+	rules           []Rule
+	cascade         CascadeConfig
+	availability    Availability
+}
+
+// NewRouter validates the rules and the cascade config, checking that they only send payments to the default acquirer
+// or to the acquirers of the config.
+func NewRouter(defaultAcquirer string, cfg Config) (*Router, error) {
+	known := map[string]bool{defaultAcquirer: true}
+	for _, a := range cfg.Acquirers {
+		known[a.Name] = true
+	}
+	for _, rule := range cfg.Rules {
+		err := validateRule(rule, known)
+		if err != nil {
+			return nil, fmt.Errorf("routing rule %s: %w", rule.Name, err)
+		}
+	}
+	err := validateCascade(cfg.Cascade, known)
+	if err != nil {
+		return nil, fmt.Errorf("cascade: %w", err)
+	}
+	return &Router{defaultAcquirer: defaultAcquirer, rules: cfg.Rules, cascade: cfg.Cascade}, nil
+}
+
+// DefaultAcquirer is the acquirer of the payments that no rule matches.
+func (r *Router) DefaultAcquirer() string {
+	return r.defaultAcquirer
+}
+
+// SkipUnavailable makes the router skip the acquirers that are not available. Rules only send payments to their
+// available targets, while they have any, and payments do not cascade to a fallback that is not available.
+func (r *Router) SkipUnavailable(availability Availability) {
+	r.availability = availability
+}
+
+// Route returns the acquirer of the payment, and the rule that chose it.
+func (r *Router) Route(p Payment) Route {
+	brand, _ := (&creditcard.Card{Number: p.CardNumber}).IssuerValidate()
+	for _, rule := range r.rules {
+		if rule.matches(p, brand) {
+			return Route{Acquirer: rule.target(p.Amount, r.available).Acquirer, Rule: rule.Name}
+		}
+	}
+	return Route{Acquirer: r.defaultAcquirer}
+}
+
+func (r *Router) available(acquirer string) bool {
+	return r.availability == nil || r.availability.Available(acquirer)
+}
+
+// Fallback returns the acquirer that a soft declined payment of the merchant cascades to, given the acquirers it was
+// already sent to, in order. It returns false when the policy of the merchant does not allow another attempt.
+func (r *Router) Fallback(merchantID uuid.UUID, attempted []string) (string, bool) {
+	policy, ok := r.cascade.MerchantPolicies[merchantID]
+	if !ok {
+		policy = r.cascade.Policy
+	}
+	maxAttempts := policy.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+	if !policy.Enabled || len(attempted) == 0 || len(attempted) >= maxAttempts {
+		return "", false
+	}
+	fallback, ok := r.cascade.Fallbacks[attempted[len(attempted)-1]]
+	// A payment is never sent twice to the same acquirer
+	if !ok || contains(attempted, fallback) || !r.available(fallback) {
+		return "", false
+	}
+	return fallback, true
+}
+
+func (rule Rule) matches(p Payment, brand string) bool {
+	if len(rule.Brands) > 0 && !contains(rule.Brands, brand) {
+		return false
+	}
+	if len(rule.Currencies) > 0 && !contains(rule.Currencies, p.CurrencyCode) {
+		return false
+	}
+	if len(rule.MerchantIDs) > 0 && !containsID(rule.MerchantIDs, p.MerchantID) {
+		return false
+	}
+	if p.Amount < rule.MinAmount || (rule.MaxAmount > 0 && p.Amount > rule.MaxAmount) {
+		return false
+	}
+	if len(rule.BINRanges) == 0 {
+		return true
+	}
+	for _, binRange := range rule.BINRanges {
+		if binRange.contains(p.CardNumber) {
+			return true
+		}
+	}
+	return false
+}
+
+// target chooses the target of a payment with the given amount, out of the available targets of the rule.
+// When none of them are available, it chooses out of all of them.
+func (rule Rule) target(amount int64, available func(acquirer string) bool) Target {
+	targets := make([]Target, 0, len(rule.Targets))
+	for _, t := range rule.Targets {
+		if available(t.Acquirer) {
+			targets = append(targets, t)
+		}
+	}
+	if len(targets) == 0 {
+		targets = rule.Targets
+	}
+	if rule.Strategy == StrategyLeastCost {
+		cheapest := targets[0]
+		for _, t := range targets[1:] {
+			if t.Cost(amount) < cheapest.Cost(amount) {
+				cheapest = t
+			}
+		}
+		return cheapest
+	}
+	var total int
+	for _, t := range targets {
+		total += t.Weight
+	}
+	if total == 0 {
+		return targets[0]
+	}
+	n := rand.Intn(total)
+	for _, t := range targets {
+		if n < t.Weight {
+			return t
+		}
+		n -= t.Weight
+	}
+	return targets[len(targets)-1]
+}
+
+func (b BINRange) contains(cardNumber string) bool {
+	if len(cardNumber) < len(b.From) {
+		return false
+	}
+	// Both ends have the same length, so comparing the strings compares the numbers
+	prefix := cardNumber[:len(b.From)]
+	return prefix >= b.From && prefix <= b.To
+}
+
+func validateRule(rule Rule, acquirers map[string]bool) error {
+	if rule.Name == "" {
+		return errors.New("name is required")
+	}
+	if rule.Strategy != "" && rule.Strategy != StrategyWeighted && rule.Strategy != StrategyLeastCost {
+		return fmt.Errorf("strategy %s is not supported", rule.Strategy)
+	}
+	if len(rule.Targets) == 0 {
+		return errors.New("at least one target is required")
+	}
+	for _, t := range rule.Targets {
+		if !acquirers[t.Acquirer] {
+			return fmt.Errorf("acquirer %s is not registered", t.Acquirer)
+		}
+		if t.Weight < 0 {
+			return errors.New("weights can not be negative")
+		}
+	}
+	for _, b := range rule.BINRanges {
+		if len(b.From) == 0 || len(b.From) != len(b.To) {
+			return fmt.Errorf("bin range %s-%s needs both ends with the same number of digits", b.From, b.To)
+		}
+	}
+	return nil
+}
+
+func validateCascade(cascade CascadeConfig, acquirers map[string]bool) error {
+	for from, to := range cascade.Fallbacks {
+		if !acquirers[from] {
+			return fmt.Errorf("acquirer %s is not registered", from)
+		}
+		if !acquirers[to] {
+			return fmt.Errorf("acquirer %s is not registered", to)
+		}
+	}
+	if cascade.Policy.MaxAttempts < 0 {
+		return errors.New("max attempts can not be negative")
+	}
+	for merchantID, policy := range cascade.MerchantPolicies {
+		if policy.MaxAttempts < 0 {
+			return fmt.Errorf("max attempts of merchant %s can not be negative", merchantID)
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsID(ids []uuid.UUID, id uuid.UUID) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,275 @@
+package routing_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway/internal/routing"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	testMerchantID = uuid.Must(uuid.Parse("6c5a19d0-f132-4a55-93d3-2c00db06d41b"))
+	visaPayment    = routing.Payment{MerchantID: testMerchantID, CardNumber: "4242424242424242", CurrencyCode: "EUR", Amount: 2000}
+
+	testAcquirers = []routing.Acquirer{{Name: "secondary"}, {Name: "tertiary"}}
+)
+
+func TestRouter_Route(t *testing.T) {
+	otherMerchantID := uuid.Must(uuid.Parse("a1e3f405-44f0-44b4-a584-b0b3c80bc8ac"))
+	cases := []struct {
+		name          string
+		rules         []routing.Rule
+		payment       routing.Payment
+		expectedRoute routing.Route
+	}{
+		{
+			name:          "no_rules",
+			payment:       visaPayment,
+			expectedRoute: routing.Route{Acquirer: "primary"},
+		}, {
+			name:          "match_brand",
+			rules:         []routing.Rule{{Name: "visa", Brands: []string{"visa"}, Targets: []routing.Target{{Acquirer: "secondary"}}}},
+			payment:       visaPayment,
+			expectedRoute: routing.Route{Acquirer: "secondary", Rule: "visa"},
+		}, {
+			name:          "other_brand",
+			rules:         []routing.Rule{{Name: "amex", Brands: []string{"amex"}, Targets: []routing.Target{{Acquirer: "secondary"}}}},
+			payment:       visaPayment,
+			expectedRoute: routing.Route{Acquirer: "primary"},
+		}, {
+			name:          "match_bin_range",
+			rules:         []routing.Rule{{Name: "bins", BINRanges: []routing.BINRange{{From: "510000", To: "519999"}, {From: "424000", To: "424999"}}, Targets: []routing.Target{{Acquirer: "secondary"}}}},
+			payment:       visaPayment,
+			expectedRoute: routing.Route{Acquirer: "secondary", Rule: "bins"},
+		}, {
+			name:          "outside_bin_range",
+			rules:         []routing.Rule{{Name: "bins", BINRanges: []routing.BINRange{{From: "400000", To: "424241"}}, Targets: []routing.Target{{Acquirer: "secondary"}}}},
+			payment:       visaPayment,
+			expectedRoute: routing.Route{Acquirer: "primary"},
+		}, {
+			name:          "other_currency",
+			rules:         []routing.Rule{{Name: "usd", Currencies: []string{"USD"}, Targets: []routing.Target{{Acquirer: "secondary"}}}},
+			payment:       visaPayment,
+			expectedRoute: routing.Route{Acquirer: "primary"},
+		}, {
+			name:          "other_merchant",
+			rules:         []routing.Rule{{Name: "merchant", MerchantIDs: []uuid.UUID{otherMerchantID}, Targets: []routing.Target{{Acquirer: "secondary"}}}},
+			payment:       visaPayment,
+			expectedRoute: routing.Route{Acquirer: "primary"},
+		}, {
+			name:          "match_all_conditions",
+			rules:         []routing.Rule{{Name: "all", Brands: []string{"visa"}, Currencies: []string{"EUR"}, MerchantIDs: []uuid.UUID{testMerchantID}, MinAmount: 1000, MaxAmount: 2000, Targets: []routing.Target{{Acquirer: "secondary"}}}},
+			payment:       visaPayment,
+			expectedRoute: routing.Route{Acquirer: "secondary", Rule: "all"},
+		}, {
+			name:          "above_max_amount",
+			rules:         []routing.Rule{{Name: "small", MaxAmount: 1999, Targets: []routing.Target{{Acquirer: "secondary"}}}},
+			payment:       visaPayment,
+			expectedRoute: routing.Route{Acquirer: "primary"},
+		}, {
+			name: "first_matching_rule_wins",
+			rules: []routing.Rule{
+				{Name: "usd", Currencies: []string{"USD"}, Targets: []routing.Target{{Acquirer: "primary"}}},
+				{Name: "eur", Currencies: []string{"EUR"}, Targets: []routing.Target{{Acquirer: "secondary"}}},
+				{Name: "visa", Brands: []string{"visa"}, Targets: []routing.Target{{Acquirer: "primary"}}},
+			},
+			payment:       visaPayment,
+			expectedRoute: routing.Route{Acquirer: "secondary", Rule: "eur"},
+		}, {
+			name: "least_cost",
+			rules: []routing.Rule{{Name: "cheapest", Strategy: routing.StrategyLeastCost, Targets: []routing.Target{
+				{Acquirer: "primary", FixedCost: 10, PercentageCost: 150},
+				{Acquirer: "secondary", FixedCost: 25, PercentageCost: 50},
+			}}},
+			payment:       visaPayment,
+			expectedRoute: routing.Route{Acquirer: "secondary", Rule: "cheapest"},
+		}, {
+			name: "least_cost_small_amount",
+			rules: []routing.Rule{{Name: "cheapest", Strategy: routing.StrategyLeastCost, Targets: []routing.Target{
+				{Acquirer: "primary", FixedCost: 10, PercentageCost: 150},
+				{Acquirer: "secondary", FixedCost: 25, PercentageCost: 50},
+			}}},
+			payment:       routing.Payment{CardNumber: "4242424242424242", CurrencyCode: "EUR", Amount: 100},
+			expectedRoute: routing.Route{Acquirer: "primary", Rule: "cheapest"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			router, err := routing.NewRouter("primary", routing.Config{Acquirers: testAcquirers, Rules: c.rules})
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, c.expectedRoute, router.Route(c.payment))
+		})
+	}
+}
+
+func TestRouter_Route_WeightedSplit(t *testing.T) {
+	router, err := routing.NewRouter("primary", routing.Config{Acquirers: testAcquirers, Rules: []routing.Rule{{
+		Name:    "split",
+		Targets: []routing.Target{{Acquirer: "primary", Weight: 80}, {Acquirer: "secondary", Weight: 20}},
+	}}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	counts := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		counts[router.Route(visaPayment).Acquirer]++
+	}
+	assert.InDelta(t, 8000, counts["primary"], 400)
+	assert.InDelta(t, 2000, counts["secondary"], 400)
+}
+
+// unavailable is the availability of the router when the given acquirers have their circuit breaker open.
+type unavailable []string
+
+func (u unavailable) Available(acquirer string) bool {
+	for _, a := range u {
+		if a == acquirer {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRouter_Route_SkipUnavailable(t *testing.T) {
+	split := routing.Rule{Name: "split", Targets: []routing.Target{{Acquirer: "primary", Weight: 100}, {Acquirer: "secondary", Weight: 0}}}
+	cheapest := routing.Rule{Name: "cheapest", Strategy: routing.StrategyLeastCost, Targets: []routing.Target{
+		{Acquirer: "secondary", FixedCost: 10},
+		{Acquirer: "tertiary", FixedCost: 20},
+		{Acquirer: "primary", FixedCost: 30},
+	}}
+	cases := []struct {
+		name          string
+		rule          routing.Rule
+		unavailable   unavailable
+		expectedRoute routing.Route
+	}{
+		{name: "weighted_target_available", rule: split, expectedRoute: routing.Route{Acquirer: "primary", Rule: "split"}},
+		{name: "weighted_target_unavailable", rule: split, unavailable: unavailable{"primary"}, expectedRoute: routing.Route{Acquirer: "secondary", Rule: "split"}},
+		{name: "cheapest_unavailable", rule: cheapest, unavailable: unavailable{"secondary"}, expectedRoute: routing.Route{Acquirer: "tertiary", Rule: "cheapest"}},
+		{name: "all_unavailable", rule: cheapest, unavailable: unavailable{"primary", "secondary", "tertiary"}, expectedRoute: routing.Route{Acquirer: "secondary", Rule: "cheapest"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			router, err := routing.NewRouter("primary", routing.Config{Acquirers: testAcquirers, Rules: []routing.Rule{c.rule}})
+			if !assert.NoError(t, err) {
+				return
+			}
+			router.SkipUnavailable(c.unavailable)
+			assert.Equal(t, c.expectedRoute, router.Route(visaPayment))
+		})
+	}
+}
+
+func TestNewRouter_InvalidRules(t *testing.T) {
+	cases := []struct {
+		name string
+		rule routing.Rule
+	}{
+		{name: "missing_name", rule: routing.Rule{Targets: []routing.Target{{Acquirer: "primary"}}}},
+		{name: "missing_targets", rule: routing.Rule{Name: "rule"}},
+		{name: "unknown_acquirer", rule: routing.Rule{Name: "rule", Targets: []routing.Target{{Acquirer: "unknown"}}}},
+		{name: "unknown_strategy", rule: routing.Rule{Name: "rule", Strategy: "random", Targets: []routing.Target{{Acquirer: "primary"}}}},
+		{name: "negative_weight", rule: routing.Rule{Name: "rule", Targets: []routing.Target{{Acquirer: "primary", Weight: -1}}}},
+		{name: "uneven_bin_range", rule: routing.Rule{Name: "rule", BINRanges: []routing.BINRange{{From: "4000", To: "499999"}}, Targets: []routing.Target{{Acquirer: "primary"}}}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := routing.NewRouter("primary", routing.Config{Rules: []routing.Rule{c.rule}})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestRouter_Fallback(t *testing.T) {
+	otherMerchantID := uuid.Must(uuid.Parse("a1e3f405-44f0-44b4-a584-b0b3c80bc8ac"))
+	cascade := routing.CascadeConfig{
+		Fallbacks: map[string]string{"primary": "secondary", "secondary": "tertiary", "tertiary": "primary"},
+		Policy:    routing.CascadePolicy{Enabled: true},
+		MerchantPolicies: map[uuid.UUID]routing.CascadePolicy{
+			otherMerchantID: {Enabled: true, MaxAttempts: 3},
+		},
+	}
+	cases := []struct {
+		name             string
+		cascade          routing.CascadeConfig
+		merchantID       uuid.UUID
+		attempted        []string
+		unavailable      unavailable
+		expectedFallback string
+		expectedOk       bool
+	}{
+		{name: "fallback", cascade: cascade, merchantID: testMerchantID, attempted: []string{"primary"}, expectedFallback: "secondary", expectedOk: true},
+		{name: "default_max_attempts", cascade: cascade, merchantID: testMerchantID, attempted: []string{"primary", "secondary"}},
+		{name: "merchant_max_attempts", cascade: cascade, merchantID: otherMerchantID, attempted: []string{"primary", "secondary"}, expectedFallback: "tertiary", expectedOk: true},
+		{name: "fallback_already_attempted", cascade: cascade, merchantID: otherMerchantID, attempted: []string{"secondary", "primary"}},
+		{name: "no_attempts", cascade: cascade, merchantID: testMerchantID},
+		{name: "disabled", cascade: routing.CascadeConfig{Fallbacks: cascade.Fallbacks}, merchantID: testMerchantID, attempted: []string{"primary"}},
+		{
+			name: "disabled_for_merchant",
+			cascade: routing.CascadeConfig{
+				Fallbacks:        cascade.Fallbacks,
+				Policy:           routing.CascadePolicy{Enabled: true},
+				MerchantPolicies: map[uuid.UUID]routing.CascadePolicy{testMerchantID: {}},
+			},
+			merchantID: testMerchantID,
+			attempted:  []string{"primary"},
+		},
+		{name: "no_fallback", cascade: routing.CascadeConfig{Policy: routing.CascadePolicy{Enabled: true}}, merchantID: testMerchantID, attempted: []string{"primary"}},
+		{name: "fallback_unavailable", cascade: cascade, merchantID: testMerchantID, attempted: []string{"primary"}, unavailable: unavailable{"secondary"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			router, err := routing.NewRouter("primary", routing.Config{Acquirers: testAcquirers, Cascade: c.cascade})
+			if !assert.NoError(t, err) {
+				return
+			}
+			router.SkipUnavailable(c.unavailable)
+			fallback, ok := router.Fallback(c.merchantID, c.attempted)
+			assert.Equal(t, c.expectedOk, ok)
+			assert.Equal(t, c.expectedFallback, fallback)
+		})
+	}
+}
+
+func TestNewRouter_InvalidCascade(t *testing.T) {
+	_, err := routing.NewRouter("primary", routing.Config{Cascade: routing.CascadeConfig{Fallbacks: map[string]string{"primary": "unknown"}}})
+	assert.Error(t, err)
+	_, err = routing.NewRouter("primary", routing.Config{Cascade: routing.CascadeConfig{Policy: routing.CascadePolicy{MaxAttempts: -1}}})
+	assert.Error(t, err)
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routing.json")
+	err := os.WriteFile(path, []byte(`{
+		"acquirers": [{"name": "secondary", "client": "iso", "tcp_address": "localhost:8584"}],
+		"rules": [{"name": "eur", "currencies": ["EUR"], "targets": [{"acquirer": "secondary", "weight": 100}]}],
+		"cascade": {
+			"fallbacks": {"primary": "secondary"},
+			"policy": {"enabled": true},
+			"merchant_policies": {"6c5a19d0-f132-4a55-93d3-2c00db06d41b": {"enabled": true, "max_attempts": 3}}
+		}
+	}`), 0o600)
+	if !assert.NoError(t, err) {
+		return
+	}
+	cfg, err := routing.LoadConfig(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []routing.Acquirer{{Name: "secondary", Client: "iso", TCPAddress: "localhost:8584"}}, cfg.Acquirers)
+	assert.Equal(t, []routing.Rule{{Name: "eur", Currencies: []string{"EUR"}, Targets: []routing.Target{{Acquirer: "secondary", Weight: 100}}}}, cfg.Rules)
+	assert.Equal(t, routing.CascadeConfig{
+		Fallbacks:        map[string]string{"primary": "secondary"},
+		Policy:           routing.CascadePolicy{Enabled: true},
+		MerchantPolicies: map[uuid.UUID]routing.CascadePolicy{testMerchantID: {Enabled: true, MaxAttempts: 3}},
+	}, cfg.Cascade)
+
+	cfg, err = routing.LoadConfig("")
+	assert.NoError(t, err)
+	assert.Equal(t, routing.Config{}, cfg)
+}
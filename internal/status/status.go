@@ -0,0 +1,135 @@
+package status
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrIllegalTransition is returned when a status update is not allowed by the transition tables.
+var ErrIllegalTransition = errors.New("illegal status transition")
+
+// PaymentStatus is the state of a payment on its lifecycle.
+type PaymentStatus string
+
+const (
+	// PaymentProcessing is the initial status, while we wait for the acquiring bank to process the payment.
+	PaymentProcessing PaymentStatus = "processing"
+	// PaymentAuthorized means that the acquiring bank approved a manual capture payment, which is waiting to be captured.
+	PaymentAuthorized PaymentStatus = "authorized"
+	// PaymentSucceeded means that the card was charged on an automatic capture payment.
+	PaymentSucceeded PaymentStatus = "succeeded"
+	// PaymentCaptured means that the card was charged on a manual capture payment.
+	PaymentCaptured PaymentStatus = "captured"
+	// PaymentFailed means that the acquiring bank declined the payment.
+	PaymentFailed PaymentStatus = "failed"
+	// PaymentCanceled means that the merchant canceled the payment before it was charged.
+	PaymentCanceled PaymentStatus = "canceled"
+	// PaymentUnknown means that the acquiring bank did not answer in time, so it may have charged the card.
+	// The payment stays unknown until a status inquiry or a reversal resolves it.
+	PaymentUnknown PaymentStatus = "unknown"
+)
+
+// paymentTransitions declares all the allowed moves between payment statuses.
+// Statuses that are not keys on this table are final.
+var paymentTransitions = map[PaymentStatus][]PaymentStatus{
+	PaymentProcessing: {PaymentAuthorized, PaymentSucceeded, PaymentFailed, PaymentCanceled, PaymentUnknown},
+	PaymentAuthorized: {PaymentCaptured, PaymentCanceled},
+	PaymentUnknown:    {PaymentProcessing, PaymentAuthorized, PaymentSucceeded, PaymentFailed, PaymentCanceled},
+}
+
+// IsValid reports whether s is one of the known payment statuses.
+func (s PaymentStatus) IsValid() bool {
+	switch s {
+	case PaymentProcessing, PaymentAuthorized, PaymentSucceeded, PaymentCaptured, PaymentFailed, PaymentCanceled, PaymentUnknown:
+		return true
+	}
+	return false
+}
+
+// IsFinal reports whether a payment with this status can not move to any other status.
+func (s PaymentStatus) IsFinal() bool {
+	return len(paymentTransitions[s]) == 0
+}
+
+// CanTransitionTo reports whether a payment can move from s to the given status.
+func (s PaymentStatus) CanTransitionTo(to PaymentStatus) bool {
+	for _, allowed := range paymentTransitions[s] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// PaymentStatusesBefore returns all the statuses that are allowed to move to the given status.
+func PaymentStatusesBefore(to PaymentStatus) []PaymentStatus {
+	from := make([]PaymentStatus, 0)
+	for s, allowed := range paymentTransitions {
+		for _, a := range allowed {
+			if a == to {
+				from = append(from, s)
+			}
+		}
+	}
+	return from
+}
+
+// PaymentTransitionError returns an ErrIllegalTransition describing the rejected payment transition.
+func PaymentTransitionError(from PaymentStatus, to PaymentStatus) error {
+	return fmt.Errorf("%w: payment from %s to %s", ErrIllegalTransition, from, to)
+}
+
+// RefundStatus is the state of a refund on its lifecycle.
+type RefundStatus string
+
+const (
+	// RefundProcessing is the initial status, while we wait for the acquiring bank to process the refund.
+	RefundProcessing RefundStatus = "processing"
+	// RefundSucceeded means that the money was returned to the card.
+	RefundSucceeded RefundStatus = "succeeded"
+	// RefundFailed means that the acquiring bank declined the refund.
+	RefundFailed RefundStatus = "failed"
+)
+
+// refundTransitions declares all the allowed moves between refund statuses.
+// Statuses that are not keys on this table are final.
+var refundTransitions = map[RefundStatus][]RefundStatus{
+	RefundProcessing: {RefundSucceeded, RefundFailed},
+}
+
+// IsValid reports whether s is one of the known refund statuses.
+func (s RefundStatus) IsValid() bool {
+	switch s {
+	case RefundProcessing, RefundSucceeded, RefundFailed:
+		return true
+	}
+	return false
+}
+
+// CanTransitionTo reports whether a refund can move from s to the given status.
+func (s RefundStatus) CanTransitionTo(to RefundStatus) bool {
+	for _, allowed := range refundTransitions[s] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// RefundStatusesBefore returns all the statuses that are allowed to move to the given status.
+func RefundStatusesBefore(to RefundStatus) []RefundStatus {
+	from := make([]RefundStatus, 0)
+	for s, allowed := range refundTransitions {
+		for _, a := range allowed {
+			if a == to {
+				from = append(from, s)
+			}
+		}
+	}
+	return from
+}
+
+// RefundTransitionError returns an ErrIllegalTransition describing the rejected refund transition.
+func RefundTransitionError(from RefundStatus, to RefundStatus) error {
+	return fmt.Errorf("%w: refund from %s to %s", ErrIllegalTransition, from, to)
+}
@@ -0,0 +1,71 @@
+package status_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/marioarizaj/payment-gateway/internal/status"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaymentStatus_CanTransitionTo(t *testing.T) {
+	cases := []struct {
+		name     string
+		from     status.PaymentStatus
+		to       status.PaymentStatus
+		expected bool
+	}{
+		{name: "processing_to_succeeded", from: status.PaymentProcessing, to: status.PaymentSucceeded, expected: true},
+		{name: "processing_to_failed", from: status.PaymentProcessing, to: status.PaymentFailed, expected: true},
+		{name: "processing_to_authorized", from: status.PaymentProcessing, to: status.PaymentAuthorized, expected: true},
+		{name: "processing_to_canceled", from: status.PaymentProcessing, to: status.PaymentCanceled, expected: true},
+		{name: "authorized_to_captured", from: status.PaymentAuthorized, to: status.PaymentCaptured, expected: true},
+		{name: "authorized_to_canceled", from: status.PaymentAuthorized, to: status.PaymentCanceled, expected: true},
+		{name: "processing_to_captured", from: status.PaymentProcessing, to: status.PaymentCaptured, expected: false},
+		{name: "succeeded_to_failed", from: status.PaymentSucceeded, to: status.PaymentFailed, expected: false},
+		{name: "canceled_to_succeeded", from: status.PaymentCanceled, to: status.PaymentSucceeded, expected: false},
+		{name: "processing_to_invalid_status", from: status.PaymentProcessing, to: status.PaymentStatus("success"), expected: false},
+		{name: "processing_to_unknown", from: status.PaymentProcessing, to: status.PaymentUnknown, expected: true},
+		{name: "unknown_to_succeeded", from: status.PaymentUnknown, to: status.PaymentSucceeded, expected: true},
+		{name: "unknown_to_failed", from: status.PaymentUnknown, to: status.PaymentFailed, expected: true},
+		{name: "unknown_to_canceled", from: status.PaymentUnknown, to: status.PaymentCanceled, expected: true},
+		{name: "unknown_to_processing", from: status.PaymentUnknown, to: status.PaymentProcessing, expected: true},
+		{name: "succeeded_to_unknown", from: status.PaymentSucceeded, to: status.PaymentUnknown, expected: false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, c.from.CanTransitionTo(c.to))
+		})
+	}
+}
+
+func TestPaymentStatus_IsFinal(t *testing.T) {
+	assert.False(t, status.PaymentProcessing.IsFinal())
+	assert.False(t, status.PaymentAuthorized.IsFinal())
+	assert.False(t, status.PaymentUnknown.IsFinal())
+	assert.True(t, status.PaymentSucceeded.IsFinal())
+	assert.True(t, status.PaymentCaptured.IsFinal())
+	assert.True(t, status.PaymentFailed.IsFinal())
+	assert.True(t, status.PaymentCanceled.IsFinal())
+}
+
+func TestPaymentStatusesBefore(t *testing.T) {
+	assert.ElementsMatch(t, []status.PaymentStatus{status.PaymentProcessing, status.PaymentAuthorized, status.PaymentUnknown}, status.PaymentStatusesBefore(status.PaymentCanceled))
+	assert.ElementsMatch(t, []status.PaymentStatus{status.PaymentAuthorized}, status.PaymentStatusesBefore(status.PaymentCaptured))
+	// Only payments whose outcome was unknown go back to processing, when the bank is still processing them
+	assert.ElementsMatch(t, []status.PaymentStatus{status.PaymentUnknown}, status.PaymentStatusesBefore(status.PaymentProcessing))
+	assert.ElementsMatch(t, []status.PaymentStatus{status.PaymentProcessing}, status.PaymentStatusesBefore(status.PaymentUnknown))
+}
+
+func TestRefundStatus_CanTransitionTo(t *testing.T) {
+	assert.True(t, status.RefundProcessing.CanTransitionTo(status.RefundSucceeded))
+	assert.True(t, status.RefundProcessing.CanTransitionTo(status.RefundFailed))
+	assert.False(t, status.RefundSucceeded.CanTransitionTo(status.RefundFailed))
+	assert.False(t, status.RefundFailed.CanTransitionTo(status.RefundSucceeded))
+}
+
+func TestTransitionError(t *testing.T) {
+	err := status.PaymentTransitionError(status.PaymentSucceeded, status.PaymentFailed)
+	assert.True(t, errors.Is(err, status.ErrIllegalTransition))
+	assert.Equal(t, "illegal status transition: payment from succeeded to failed", err.Error())
+}
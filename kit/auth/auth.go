@@ -6,6 +6,7 @@ import (
 	"encoding/hex"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/marioarizaj/payment-gateway/kit/ctx"
@@ -50,3 +51,19 @@ func Middleware(secret string) func(next http.Handler) http.Handler {
 		})
 	}
 }
+
+// OperatorMiddleware authenticates the operators of the gateway, with the api key sent as a bearer token on the
+// Authorization header. Every request is rejected when the api key is empty, so the routes are disabled.
+func OperatorMiddleware(apiKey string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			token := strings.TrimPrefix(header, "Bearer ")
+			if apiKey == "" || token == header || !hmac.Equal([]byte(token), []byte(apiKey)) {
+				responses.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
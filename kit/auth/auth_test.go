@@ -0,0 +1,62 @@
+package auth_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/marioarizaj/payment-gateway/kit/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperatorMiddleware(t *testing.T) {
+	cases := []struct {
+		name           string
+		apiKey         string
+		header         string
+		expectedStatus int
+	}{
+		{
+			name:           "valid_api_key",
+			apiKey:         "operator-key",
+			header:         "Bearer operator-key",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "wrong_api_key",
+			apiKey:         "operator-key",
+			header:         "Bearer other-key",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "not_a_bearer_token",
+			apiKey:         "operator-key",
+			header:         "operator-key",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "missing_header",
+			apiKey:         "operator-key",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "api_key_not_configured",
+			header:         "Bearer ",
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			req := httptest.NewRequest(http.MethodGet, "/ops/reconciliation/files", nil)
+			if c.header != "" {
+				req.Header.Set("Authorization", c.header)
+			}
+			rr := httptest.NewRecorder()
+			auth.OperatorMiddleware(c.apiKey)(next).ServeHTTP(rr, req)
+			assert.Equal(t, c.expectedStatus, rr.Code)
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package auth_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marioarizaj/payment-gateway/kit/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyPayload(t *testing.T) {
+	body := []byte(`{"event_id":"evt_1"}`)
+	now := time.Unix(1660208400, 0)
+	cases := []struct {
+		name          string
+		header        string
+		body          []byte
+		expectedError error
+	}{
+		{
+			name:   "valid_signature",
+			header: auth.SignPayload("secret", now, body),
+			body:   body,
+		},
+		{
+			name:   "signed_within_tolerance",
+			header: auth.SignPayload("secret", now.Add(-4*time.Minute), body),
+			body:   body,
+		},
+		{
+			name:          "wrong_secret",
+			header:        auth.SignPayload("other", now, body),
+			body:          body,
+			expectedError: auth.ErrInvalidSignature,
+		},
+		{
+			name:          "changed_body",
+			header:        auth.SignPayload("secret", now, body),
+			body:          []byte(`{"event_id":"evt_2"}`),
+			expectedError: auth.ErrInvalidSignature,
+		},
+		{
+			name:          "signed_too_long_ago",
+			header:        auth.SignPayload("secret", now.Add(-6*time.Minute), body),
+			body:          body,
+			expectedError: auth.ErrStaleSignature,
+		},
+		{
+			name:          "signed_in_the_future",
+			header:        auth.SignPayload("secret", now.Add(6*time.Minute), body),
+			body:          body,
+			expectedError: auth.ErrStaleSignature,
+		},
+		{
+			name:          "missing_header",
+			body:          body,
+			expectedError: auth.ErrInvalidSignature,
+		},
+		{
+			name:          "malformed_header",
+			header:        "v1",
+			body:          body,
+			expectedError: auth.ErrInvalidSignature,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := auth.VerifyPayload("secret", c.header, c.body, now, 5*time.Minute)
+			assert.Equal(t, c.expectedError, err)
+		})
+	}
+}
+
+func TestVerifyPayload_EmptySecret(t *testing.T) {
+	body := []byte(`{"event_id":"evt_1"}`)
+	now := time.Unix(1660208400, 0)
+	err := auth.VerifyPayload("", auth.SignPayload("", now, body), body, now, 5*time.Minute)
+	assert.Equal(t, auth.ErrInvalidSignature, err)
+}
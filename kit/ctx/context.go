@@ -3,13 +3,17 @@ package ctx
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 type ctxKey int
 
-const merchantIdKey ctxKey = 1
+const (
+	merchantIdKey ctxKey = iota + 1
+	requestIdKey
+)
 
 var notFoundError = errors.New("not found")
 
@@ -25,3 +29,42 @@ func GetMerchantID(ctx context.Context) (uuid.UUID, error) {
 	}
 	return value, nil
 }
+
+// AddRequestID stores the id of the request, so it can be logged and sent along to the acquiring bank.
+func AddRequestID(ctx context.Context, value string) context.Context {
+	return context.WithValue(ctx, requestIdKey, value)
+}
+
+func GetRequestID(ctx context.Context) (string, error) {
+	value, ok := ctx.Value(requestIdKey).(string)
+	if !ok {
+		return "", notFoundError
+	}
+	return value, nil
+}
+
+// WithoutCancel returns a context with the values of the given one, which is never canceled and has no deadline.
+// It is used for the writes that have to finish even when the request that started them is gone.
+func WithoutCancel(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
+
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
@@ -0,0 +1,27 @@
+package ctx_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	kitctx "github.com/marioarizaj/payment-gateway/kit/ctx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithoutCancel(t *testing.T) {
+	merchantID := uuid.New()
+	parent, cancel := context.WithTimeout(kitctx.AddMerchantID(context.Background(), merchantID), time.Minute)
+	detached := kitctx.WithoutCancel(parent)
+	cancel()
+
+	assert.Error(t, parent.Err())
+	assert.NoError(t, detached.Err())
+	assert.Nil(t, detached.Done())
+	_, ok := detached.Deadline()
+	assert.False(t, ok)
+	value, err := kitctx.GetMerchantID(detached)
+	assert.NoError(t, err)
+	assert.Equal(t, merchantID, value)
+}
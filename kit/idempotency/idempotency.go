@@ -0,0 +1,182 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/marioarizaj/payment-gateway/kit/ctx"
+	"github.com/marioarizaj/payment-gateway/kit/responses"
+	"go.uber.org/zap"
+)
+
+const (
+	// HeaderKey is the header merchants use to send the idempotency key of a request
+	HeaderKey = "Idempotency-Key"
+	// HeaderReplayed is set on responses that are replayed from a previous request
+	HeaderReplayed = "Idempotent-Replayed"
+
+	cacheKeyPrefix = "idempotency"
+	maxKeyLength   = 255
+	// lockExpiration releases the key of a request that never finished, for example when the server crashed
+	lockExpiration = time.Minute
+	// responseExpiration is how long a response can be replayed
+	responseExpiration = 24 * time.Hour
+)
+
+type Cache interface {
+	SetValueIfNotExists(ctx context.Context, k string, v interface{}, expiration time.Duration) (bool, error)
+	SetValue(ctx context.Context, k string, v interface{}, expiration time.Duration) error
+	GetValue(ctx context.Context, k string, dest interface{}) error
+	DeleteKey(ctx context.Context, k string) error
+}
+
+// record is what is stored for each idempotency key. Until the request finishes, only the hash of the request is set.
+type record struct {
+	RequestHash string `json:"request_hash"`
+	Completed   bool   `json:"completed"`
+	StatusCode  int    `json:"status_code,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// responseRecorder writes the response to the client, while keeping a copy of it so it can be stored.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *responseRecorder) WriteHeader(code int) {
+	if rw.status == 0 {
+		rw.status = code
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseRecorder) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+// Middleware makes POST requests that have an Idempotency-Key header safe to retry. Keys are scoped to the merchant.
+// The first request with a key is processed and its response is stored. Later requests with the same key and body
+// get the stored response, while requests with the same key and a different body are rejected with 422.
+// A request that arrives while the first one is still being processed is rejected with 409.
+// Server errors are not stored, so the request can be retried with the same key.
+func Middleware(cache Cache, logger *zap.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(HeaderKey)
+			if key == "" || r.Method != http.MethodPost {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxKeyLength {
+				responses.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s can not be longer than %d characters", HeaderKey, maxKeyLength))
+				return
+			}
+			merchantID, err := ctx.GetMerchantID(r.Context())
+			if err != nil {
+				responses.AuthenticationError(w)
+				return
+			}
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				responses.RespondWithError(w, http.StatusBadRequest, "could not read request body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			cacheKey := fmt.Sprintf("%s_%s_%s", cacheKeyPrefix, merchantID, key)
+			requestHash := hashRequest(r, body)
+			locked, err := cache.SetValueIfNotExists(r.Context(), cacheKey, record{RequestHash: requestHash}, lockExpiration)
+			if err != nil {
+				logger.Error("Redis unexpected error", zap.Error(err))
+				responses.InternalServerError{Err: err}.Response(w)
+				return
+			}
+			if !locked {
+				replay(w, r, cache, cacheKey, requestHash, logger)
+				return
+			}
+
+			// The key is released when the request does not finish with a response that can be stored,
+			// including when the handler panics.
+			stored := false
+			defer func() {
+				if stored {
+					return
+				}
+				err := cache.DeleteKey(context.Background(), cacheKey)
+				if err != nil {
+					logger.Error("Redis unexpected error", zap.Error(err))
+				}
+			}()
+
+			recorder := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r)
+			if recorder.status >= http.StatusInternalServerError {
+				return
+			}
+			err = cache.SetValue(context.Background(), cacheKey, record{
+				RequestHash: requestHash,
+				Completed:   true,
+				StatusCode:  recorder.status,
+				ContentType: recorder.Header().Get("Content-Type"),
+				Body:        recorder.body.Bytes(),
+			}, responseExpiration)
+			if err != nil {
+				logger.Error("Could not store idempotent response", zap.String("key", key), zap.Error(err))
+				return
+			}
+			stored = true
+		})
+	}
+}
+
+func replay(w http.ResponseWriter, r *http.Request, cache Cache, cacheKey string, requestHash string, logger *zap.Logger) {
+	var existing record
+	err := cache.GetValue(r.Context(), cacheKey, &existing)
+	if err == redis.Nil {
+		// The first request released the key just now, so it is still not safe to process this one
+		responses.RespondWithError(w, http.StatusConflict, "a request with the same idempotency key is being processed")
+		return
+	}
+	if err != nil {
+		logger.Error("Redis unexpected error", zap.Error(err))
+		responses.InternalServerError{Err: err}.Response(w)
+		return
+	}
+	if existing.RequestHash != requestHash {
+		responses.RespondWithError(w, http.StatusUnprocessableEntity, "idempotency key was already used with a different request")
+		return
+	}
+	if !existing.Completed {
+		responses.RespondWithError(w, http.StatusConflict, "a request with the same idempotency key is being processed")
+		return
+	}
+	if existing.ContentType != "" {
+		w.Header().Set("Content-Type", existing.ContentType)
+	}
+	w.Header().Set(HeaderReplayed, "true")
+	w.WriteHeader(existing.StatusCode)
+	_, _ = w.Write(existing.Body)
+}
+
+// hashRequest identifies a request by its method, path and body, so a key can not be reused on a different endpoint.
+func hashRequest(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
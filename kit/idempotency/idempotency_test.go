@@ -0,0 +1,201 @@
+package idempotency_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway/kit/ctx"
+	"github.com/marioarizaj/payment-gateway/kit/idempotency"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// memoryCache is a cache that behaves like the redis client, without needing a redis server
+type memoryCache struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{values: make(map[string][]byte)}
+}
+
+func (c *memoryCache) SetValueIfNotExists(_ context.Context, k string, v interface{}, _ time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.values[k]; ok {
+		return false, nil
+	}
+	bts, err := json.Marshal(v)
+	c.values[k] = bts
+	return true, err
+}
+
+func (c *memoryCache) SetValue(_ context.Context, k string, v interface{}, _ time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	bts, err := json.Marshal(v)
+	c.values[k] = bts
+	return err
+}
+
+func (c *memoryCache) GetValue(_ context.Context, k string, dest interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	bts, ok := c.values[k]
+	if !ok {
+		return redis.Nil
+	}
+	return json.Unmarshal(bts, dest)
+}
+
+func (c *memoryCache) DeleteKey(_ context.Context, k string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.values, k)
+	return nil
+}
+
+var merchantID = uuid.Must(uuid.Parse("6c5a19d0-f132-4a55-93d3-2c00db06d41b"))
+
+func newRequest(key string, body string, merchant uuid.UUID) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/v1/payments", bytes.NewBufferString(body))
+	req.Header.Set(idempotency.HeaderKey, key)
+	return req.WithContext(ctx.AddMerchantID(req.Context(), merchant))
+}
+
+func TestMiddleware(t *testing.T) {
+	cases := []struct {
+		name            string
+		handlerStatus   int
+		firstRequest    *http.Request
+		secondRequest   *http.Request
+		expectedCode    int
+		expectedCalls   int
+		expectedReplay  bool
+		expectedMessage string
+	}{
+		{
+			name:           "replay_same_request",
+			handlerStatus:  http.StatusCreated,
+			firstRequest:   newRequest("key-1", `{"amount":1000}`, merchantID),
+			secondRequest:  newRequest("key-1", `{"amount":1000}`, merchantID),
+			expectedCode:   http.StatusCreated,
+			expectedCalls:  1,
+			expectedReplay: true,
+		},
+		{
+			name:            "same_key_different_body",
+			handlerStatus:   http.StatusCreated,
+			firstRequest:    newRequest("key-1", `{"amount":1000}`, merchantID),
+			secondRequest:   newRequest("key-1", `{"amount":2000}`, merchantID),
+			expectedCode:    http.StatusUnprocessableEntity,
+			expectedCalls:   1,
+			expectedMessage: "idempotency key was already used with a different request",
+		},
+		{
+			name:          "same_key_other_merchant",
+			handlerStatus: http.StatusCreated,
+			firstRequest:  newRequest("key-1", `{"amount":1000}`, merchantID),
+			secondRequest: newRequest("key-1", `{"amount":1000}`, uuid.Must(uuid.Parse("a1e3f405-44f0-44b4-a584-b0b3c80bc8ac"))),
+			expectedCode:  http.StatusCreated,
+			expectedCalls: 2,
+		},
+		{
+			name:           "client_errors_are_replayed",
+			handlerStatus:  http.StatusBadRequest,
+			firstRequest:   newRequest("key-1", `{"amount":1000}`, merchantID),
+			secondRequest:  newRequest("key-1", `{"amount":1000}`, merchantID),
+			expectedCode:   http.StatusBadRequest,
+			expectedCalls:  1,
+			expectedReplay: true,
+		},
+		{
+			name:          "server_errors_can_be_retried",
+			handlerStatus: http.StatusInternalServerError,
+			firstRequest:  newRequest("key-1", `{"amount":1000}`, merchantID),
+			secondRequest: newRequest("key-1", `{"amount":1000}`, merchantID),
+			expectedCode:  http.StatusInternalServerError,
+			expectedCalls: 2,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			calls := 0
+			handler := idempotency.Middleware(newMemoryCache(), zap.NewNop())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(c.handlerStatus)
+				_, _ = w.Write([]byte(`{"call":1}`))
+			}))
+			first := httptest.NewRecorder()
+			handler.ServeHTTP(first, c.firstRequest)
+			second := httptest.NewRecorder()
+			handler.ServeHTTP(second, c.secondRequest)
+
+			assert.Equal(t, c.expectedCode, second.Code)
+			assert.Equal(t, c.expectedCalls, calls)
+			if c.expectedMessage != "" {
+				var resBody map[string]string
+				err := json.NewDecoder(second.Body).Decode(&resBody)
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Equal(t, c.expectedMessage, resBody["error"])
+				return
+			}
+			if c.expectedReplay {
+				assert.Equal(t, "true", second.Header().Get(idempotency.HeaderReplayed))
+				assert.Equal(t, first.Body.String(), second.Body.String())
+				assert.Equal(t, "application/json", second.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestMiddleware_ConcurrentRequest(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := idempotency.Middleware(newMemoryCache(), zap.NewNop())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	first := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(first, newRequest("key-1", `{"amount":1000}`, merchantID))
+		close(done)
+	}()
+	<-started
+
+	second := httptest.NewRecorder()
+	handler.ServeHTTP(second, newRequest("key-1", `{"amount":1000}`, merchantID))
+	assert.Equal(t, http.StatusConflict, second.Code)
+
+	close(release)
+	<-done
+	assert.Equal(t, http.StatusCreated, first.Code)
+}
+
+func TestMiddleware_WithoutKey(t *testing.T) {
+	calls := 0
+	handler := idempotency.Middleware(newMemoryCache(), zap.NewNop())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+	}))
+	for i := 0; i < 2; i++ {
+		req := newRequest("", `{"amount":1000}`, merchantID)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	assert.Equal(t, 2, calls)
+}
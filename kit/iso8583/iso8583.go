@@ -0,0 +1,302 @@
+// Package iso8583 encodes and decodes ISO 8583 messages. The layout of every field is described by a Spec,
+// so the same codec can be used with the different dialects acquirers use.
+package iso8583
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+type Encoding int
+
+const (
+	// ASCII encodes every character on its own byte
+	ASCII Encoding = iota
+	// BCD packs two digits on each byte. Values with an odd number of digits are padded with a leading 0.
+	BCD
+)
+
+type LengthType int
+
+const (
+	// Fixed fields always have the length of their spec
+	Fixed LengthType = iota
+	// LLVar fields are prefixed with their length, using 2 digits
+	LLVar
+	// LLLVar fields are prefixed with their length, using 3 digits
+	LLLVar
+)
+
+// FieldSpec describes how a field is encoded. Length is the exact length of fixed fields, and the maximum length
+// of variable fields. Lengths are counted in characters, or digits for BCD fields.
+type FieldSpec struct {
+	Description string
+	Length      int
+	LengthType  LengthType
+	Encoding    Encoding
+}
+
+// Spec describes the encoding of the MTI and of every field a message can contain. Bitmaps are always binary.
+type Spec struct {
+	MTIEncoding Encoding
+	Fields      map[int]FieldSpec
+}
+
+// Message is an ISO 8583 message, with its fields stored by their number.
+type Message struct {
+	MTI    string
+	Fields map[int]string
+}
+
+func NewMessage(mti string) *Message {
+	return &Message{
+		MTI:    mti,
+		Fields: map[int]string{},
+	}
+}
+
+func (m *Message) Set(field int, value string) {
+	m.Fields[field] = value
+}
+
+// Get returns the value of the field, or an empty string when the message does not contain it.
+func (m *Message) Get(field int) string {
+	return m.Fields[field]
+}
+
+func (m *Message) Has(field int) bool {
+	_, ok := m.Fields[field]
+	return ok
+}
+
+// OriginalDataElements returns field 90 of a message that refers to an earlier one, like a completion or a reversal:
+// the MTI, STAN and transmission date and time of the original message, followed by the acquiring and forwarding
+// institution ids, which are left as zeros.
+func OriginalDataElements(mti, stan, transmissionDateTime string) string {
+	return fmt.Sprintf("%4s%6s%10s%022d", mti, stan, transmissionDateTime, 0)
+}
+
+// ResponseMTI returns the MTI of the response to a request with the given MTI, for example 0210 for 0200.
+func ResponseMTI(mti string) (string, error) {
+	if len(mti) != 4 || !isNumeric(mti) {
+		return "", fmt.Errorf("mti %s is not valid", mti)
+	}
+	function := mti[2]
+	if function != '0' && function != '2' && function != '4' {
+		return "", fmt.Errorf("mti %s is not a request", mti)
+	}
+	return mti[:2] + string(function+1) + mti[3:], nil
+}
+
+// Pack encodes the message. It fails when the message contains a field that is not in the spec,
+// or a value that does not fit its field.
+func (s Spec) Pack(m *Message) ([]byte, error) {
+	if len(m.MTI) != 4 || !isNumeric(m.MTI) {
+		return nil, fmt.Errorf("mti %s is not valid", m.MTI)
+	}
+	out, err := encodeValue(m.MTI, s.MTIEncoding)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([]int, 0, len(m.Fields))
+	for field := range m.Fields {
+		if field < 2 || field > 128 {
+			return nil, fmt.Errorf("field %d can not be set, fields go from 2 to 128", field)
+		}
+		fields = append(fields, field)
+	}
+	sort.Ints(fields)
+
+	bitmapLength := 8
+	if len(fields) > 0 && fields[len(fields)-1] > 64 {
+		bitmapLength = 16
+	}
+	bitmap := make([]byte, bitmapLength)
+	if bitmapLength == 16 {
+		// The first bit tells that the secondary bitmap follows the primary one
+		bitmap[0] |= 0x80
+	}
+	for _, field := range fields {
+		bitmap[(field-1)/8] |= 0x80 >> ((field - 1) % 8)
+	}
+	out = append(out, bitmap...)
+
+	for _, field := range fields {
+		spec, ok := s.Fields[field]
+		if !ok {
+			return nil, fmt.Errorf("field %d is not defined in the spec", field)
+		}
+		packed, err := spec.pack(m.Fields[field])
+		if err != nil {
+			return nil, fmt.Errorf("field %d: %w", field, err)
+		}
+		out = append(out, packed...)
+	}
+	return out, nil
+}
+
+// Unpack decodes a message encoded with the spec.
+func (s Spec) Unpack(b []byte) (*Message, error) {
+	r := &reader{b: b}
+	mti, err := r.readValue(4, s.MTIEncoding)
+	if err != nil {
+		return nil, fmt.Errorf("could not read mti: %w", err)
+	}
+	m := NewMessage(mti)
+
+	bitmap, err := r.read(8)
+	if err != nil {
+		return nil, fmt.Errorf("could not read bitmap: %w", err)
+	}
+	if bitmap[0]&0x80 != 0 {
+		secondary, err := r.read(8)
+		if err != nil {
+			return nil, fmt.Errorf("could not read secondary bitmap: %w", err)
+		}
+		bitmap = append(bitmap, secondary...)
+	}
+
+	for field := 2; field <= len(bitmap)*8; field++ {
+		if bitmap[(field-1)/8]&(0x80>>((field-1)%8)) == 0 {
+			continue
+		}
+		spec, ok := s.Fields[field]
+		if !ok {
+			return nil, fmt.Errorf("field %d is not defined in the spec", field)
+		}
+		value, err := spec.unpack(r)
+		if err != nil {
+			return nil, fmt.Errorf("field %d: %w", field, err)
+		}
+		m.Set(field, value)
+	}
+	if r.remaining() > 0 {
+		return nil, fmt.Errorf("message has %d unexpected bytes after the last field", r.remaining())
+	}
+	return m, nil
+}
+
+func (f FieldSpec) pack(value string) ([]byte, error) {
+	if f.Encoding == BCD && !isNumeric(value) {
+		return nil, errors.New("bcd values can only contain digits")
+	}
+	switch f.LengthType {
+	case Fixed:
+		if len(value) != f.Length {
+			return nil, fmt.Errorf("value must be %d characters long, got %d", f.Length, len(value))
+		}
+		return encodeValue(value, f.Encoding)
+	case LLVar, LLLVar:
+		if len(value) > f.Length {
+			return nil, fmt.Errorf("value can not be longer than %d characters, got %d", f.Length, len(value))
+		}
+		prefix, err := encodeValue(fmt.Sprintf("%0*d", f.prefixDigits(), len(value)), f.Encoding)
+		if err != nil {
+			return nil, err
+		}
+		data, err := encodeValue(value, f.Encoding)
+		if err != nil {
+			return nil, err
+		}
+		return append(prefix, data...), nil
+	default:
+		return nil, fmt.Errorf("length type %d is not supported", f.LengthType)
+	}
+}
+
+func (f FieldSpec) unpack(r *reader) (string, error) {
+	length := f.Length
+	if f.LengthType != Fixed {
+		prefix, err := r.readValue(f.prefixDigits(), f.Encoding)
+		if err != nil {
+			return "", fmt.Errorf("could not read length: %w", err)
+		}
+		length, err = strconv.Atoi(prefix)
+		if err != nil {
+			return "", fmt.Errorf("length %s is not a number", prefix)
+		}
+		if length > f.Length {
+			return "", fmt.Errorf("length %d is longer than the maximum of %d", length, f.Length)
+		}
+	}
+	return r.readValue(length, f.Encoding)
+}
+
+func (f FieldSpec) prefixDigits() int {
+	if f.LengthType == LLLVar {
+		return 3
+	}
+	return 2
+}
+
+// encodeValue encodes the characters of the value. BCD values must only contain digits.
+func encodeValue(value string, encoding Encoding) ([]byte, error) {
+	switch encoding {
+	case ASCII:
+		return []byte(value), nil
+	case BCD:
+		if !isNumeric(value) {
+			return nil, errors.New("bcd values can only contain digits")
+		}
+		if len(value)%2 != 0 {
+			value = "0" + value
+		}
+		return hex.DecodeString(value)
+	default:
+		return nil, fmt.Errorf("encoding %d is not supported", encoding)
+	}
+}
+
+// reader reads the fields of a message one after the other.
+type reader struct {
+	b      []byte
+	offset int
+}
+
+func (r *reader) read(n int) ([]byte, error) {
+	if r.remaining() < n {
+		return nil, fmt.Errorf("message is too short, need %d bytes but only %d are left", n, r.remaining())
+	}
+	out := r.b[r.offset : r.offset+n]
+	r.offset += n
+	return out, nil
+}
+
+// readValue reads a value with the given number of characters, or digits for BCD values.
+func (r *reader) readValue(length int, encoding Encoding) (string, error) {
+	switch encoding {
+	case ASCII:
+		b, err := r.read(length)
+		return string(b), err
+	case BCD:
+		b, err := r.read((length + 1) / 2)
+		if err != nil {
+			return "", err
+		}
+		digits := hex.EncodeToString(b)
+		if !isNumeric(digits) {
+			return "", fmt.Errorf("bcd value %s contains characters that are not digits", digits)
+		}
+		// Odd values are padded with a leading 0
+		return digits[len(digits)-length:], nil
+	default:
+		return "", fmt.Errorf("encoding %d is not supported", encoding)
+	}
+}
+
+func (r *reader) remaining() int {
+	return len(r.b) - r.offset
+}
+
+func isNumeric(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
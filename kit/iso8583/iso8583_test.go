@@ -0,0 +1,226 @@
+package iso8583_test
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/marioarizaj/payment-gateway/kit/iso8583"
+	"github.com/stretchr/testify/assert"
+)
+
+var bcdSpec = iso8583.Spec{
+	MTIEncoding: iso8583.BCD,
+	Fields: map[int]iso8583.FieldSpec{
+		2:  {Length: 19, LengthType: iso8583.LLVar, Encoding: iso8583.BCD},
+		3:  {Length: 6, LengthType: iso8583.Fixed, Encoding: iso8583.BCD},
+		4:  {Length: 12, LengthType: iso8583.Fixed, Encoding: iso8583.BCD},
+		11: {Length: 6, LengthType: iso8583.Fixed, Encoding: iso8583.BCD},
+		14: {Length: 3, LengthType: iso8583.Fixed, Encoding: iso8583.BCD},
+		48: {Length: 999, LengthType: iso8583.LLLVar, Encoding: iso8583.BCD},
+		70: {Length: 3, LengthType: iso8583.Fixed, Encoding: iso8583.BCD},
+	},
+}
+
+func TestSpec_Pack(t *testing.T) {
+	cases := []struct {
+		name          string
+		spec          iso8583.Spec
+		message       func() *iso8583.Message
+		expectedHex   string
+		expectedError string
+	}{
+		{
+			name: "ascii_primary_bitmap",
+			spec: iso8583.DefaultSpec,
+			message: func() *iso8583.Message {
+				m := iso8583.NewMessage("0200")
+				m.Set(iso8583.FieldPAN, "4111111111111111")
+				m.Set(iso8583.FieldProcessingCode, "000000")
+				m.Set(iso8583.FieldResponseCode, "00")
+				return m
+			},
+			// MTI, bitmap with fields 2, 3 and 39, LLVAR PAN, fixed processing code and response code
+			expectedHex: hex.EncodeToString([]byte("0200")) + "6000000002000000" +
+				hex.EncodeToString([]byte("164111111111111111000000")) + hex.EncodeToString([]byte("00")),
+		},
+		{
+			name: "ascii_secondary_bitmap",
+			spec: iso8583.DefaultSpec,
+			message: func() *iso8583.Message {
+				m := iso8583.NewMessage("0800")
+				m.Set(iso8583.FieldSTAN, "000001")
+				m.Set(iso8583.FieldNetworkManagementCode, "301")
+				return m
+			},
+			expectedHex: hex.EncodeToString([]byte("0800")) + "8020000000000000" + "0400000000000000" +
+				hex.EncodeToString([]byte("000001301")),
+		},
+		{
+			name: "bcd_fields",
+			spec: bcdSpec,
+			message: func() *iso8583.Message {
+				m := iso8583.NewMessage("0100")
+				m.Set(2, "411111111111111")
+				m.Set(4, "000000002000")
+				m.Set(14, "123")
+				return m
+			},
+			// Odd values are padded with a leading 0, and the length prefix of LLVAR fields is a single byte
+			expectedHex: "0100" + "5004000000000000" + "15" + "0411111111111111" + "000000002000" + "0123",
+		},
+		{
+			name: "bcd_lllvar",
+			spec: bcdSpec,
+			message: func() *iso8583.Message {
+				m := iso8583.NewMessage("0100")
+				m.Set(48, "12345")
+				return m
+			},
+			expectedHex: "0100" + "0000000000010000" + "0005" + "012345",
+		},
+		{
+			name: "fixed_field_with_wrong_length",
+			spec: iso8583.DefaultSpec,
+			message: func() *iso8583.Message {
+				m := iso8583.NewMessage("0200")
+				m.Set(iso8583.FieldAmount, "2000")
+				return m
+			},
+			expectedError: "field 4: value must be 12 characters long, got 4",
+		},
+		{
+			name: "variable_field_too_long",
+			spec: iso8583.DefaultSpec,
+			message: func() *iso8583.Message {
+				m := iso8583.NewMessage("0200")
+				m.Set(iso8583.FieldPAN, "41111111111111111111")
+				return m
+			},
+			expectedError: "field 2: value can not be longer than 19 characters, got 20",
+		},
+		{
+			name: "bcd_field_with_letters",
+			spec: bcdSpec,
+			message: func() *iso8583.Message {
+				m := iso8583.NewMessage("0200")
+				m.Set(3, "00000A")
+				return m
+			},
+			expectedError: "field 3: bcd values can only contain digits",
+		},
+		{
+			name: "field_not_in_spec",
+			spec: bcdSpec,
+			message: func() *iso8583.Message {
+				m := iso8583.NewMessage("0200")
+				m.Set(5, "1")
+				return m
+			},
+			expectedError: "field 5 is not defined in the spec",
+		},
+		{
+			name: "invalid_mti",
+			spec: iso8583.DefaultSpec,
+			message: func() *iso8583.Message {
+				return iso8583.NewMessage("20")
+			},
+			expectedError: "mti 20 is not valid",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := c.message()
+			packed, err := c.spec.Pack(m)
+			if c.expectedError != "" {
+				assert.EqualError(t, err, c.expectedError)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, c.expectedHex, hex.EncodeToString(packed))
+
+			unpacked, err := c.spec.Unpack(packed)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, m, unpacked)
+		})
+	}
+}
+
+func TestSpec_Unpack(t *testing.T) {
+	m := iso8583.NewMessage("0210")
+	m.Set(iso8583.FieldPAN, "4111111111111111")
+	m.Set(iso8583.FieldResponseCode, "00")
+	packed, err := iso8583.DefaultSpec.Pack(m)
+	if !assert.NoError(t, err) {
+		return
+	}
+	cases := []struct {
+		name          string
+		input         []byte
+		expectedError string
+	}{
+		{
+			name:          "truncated_mti",
+			input:         packed[:2],
+			expectedError: "could not read mti: message is too short, need 4 bytes but only 2 are left",
+		},
+		{
+			name:          "truncated_bitmap",
+			input:         packed[:8],
+			expectedError: "could not read bitmap: message is too short, need 8 bytes but only 4 are left",
+		},
+		{
+			name:          "truncated_field",
+			input:         packed[:len(packed)-1],
+			expectedError: "field 39: message is too short, need 2 bytes but only 1 are left",
+		},
+		{
+			name:          "trailing_bytes",
+			input:         append(append([]byte{}, packed...), '0'),
+			expectedError: "message has 1 unexpected bytes after the last field",
+		},
+		{
+			name:          "invalid_length_prefix",
+			input:         append(append([]byte{}, packed[:12]...), []byte("x1")...),
+			expectedError: "field 2: length x1 is not a number",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := iso8583.DefaultSpec.Unpack(c.input)
+			assert.EqualError(t, err, c.expectedError)
+		})
+	}
+}
+
+func TestResponseMTI(t *testing.T) {
+	cases := map[string]string{
+		"0100": "0110",
+		"0200": "0210",
+		"0220": "0230",
+		"0400": "0410",
+		"0800": "0810",
+	}
+	for request, response := range cases {
+		actual, err := iso8583.ResponseMTI(request)
+		if assert.NoError(t, err) {
+			assert.Equal(t, response, actual)
+		}
+	}
+	_, err := iso8583.ResponseMTI("0210")
+	assert.EqualError(t, err, "mti 0210 is not a request")
+}
+
+func TestOriginalDataElements(t *testing.T) {
+	value := iso8583.OriginalDataElements("0100", "000042", "0902091530")
+	assert.Equal(t, "01000000420902091530"+strings.Repeat("0", 22), value)
+	// The value fills field 90
+	m := iso8583.NewMessage("0400")
+	m.Set(iso8583.FieldOriginalDataElements, value)
+	_, err := iso8583.DefaultSpec.Pack(m)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,48 @@
+package iso8583
+
+// Field numbers of the data elements used by the gateway.
+const (
+	FieldPAN                   = 2
+	FieldProcessingCode        = 3
+	FieldAmount                = 4
+	FieldTransmissionDateTime  = 7
+	FieldSTAN                  = 11
+	FieldLocalTime             = 12
+	FieldLocalDate             = 13
+	FieldExpiryDate            = 14
+	FieldPOSEntryMode          = 22
+	FieldRRN                   = 37
+	FieldAuthorizationCode     = 38
+	FieldResponseCode          = 39
+	FieldTerminalID            = 41
+	FieldCardAcceptorID        = 42
+	FieldAdditionalData        = 48
+	FieldCurrencyCode          = 49
+	FieldNetworkManagementCode = 70
+	FieldOriginalDataElements  = 90
+)
+
+// DefaultSpec is the subset of ISO 8583:1987 exchanged with the acquirer. The MTI and every field are ASCII.
+var DefaultSpec = Spec{
+	MTIEncoding: ASCII,
+	Fields: map[int]FieldSpec{
+		FieldPAN:                   {Description: "Primary account number", Length: 19, LengthType: LLVar, Encoding: ASCII},
+		FieldProcessingCode:        {Description: "Processing code", Length: 6, LengthType: Fixed, Encoding: ASCII},
+		FieldAmount:                {Description: "Amount, transaction", Length: 12, LengthType: Fixed, Encoding: ASCII},
+		FieldTransmissionDateTime:  {Description: "Transmission date and time, MMDDhhmmss", Length: 10, LengthType: Fixed, Encoding: ASCII},
+		FieldSTAN:                  {Description: "Systems trace audit number", Length: 6, LengthType: Fixed, Encoding: ASCII},
+		FieldLocalTime:             {Description: "Time, local transaction, hhmmss", Length: 6, LengthType: Fixed, Encoding: ASCII},
+		FieldLocalDate:             {Description: "Date, local transaction, MMDD", Length: 4, LengthType: Fixed, Encoding: ASCII},
+		FieldExpiryDate:            {Description: "Date, expiration, YYMM", Length: 4, LengthType: Fixed, Encoding: ASCII},
+		FieldPOSEntryMode:          {Description: "Point of service entry mode", Length: 3, LengthType: Fixed, Encoding: ASCII},
+		FieldRRN:                   {Description: "Retrieval reference number", Length: 12, LengthType: Fixed, Encoding: ASCII},
+		FieldAuthorizationCode:     {Description: "Authorization identification response", Length: 6, LengthType: Fixed, Encoding: ASCII},
+		FieldResponseCode:          {Description: "Response code", Length: 2, LengthType: Fixed, Encoding: ASCII},
+		FieldTerminalID:            {Description: "Card acceptor terminal identification", Length: 8, LengthType: Fixed, Encoding: ASCII},
+		FieldCardAcceptorID:        {Description: "Card acceptor identification code", Length: 15, LengthType: Fixed, Encoding: ASCII},
+		FieldAdditionalData:        {Description: "Additional data, private", Length: 999, LengthType: LLLVar, Encoding: ASCII},
+		FieldCurrencyCode:          {Description: "Currency code, transaction", Length: 3, LengthType: Fixed, Encoding: ASCII},
+		FieldNetworkManagementCode: {Description: "Network management information code", Length: 3, LengthType: Fixed, Encoding: ASCII},
+		FieldOriginalDataElements:  {Description: "Original data elements", Length: 42, LengthType: Fixed, Encoding: ASCII},
+	},
+}
@@ -5,6 +5,7 @@ import (
 	"runtime/debug"
 	"time"
 
+	"github.com/marioarizaj/payment-gateway/kit/ctx"
 	"go.uber.org/zap"
 )
 
@@ -48,7 +49,9 @@ func Middleware(logger *zap.Logger) func(http.Handler) http.Handler {
 			start := time.Now()
 			wrapped := wrapResponseWriter(w)
 			next.ServeHTTP(wrapped, r)
+			requestID, _ := ctx.GetRequestID(r.Context())
 			logger.Info("Request",
+				zap.String("request_id", requestID),
 				zap.Int("status", wrapped.status),
 				zap.String("method", r.Method),
 				zap.String("path", r.URL.EscapedPath()),
@@ -0,0 +1,69 @@
+package prometheus
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// RejectionCircuitOpen is the reason of the calls rejected because the breaker was open
+	RejectionCircuitOpen = "circuit_open"
+	// RejectionMaxConcurrency is the reason of the calls rejected because the breaker had too many calls running
+	RejectionMaxConcurrency = "max_concurrency"
+)
+
+var (
+	breakerRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "circuit_breaker_rejections_total",
+		Help: "Calls rejected by a circuit breaker without being sent to the acquirer.",
+	}, []string{"acquirer", "operation", "reason"})
+	breakerFallbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "circuit_breaker_fallbacks_total",
+		Help: "Calls that ended on the fallback of a circuit breaker, because they failed, timed out or were rejected.",
+	}, []string{"acquirer", "operation"})
+	breakerOpenDesc = prometheus.NewDesc(
+		"circuit_breaker_open",
+		"Whether the circuit breaker is open, 1 when it is and 0 when it is not.",
+		[]string{"acquirer", "operation"}, nil,
+	)
+)
+
+// BreakerState tells whether the circuit breaker of an operation on an acquirer is open.
+type BreakerState struct {
+	Acquirer  string
+	Operation string
+	Open      bool
+}
+
+// RegisterBreakerStates exports the state of the circuit breakers, which is read from the given function on every scrape.
+func RegisterBreakerStates(states func() []BreakerState) error {
+	return prometheus.Register(breakerStateCollector{states: states})
+}
+
+// BreakerRejected counts a call that the circuit breaker did not send to the acquirer, for the given reason.
+func BreakerRejected(acquirer, operation, reason string) {
+	breakerRejections.WithLabelValues(acquirer, operation, reason).Inc()
+}
+
+// BreakerFallback counts a call that ended on the fallback of the circuit breaker.
+func BreakerFallback(acquirer, operation string) {
+	breakerFallbacks.WithLabelValues(acquirer, operation).Inc()
+}
+
+type breakerStateCollector struct {
+	states func() []BreakerState
+}
+
+func (c breakerStateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- breakerOpenDesc
+}
+
+func (c breakerStateCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, state := range c.states() {
+		var open float64
+		if state.Open {
+			open = 1
+		}
+		ch <- prometheus.MustNewConstMetric(breakerOpenDesc, prometheus.GaugeValue, open, state.Acquirer, state.Operation)
+	}
+}
@@ -0,0 +1,41 @@
+package prometheus
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// SweepResolved is the outcome of the inquiries that moved a stuck payment out of processing
+	SweepResolved = "resolved"
+	// SweepRetried is the outcome of the inquiries that did not tell the status of a stuck payment, and are sent again
+	SweepRetried = "retried"
+	// SweepReview is the outcome of the inquiries after which a stuck payment is flagged for manual review
+	SweepReview = "review"
+)
+
+var (
+	stuckPayments = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "stuck_payments",
+		Help: "Payments processing for longer than the SLA, on the last sweep.",
+	})
+	stuckPaymentsReview = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "stuck_payments_review",
+		Help: "Stuck payments flagged for manual review, on the last sweep.",
+	})
+	stuckPaymentSweeps = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "stuck_payment_sweeps_total",
+		Help: "Status inquiries sent for stuck payments, by their outcome.",
+	}, []string{"outcome"})
+)
+
+// SetStuckPayments sets how many payments are stuck, and how many of them are flagged for manual review.
+func SetStuckPayments(stuck, review int) {
+	stuckPayments.Set(float64(stuck))
+	stuckPaymentsReview.Set(float64(review))
+}
+
+// StuckPaymentSwept counts an inquiry sent for a stuck payment, with the given outcome.
+func StuckPaymentSwept(outcome string) {
+	stuckPaymentSweeps.WithLabelValues(outcome).Inc()
+}
@@ -26,6 +26,15 @@ func (c *Client) SetValue(ctx context.Context, k string, v interface{}, expirati
 	return c.redis.Set(ctx, k, valBts, expiration).Err()
 }
 
+// SetValueIfNotExists sets the value only when the key does not exist, and reports whether it was set.
+func (c *Client) SetValueIfNotExists(ctx context.Context, k string, v interface{}, expiration time.Duration) (bool, error) {
+	valBts, err := json.Marshal(v)
+	if err != nil {
+		return false, err
+	}
+	return c.redis.SetNX(ctx, k, valBts, expiration).Result()
+}
+
 func (c *Client) DeleteKey(ctx context.Context, k string) error {
 	return c.redis.Del(ctx, k).Err()
 }
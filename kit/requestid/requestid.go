@@ -0,0 +1,28 @@
+package requestid
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway/kit/ctx"
+)
+
+const (
+	// HeaderKey is the header that carries the id of a request, both on the request and on its response
+	HeaderKey = "X-Request-ID"
+
+	maxLength = 128
+)
+
+// Middleware gives every request an id, so its logs and the calls it makes to the acquiring bank can be linked.
+// The id sent by the caller is kept, and a new one is generated when it is missing or too long.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(HeaderKey)
+		if id == "" || len(id) > maxLength {
+			id = uuid.New().String()
+		}
+		w.Header().Set(HeaderKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx.AddRequestID(r.Context(), id)))
+	})
+}
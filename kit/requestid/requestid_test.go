@@ -0,0 +1,50 @@
+package requestid_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway/kit/ctx"
+	"github.com/marioarizaj/payment-gateway/kit/requestid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	cases := []struct {
+		name       string
+		header     string
+		expectKept bool
+	}{
+		{name: "keeps_id_of_caller", header: "req-123", expectKept: true},
+		{name: "generates_missing_id"},
+		{name: "replaces_long_id", header: strings.Repeat("a", 129)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var fromCtx string
+			handler := requestid.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var err error
+				fromCtx, err = ctx.GetRequestID(r.Context())
+				assert.NoError(t, err)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/v1/payments", nil)
+			if c.header != "" {
+				req.Header.Set(requestid.HeaderKey, c.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			id := rec.Header().Get(requestid.HeaderKey)
+			assert.Equal(t, fromCtx, id)
+			if c.expectKept {
+				assert.Equal(t, c.header, id)
+				return
+			}
+			_, err := uuid.Parse(id)
+			assert.NoError(t, err)
+		})
+	}
+}
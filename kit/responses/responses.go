@@ -64,6 +64,22 @@ func (e ConflictError) Response(w http.ResponseWriter) {
 	RespondWithError(w, http.StatusConflict, e.Error())
 }
 
+// Page is the envelope of a paginated list. NextCursor is sent back by the client to get the next page,
+// and it is empty on the last page.
+type Page struct {
+	Data       interface{} `json:"data"`
+	HasMore    bool        `json:"has_more"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+func RespondWithPage(w http.ResponseWriter, data interface{}, nextCursor string) {
+	RespondWithJSON(w, http.StatusOK, Page{
+		Data:       data,
+		HasMore:    nextCursor != "",
+		NextCursor: nextCursor,
+	})
+}
+
 func AuthenticationError(w http.ResponseWriter) {
 	w.Header().Set("WWW-Authenticate", "Basic realm=<realm>")
 	RespondWithError(w, http.StatusUnauthorized, "unauthorized")
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS refunds
+(
+    id            uuid PRIMARY KEY,
+    payment_id    uuid      NOT NULL references payments (id),
+    merchant_id   uuid      NOT NULL references merchants (id),
+    refund_status varchar   NOT NULL DEFAULT 'processing',
+    failed_reason varchar,
+    amount        bigint    NOT NULL CHECK (amount > 0),
+    currency_code varchar   NOT NULL,
+    reason        varchar   NOT NULL DEFAULT '',
+    created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS refunds_payment_id_idx ON refunds (payment_id);
@@ -0,0 +1,3 @@
+ALTER TABLE payments
+    DROP COLUMN IF EXISTS capture_method,
+    DROP COLUMN IF EXISTS amount_captured;
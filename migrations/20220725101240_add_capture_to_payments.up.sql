@@ -0,0 +1,3 @@
+ALTER TABLE payments
+    ADD COLUMN IF NOT EXISTS capture_method  varchar NOT NULL DEFAULT 'automatic',
+    ADD COLUMN IF NOT EXISTS amount_captured bigint  NOT NULL DEFAULT 0;
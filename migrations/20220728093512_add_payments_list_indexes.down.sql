@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS payments_description_trgm_idx;
+DROP INDEX IF EXISTS payments_merchant_id_card_last4_idx;
+DROP INDEX IF EXISTS payments_merchant_id_payment_status_created_at_idx;
+DROP INDEX IF EXISTS payments_merchant_id_created_at_id_idx;
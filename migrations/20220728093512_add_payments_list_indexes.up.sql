@@ -0,0 +1,7 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Keyset pagination walks the payments of a merchant by (created_at, id), newest first
+CREATE INDEX IF NOT EXISTS payments_merchant_id_created_at_id_idx ON payments (merchant_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS payments_merchant_id_payment_status_created_at_idx ON payments (merchant_id, payment_status, created_at DESC);
+CREATE INDEX IF NOT EXISTS payments_merchant_id_card_last4_idx ON payments (merchant_id, right(card_number, 4));
+CREATE INDEX IF NOT EXISTS payments_description_trgm_idx ON payments USING gin (description gin_trgm_ops);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS payments_merchant_id_reference_idx;
+
+ALTER TABLE payments
+    DROP COLUMN IF EXISTS reference;
@@ -0,0 +1,4 @@
+ALTER TABLE payments
+    ADD COLUMN IF NOT EXISTS reference varchar;
+
+CREATE UNIQUE INDEX IF NOT EXISTS payments_merchant_id_reference_idx ON payments (merchant_id, reference) WHERE reference IS NOT NULL;
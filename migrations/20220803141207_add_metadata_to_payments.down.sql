@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS payments_metadata_idx;
+
+ALTER TABLE payments
+    DROP COLUMN IF EXISTS metadata;
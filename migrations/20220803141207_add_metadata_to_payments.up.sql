@@ -0,0 +1,4 @@
+ALTER TABLE payments
+    ADD COLUMN IF NOT EXISTS metadata jsonb NOT NULL DEFAULT '{}'::jsonb;
+
+CREATE INDEX IF NOT EXISTS payments_metadata_idx ON payments USING gin (metadata jsonb_path_ops);
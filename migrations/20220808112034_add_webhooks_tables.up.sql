@@ -0,0 +1,55 @@
+CREATE TABLE IF NOT EXISTS webhook_endpoints
+(
+    id          uuid PRIMARY KEY,
+    merchant_id uuid      NOT NULL references merchants (id),
+    url         varchar   NOT NULL,
+    event_types jsonb     NOT NULL DEFAULT '[]'::jsonb,
+    secret      varchar   NOT NULL,
+    enabled     boolean   NOT NULL DEFAULT true,
+    created_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS webhook_endpoints_merchant_id_idx ON webhook_endpoints (merchant_id);
+
+-- Every event is stored once for every endpoint it is sent to, and sent by a pool of workers until the endpoint accepts
+-- it, so events are not lost when the gateway restarts. Events are claimed with FOR UPDATE SKIP LOCKED, and become due
+-- again once next_attempt_at passes, either after the backoff of a failed attempt or when the worker that claimed them
+-- did not finish them
+CREATE TABLE IF NOT EXISTS webhook_events
+(
+    id              uuid PRIMARY KEY,
+    endpoint_id     uuid      NOT NULL references webhook_endpoints (id) ON DELETE CASCADE,
+    merchant_id     uuid      NOT NULL references merchants (id),
+    event_id        uuid      NOT NULL,
+    event_type      varchar   NOT NULL,
+    body            jsonb     NOT NULL,
+    status          varchar   NOT NULL DEFAULT 'pending',
+    attempts        integer   NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_error      varchar   NOT NULL DEFAULT '',
+    finished_at     TIMESTAMP,
+    created_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS webhook_events_next_attempt_at_idx ON webhook_events (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_events_endpoint_id_idx ON webhook_events (endpoint_id);
+
+-- Every delivery attempt is stored, so merchants can see why an event did not reach them
+CREATE TABLE IF NOT EXISTS webhook_deliveries
+(
+    id                   uuid PRIMARY KEY,
+    endpoint_id          uuid      NOT NULL references webhook_endpoints (id) ON DELETE CASCADE,
+    merchant_id          uuid      NOT NULL references merchants (id),
+    event_id             uuid      NOT NULL,
+    event_type           varchar   NOT NULL,
+    attempt              int       NOT NULL,
+    succeeded            boolean   NOT NULL,
+    response_status_code int       NOT NULL DEFAULT 0,
+    error                varchar   NOT NULL DEFAULT '',
+    duration_ms          bigint    NOT NULL DEFAULT 0,
+    created_at           TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_endpoint_id_created_at_idx ON webhook_deliveries (endpoint_id, created_at DESC);
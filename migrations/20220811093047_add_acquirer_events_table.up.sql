@@ -0,0 +1,8 @@
+-- Results posted by the acquiring bank are stored by their event id, so a result delivered twice is applied once
+CREATE TABLE IF NOT EXISTS acquirer_events
+(
+    id          varchar PRIMARY KEY,
+    operation   varchar   NOT NULL,
+    resource_id uuid      NOT NULL,
+    created_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,6 @@
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS failed_reason varchar;
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS failed_reason varchar;
+UPDATE payments SET failed_reason = decline ->> 'message' WHERE decline IS NOT NULL;
+UPDATE refunds SET failed_reason = decline ->> 'message' WHERE decline IS NOT NULL;
+ALTER TABLE payments DROP COLUMN IF EXISTS decline;
+ALTER TABLE refunds DROP COLUMN IF EXISTS decline;
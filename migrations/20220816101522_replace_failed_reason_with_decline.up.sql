@@ -0,0 +1,11 @@
+-- The free text failed reason is replaced by a structured decline. Existing reasons are kept as the message of unknown declines
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS decline jsonb;
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS decline jsonb;
+UPDATE payments
+SET decline = jsonb_build_object('code', 'unknown', 'category', 'unknown', 'soft', false, 'retry_advised', false, 'message', failed_reason)
+WHERE failed_reason IS NOT NULL AND failed_reason <> '';
+UPDATE refunds
+SET decline = jsonb_build_object('code', 'unknown', 'category', 'unknown', 'soft', false, 'retry_advised', false, 'message', failed_reason)
+WHERE failed_reason IS NOT NULL AND failed_reason <> '';
+ALTER TABLE payments DROP COLUMN IF EXISTS failed_reason;
+ALTER TABLE refunds DROP COLUMN IF EXISTS failed_reason;
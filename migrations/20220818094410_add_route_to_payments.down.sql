@@ -0,0 +1,4 @@
+ALTER TABLE payments
+    DROP COLUMN IF EXISTS routing_rule;
+ALTER TABLE payments
+    DROP COLUMN IF EXISTS acquirer;
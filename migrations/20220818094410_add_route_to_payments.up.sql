@@ -0,0 +1,5 @@
+-- Payments created before routing keep an empty acquirer, which stands for the default acquirer
+ALTER TABLE payments
+    ADD COLUMN IF NOT EXISTS acquirer varchar NOT NULL DEFAULT '';
+ALTER TABLE payments
+    ADD COLUMN IF NOT EXISTS routing_rule varchar NOT NULL DEFAULT '';
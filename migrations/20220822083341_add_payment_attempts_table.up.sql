@@ -0,0 +1,13 @@
+-- Every acquirer a payment is sent to is an attempt, so payments cascaded to a fallback acquirer keep the result of each one
+CREATE TABLE IF NOT EXISTS payment_attempts
+(
+    payment_id uuid      NOT NULL references payments (id),
+    attempt    integer   NOT NULL,
+    acquirer   varchar   NOT NULL,
+    status     varchar   NOT NULL DEFAULT 'processing',
+    decline    jsonb,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (payment_id, attempt),
+    UNIQUE (payment_id, acquirer)
+);
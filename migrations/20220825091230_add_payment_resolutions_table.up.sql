@@ -0,0 +1,15 @@
+-- Payments whose outcome is unknown, because the acquirer did not answer in time, are resolved in the background
+-- with status inquiries, and with a reversal when the inquiries do not get an answer
+CREATE TABLE IF NOT EXISTS payment_resolutions
+(
+    payment_id      uuid      NOT NULL PRIMARY KEY references payments (id),
+    attempts        integer   NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_error      varchar   NOT NULL DEFAULT '',
+    resolution      varchar   NOT NULL DEFAULT '',
+    resolved_at     TIMESTAMP,
+    created_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS payment_resolutions_next_attempt_at_idx ON payment_resolutions (next_attempt_at) WHERE resolved_at IS NULL;
@@ -0,0 +1,19 @@
+-- Payments are written along with a job on the same transaction, and the jobs are submitted to the acquirer by a pool
+-- of workers, so no transaction is open while the acquirer is called. Jobs are claimed with FOR UPDATE SKIP LOCKED,
+-- and become visible again once visible_at passes, when the worker that claimed them did not finish them
+CREATE TABLE IF NOT EXISTS outbox_jobs
+(
+    id          uuid      NOT NULL PRIMARY KEY,
+    kind        varchar   NOT NULL,
+    resource_id uuid      NOT NULL,
+    status      varchar   NOT NULL DEFAULT 'pending',
+    attempts    integer   NOT NULL DEFAULT 0,
+    visible_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_error  varchar   NOT NULL DEFAULT '',
+    finished_at TIMESTAMP,
+    created_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS outbox_jobs_visible_at_idx ON outbox_jobs (visible_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS outbox_jobs_resource_id_idx ON outbox_jobs (resource_id);
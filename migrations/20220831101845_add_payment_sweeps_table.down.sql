@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS payments_processing_updated_at_idx;
+DROP TABLE IF EXISTS payment_sweeps;
@@ -0,0 +1,19 @@
+-- Payments that are processing for longer than the SLA, because the result of the acquirer never arrived, are swept
+-- in the background with status inquiries, and flagged for manual review when the inquiries do not tell their status.
+-- A payment that cascades to a fallback acquirer is swept again for it
+CREATE TABLE IF NOT EXISTS payment_sweeps
+(
+    payment_id      uuid      NOT NULL references payments (id),
+    acquirer        varchar   NOT NULL,
+    attempts        integer   NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_error      varchar   NOT NULL DEFAULT '',
+    outcome         varchar   NOT NULL DEFAULT '',
+    finished_at     TIMESTAMP,
+    created_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (payment_id, acquirer)
+);
+
+CREATE INDEX IF NOT EXISTS payment_sweeps_next_attempt_at_idx ON payment_sweeps (next_attempt_at) WHERE finished_at IS NULL;
+CREATE INDEX IF NOT EXISTS payments_processing_updated_at_idx ON payments (updated_at) WHERE payment_status = 'processing';
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS payments_settled_updated_at_idx;
+DROP TABLE IF EXISTS settlement_discrepancies;
+DROP TABLE IF EXISTS settlement_files;
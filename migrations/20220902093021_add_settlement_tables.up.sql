@@ -0,0 +1,38 @@
+-- Settlement files of the acquirers are imported once, and every line is matched to the payment with the same id.
+-- Lines that do not match, and the settled payments that are not on the file, are discrepancies for the operators to resolve
+CREATE TABLE IF NOT EXISTS settlement_files
+(
+    id                uuid      NOT NULL PRIMARY KEY,
+    name              varchar   NOT NULL UNIQUE,
+    acquirer          varchar   NOT NULL,
+    settlement_date   date      NOT NULL,
+    line_count        integer   NOT NULL DEFAULT 0,
+    discrepancy_count integer   NOT NULL DEFAULT 0,
+    created_at        TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS settlement_discrepancies
+(
+    id                 uuid      NOT NULL PRIMARY KEY,
+    settlement_file_id uuid      NOT NULL references settlement_files (id),
+    payment_id         uuid      NOT NULL,
+    kind               varchar   NOT NULL,
+    gateway_amount     bigint    NOT NULL DEFAULT 0,
+    gateway_currency   varchar   NOT NULL DEFAULT '',
+    gateway_status     varchar   NOT NULL DEFAULT '',
+    acquirer_amount    bigint    NOT NULL DEFAULT 0,
+    acquirer_currency  varchar   NOT NULL DEFAULT '',
+    acquirer_status    varchar   NOT NULL DEFAULT '',
+    status             varchar   NOT NULL DEFAULT 'open',
+    resolution_note    varchar   NOT NULL DEFAULT '',
+    resolved_at        TIMESTAMP,
+    created_at         TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at         TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS settlement_discrepancies_status_created_at_idx ON settlement_discrepancies (status, created_at);
+CREATE INDEX IF NOT EXISTS settlement_discrepancies_settlement_file_id_idx ON settlement_discrepancies (settlement_file_id);
+CREATE INDEX IF NOT EXISTS settlement_discrepancies_payment_id_idx ON settlement_discrepancies (payment_id);
+
+-- The payments an acquirer settled on a day are the ones that moved to succeeded or captured on that day
+CREATE INDEX IF NOT EXISTS payments_settled_updated_at_idx ON payments (acquirer, updated_at) WHERE payment_status IN ('succeeded', 'captured');
@@ -0,0 +1,24 @@
+-- Results of the acquirers are stored on the inbox before they are applied, so a result is not lost when it can not be
+-- applied right away. The event id deduplicates the results the acquirer delivers more than once, and the messages that
+-- are still pending are claimed with FOR UPDATE SKIP LOCKED, and applied again once visible_at passes
+CREATE TABLE IF NOT EXISTS inbox_messages
+(
+    id            uuid      NOT NULL PRIMARY KEY,
+    event_id      varchar   NOT NULL UNIQUE,
+    operation     varchar   NOT NULL,
+    resource_id   uuid      NOT NULL,
+    acquirer      varchar   NOT NULL DEFAULT '',
+    result_status varchar   NOT NULL,
+    decline       jsonb,
+    status        varchar   NOT NULL DEFAULT 'pending',
+    attempts      integer   NOT NULL DEFAULT 0,
+    visible_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_error    varchar   NOT NULL DEFAULT '',
+    applied_at    TIMESTAMP,
+    finished_at   TIMESTAMP,
+    created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS inbox_messages_visible_at_idx ON inbox_messages (visible_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS inbox_messages_resource_id_idx ON inbox_messages (resource_id);
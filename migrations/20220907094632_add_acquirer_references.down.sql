@@ -0,0 +1,2 @@
+ALTER TABLE inbox_messages DROP COLUMN IF EXISTS acquirer_reference;
+ALTER TABLE payments DROP COLUMN IF EXISTS acquirer_reference;
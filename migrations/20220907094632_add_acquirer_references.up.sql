@@ -0,0 +1,5 @@
+-- Acquirers that use ISO 8583 identify an authorization by the MTI, STAN, RRN and transmission time of its request, and
+-- by the authorization code of its response. They are kept on the payment, so its completion and reversal can refer to
+-- it, and on the inbox message that carries the result of the authorization
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS acquirer_reference jsonb;
+ALTER TABLE inbox_messages ADD COLUMN IF NOT EXISTS acquirer_reference jsonb;
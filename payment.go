@@ -1,11 +1,32 @@
 package payment_gateway
 
 import (
+	"errors"
+	"fmt"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
 	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/internal/routing"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+)
+
+const (
+	// CaptureMethodAutomatic charges the card as soon as the acquiring bank approves the payment.
+	CaptureMethodAutomatic = "automatic"
+	// CaptureMethodManual only authorizes the payment, the merchant needs to capture it later.
+	CaptureMethodManual = "manual"
+
+	maxReferenceLength = 255
+
+	// MaxMetadataKeys is the maximum number of keys the metadata of a payment can have
+	MaxMetadataKeys = 20
+	// MaxMetadataKeyLength is the maximum number of characters of a metadata key
+	MaxMetadataKeyLength = 40
+	// MaxMetadataValueLength is the maximum number of characters of a metadata value
+	MaxMetadataValueLength = 500
 )
 
 // Payment represents a transaction request object received from a Merchant
@@ -13,15 +34,29 @@ type Payment struct {
 	// ID is the unique Payment ID that globally identifies this Payment, this will be our idempotency key
 	ID         uuid.UUID `json:"id"`
 	MerchantID uuid.UUID `json:"merchant_id"`
+	// Reference is the merchant's own id for this payment, like an order number. It is unique for each merchant.
+	Reference string `json:"reference,omitempty"`
 	// Amount is the amount that we need to charge to the given card fo this transaction.
-	PaymentStatus string `json:"payment_status"`
-	FailedReason  string `json:"failed_reason,omitempty"`
-	Amount        Amount `json:"amount"`
+	PaymentStatus status.PaymentStatus `json:"payment_status"`
+	// Decline describes why the payment failed, and whether it is worth retrying it
+	Decline *decline.Decline `json:"decline,omitempty"`
+	Amount  Amount           `json:"amount"`
+	// CaptureMethod decides whether the payment is charged immediately, or only authorized to be captured later.
+	CaptureMethod string `json:"capture_method"`
+	// AmountCaptured is the amount of an authorized payment that the merchant has captured.
+	AmountCaptured int64 `json:"amount_captured"`
 	// Description describes the reason why we are charging this given card
-	Description string     `json:"description"`
-	CardInfo    CardInfo   `json:"card_info"`
-	CreatedAt   *time.Time `json:"created_at"`
-	UpdatedAt   *time.Time `json:"updated_at"`
+	Description string `json:"description"`
+	// Metadata contains any extra information the merchant wants to keep on the payment, like a cart or a customer id.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Route is the acquirer that processes the payment, chosen by the routing rules when the payment is created.
+	Route    *routing.Route `json:"route,omitempty"`
+	CardInfo CardInfo       `json:"card_info"`
+	// AcquirerReference identifies the authorization on the acquirer. It is only used to talk to the acquirer, so it is
+	// never shown to the merchant.
+	AcquirerReference *repositiory.AcquirerReference `json:"-"`
+	CreatedAt         *time.Time                     `json:"created_at"`
+	UpdatedAt         *time.Time                     `json:"updated_at"`
 }
 
 type Amount struct {
@@ -47,41 +82,128 @@ type CardInfo struct {
 }
 
 func (p Payment) GetStoragePayment() *repositiory.Payment {
+	var routingRule string
+	if p.Route != nil {
+		routingRule = p.Route.Rule
+	}
 	return &repositiory.Payment{
-		ID:              p.ID,
-		Amount:          p.Amount.AmountFractional,
-		MerchantID:      p.MerchantID,
-		PaymentStatus:   p.PaymentStatus,
-		FailedReason:    p.FailedReason,
-		CurrencyCode:    p.Amount.CurrencyCode,
-		Description:     p.Description,
-		CardName:        p.CardInfo.CardName,
-		CardNumber:      p.CardInfo.CardNumber,
-		CardExpiryMonth: p.CardInfo.ExpiryMonth,
-		CardExpiryYear:  p.CardInfo.ExpiryYear,
+		ID:                p.ID,
+		Amount:            p.Amount.AmountFractional,
+		MerchantID:        p.MerchantID,
+		Reference:         getStorageReference(p.Reference),
+		PaymentStatus:     p.PaymentStatus,
+		Decline:           p.Decline,
+		CurrencyCode:      p.Amount.CurrencyCode,
+		CaptureMethod:     p.CaptureMethod,
+		AmountCaptured:    p.AmountCaptured,
+		Description:       p.Description,
+		Metadata:          p.Metadata,
+		Acquirer:          p.Acquirer(),
+		RoutingRule:       routingRule,
+		CardName:          p.CardInfo.CardName,
+		CardNumber:        p.CardInfo.CardNumber,
+		CardExpiryMonth:   p.CardInfo.ExpiryMonth,
+		CardExpiryYear:    p.CardInfo.ExpiryYear,
+		AcquirerReference: p.AcquirerReference,
 	}
 }
 
+// GetPaymentFromStoredPayment returns the payment as it is shown to the merchant, with a masked card number. The stored
+// payment is not changed, so it still has the card number that is sent to the acquirer.
 func GetPaymentFromStoredPayment(p *repositiory.Payment) Payment {
-	p.CardNumber = MaskCreditCard(p.CardNumber)
+	var reference string
+	if p.Reference != nil {
+		reference = *p.Reference
+	}
+	var metadata map[string]string
+	if len(p.Metadata) > 0 {
+		metadata = p.Metadata
+	}
+	var route *routing.Route
+	if p.Acquirer != "" {
+		route = &routing.Route{Acquirer: p.Acquirer, Rule: p.RoutingRule}
+	}
 	return Payment{
 		ID:            p.ID,
+		Reference:     reference,
 		PaymentStatus: p.PaymentStatus,
-		FailedReason:  p.FailedReason,
+		Decline:       p.Decline,
 		Amount: Amount{
 			AmountFractional: p.Amount,
 			CurrencyCode:     p.CurrencyCode,
 		},
-		MerchantID:  p.MerchantID,
-		Description: p.Description,
+		CaptureMethod:  p.CaptureMethod,
+		AmountCaptured: p.AmountCaptured,
+		MerchantID:     p.MerchantID,
+		Description:    p.Description,
+		Metadata:       metadata,
+		Route:          route,
 		CardInfo: CardInfo{
 			CardName:    p.CardName,
-			CardNumber:  p.CardNumber,
+			CardNumber:  MaskCreditCard(p.CardNumber),
 			ExpiryMonth: p.CardExpiryMonth,
 			ExpiryYear:  p.CardExpiryYear,
 		},
-		CreatedAt: p.CreatedAt,
-		UpdatedAt: p.UpdatedAt,
+		AcquirerReference: p.AcquirerReference,
+		CreatedAt:         p.CreatedAt,
+		UpdatedAt:         p.UpdatedAt,
+	}
+}
+
+// Acquirer returns the name of the acquirer that processes the payment. It is empty for payments that were
+// created before routing, which are processed by the default acquirer.
+func (p Payment) Acquirer() string {
+	if p.Route == nil {
+		return ""
+	}
+	return p.Route.Acquirer
+}
+
+// getStorageReference stores payments without a reference as NULL, so they do not conflict with each other.
+func getStorageReference(reference string) *string {
+	if reference == "" {
+		return nil
+	}
+	return &reference
+}
+
+// ValidateMetadata validates the number of metadata keys, along with the length of each key and value.
+func (p Payment) ValidateMetadata() error {
+	if len(p.Metadata) > MaxMetadataKeys {
+		return fmt.Errorf("metadata can not have more than %d keys", MaxMetadataKeys)
+	}
+	for k, v := range p.Metadata {
+		if k == "" {
+			return errors.New("metadata keys can not be empty")
+		}
+		if len(k) > MaxMetadataKeyLength {
+			return fmt.Errorf("metadata key %s is longer than %d characters", k, MaxMetadataKeyLength)
+		}
+		if len(v) > MaxMetadataValueLength {
+			return fmt.Errorf("metadata value of key %s is longer than %d characters", k, MaxMetadataValueLength)
+		}
+	}
+	return nil
+}
+
+// ValidateReference validates the length of the merchant reference, when it is given.
+func (p Payment) ValidateReference() error {
+	if len(p.Reference) > maxReferenceLength {
+		return fmt.Errorf("reference can not be longer than %d characters", maxReferenceLength)
+	}
+	return nil
+}
+
+// ValidateCaptureMethod sets the default capture method when it is not given, and validates it otherwise.
+func (p *Payment) ValidateCaptureMethod() error {
+	switch p.CaptureMethod {
+	case "":
+		p.CaptureMethod = CaptureMethodAutomatic
+		return nil
+	case CaptureMethodAutomatic, CaptureMethodManual:
+		return nil
+	default:
+		return errors.New("capture_method must be either automatic or manual")
 	}
 }
 
@@ -0,0 +1,59 @@
+package payment_gateway
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway/internal/decline"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+)
+
+// Refund represents a request from a Merchant to return money from a previously charged Payment.
+// A Payment can be refunded in one or more partial refunds, as long as their sum does not exceed the charged amount.
+type Refund struct {
+	// ID is the unique Refund ID that globally identifies this Refund, this will be our idempotency key
+	ID         uuid.UUID `json:"id"`
+	PaymentID  uuid.UUID `json:"payment_id"`
+	MerchantID uuid.UUID `json:"merchant_id"`
+	// RefundStatus is updated by the acquiring bank once the refund is processed.
+	RefundStatus status.RefundStatus `json:"refund_status"`
+	// Decline describes why the refund failed
+	Decline *decline.Decline `json:"decline,omitempty"`
+	// Amount is the amount that we need to return to the card used on the Payment.
+	Amount Amount `json:"amount"`
+	// Reason describes why the money is being returned to the shopper
+	Reason    string     `json:"reason"`
+	CreatedAt *time.Time `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+func (r Refund) GetStorageRefund() *repositiory.Refund {
+	return &repositiory.Refund{
+		ID:           r.ID,
+		PaymentID:    r.PaymentID,
+		MerchantID:   r.MerchantID,
+		Amount:       r.Amount.AmountFractional,
+		CurrencyCode: r.Amount.CurrencyCode,
+		RefundStatus: r.RefundStatus,
+		Decline:      r.Decline,
+		Reason:       r.Reason,
+	}
+}
+
+func GetRefundFromStoredRefund(r *repositiory.Refund) Refund {
+	return Refund{
+		ID:           r.ID,
+		PaymentID:    r.PaymentID,
+		MerchantID:   r.MerchantID,
+		RefundStatus: r.RefundStatus,
+		Decline:      r.Decline,
+		Amount: Amount{
+			AmountFractional: r.Amount,
+			CurrencyCode:     r.CurrencyCode,
+		},
+		Reason:    r.Reason,
+		CreatedAt: r.CreatedAt,
+		UpdatedAt: r.UpdatedAt,
+	}
+}
@@ -0,0 +1,89 @@
+package payment_gateway
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+)
+
+// SettlementFile is a settlement file of an acquirer that was imported, with the payments it settled on a day.
+type SettlementFile struct {
+	ID       uuid.UUID `json:"id"`
+	Name     string    `json:"name"`
+	Acquirer string    `json:"acquirer"`
+	// SettlementDate is the day the payments on the file were settled, with the YYYY-MM-DD format
+	SettlementDate   string     `json:"settlement_date"`
+	LineCount        int        `json:"line_count"`
+	DiscrepancyCount int        `json:"discrepancy_count"`
+	CreatedAt        *time.Time `json:"created_at"`
+}
+
+// SettlementAmount is the amount, currency and status of a payment, either as we have it or as the acquirer settled it.
+type SettlementAmount struct {
+	AmountFractional int64  `json:"amount_fractional"`
+	CurrencyCode     string `json:"currency_code"`
+	Status           string `json:"status"`
+}
+
+// SettlementDiscrepancy is a difference between the settlement file of an acquirer and the payments.
+type SettlementDiscrepancy struct {
+	ID               uuid.UUID `json:"id"`
+	SettlementFileID uuid.UUID `json:"settlement_file_id"`
+	PaymentID        uuid.UUID `json:"payment_id"`
+	// Kind is missing_in_gateway, missing_at_acquirer, amount_mismatch, status_mismatch, acquirer_mismatch
+	// or duplicate_line
+	Kind string `json:"kind"`
+	// Gateway is the payment as we have it, which is not set for payments missing in the gateway
+	Gateway *SettlementAmount `json:"gateway,omitempty"`
+	// Acquirer is the payment as the acquirer settled it, which is not set for payments missing at the acquirer
+	Acquirer *SettlementAmount `json:"acquirer,omitempty"`
+	// Status is open until an operator resolves the discrepancy
+	Status         string     `json:"status"`
+	ResolutionNote string     `json:"resolution_note,omitempty"`
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty"`
+	CreatedAt      *time.Time `json:"created_at"`
+	UpdatedAt      *time.Time `json:"updated_at"`
+}
+
+func GetSettlementFileFromStoredFile(f *repositiory.SettlementFile) SettlementFile {
+	return SettlementFile{
+		ID:               f.ID,
+		Name:             f.Name,
+		Acquirer:         f.Acquirer,
+		SettlementDate:   f.SettlementDate.Format("2006-01-02"),
+		LineCount:        f.LineCount,
+		DiscrepancyCount: f.DiscrepancyCount,
+		CreatedAt:        f.CreatedAt,
+	}
+}
+
+func GetSettlementDiscrepancyFromStoredDiscrepancy(d *repositiory.SettlementDiscrepancy) SettlementDiscrepancy {
+	discrepancy := SettlementDiscrepancy{
+		ID:               d.ID,
+		SettlementFileID: d.SettlementFileID,
+		PaymentID:        d.PaymentID,
+		Kind:             d.Kind,
+		Status:           d.Status,
+		ResolutionNote:   d.ResolutionNote,
+		ResolvedAt:       d.ResolvedAt,
+		CreatedAt:        d.CreatedAt,
+		UpdatedAt:        d.UpdatedAt,
+	}
+	// Duplicate lines of a payment we do not have are also missing in the gateway
+	if d.Kind != repositiory.DiscrepancyMissingInGateway && d.GatewayStatus != "" {
+		discrepancy.Gateway = &SettlementAmount{
+			AmountFractional: d.GatewayAmount,
+			CurrencyCode:     d.GatewayCurrency,
+			Status:           d.GatewayStatus,
+		}
+	}
+	if d.Kind != repositiory.DiscrepancyMissingAtAcquirer {
+		discrepancy.Acquirer = &SettlementAmount{
+			AmountFractional: d.AcquirerAmount,
+			CurrencyCode:     d.AcquirerCurrency,
+			Status:           d.AcquirerStatus,
+		}
+	}
+	return discrepancy
+}
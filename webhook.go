@@ -0,0 +1,100 @@
+package payment_gateway
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marioarizaj/payment-gateway/internal/repositiory"
+	"github.com/marioarizaj/payment-gateway/internal/status"
+)
+
+const (
+	EventPaymentSucceeded = "payment.succeeded"
+	EventPaymentCaptured  = "payment.captured"
+	EventPaymentFailed    = "payment.failed"
+	EventPaymentCanceled  = "payment.canceled"
+	// EventWebhookTest is only sent when the merchant asks for a test event
+	EventWebhookTest = "webhook.test"
+)
+
+// EventTypes are the events a webhook endpoint can subscribe to.
+var EventTypes = []string{EventPaymentSucceeded, EventPaymentCaptured, EventPaymentFailed, EventPaymentCanceled}
+
+// GetPaymentEventType returns the event sent when a payment moves to the given final status.
+func GetPaymentEventType(paymentStatus status.PaymentStatus) string {
+	return "payment." + string(paymentStatus)
+}
+
+// WebhookEndpoint is a URL registered by a Merchant, where we send the events it is subscribed to.
+type WebhookEndpoint struct {
+	ID         uuid.UUID `json:"id"`
+	MerchantID uuid.UUID `json:"merchant_id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	// Secret is used to verify the signature of the events. It is only returned when the endpoint is created.
+	Secret    string     `json:"secret,omitempty"`
+	Enabled   bool       `json:"enabled"`
+	CreatedAt *time.Time `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+// WebhookEvent is the body of the requests sent to webhook endpoints.
+type WebhookEvent struct {
+	ID        uuid.UUID   `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// WebhookDelivery is a single attempt to send an event to a webhook endpoint.
+type WebhookDelivery struct {
+	ID                 uuid.UUID  `json:"id"`
+	EndpointID         uuid.UUID  `json:"endpoint_id"`
+	EventID            uuid.UUID  `json:"event_id"`
+	EventType          string     `json:"event_type"`
+	Attempt            int        `json:"attempt"`
+	Succeeded          bool       `json:"succeeded"`
+	ResponseStatusCode int        `json:"response_status_code,omitempty"`
+	Error              string     `json:"error,omitempty"`
+	DurationMs         int64      `json:"duration_ms"`
+	CreatedAt          *time.Time `json:"created_at"`
+}
+
+func (e WebhookEndpoint) GetStorageWebhookEndpoint() *repositiory.WebhookEndpoint {
+	return &repositiory.WebhookEndpoint{
+		ID:         e.ID,
+		MerchantID: e.MerchantID,
+		URL:        e.URL,
+		EventTypes: e.EventTypes,
+		Secret:     e.Secret,
+		Enabled:    e.Enabled,
+	}
+}
+
+// GetWebhookEndpointFromStoredEndpoint returns the endpoint without its secret.
+func GetWebhookEndpointFromStoredEndpoint(e *repositiory.WebhookEndpoint) WebhookEndpoint {
+	return WebhookEndpoint{
+		ID:         e.ID,
+		MerchantID: e.MerchantID,
+		URL:        e.URL,
+		EventTypes: e.EventTypes,
+		Enabled:    e.Enabled,
+		CreatedAt:  e.CreatedAt,
+		UpdatedAt:  e.UpdatedAt,
+	}
+}
+
+func GetWebhookDeliveryFromStoredDelivery(d *repositiory.WebhookDelivery) WebhookDelivery {
+	return WebhookDelivery{
+		ID:                 d.ID,
+		EndpointID:         d.EndpointID,
+		EventID:            d.EventID,
+		EventType:          d.EventType,
+		Attempt:            d.Attempt,
+		Succeeded:          d.Succeeded,
+		ResponseStatusCode: d.ResponseStatusCode,
+		Error:              d.Error,
+		DurationMs:         d.DurationMs,
+		CreatedAt:          d.CreatedAt,
+	}
+}